@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// connectedClients is the number of currently open client connections
+var connectedClients atomic.Int64
+
+// totalConnectionsReceived counts every connection accepted since startup
+var totalConnectionsReceived atomic.Int64
+
+// nextClientID hands out connection IDs, starting from 1
+var nextClientID atomic.Int64
+
+// clients indexes every connected client by ID
+var (
+	clientsMu sync.RWMutex
+	clients   = make(map[int64]*Client)
+)
+
+// newClient wraps a freshly accepted connection and registers it
+func newClient(conn net.Conn) *Client {
+	client := &Client{Conn: conn, id: nextClientID.Add(1), proto: 2}
+
+	clientsMu.Lock()
+	clients[client.id] = client
+	clientsMu.Unlock()
+	return client
+}
+
+// unregisterClient removes a client from the registry, and from the pub/sub
+// registries, once it disconnects
+func unregisterClient(client *Client) {
+	clientsMu.Lock()
+	delete(clients, client.id)
+	clientsMu.Unlock()
+	unsubscribeAll(client)
+}
+
+// Write sends a reply to the client, noting whether it is an error reply
+func (c *Client) Write(b []byte) (int, error) {
+	if len(b) > 0 && b[0] == '-' {
+		c.errorReplies.Add(1)
+	}
+	return c.Conn.Write(b)
+}
+
+// handleClient implements CLIENT ID and CLIENT UNBLOCK
+func handleClient(args []string, conn net.Conn) {
+	switch strings.ToUpper(args[1]) {
+	case "ID":
+		writeInteger(conn, int(conn.(*Client).id))
+	case "UNBLOCK":
+		// CLIENT UNBLOCK client-id [TIMEOUT|ERROR]
+		if len(args) != 3 && len(args) != 4 {
+			writeError(conn, "wrong number of arguments for 'client|unblock' command")
+			return
+		}
+		id, ok := parseStrictInt(args[2])
+		if !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+
+		withError := false
+		if len(args) == 4 {
+			switch strings.ToUpper(args[3]) {
+			case "TIMEOUT":
+			case "ERROR":
+				withError = true
+			default:
+				writeError(conn, "CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+				return
+			}
+		}
+
+		clientsMu.RLock()
+		target, ok := clients[id]
+		clientsMu.RUnlock()
+
+		if ok && unblockClient(target, withError) {
+			writeInteger(conn, 1)
+		} else {
+			writeInteger(conn, 0)
+		}
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try CLIENT HELP.", args[1]))
+	}
+}
+
+// handleHello implements HELLO [protover], switching the connection to RESP2
+// or RESP3 and replying with a map describing the server and connection.
+// Authentication and client names aren't supported, so neither are the
+// AUTH and SETNAME options.
+func handleHello(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	proto := client.proto
+	if len(args) > 1 {
+		n, ok := parseStrictInt(args[1])
+		if !ok {
+			writeError(conn, "Protocol version is not an integer or out of range")
+			return
+		}
+		if n != 2 && n != 3 {
+			writeRawError(conn, "NOPROTO unsupported protocol version")
+			return
+		}
+		proto = int(n)
+	}
+	if len(args) > 2 {
+		writeError(conn, fmt.Sprintf("Syntax error in HELLO option '%s'", args[2]))
+		return
+	}
+
+	client.proto = proto
+	writeValue(conn, respMap{
+		"server", "redis",
+		"version", "7.2.0",
+		"proto", proto,
+		"id", client.id,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []any{},
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientUnblock(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		reason  []string
+		want    string
+	}{
+		{"BLPOP timeout", []string{"BLPOP", "unblock-list", "0"}, []string{"TIMEOUT"}, "*-1\r\n"},
+		{"BLPOP default reason", []string{"BLPOP", "unblock-list", "0"}, nil, "*-1\r\n"},
+		{"BRPOP error", []string{"BRPOP", "unblock-list", "0"}, []string{"ERROR"}, "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n"},
+		{"BLMPOP error", []string{"BLMPOP", "0", "1", "unblock-list", "LEFT"}, []string{"error"}, "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n"},
+		{"XREAD timeout", []string{"XREAD", "BLOCK", "0", "STREAMS", "unblock-stream", "$"}, []string{"TIMEOUT"}, "*-1\r\n"},
+		{"XREAD error", []string{"XREAD", "BLOCK", "0", "STREAMS", "unblock-stream", "$"}, []string{"ERROR"}, "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n"},
+	}
+	admin := newTestClient(t)
+	admin.do("DEL", "unblock-list", "unblock-stream")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocked := newTestClient(t)
+			id := strings.TrimSuffix(strings.TrimPrefix(blocked.do("CLIENT", "ID"), ":"), "\r\n")
+
+			reply := make(chan string, 1)
+			go func() { reply <- blocked.do(tt.command...) }()
+
+			// CLIENT UNBLOCK replies 0 until the client has actually blocked
+			unblock := append([]string{"CLIENT", "UNBLOCK", id}, tt.reason...)
+			for i := 0; admin.do(unblock...) != ":1\r\n"; i++ {
+				if i == 1000 {
+					t.Fatalf("%v never found the client blocked", unblock)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			select {
+			case got := <-reply:
+				if got != tt.want {
+					t.Errorf("%v after %v = %q, want %q", tt.command, unblock, got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%v is still blocked after %v", tt.command, unblock)
+			}
+
+			if got := admin.do(unblock...); got != ":0\r\n" {
+				t.Errorf("second %v = %q, want 0", unblock, got)
+			}
+			if got := blocked.do("PING"); got != "+PONG\r\n" {
+				t.Errorf("PING on the unblocked connection = %q", got)
+			}
+		})
+	}
+}
+
+func TestClientUnblockArguments(t *testing.T) {
+	c := newTestClient(t)
+	id := strings.TrimSuffix(strings.TrimPrefix(c.do("CLIENT", "ID"), ":"), "\r\n")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"CLIENT", "UNBLOCK", id}, ":0\r\n"},
+		{[]string{"CLIENT", "UNBLOCK", "999999999"}, ":0\r\n"},
+		{[]string{"CLIENT", "UNBLOCK", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"CLIENT", "UNBLOCK", id, "LATER"}, "-ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR\r\n"},
+		{[]string{"CLIENT", "UNBLOCK"}, "-ERR wrong number of arguments for 'client|unblock' command\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	c := newTestClient(t)
+	id := strings.TrimSuffix(strings.TrimPrefix(c.do("CLIENT", "ID"), ":"), "\r\n")
+	server := func(proto string) []any {
+		n, _ := strconv.Atoi(proto)
+		clientID, _ := strconv.Atoi(id)
+		return []any{"server", "redis", "version", "7.2.0", "proto", n, "id", clientID,
+			"mode", "standalone", "role", "master", "modules", []any{}}
+	}
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HELLO"}, encodeValue(server("2"))},
+		{[]string{"HELLO", "3"}, "%7\r\n" + strings.TrimPrefix(encodeValue(server("3")), "*14\r\n")},
+		// without a version HELLO keeps the current one
+		{[]string{"HELLO"}, "%7\r\n" + strings.TrimPrefix(encodeValue(server("3")), "*14\r\n")},
+		{[]string{"HELLO", "4"}, "-NOPROTO unsupported protocol version\r\n"},
+		{[]string{"HELLO", "two"}, "-ERR Protocol version is not an integer or out of range\r\n"},
+		{[]string{"HELLO", "2", "SETNAME", "x"}, "-ERR Syntax error in HELLO option 'SETNAME'\r\n"},
+		// failed calls leave the connection on RESP3
+		{[]string{"CONFIG", "GET", "hash-max-listpack-value"}, "%1\r\n$23\r\nhash-max-listpack-value\r\n$2\r\n64\r\n"},
+		{[]string{"HELLO", "2"}, encodeValue(server("2"))},
+		{[]string{"CONFIG", "GET", "hash-max-listpack-value"}, "*2\r\n$23\r\nhash-max-listpack-value\r\n$2\r\n64\r\n"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Errorf("%q = %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+// TestRESP3Framing checks the replies that are sets or maps in RESP3 under
+// both protocol versions, and that the other replies don't change
+func TestRESP3Framing(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "r3-set", "r3-hash", "r3-missing")
+	c.do("SADD", "r3-set", "a", "b")
+	c.do("HSET", "r3-hash", "f1", "v1", "f2", "v2")
+
+	tests := []struct {
+		args         []string
+		resp2, resp3 string
+	}{
+		{[]string{"SMEMBERS", "r3-set"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"SMEMBERS", "r3-missing"}, "*0\r\n", "~0\r\n"},
+		{[]string{"SUNION", "r3-set", "r3-missing"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"HGETALL", "r3-hash"}, "*4\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf2\r\n$2\r\nv2\r\n", "%2\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf2\r\n$2\r\nv2\r\n"},
+		{[]string{"HGETALL", "r3-missing"}, "*0\r\n", "%0\r\n"},
+		{[]string{"CONFIG", "GET", "hash-max-listpack-value"}, "*2\r\n$23\r\nhash-max-listpack-value\r\n$2\r\n64\r\n", "%1\r\n$23\r\nhash-max-listpack-value\r\n$2\r\n64\r\n"},
+		{[]string{"HKEYS", "r3-hash"}, "*2\r\n$2\r\nf1\r\n$2\r\nf2\r\n", "*2\r\n$2\r\nf1\r\n$2\r\nf2\r\n"},
+		{[]string{"LRANGE", "r3-missing", "0", "-1"}, "*0\r\n", "*0\r\n"},
+	}
+
+	check := func(resp3 bool) {
+		t.Helper()
+		for _, tt := range tests {
+			want := tt.resp2
+			if resp3 {
+				want = tt.resp3
+			}
+			got := c.do(tt.args...)
+			if tt.args[0] == "SMEMBERS" || tt.args[0] == "SUNION" {
+				// set members come in no particular order
+				got = strings.Replace(got, "$1\r\nb\r\n$1\r\na\r\n", "$1\r\na\r\n$1\r\nb\r\n", 1)
+			}
+			if got != want {
+				t.Errorf("RESP3 %v: %q = %q, want %q", resp3, tt.args, got, want)
+			}
+		}
+	}
+	check(false)
+	c.do("HELLO", "3")
+	check(true)
+}
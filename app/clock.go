@@ -0,0 +1,25 @@
+package main
+
+import "time"
+
+// Clock is the source of time for expiry and blocking timeouts. Routing
+// these through a package-level clock lets tests substitute a fake one and
+// advance time instantly instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock backed by the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clock is the Clock used by the server
+var clock Clock = realClock{}
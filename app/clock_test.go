@@ -0,0 +1,157 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when a test advances it. Channels
+// returned by After fire once the clock has been advanced past them.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{c.now.Add(d), ch})
+	return ch
+}
+
+// advance moves the clock forward by d and fires the timers that are due
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// waitTimers waits until n timers are waiting to fire, so that a test
+// knows a blocked command has started its timeout
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	for range 1000 {
+		c.mu.Lock()
+		waiting := len(c.timers)
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d timers", n)
+}
+
+// setClock replaces the server's clock while holding every shard lock, so
+// that a sweep of the active expiry cycle already under way, which reads the
+// clock under a shard lock, sees the change in order
+func setClock(c Clock) {
+	unlock := DB.LockAll()
+	clock = c
+	unlock()
+}
+
+// useFakeClock makes the server run on a fake clock, starting at the
+// current time, until the test ends. Active expiry is turned off for the
+// duration so that keys only expire when a test looks at them.
+func useFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	activeExpireEnabled.Store(false)
+	fake := &fakeClock{now: time.Now()}
+	setClock(fake)
+	t.Cleanup(func() {
+		setClock(realClock{})
+		activeExpireEnabled.Store(true)
+	})
+	return fake
+}
+
+func TestFakeClockExpiry(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("DEL", "clock-ex", "clock-px")
+	c.do("SET", "clock-ex", "v", "EX", "100")
+	c.do("SET", "clock-px", "v", "PX", "1500")
+
+	steps := []struct {
+		advance        time.Duration
+		ttl, pttl      string
+		typeEx, typePx string
+	}{
+		{0, ":100\r\n", ":1500\r\n", "+string\r\n", "+string\r\n"},
+		{time.Second, ":99\r\n", ":500\r\n", "+string\r\n", "+string\r\n"},
+		{501 * time.Millisecond, ":98\r\n", ":-2\r\n", "+string\r\n", "+none\r\n"},
+		{99 * time.Second, ":-2\r\n", ":-2\r\n", "+none\r\n", "+none\r\n"},
+	}
+	for _, step := range steps {
+		fake.advance(step.advance)
+		if got := c.do("TTL", "clock-ex"); got != step.ttl {
+			t.Errorf("after %v more: TTL = %q, want %q", step.advance, got, step.ttl)
+		}
+		if got := c.do("PTTL", "clock-px"); got != step.pttl {
+			t.Errorf("after %v more: PTTL = %q, want %q", step.advance, got, step.pttl)
+		}
+		if got := c.do("TYPE", "clock-ex"); got != step.typeEx {
+			t.Errorf("after %v more: TYPE clock-ex = %q, want %q", step.advance, got, step.typeEx)
+		}
+		if got := c.do("TYPE", "clock-px"); got != step.typePx {
+			t.Errorf("after %v more: TYPE clock-px = %q, want %q", step.advance, got, step.typePx)
+		}
+	}
+}
+
+// TestFakeClockBLPopTimeout times out a BLPOP by advancing the clock rather
+// than waiting for it
+func TestFakeClockBLPopTimeout(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("DEL", "clock-list")
+
+	reply := make(chan string, 1)
+	go func() { reply <- c.do("BLPOP", "clock-list", "3600") }()
+	fake.waitTimers(t, 1)
+
+	fake.advance(time.Hour - time.Millisecond)
+	select {
+	case got := <-reply:
+		t.Fatalf("BLPOP returned %q before its timeout", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	fake.advance(time.Millisecond)
+	select {
+	case got := <-reply:
+		if got != "*-1\r\n" {
+			t.Errorf("BLPOP after its timeout = %q, want a null array", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BLPOP didn't time out when the clock passed its deadline")
+	}
+}
@@ -1,300 +1,1551 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Map of command names to their handler functions
-var commandHandlers = map[string]CommandHandler{
-	"PING":   handlePing,
-	"ECHO":   handleEcho,
-	"SET":    handleSet,
-	"GET":    handleGet,
-	"TYPE":   handleType,
-	"RPUSH":  handleRPush,
-	"LRANGE": handleLRange,
-	"LLEN":   handleLLen,
-	"LPUSH":  handleLPush,
-	"LPOP":   handleLPop,
-	"BLPOP":  handleBLPop,
-	"XADD":   handleXAdd,
+// commandTable maps upper-case command names to their specs. It is filled
+// from commandSpecs by init, which keeps the dispatcher, COMMAND COUNT and
+// COMMAND INFO working from a single source of truth.
+var commandTable = make(map[string]*Command)
+
+// commandSpecs lists every command the server understands
+var commandSpecs = []Command{
+	// name, handler, arity, flags, first key, last key, key step
+	{"PING", handlePing, -1, "fast", 0, 0, 0},
+	{"ECHO", handleEcho, 2, "fast", 0, 0, 0},
+	{"SET", handleSet, -3, "write", 1, 1, 1},
+	{"GET", handleGet, 2, "readonly fast", 1, 1, 1},
+	{"GETEX", handleGetEx, -2, "write fast", 1, 1, 1},
+	{"SETEX", handleSetEx, 4, "write", 1, 1, 1},
+	{"PSETEX", handlePSetEx, 4, "write", 1, 1, 1},
+	{"SETNX", handleSetNX, 3, "write fast", 1, 1, 1},
+	{"GETSET", handleGetSet, 3, "write fast", 1, 1, 1},
+	{"CAS", handleCAS, -4, "write", 1, 1, 1},
+	{"INCR", handleIncr, 2, "write fast", 1, 1, 1},
+	{"DECR", handleDecr, 2, "write fast", 1, 1, 1},
+	{"INCRBY", handleIncrBy, 3, "write fast", 1, 1, 1},
+	{"DECRBY", handleDecrBy, 3, "write fast", 1, 1, 1},
+	{"INCRBYFLOAT", handleIncrByFloat, 3, "write fast", 1, 1, 1},
+	{"GETRANGE", handleGetRange, 4, "readonly", 1, 1, 1},
+	{"SUBSTR", handleGetRange, 4, "readonly", 1, 1, 1},
+	{"SETRANGE", handleSetRange, 4, "write", 1, 1, 1},
+	{"APPEND", handleAppend, 3, "write", 1, 1, 1},
+	{"TYPE", handleType, 2, "readonly fast", 1, 1, 1},
+	{"DEL", handleDel, -2, "write", 1, -1, 1},
+	{"UNLINK", handleDel, -2, "write fast", 1, -1, 1},
+	{"EXISTS", handleExists, -2, "readonly fast", 1, -1, 1},
+	{"EXPIRE", handleExpire, -3, "write fast", 1, 1, 1},
+	{"PEXPIRE", handlePExpire, -3, "write fast", 1, 1, 1},
+	{"EXPIREAT", handleExpireAt, -3, "write fast", 1, 1, 1},
+	{"PEXPIREAT", handlePExpireAt, -3, "write fast", 1, 1, 1},
+	{"TTL", handleTTL, 2, "readonly fast", 1, 1, 1},
+	{"PTTL", handlePTTL, 2, "readonly fast", 1, 1, 1},
+	{"EXPIRETIME", handleExpireTime, 2, "readonly fast", 1, 1, 1},
+	{"PEXPIRETIME", handlePExpireTime, 2, "readonly fast", 1, 1, 1},
+	{"PERSIST", handlePersist, 2, "write fast", 1, 1, 1},
+	{"KEYS", handleKeys, 2, "readonly", 0, 0, 0},
+	{"SCAN", handleScan, -2, "readonly", 0, 0, 0},
+	{"RANDOMKEY", handleRandomKey, 1, "readonly", 0, 0, 0},
+	{"COPY", handleCopy, -3, "write", 1, 2, 1},
+	{"DUMP", handleDump, 2, "readonly", 1, 1, 1},
+	{"RESTORE", handleRestore, -4, "write", 1, 1, 1},
+	{"OBJECT", handleObject, -2, "readonly", 2, 2, 1},
+	{"SORT", handleSort, -2, "write", 1, 1, 1},
+	{"SORT_RO", handleSortRO, -2, "readonly", 1, 1, 1},
+	{"MGET", handleMGet, -2, "readonly fast", 1, -1, 1},
+	{"MGETTYPE", handleMGetType, -3, "readonly", 2, -1, 1},
+	{"MSET", handleMSet, -3, "write", 1, -1, 2},
+	{"MSETNX", handleMSetNX, -3, "write", 1, -1, 2},
+	{"RPUSH", handleRPush, -3, "write fast", 1, 1, 1},
+	{"LPUSH", handleLPush, -3, "write fast", 1, 1, 1},
+	{"RPUSHX", handleRPushX, -3, "write fast", 1, 1, 1},
+	{"LPUSHX", handleLPushX, -3, "write fast", 1, 1, 1},
+	{"LINSERT", handleLInsert, 5, "write", 1, 1, 1},
+	{"LSET", handleLSet, 4, "write", 1, 1, 1},
+	{"LREM", handleLRem, 4, "write", 1, 1, 1},
+	{"LPOS", handleLPos, -3, "readonly", 1, 1, 1},
+	{"LTRIM", handleLTrim, 4, "write", 1, 1, 1},
+	{"LMOVE", handleLMove, 5, "write", 1, 2, 1},
+	{"RPOPLPUSH", handleRPopLPush, 3, "write", 1, 2, 1},
+	{"BLMOVE", handleBLMove, 6, "write blocking", 1, 2, 1},
+	{"BRPOPLPUSH", handleBRPopLPush, 4, "write blocking", 1, 2, 1},
+	{"LMPOP", handleLMPop, -4, "write movablekeys", 0, 0, 0},
+	{"BLMPOP", handleBLMPop, -5, "write blocking movablekeys", 0, 0, 0},
+	{"LPOP", handleLPop, -2, "write fast", 1, 1, 1},
+	{"RPOP", handleRPop, -2, "write fast", 1, 1, 1},
+	{"LRANGE", handleLRange, 4, "readonly", 1, 1, 1},
+	{"LLEN", handleLLen, 2, "readonly fast", 1, 1, 1},
+	{"BLPOP", handleBLPop, -3, "write blocking", 1, -2, 1},
+	{"BRPOP", handleBRPop, -3, "write blocking", 1, -2, 1},
+	{"HSET", handleHSet, -4, "write fast", 1, 1, 1},
+	{"HGET", handleHGet, 3, "readonly fast", 1, 1, 1},
+	{"HDEL", handleHDel, -3, "write fast", 1, 1, 1},
+	{"HEXISTS", handleHExists, 3, "readonly fast", 1, 1, 1},
+	{"HLEN", handleHLen, 2, "readonly fast", 1, 1, 1},
+	{"HGETALL", handleHGetAll, 2, "readonly", 1, 1, 1},
+	{"HKEYS", handleHKeys, 2, "readonly", 1, 1, 1},
+	{"HVALS", handleHVals, 2, "readonly", 1, 1, 1},
+	{"HMGET", handleHMGet, -3, "readonly fast", 1, 1, 1},
+	{"HSETNX", handleHSetNX, 4, "write fast", 1, 1, 1},
+	{"HRANDFIELD", handleHRandField, -2, "readonly", 1, 1, 1},
+	{"HSCAN", handleHScan, -3, "readonly", 1, 1, 1},
+	{"HEXPIRE", handleHExpire, -6, "write fast", 1, 1, 1},
+	{"HPEXPIRE", handleHPExpire, -6, "write fast", 1, 1, 1},
+	{"HEXPIREAT", handleHExpireAt, -6, "write fast", 1, 1, 1},
+	{"HPEXPIREAT", handleHPExpireAt, -6, "write fast", 1, 1, 1},
+	{"HTTL", handleHTTL, -5, "readonly fast", 1, 1, 1},
+	{"HPTTL", handleHPTTL, -5, "readonly fast", 1, 1, 1},
+	{"HEXPIRETIME", handleHExpireTime, -5, "readonly fast", 1, 1, 1},
+	{"HPEXPIRETIME", handleHPExpireTime, -5, "readonly fast", 1, 1, 1},
+	{"HPERSIST", handleHPersist, -5, "write fast", 1, 1, 1},
+	{"HGETEX", handleHGetEx, -5, "write fast", 1, 1, 1},
+	{"SADD", handleSAdd, -3, "write fast", 1, 1, 1},
+	{"SREM", handleSRem, -3, "write fast", 1, 1, 1},
+	{"SMEMBERS", handleSMembers, 2, "readonly", 1, 1, 1},
+	{"SISMEMBER", handleSIsMember, 3, "readonly fast", 1, 1, 1},
+	{"SCARD", handleSCard, 2, "readonly fast", 1, 1, 1},
+	{"SINTER", handleSInter, -2, "readonly", 1, -1, 1},
+	{"SUNION", handleSUnion, -2, "readonly", 1, -1, 1},
+	{"SDIFF", handleSDiff, -2, "readonly", 1, -1, 1},
+	{"SINTERSTORE", handleSInterStore, -3, "write", 1, -1, 1},
+	{"SUNIONSTORE", handleSUnionStore, -3, "write", 1, -1, 1},
+	{"SDIFFSTORE", handleSDiffStore, -3, "write", 1, -1, 1},
+	{"SPOP", handleSPop, -2, "write fast", 1, 1, 1},
+	{"SRANDMEMBER", handleSRandMember, -2, "readonly", 1, 1, 1},
+	{"SMOVE", handleSMove, 4, "write fast", 1, 2, 1},
+	{"SMISMEMBER", handleSMIsMember, -3, "readonly fast", 1, 1, 1},
+	{"SSCAN", handleSScan, -3, "readonly", 1, 1, 1},
+	{"SINTERCARD", handleSInterCard, -3, "readonly movablekeys", 0, 0, 0},
+	{"ZADD", handleZAdd, -4, "write fast", 1, 1, 1},
+	{"ZSCORE", handleZScore, 3, "readonly fast", 1, 1, 1},
+	{"ZCARD", handleZCard, 2, "readonly fast", 1, 1, 1},
+	{"ZMSCORE", handleZMScore, -3, "readonly fast", 1, 1, 1},
+	{"ZINCRBY", handleZIncrBy, 4, "write fast", 1, 1, 1},
+	{"ZRANGE", handleZRange, -4, "readonly", 1, 1, 1},
+	{"ZRANGESTORE", handleZRangeStore, -5, "write", 1, 2, 1},
+	{"ZREVRANGE", handleZRevRange, -4, "readonly", 1, 1, 1},
+	{"ZRANGEBYSCORE", handleZRangeByScore, -4, "readonly", 1, 1, 1},
+	{"ZREVRANGEBYSCORE", handleZRevRangeByScore, -4, "readonly", 1, 1, 1},
+	{"ZRANGEBYLEX", handleZRangeByLex, -4, "readonly", 1, 1, 1},
+	{"ZREVRANGEBYLEX", handleZRevRangeByLex, -4, "readonly", 1, 1, 1},
+	{"ZRANK", handleZRank, -3, "readonly fast", 1, 1, 1},
+	{"ZREVRANK", handleZRevRank, -3, "readonly fast", 1, 1, 1},
+	{"ZRANDMEMBER", handleZRandMember, -2, "readonly", 1, 1, 1},
+	{"ZREM", handleZRem, -3, "write fast", 1, 1, 1},
+	{"ZREMRANGEBYRANK", handleZRemRangeByRank, 4, "write", 1, 1, 1},
+	{"ZREMRANGEBYSCORE", handleZRemRangeByScore, 4, "write", 1, 1, 1},
+	{"ZREMRANGEBYLEX", handleZRemRangeByLex, 4, "write", 1, 1, 1},
+	{"ZUNIONSTORE", handleZUnionStore, -4, "write movablekeys", 0, 0, 0},
+	{"ZINTERSTORE", handleZInterStore, -4, "write movablekeys", 0, 0, 0},
+	{"ZDIFFSTORE", handleZDiffStore, -4, "write movablekeys", 0, 0, 0},
+	{"ZDIFF", handleZDiff, -3, "readonly movablekeys", 0, 0, 0},
+	{"ZSCAN", handleZScan, -3, "readonly", 1, 1, 1},
+	{"ZMPOP", handleZMPop, -4, "write movablekeys", 0, 0, 0},
+	{"BZMPOP", handleBZMPop, -5, "write blocking movablekeys", 0, 0, 0},
+	{"XADD", handleXAdd, -5, "write fast", 1, 1, 1},
+	{"XLEN", handleXLen, 2, "readonly fast", 1, 1, 1},
+	{"XREAD", handleXRead, -4, "readonly blocking movablekeys", 0, 0, 0},
+	{"XDEL", handleXDel, -3, "write fast", 1, 1, 1},
+	{"XTRIM", handleXTrim, -4, "write", 1, 1, 1},
+	{"XGROUP", handleXGroup, -2, "write", 2, 2, 1},
+	{"XREADGROUP", handleXReadGroup, -7, "write blocking movablekeys", 0, 0, 0},
+	{"XACK", handleXAck, -4, "write fast", 1, 1, 1},
+	{"XPENDING", handleXPending, -3, "readonly", 1, 1, 1},
+	{"XCLAIM", handleXClaim, -6, "write fast", 1, 1, 1},
+	{"XAUTOCLAIM", handleXAutoClaim, -6, "write fast", 1, 1, 1},
+	{"XINFO", handleXInfo, -2, "readonly", 2, 2, 1},
+	{"XSETID", handleXSetID, -3, "write fast", 1, 1, 1},
+	{"CONFIG", handleConfig, -2, "admin", 0, 0, 0},
+	{"DEBUG", handleDebug, -2, "admin", 0, 0, 0},
+	{"INFO", handleInfo, -1, "", 0, 0, 0},
+	{"LATENCY", handleLatency, -2, "admin", 0, 0, 0},
+	{"COMMAND", handleCommand, -1, "", 0, 0, 0},
+	{"CLIENT", handleClient, -2, "", 0, 0, 0},
+	{"HELLO", handleHello, -1, "fast", 0, 0, 0},
+	{"SUBSCRIBE", handleSubscribe, -2, "pubsub", 0, 0, 0},
+	{"PSUBSCRIBE", handlePSubscribe, -2, "pubsub", 0, 0, 0},
+	{"UNSUBSCRIBE", handleUnsubscribe, -1, "pubsub", 0, 0, 0},
+	{"PUNSUBSCRIBE", handlePUnsubscribe, -1, "pubsub", 0, 0, 0},
+	{"PUBLISH", handlePublish, 3, "pubsub fast", 0, 0, 0},
+	{"PUBSUB", handlePubSub, -2, "pubsub", 0, 0, 0},
+	{"MULTI", handleMulti, 1, "fast", 0, 0, 0},
+	{"EXEC", handleExec, 1, "", 0, 0, 0},
+	{"DISCARD", handleDiscard, 1, "fast", 0, 0, 0},
+	{"EVAL", handleEval, -3, "noscript", 0, 0, 0},
+	{"EVAL_RO", handleEval, -3, "noscript readonly", 0, 0, 0},
+	{"EVALSHA", handleEvalSha, -3, "noscript", 0, 0, 0},
+	{"EVALSHA_RO", handleEvalSha, -3, "noscript readonly", 0, 0, 0},
+	{"FCALL", handleEval, -3, "noscript", 0, 0, 0},
+	{"FCALL_RO", handleEval, -3, "noscript readonly", 0, 0, 0},
+	{"SCRIPT", handleScript, -2, "noscript", 0, 0, 0},
+	{"FUNCTION", handleFunction, -2, "noscript", 0, 0, 0},
+}
+
+func init() {
+	for i := range commandSpecs {
+		cmd := &commandSpecs[i]
+		if _, dup := commandTable[cmd.name]; dup {
+			panic(fmt.Sprintf("command '%s' is registered twice", cmd.name))
+		}
+		commandTable[cmd.name] = cmd
+	}
+}
+
+// checkArity reports whether argc arguments (the command name included)
+// satisfy the command's arity
+func (cmd *Command) checkArity(argc int) bool {
+	if cmd.arity > 0 {
+		return argc == cmd.arity
+	}
+	return argc >= -cmd.arity
+}
+
+// commandInfo renders a command the way COMMAND and COMMAND INFO report it
+func commandInfo(cmd *Command) []any {
+	flags := make([]any, 0)
+	for _, flag := range strings.Fields(cmd.flags) {
+		flags = append(flags, flag)
+	}
+	return []any{strings.ToLower(cmd.name), cmd.arity, flags, cmd.firstKey, cmd.lastKey, cmd.keyStep}
+}
+
+// handleCommand implements COMMAND, COMMAND COUNT, COMMAND INFO and COMMAND LIST
+func handleCommand(args []string, conn net.Conn) {
+	if len(args) == 1 {
+		result := make([]any, 0, len(commandTable))
+		for _, cmd := range commandTable {
+			result = append(result, commandInfo(cmd))
+		}
+		writeValue(conn, result)
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "COUNT":
+		writeInteger(conn, len(commandTable))
+	case "INFO":
+		result := make([]any, 0, len(args)-2)
+		for _, name := range args[2:] {
+			if cmd, ok := commandTable[strings.ToUpper(name)]; ok {
+				result = append(result, commandInfo(cmd))
+			} else {
+				result = append(result, nil)
+			}
+		}
+		writeValue(conn, result)
+	case "LIST":
+		names := make([]string, 0, len(commandTable))
+		for _, cmd := range commandTable {
+			names = append(names, strings.ToLower(cmd.name))
+		}
+		writeArray(conn, names)
+	case "DOCS":
+		// no documentation is bundled; an empty reply keeps redis-cli happy
+		writeArray(conn, []string{})
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try COMMAND HELP.", args[1]))
+	}
 }
 
 // Command handlers
+// handlePing replies PONG, or with a pong message when a RESP2 client is
+// subscribed and its replies share the connection with messages
 func handlePing(args []string, conn net.Conn) {
+	if client, ok := conn.(*Client); ok && client.proto != 3 && subscriptionCount(client) > 0 {
+		writeValue(conn, []any{"pong", ""})
+		return
+	}
 	writeSimpleString(conn, "PONG")
 }
 
 func handleEcho(args []string, conn net.Conn) {
-	if len(args) < 2 {
-		writeError(conn, "wrong number of arguments for 'echo' command")
+	writeBulkString(conn, args[1])
+}
+
+// expireDeadline converts the argument of an EX, PX, EXAT or PXAT option into
+// an absolute deadline. command names the command in error messages.
+func expireDeadline(option, arg, command string) (time.Time, error) {
+	n, ok := parseStrictInt(arg)
+	if !ok {
+		return time.Time{}, fmt.Errorf("value is not an integer or out of range")
+	}
+	invalid := fmt.Errorf("invalid expire time in '%s' command", command)
+	if n <= 0 {
+		return time.Time{}, invalid
+	}
+
+	switch option {
+	case "EX", "EXAT":
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		n *= 1000
+	}
+
+	switch option {
+	case "EX", "PX":
+		if n > math.MaxInt64-clock.Now().UnixMilli() {
+			return time.Time{}, invalid
+		}
+		return clock.Now().Add(time.Duration(n) * time.Millisecond), nil
+	default:
+		return time.UnixMilli(n), nil
+	}
+}
+
+// handleSet implements
+// SET key value [NX | XX] [GET] [EX s | PX ms | EXAT unix-s | PXAT unix-ms | KEEPTTL]
+func handleSet(args []string, conn net.Conn) {
+	key := args[1]
+	value := args[2]
+
+	var expiresAt = time.Time{} // zero time. Will not expire by default
+	var nx, xx, get, keepTTL, hasExpire bool
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) || hasExpire {
+				writeError(conn, "syntax error")
+				return
+			}
+			deadline, err := expireDeadline(option, args[i+1], "set")
+			if err != nil {
+				writeError(conn, err.Error())
+				return
+			}
+			expiresAt = deadline
+			hasExpire = true
+			i++
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+	if (nx && xx) || (keepTTL && hasExpire) {
+		writeError(conn, "syntax error")
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	// with GET the previous value is returned, so it must be a string
+	var oldValue *string
+	current, exists := lookupKey(key)
+	if exists && get {
+		entry, ok := current.(Entry)
+		if !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		oldValue = &entry.value
+	}
+
+	// a failed NX/XX condition replies null, or the old value with GET
+	if (nx && exists) || (xx && !exists) {
+		if get && oldValue != nil {
+			writeBulkString(conn, *oldValue)
+		} else {
+			writeNullBulkString(conn)
+		}
+		return
+	}
+
+	// KEEPTTL carries the current deadline over, whatever the old type was
+	if keepTTL && exists {
+		expiresAt = expiresAtOf(current)
+	}
+
+	// if no expiration is set, use a zero time.Time value.
+	entry := Entry{value: value, expiresAt: expiresAt}
+	DB.Store(key, entry)
+
+	if !get {
+		writeSimpleString(conn, "OK")
+	} else if oldValue != nil {
+		writeBulkString(conn, *oldValue)
+	} else {
+		writeNullBulkString(conn)
+	}
+}
+
+func handleGet(args []string, conn net.Conn) {
+	value, ok, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !ok {
+		writeNullBulkString(conn)
+		return
+	}
+
+	entry, ok := value.(Entry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	writeBulkString(conn, entry.value)
+}
+
+// setWithExpire implements SETEX and PSETEX: key unit-count value. Unlike
+// SET EX, the TTL comes before the value and is mandatory.
+func setWithExpire(args []string, conn net.Conn, option string) {
+	key := args[1]
+	expiresAt, err := expireDeadline(option, args[2], strings.ToLower(args[0]))
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	DB.Store(key, Entry{value: args[3], expiresAt: expiresAt})
+	writeSimpleString(conn, "OK")
+}
+
+func handleSetEx(args []string, conn net.Conn) {
+	setWithExpire(args, conn, "EX")
+}
+
+func handlePSetEx(args []string, conn net.Conn) {
+	setWithExpire(args, conn, "PX")
+}
+
+// handleSetNX sets key only if it doesn't exist, replying 1 if it was set
+// and 0 otherwise
+func handleSetNX(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	if _, exists := lookupKey(key); exists {
+		writeInteger(conn, 0)
+		return
+	}
+	DB.Store(key, Entry{value: args[2]})
+	writeInteger(conn, 1)
+}
+
+// handleGetSet sets key to a new value and replies with the old one, or null
+// if there was none, in one atomic step. Like SET, it clears any TTL.
+func handleGetSet(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	current, exists := lookupKey(key)
+	var old Entry
+	if exists {
+		var ok bool
+		if old, ok = current.(Entry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	}
+
+	DB.Store(key, Entry{value: args[2]})
+	if !exists {
+		writeNullBulkString(conn)
+		return
+	}
+	writeBulkString(conn, old.value)
+}
+
+// handleGetEx returns the value of key and optionally changes its TTL in the
+// same step: GETEX key [EX s | PX ms | EXAT unix-s | PXAT unix-ms | PERSIST]
+func handleGetEx(args []string, conn net.Conn) {
+	key := args[1]
+
+	var expiresAt time.Time
+	var persist, hasExpire bool
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "PERSIST":
+			if hasExpire {
+				writeError(conn, "syntax error")
+				return
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) || hasExpire || persist {
+				writeError(conn, "syntax error")
+				return
+			}
+			deadline, err := expireDeadline(option, args[i+1], "getex")
+			if err != nil {
+				writeError(conn, err.Error())
+				return
+			}
+			expiresAt = deadline
+			hasExpire = true
+			i++
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, ok := lookupKey(key)
+	if !ok {
+		writeNullBulkString(conn)
+		return
+	}
+	entry, ok := value.(Entry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	if hasExpire || persist {
+		// PERSIST leaves expiresAt as the zero time, clearing the TTL
+		DB.Store(key, withExpiresAt(entry, expiresAt))
+	}
+	writeBulkString(conn, entry.value)
+}
+
+// parseStrictInt parses a base-10 int64 the way Redis does: no surrounding
+// whitespace, no '+' sign, no leading zeros and no "-0"
+func parseStrictInt(str string) (int64, bool) {
+	digits := strings.TrimPrefix(str, "-")
+	if digits == "" || digits[0] < '0' || digits[0] > '9' {
+		return 0, false
+	}
+	if digits[0] == '0' && (len(digits) > 1 || len(str) > 1) {
+		return 0, false
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		// out of the int64 range
+		return 0, false
+	}
+	return n, true
+}
+
+// parseIntArg parses a command argument held as an int, such as an index or
+// a count, as strictly as parseStrictInt
+func parseIntArg(str string) (int, bool) {
+	n, ok := parseStrictInt(str)
+	return int(n), ok
+}
+
+// parseStrictFloat parses a float the way Redis does for INCRBYFLOAT and
+// friends: decimal notation with an optional exponent, or inf with an
+// optional sign. It refuses surrounding whitespace, NaN, and the hex floats,
+// underscores and "infinity" spellings strconv would otherwise take.
+func parseStrictFloat(str string) (float64, bool) {
+	if str == "" || strings.ContainsAny(str, "xX_") || strings.TrimSpace(str) != str {
+		return 0, false
+	}
+	unsigned := strings.TrimLeft(str, "+-")
+	if len(unsigned) > 0 && (unsigned[0] == 'i' || unsigned[0] == 'I') && !strings.EqualFold(unsigned, "inf") {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// formatFloat renders a float for INCRBYFLOAT-style replies: plain decimal
+// notation, never an exponent, with trailing zeros trimmed. Hash float
+// increments must use the same formatting.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// incrementBy adds delta to the integer stored at key, creating it as 0 if
+// it doesn't exist, and replies with the new value. The key keeps its TTL.
+func incrementBy(key string, delta int64, conn net.Conn) {
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var entry Entry
+	current := int64(0)
+	if value, ok := lookupKey(key); ok {
+		var isString bool
+		entry, isString = value.(Entry)
+		if !isString {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		n, valid := parseStrictInt(entry.value)
+		if !valid {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+		current = n
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		writeError(conn, "increment or decrement would overflow")
+		return
+	}
+
+	current += delta
+	entry = Entry{value: strconv.FormatInt(current, 10), expiresAt: entry.expiresAt}
+	DB.Store(key, entry)
+	writeInteger(conn, int(current))
+}
+
+// handleIncrByFloat adds a floating point increment to the value stored at
+// key and replies with the new value. The key keeps its TTL.
+func handleIncrByFloat(args []string, conn net.Conn) {
+	key := args[1]
+	delta, ok := parseStrictFloat(args[2])
+	if !ok {
+		writeError(conn, "value is not a valid float")
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var entry Entry
+	current := 0.0
+	if value, exists := lookupKey(key); exists {
+		var isString bool
+		entry, isString = value.(Entry)
+		if !isString {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		f, valid := parseStrictFloat(entry.value)
+		if !valid {
+			writeError(conn, "value is not a valid float")
+			return
+		}
+		current = f
+	}
+
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		writeError(conn, "increment would produce NaN or Infinity")
+		return
+	}
+
+	entry = Entry{value: formatFloat(current), expiresAt: entry.expiresAt}
+	DB.Store(key, entry)
+	writeBulkString(conn, entry.value)
+}
+
+func handleIncr(args []string, conn net.Conn) {
+	incrementBy(args[1], 1, conn)
+}
+
+func handleDecr(args []string, conn net.Conn) {
+	incrementBy(args[1], -1, conn)
+}
+
+func handleIncrBy(args []string, conn net.Conn) {
+	delta, ok := parseStrictInt(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+	incrementBy(args[1], delta, conn)
+}
+
+func handleDecrBy(args []string, conn net.Conn) {
+	delta, ok := parseStrictInt(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+	// negating MinInt64 would itself overflow
+	if delta == math.MinInt64 {
+		writeError(conn, "decrement would overflow")
+		return
+	}
+	incrementBy(args[1], -delta, conn)
+}
+
+// handleGetRange returns the substring of the value stored at key between
+// the start and end byte offsets, both inclusive; negative offsets count
+// from the end of the string
+func handleGetRange(args []string, conn net.Conn) {
+	start, ok := parseIntArg(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+	end, ok := parseIntArg(args[3])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+
+	value, ok, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !ok {
+		writeBulkString(conn, "")
+		return
+	}
+	entry, ok := value.(Entry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	strLen := len(entry.value)
+	if start < 0 {
+		start = max(strLen+start, 0)
+	}
+	if end < 0 {
+		end = max(strLen+end, 0)
+	}
+	end = min(end, strLen-1)
+
+	if strLen == 0 || start > end {
+		writeBulkString(conn, "")
+		return
+	}
+	writeBulkString(conn, entry.value[start:end+1])
+}
+
+// handleSetRange overwrites part of the string stored at key starting at the
+// given byte offset, padding with zero bytes if the string is too short, and
+// replies with the new length. The key keeps its TTL.
+func handleSetRange(args []string, conn net.Conn) {
+	key := args[1]
+	patch := args[3]
+	offset, ok := parseIntArg(args[2])
+	if !ok || offset < 0 {
+		writeError(conn, "offset is out of range")
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var entry Entry
+	value, exists := lookupKey(key)
+	if exists {
+		var ok bool
+		entry, ok = value.(Entry)
+		if !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	}
+
+	// an empty patch never changes anything, and never creates the key
+	if len(patch) == 0 {
+		writeInteger(conn, len(entry.value))
+		return
+	}
+	if int64(offset)+int64(len(patch)) > protoMaxBulkLen.value.Load() {
+		writeError(conn, "string exceeds maximum allowed size (proto-max-bulk-len)")
+		return
+	}
+
+	buf := []byte(entry.value)
+	if need := offset + len(patch); need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	copy(buf[offset:], patch)
+
+	entry.value = string(buf)
+	entry.raw = true
+	DB.Store(key, entry)
+	writeInteger(conn, len(entry.value))
+}
+
+// handleAppend appends a value to the string stored at key, creating the key
+// if it doesn't exist, and replies with the new length. The key keeps its
+// TTL.
+func handleAppend(args []string, conn net.Conn) {
+	key := args[1]
+	suffix := args[2]
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		// a new key is stored as given, so it may still be an int or embstr
+		DB.Store(key, Entry{value: suffix})
+		writeInteger(conn, len(suffix))
+		return
+	}
+	entry, ok := value.(Entry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	if int64(len(entry.value))+int64(len(suffix)) > protoMaxBulkLen.value.Load() {
+		writeError(conn, "string exceeds maximum allowed size (proto-max-bulk-len)")
+		return
+	}
+
+	entry.value += suffix
+	entry.raw = true
+	DB.Store(key, entry)
+	writeInteger(conn, len(entry.value))
+}
+
+// handleCAS implements CAS key expected new [NX], setting key to a new value
+// only if it currently holds the expected one. A missing key is a mismatch
+// unless NX is given, in which case the key is created with no expiry. A
+// successful swap of an existing key keeps its expiry.
+func handleCAS(args []string, conn net.Conn) {
+	key := args[1]
+	expected := args[2]
+	createMissing := false
+	if len(args) > 4 {
+		if len(args) > 5 || !strings.EqualFold(args[4], "NX") {
+			writeError(conn, "syntax error")
+			return
+		}
+		createMissing = true
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, ok := lookupKey(key)
+	if !ok {
+		if !createMissing {
+			writeInteger(conn, 0)
+			return
+		}
+		DB.Store(key, Entry{value: args[3]})
+		writeInteger(conn, 1)
+		return
+	}
+	entry, isString := value.(Entry)
+	if !isString {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	if entry.value != expected {
+		writeInteger(conn, 0)
+		return
+	}
+
+	DB.Store(key, Entry{value: args[3], expiresAt: entry.expiresAt})
+	writeInteger(conn, 1)
+}
+
+func handleType(args []string, conn net.Conn) {
+	value, ok, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !ok {
+		writeSimpleString(conn, "none")
+		return
+	}
+
+	writeSimpleString(conn, typeName(value))
+}
+
+// handleMGetType returns the values of the given keys that hold the requested
+// type, with nulls in place of keys that are missing or of any other type
+func handleMGetType(args []string, conn net.Conn) {
+	wantType := strings.ToLower(args[1])
+	keys := args[2:]
+
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		value, ok := lookupKey(key)
+		if ok && typeName(value) == wantType {
+			values[i] = valueReply(value)
+		}
+	}
+	writeValue(conn, values)
+}
+
+// valueReply renders a whole value the way reading it back would reply
+// with it: a string as itself, a list as its elements, a set as its sorted
+// members, a hash or sorted set as a flat array of fields and values or
+// members and scores, and a stream as its entries
+func valueReply(value any) any {
+	switch v := value.(type) {
+	case Entry:
+		return v.value
+	case ListEntry:
+		return v.list.elements()
+	case HashEntry:
+		fields := hashFields(v)
+		pairs := make([]string, 0, 2*len(fields))
+		for _, field := range fields {
+			pairs = append(pairs, field, v.fields[field])
+		}
+		return pairs
+	case SetEntry:
+		return setMembers(v)
+	case ZSetEntry:
+		items := v.zset.items()
+		pairs := make([]string, 0, 2*len(items))
+		for _, item := range items {
+			pairs = append(pairs, item.member, formatScore(item.score))
+		}
+		return pairs
+	case StreamEntry:
+		return streamEntriesReply(v.entries)
+	}
+	return nil
+}
+
+// stringValues looks up the string values of keys, leaving nil for keys that
+// are missing or hold another type
+func stringValues(keys []string) []*string {
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	values := make([]*string, len(keys))
+	for i, key := range keys {
+		value, ok := lookupKey(key)
+		if !ok {
+			continue
+		}
+		if entry, isString := value.(Entry); isString {
+			values[i] = &entry.value
+		}
+	}
+	return values
+}
+
+// handleMGet returns the values of all given keys, with nulls for keys that
+// are missing or don't hold a string
+func handleMGet(args []string, conn net.Conn) {
+	writeNullableArray(conn, stringValues(args[1:]))
+}
+
+// handleMSet sets every given key to its value, clearing any TTLs
+func handleMSet(args []string, conn net.Conn) {
+	if len(args)%2 != 1 {
+		writeError(conn, "wrong number of arguments for 'mset' command")
+		return
+	}
+
+	unlock := DB.Lock(msetKeys(args)...)
+	defer unlock()
+
+	for i := 1; i < len(args); i += 2 {
+		DB.Store(args[i], Entry{value: args[i+1]})
+	}
+	writeSimpleString(conn, "OK")
+}
+
+// handleMSetNX sets the given keys only if none of them exists, as a single
+// all-or-nothing step
+func handleMSetNX(args []string, conn net.Conn) {
+	if len(args)%2 != 1 {
+		writeError(conn, "wrong number of arguments for 'msetnx' command")
+		return
+	}
+
+	unlock := DB.Lock(msetKeys(args)...)
+	defer unlock()
+
+	for i := 1; i < len(args); i += 2 {
+		if _, exists := lookupKey(args[i]); exists {
+			writeInteger(conn, 0)
+			return
+		}
+	}
+	for i := 1; i < len(args); i += 2 {
+		DB.Store(args[i], Entry{value: args[i+1]})
+	}
+	writeInteger(conn, 1)
+}
+
+// msetKeys returns the keys of an MSET-style key/value argument list
+func msetKeys(args []string) []string {
+	keys := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// pushGeneric implements LPUSH, RPUSH, LPUSHX and RPUSHX: it adds elements
+// to the head (left) or tail of a list and replies with the new length.
+// With onlyIfExists a missing key is left alone and the reply is 0.
+func pushGeneric(args []string, conn net.Conn, left, onlyIfExists bool) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	var listEntry ListEntry
+
+	if exists {
+		var ok bool
+		listEntry, ok = value.(ListEntry)
+		if !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	} else if onlyIfExists {
+		writeInteger(conn, 0)
+		return
+	} else {
+		// key doesn't exist, create new list
+		listEntry = ListEntry{list: newQuicklist()}
+	}
+
+	if err := checkCollectionLimits(listEntry.list.len()+len(args)-2, args[2:]...); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	// with LPUSH each element is pushed onto the head in turn, so they end
+	// up in reverse argument order
+	for _, element := range args[2:] {
+		listEntry.list.push(element, left)
+	}
+
+	DB.Store(key, withEncoding(listEntry))
+
+	// the reply is the length after the push, before any blocked client
+	// takes elements from the list
+	length := listEntry.list.len()
+
+	// Notify any blocked clients waiting for this list
+	notifyBlockedClients(key)
+
+	// return the number of elements in the list
+	writeInteger(conn, length)
+}
+
+// appends elements to a list
+func handleRPush(args []string, conn net.Conn) {
+	pushGeneric(args, conn, false, false)
+}
+
+// prepends elements to a list
+func handleLPush(args []string, conn net.Conn) {
+	pushGeneric(args, conn, true, false)
+}
+
+// handleRPushX appends elements to a list only if it already exists
+func handleRPushX(args []string, conn net.Conn) {
+	pushGeneric(args, conn, false, true)
+}
+
+// handleLPushX prepends elements to a list only if it already exists
+func handleLPushX(args []string, conn net.Conn) {
+	pushGeneric(args, conn, true, true)
+}
+
+// handleLInsert implements LINSERT key BEFORE|AFTER pivot element. It
+// replies with the new length, -1 if the pivot isn't in the list, or 0 if
+// the key doesn't exist.
+func handleLInsert(args []string, conn net.Conn) {
+	key, pivot, element := args[1], args[3], args[4]
+
+	var after bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		writeError(conn, "syntax error")
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	listEntry, ok := value.(ListEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	index := -1
+	listEntry.list.each(false, func(i int, e string) bool {
+		if e == pivot {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		writeInteger(conn, -1)
+		return
+	}
+	if err := checkCollectionLimits(listEntry.list.len()+1, element); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	if after {
+		index++
+	}
+	listEntry.list.insert(index, element)
+	DB.Store(key, withEncoding(listEntry))
+	writeInteger(conn, listEntry.list.len())
+}
+
+// handleLSet replaces the element at index, which may be negative to count
+// from the tail
+func handleLSet(args []string, conn net.Conn) {
+	key, element := args[1], args[3]
+	index, ok := parseIntArg(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeError(conn, "no such key")
+		return
+	}
+	listEntry, ok := value.(ListEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	if index < 0 {
+		index += listEntry.list.len()
+	}
+	if index < 0 || index >= listEntry.list.len() {
+		writeError(conn, "index out of range")
+		return
+	}
+	if err := checkCollectionLimits(listEntry.list.len(), element); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	listEntry.list.set(index, element)
+	DB.Store(key, withEncoding(listEntry))
+	writeSimpleString(conn, "OK")
+}
+
+// handleLRem removes occurrences of element: the first count from the head
+// if count is positive, the last -count from the tail if it is negative, or
+// all of them if it is 0. It replies with the number removed.
+func handleLRem(args []string, conn net.Conn) {
+	key, element := args[1], args[3]
+	count, ok := parseIntArg(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	listEntry, ok := value.(ListEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	limit := listEntry.list.len()
+	if count > 0 {
+		limit = min(count, limit)
+	} else if count < 0 && -count > 0 {
+		limit = min(-count, limit)
+	}
+
+	// a negative count walks from the tail, so filter the list backwards
+	// and flip the result
+	removed := 0
+	kept := make([]string, 0, listEntry.list.len())
+	listEntry.list.each(count < 0, func(_ int, e string) bool {
+		if e == element && removed < limit {
+			removed++
+		} else {
+			kept = append(kept, e)
+		}
+		return true
+	})
+	if count < 0 {
+		slices.Reverse(kept)
+	}
+
+	if removed == 0 {
+		writeInteger(conn, 0)
+		return
+	}
+
+	if len(kept) == 0 {
+		DB.Delete(key)
+	} else {
+		listEntry.list = newQuicklist(kept...)
+		DB.Store(key, listEntry)
+	}
+	writeInteger(conn, removed)
+}
+
+// handleLPos implements LPOS key element [RANK rank] [COUNT num] [MAXLEN len].
+// RANK picks which match to start from, negative to search from the tail;
+// COUNT asks for that many matches (0 for all) as an array; MAXLEN caps the
+// number of elements compared.
+func handleLPos(args []string, conn net.Conn) {
+	key, element := args[1], args[2]
+
+	rank, count, maxLen := 1, -1, 0
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if (option != "RANK" && option != "COUNT" && option != "MAXLEN") || i+1 >= len(args) {
+			writeError(conn, "syntax error")
+			return
+		}
+		n, ok := parseIntArg(args[i+1])
+		if !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+
+		switch option {
+		case "RANK":
+			if n == 0 {
+				writeError(conn, "RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				return
+			}
+			if n == math.MinInt {
+				writeError(conn, "value is out of range")
+				return
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				writeError(conn, "COUNT can't be negative")
+				return
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				writeError(conn, "MAXLEN can't be negative")
+				return
+			}
+			maxLen = n
+		}
+		i++
+	}
+
+	value, exists, unlock := lookupKeyRead(key)
+	defer unlock()
+	if !exists {
+		if count >= 0 {
+			writeArray(conn, []string{})
+		} else {
+			writeNullBulkString(conn)
+		}
 		return
 	}
-	writeBulkString(conn, args[1])
-}
-
-func handleSet(args []string, conn net.Conn) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'set' command")
+	listEntry, ok := value.(ListEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
 		return
 	}
 
-	key := args[1]
-	value := args[2]
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	limit := count
+	if limit <= 0 {
+		limit = listEntry.list.len()
+	}
 
-	// check for optional PX argument
-	var expiresAt = time.Time{} // zero time. Will not expire by default
-	if len(args) > 4 {
-		for i := 3; i < len(args)-1; i++ {
-			if strings.ToUpper(args[i]) == "PX" {
-				ms, err := strconv.Atoi(args[i+1])
-				if err != nil {
-					writeError(conn, "PX value must be integer")
-					return
-				}
-				expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
-			}
+	var matches []any
+	compared := 0
+	listEntry.list.each(rank < 0, func(index int, e string) bool {
+		if maxLen > 0 && compared >= maxLen {
+			return false
 		}
-	}
-	// if no expiration is set, use a zero time.Time value.
-	entry := Entry{value: value, expiresAt: expiresAt}
-	DB.Store(key, entry)
-	writeSimpleString(conn, "OK")
-}
+		compared++
+		if e != element {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		matches = append(matches, index)
+		return len(matches) < limit
+	})
 
-func handleGet(args []string, conn net.Conn) {
-	if len(args) < 2 {
-		writeError(conn, "wrong number of arguments for 'get' command")
-		return
+	if count >= 0 {
+		writeValue(conn, matches)
+	} else if len(matches) == 0 {
+		writeNullBulkString(conn)
+	} else {
+		writeInteger(conn, matches[0].(int))
 	}
+}
 
+// handleLTrim trims a list down to the elements between start and stop,
+// both inclusive and possibly negative, deleting the key if nothing is left
+func handleLTrim(args []string, conn net.Conn) {
 	key := args[1]
-	value, ok := DB.Load(key)
+	start, ok := parseIntArg(args[2])
 	if !ok {
-		writeNullBulkString(conn)
+		writeError(conn, "value is not an integer or out of range")
 		return
 	}
-
-	entry := value.(Entry)
-	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
-		DB.Delete(key)
-		writeNullBulkString(conn)
+	stop, ok := parseIntArg(args[3])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
 		return
 	}
 
-	writeBulkString(conn, entry.value)
-}
+	unlock := DB.Lock(key)
+	defer unlock()
 
-func handleType(args []string, conn net.Conn) {
-	if len(args) < 2 {
-		writeError(conn, "wrong number of arguments for 'type' command")
+	value, exists := lookupKey(key)
+	if !exists {
+		writeSimpleString(conn, "OK")
 		return
 	}
-
-	key := args[1]
-	value, ok := DB.Load(key)
+	listEntry, ok := value.(ListEntry)
 	if !ok {
-		writeSimpleString(conn, "none")
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
 		return
 	}
 
-	// determine the type based on the value's type
-	switch v := value.(type) {
-	case Entry:
-		// check if the entry has expired
-		if !v.expiresAt.IsZero() && time.Now().After(v.expiresAt) {
-			DB.Delete(key)
-			writeSimpleString(conn, "none")
-			return
-		}
-		writeSimpleString(conn, "string")
-	case ListEntry:
-		writeSimpleString(conn, "list")
-	case StreamEntry:
-		writeSimpleString(conn, "stream")
-	default:
-		// unknown type
-		writeSimpleString(conn, "none")
+	listLen := listEntry.list.len()
+	if start < 0 {
+		start = max(listLen+start, 0)
+	}
+	if stop < 0 {
+		stop = listLen + stop
+	}
+	stop = min(stop, listLen-1)
+
+	if start > stop {
+		DB.Delete(key)
+		writeSimpleString(conn, "OK")
+		return
 	}
+
+	listEntry.list.trim(start, stop)
+	DB.Store(key, listEntry)
+	writeSimpleString(conn, "OK")
 }
 
-func handleRPush(args []string, conn net.Conn) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'rpush' command")
+// popGeneric implements LPOP and RPOP: it removes and returns the first
+// (left) or last element of a list, or with a count up to that many
+// elements as an array
+func popGeneric(args []string, conn net.Conn, left bool) {
+	if len(args) > 3 {
+		writeError(conn, fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(args[0])))
 		return
 	}
 
 	key := args[1]
-	value, exists := DB.Load(key)
-	var listEntry ListEntry
+	count := 1 // default count is 1
 
-	if exists {
+	// parse optional count parameter
+	if len(args) == 3 {
 		var ok bool
-		listEntry, ok = value.(ListEntry)
-		if !ok {
-			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		count, ok = parseIntArg(args[2])
+		if !ok || count < 0 {
+			writeError(conn, "value is not an integer or out of range")
 			return
 		}
-	} else {
-		// key doesn't exist, create new list
-		listEntry = ListEntry{elements: make([]string, 0)}
-	}
-
-	// Append all elements to the list (support for multiple values)
-	for i := 2; i < len(args); i++ {
-		listEntry.elements = append(listEntry.elements, args[i])
-	}
-
-	DB.Store(key, listEntry)
-
-	// Notify any blocked clients waiting for this list
-	notifyBlockedClients(key)
-
-	// return the number of elements in the list
-	writeInteger(conn, len(listEntry.elements))
-}
-
-// prepends elements to a list
-func handleLPush(args []string, conn net.Conn) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'lpush' command")
-		return
 	}
 
-	key := args[1]
-	value, exists := DB.Load(key)
-	var listEntry ListEntry
+	unlock := DB.Lock(key)
+	defer unlock()
 
+	// retrieve the list from the DB
+	value, exists := lookupKey(key)
 	if exists {
-		var ok bool
-		listEntry, ok = value.(ListEntry)
-		if !ok {
+		if _, ok := value.(ListEntry); !ok {
 			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
 			return
 		}
-	} else {
-		// key doesn't exist, create new list
-		listEntry = ListEntry{elements: make([]string, 0)}
 	}
 
-	// prepend all elements to the list (support for multiple values)
-	for i := 2; i < len(args); i++ {
-		// insert the element at the beginning
-		listEntry.elements = append([]string{args[i]}, listEntry.elements...)
+	var popped []string
+	if exists && count > 0 {
+		popped = popListElements(key, left, count)
 	}
 
-	DB.Store(key, listEntry)
-
-	// Notify any blocked clients waiting for this list
-	notifyBlockedClients(key)
-
-	// return the number of elements in the list
-	writeInteger(conn, len(listEntry.elements))
+	// return response based on whether count was specified
+	switch {
+	case len(args) == 3:
+		// when count is specified, always return an array, empty if the
+		// key doesn't exist
+		writeArray(conn, popped)
+	case len(popped) == 0:
+		// when no count specified and key doesn't exist, return null
+		writeNullBulkString(conn)
+	default:
+		// when no count specified, return single bulk string
+		writeBulkString(conn, popped[0])
+	}
 }
 
 // handleLPop removes and returns the first element of a list
 func handleLPop(args []string, conn net.Conn) {
-	if len(args) < 2 || len(args) > 3 {
-		writeError(conn, "wrong number of arguments for 'lpop' command")
-		return
-	}
+	popGeneric(args, conn, true)
+}
 
-	key := args[1]
-	count := 1 // default count is 1
+// handleRPop removes and returns the last element of a list
+func handleRPop(args []string, conn net.Conn) {
+	popGeneric(args, conn, false)
+}
 
-	// parse optional count parameter
-	if len(args) == 3 {
-		var err error
-		count, err = strconv.Atoi(args[2])
-		if err != nil || count < 0 {
-			writeError(conn, "value is not an integer or out of range")
-			return
-		}
+// errWrongType is the error for a key holding a value of the wrong type
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// parseListSide parses the LEFT or RIGHT argument of LMOVE and friends,
+// reporting whether it names the head of the list
+func parseListSide(arg string) (left bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
 	}
+	return false, false
+}
 
-	// retrieve the list from the DB
-	value, exists := DB.Load(key)
+// listMove pops an element from one end of the list at src and pushes it
+// onto one end of the list at dst, which is created if needed and may be
+// src itself. It reports false if src doesn't exist. The caller must hold
+// the write locks of both keys, which makes the move atomic.
+func listMove(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	value, exists := lookupKey(src)
 	if !exists {
-		if len(args) == 3 {
-			// when count is specified and key doesn't exist, return empty array
-			writeArray(conn, []string{})
-		} else {
-			// when no count specified and key doesn't exist, return null
-			writeNullBulkString(conn)
-		}
-		return
+		return "", false, nil
 	}
-
-	listEntry, ok := value.(ListEntry)
+	source, ok := value.(ListEntry)
 	if !ok {
-		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
-		return
+		return "", false, errWrongType
 	}
 
-	// if the list is empty
-	if len(listEntry.elements) == 0 {
-		if len(args) == 3 {
-			// when count is specified and list is empty, return empty array
-			writeArray(conn, []string{})
-		} else {
-			// when no count specified and list is empty, return null
-			writeNullBulkString(conn)
+	// check the destination before touching anything
+	var dest ListEntry
+	if dst != src {
+		if value, exists := lookupKey(dst); exists {
+			if dest, ok = value.(ListEntry); !ok {
+				return "", false, errWrongType
+			}
 		}
-		return
 	}
 
-	// determine how many elements to actually remove
-	elementsToRemove := min(count, len(listEntry.elements))
-
-	// get the elements to return
-	removedElements := listEntry.elements[:elementsToRemove]
-
-	// remove the elements from the slice
-	listEntry.elements = listEntry.elements[elementsToRemove:]
+	// the lists change in place, so check the destination's limits before
+	// popping anything
+	element, _ := source.list.peek(fromLeft)
+	if dst == src {
+		source.list.pop(fromLeft)
+		source.list.push(element, toLeft)
+		DB.Store(src, source)
+		return element, true, nil
+	}
 
-	// if the list becomes empty after popping, remove the key from the DB
-	if len(listEntry.elements) == 0 {
-		DB.Delete(key)
+	if dest.list == nil {
+		dest.list = newQuicklist()
+	}
+	if err := checkCollectionLimits(dest.list.len()+1, element); err != nil {
+		return "", false, err
+	}
+	source.list.pop(fromLeft)
+	dest.list.push(element, toLeft)
+	if source.list.len() == 0 {
+		DB.Delete(src)
 	} else {
-		// Otherwise, store the updated list back
-		DB.Store(key, listEntry)
+		DB.Store(src, source)
 	}
+	DB.Store(dst, withEncoding(dest))
+	notifyBlockedClients(dst)
+	return element, true, nil
+}
 
-	// return response based on whether count was specified
-	if len(args) == 3 {
-		// when count is specified, always return an array
-		writeArray(conn, removedElements)
-	} else {
-		// when no count specified, return single bulk string
-		writeBulkString(conn, removedElements[0])
+// handleLMove implements LMOVE source destination LEFT|RIGHT LEFT|RIGHT,
+// replying with the moved element or null if the source doesn't exist
+func handleLMove(args []string, conn net.Conn) {
+	fromLeft, ok1 := parseListSide(args[3])
+	toLeft, ok2 := parseListSide(args[4])
+	if !ok1 || !ok2 {
+		writeError(conn, "syntax error")
+		return
 	}
+	lmoveGeneric(args[1], args[2], fromLeft, toLeft, conn)
 }
 
-// lists elements of a list between start and stop indexes, also supporting negative indexes
-func handleLRange(args []string, conn net.Conn) {
-	if len(args) != 4 {
-		writeError(conn, "wrong number of arguments for 'lrange' command")
+// handleRPopLPush is LMOVE source destination RIGHT LEFT
+func handleRPopLPush(args []string, conn net.Conn) {
+	lmoveGeneric(args[1], args[2], false, true, conn)
+}
+
+func lmoveGeneric(src, dst string, fromLeft, toLeft bool, conn net.Conn) {
+	unlock := DB.Lock(src, dst)
+	defer unlock()
+
+	element, moved, err := listMove(src, dst, fromLeft, toLeft)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	if !moved {
+		writeNullBulkString(conn)
 		return
 	}
+	writeBulkString(conn, element)
+}
 
+// lists elements of a list between start and stop indexes, also supporting negative indexes
+func handleLRange(args []string, conn net.Conn) {
 	key := args[1]
-	start, err := strconv.Atoi(args[2])
-	if err != nil {
+	start, ok := parseIntArg(args[2])
+	if !ok {
 		writeError(conn, "invalid start index")
 		return
 	}
-	stop, err := strconv.Atoi(args[3])
-	if err != nil {
+	stop, ok := parseIntArg(args[3])
+	if !ok {
 		writeError(conn, "invalid stop index")
 		return
 	}
 
 	// retrieve the list from the DB
-	value, exists := DB.Load(key)
+	value, exists, unlock := lookupKeyRead(key)
+	defer unlock()
 	if !exists {
 		// if list doesn't exist, return an empty array
 		writeArray(conn, []string{})
@@ -307,15 +1558,16 @@ func handleLRange(args []string, conn net.Conn) {
 		return
 	}
 
-	elems := listEntry.elements
-	listLen := len(elems)
+	listLen := listEntry.list.len()
 
 	// handle negative indexes
 	if start < 0 {
 		start = max(listLen+start, 0)
 	}
 	if stop < 0 {
-		stop = max(listLen+stop, 0)
+		// a stop index still negative after normalization lies before the
+		// head, so the range is empty; don't clamp it to the first element
+		stop = listLen + stop
 	}
 
 	// if start index is out of range, return empty array
@@ -334,18 +1586,13 @@ func handleLRange(args []string, conn net.Conn) {
 		return
 	}
 
-	result := elems[start : stop+1]
-	writeArray(conn, result)
+	writeArray(conn, listEntry.list.rangeElements(start, stop))
 }
 
 // returns the number of elements in a list
 func handleLLen(args []string, conn net.Conn) {
-	if len(args) != 2 {
-		writeError(conn, "wrong number of arguments for 'llen' command")
-		return
-	}
-	key := args[1]
-	value, exists := DB.Load(key)
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
 	if !exists {
 		writeInteger(conn, 0)
 		return
@@ -355,174 +1602,250 @@ func handleLLen(args []string, conn net.Conn) {
 		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
 		return
 	}
-	writeInteger(conn, len(listEntry.elements))
+	writeInteger(conn, listEntry.list.len())
 }
 
-// handleBLPop implements the blocking list pop command
-func handleBLPop(args []string, conn net.Conn) {
-	if len(args) < 3 {
-		writeError(conn, "wrong number of arguments for 'blpop' command")
-		return
+// popListElements pops up to count elements from the head (left) or tail of
+// the list at key, in pop order, deleting the key if it empties. It returns
+// nil if there is no list to pop from. The caller must hold the lock of key.
+func popListElements(key string, left bool, count int) []string {
+	value, exists := lookupKey(key)
+	if !exists {
+		return nil
+	}
+	listEntry, ok := value.(ListEntry)
+	if !ok || listEntry.list.len() == 0 {
+		return nil
+	}
+
+	popped := make([]string, 0, min(count, listEntry.list.len()))
+	for range cap(popped) {
+		element, _ := listEntry.list.pop(left)
+		popped = append(popped, element)
+	}
+
+	if listEntry.list.len() == 0 {
+		DB.Delete(key)
+	} else {
+		DB.Store(key, listEntry)
+	}
+	return popped
+}
+
+// popListElement pops a single element, see popListElements
+func popListElement(key string, left bool) (string, bool) {
+	popped := popListElements(key, left, 1)
+	if popped == nil {
+		return "", false
 	}
+	return popped[0], true
+}
 
+// blockingPopGeneric implements BLPOP and BRPOP: it pops from the head
+// (left) or tail of the first non-empty list among the keys, blocking until
+// one gets an element if they are all empty
+func blockingPopGeneric(args []string, conn net.Conn, left bool) {
 	// parse timeout (last argument) - can be a float
-	timeoutStr := args[len(args)-1]
-	timeout, err := strconv.ParseFloat(timeoutStr, 64)
+	deadline, err := parseBlockTimeout(args[len(args)-1])
 	if err != nil {
-		writeError(conn, "timeout is not a float or out of range")
+		writeError(conn, err.Error())
 		return
 	}
 
 	// extract list keys (all arguments except the last one which is timeout)
 	listKeys := args[1 : len(args)-1]
+	unlock := DB.Lock(listKeys...)
 
 	// try to pop from any of the specified lists immediately
 	for _, key := range listKeys {
-		value, exists := DB.Load(key)
+		value, exists := lookupKey(key)
 		if !exists {
 			continue
 		}
 
-		listEntry, ok := value.(ListEntry)
-		if !ok {
+		if _, ok := value.(ListEntry); !ok {
+			unlock()
 			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
 			return
 		}
 
-		if len(listEntry.elements) > 0 {
-			// pop the first element
-			poppedElement := listEntry.elements[0]
-			listEntry.elements = listEntry.elements[1:]
-
-			// update or delete the list
-			if len(listEntry.elements) == 0 {
-				DB.Delete(key)
-			} else {
-				DB.Store(key, listEntry)
-			}
-
-			// return the result immediately
-			writeArray(conn, []string{key, poppedElement})
+		if element, ok := popListElement(key, left); ok {
+			unlock()
+			writeArray(conn, []string{key, element})
 			return
 		}
 	}
 
-	// no elements available, block the client
-	blockClient(conn, listKeys[0], timeout)
+	// no elements available, block the client until a push serves it; the
+	// serve function remembers which end of the list it pops from
+	client := blockClient(conn, listKeys, func(key string) (string, bool) {
+		element, ok := popListElement(key, left)
+		if !ok {
+			return "", false
+		}
+		return encodeValue([]string{key, element}), true
+	})
+	unlock()
+	waitBlocked(client, deadline)
 }
 
-// parseEntryID parses an entry ID string into timestamp and sequence number
-func parseEntryID(idStr string) (int64, int64, error) {
-	parts := strings.Split(idStr, "-")
-	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid entry ID format")
-	}
+// handleBLPop implements the blocking list pop command
+func handleBLPop(args []string, conn net.Conn) {
+	blockingPopGeneric(args, conn, true)
+}
 
-	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid timestamp in entry ID")
-	}
+// handleBRPop is the blocking pop from the tail of a list
+func handleBRPop(args []string, conn net.Conn) {
+	blockingPopGeneric(args, conn, false)
+}
 
-	sequence, err := strconv.ParseInt(parts[1], 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid sequence number in entry ID")
+// handleBLMove implements BLMOVE source destination LEFT|RIGHT LEFT|RIGHT
+// timeout, the blocking variant of LMOVE
+func handleBLMove(args []string, conn net.Conn) {
+	fromLeft, ok1 := parseListSide(args[3])
+	toLeft, ok2 := parseListSide(args[4])
+	if !ok1 || !ok2 {
+		writeError(conn, "syntax error")
+		return
 	}
+	blmoveGeneric(args[1], args[2], fromLeft, toLeft, args[5], conn)
+}
 
-	return timestamp, sequence, nil
+// handleBRPopLPush is BLMOVE source destination RIGHT LEFT timeout
+func handleBRPopLPush(args []string, conn net.Conn) {
+	blmoveGeneric(args[1], args[2], false, true, args[3], conn)
 }
 
-// validateEntryID validates that the new entry ID is valid according to Redis rules
-func validateEntryID(newID string, stream StreamEntry) error {
-	newTimestamp, newSequence, err := parseEntryID(newID)
+// blmoveGeneric moves an element as LMOVE does, blocking until the source
+// has one if it is empty. A blocked mover is woken by a push to the source
+// and retries the whole move with both keys locked, so the element goes
+// from one list to the other in a single step.
+func blmoveGeneric(src, dst string, fromLeft, toLeft bool, timeout string, conn net.Conn) {
+	deadline, err := parseBlockTimeout(timeout)
 	if err != nil {
-		return err
+		writeError(conn, err.Error())
+		return
 	}
 
-	// check if ID is greater than 0-0
-	if newTimestamp == 0 && newSequence == 0 {
-		return fmt.Errorf("The ID specified in XADD must be greater than 0-0")
-	}
+	for {
+		unlock := DB.Lock(src, dst)
+		element, moved, err := listMove(src, dst, fromLeft, toLeft)
+		if err != nil {
+			unlock()
+			writeError(conn, err.Error())
+			return
+		}
+		if moved {
+			unlock()
+			writeBulkString(conn, element)
+			return
+		}
 
-	// if stream is empty, any valid ID > 0-0 is acceptable
-	if len(stream.entries) == 0 {
-		return nil
+		client := blockClient(conn, []string{src}, nil)
+		unlock()
+		if !waitBlocked(client, deadline) {
+			return
+		}
 	}
+}
 
-	// get the last entry ID
-	lastEntry := stream.entries[len(stream.entries)-1]
-	lastTimestamp, lastSequence, err := parseEntryID(lastEntry.id)
-	if err != nil {
-		return err
+// parseMPopArgs parses the numkeys key [key ...] side [COUNT count]
+// arguments shared by LMPOP, ZMPOP and their blocking variants, with
+// parseSide reading the side to pop from: LEFT|RIGHT or MIN|MAX
+func parseMPopArgs(args []string, parseSide func(string) (bool, bool)) (keys []string, left bool, count int, err error) {
+	numKeys, ok := parseIntArg(args[0])
+	if !ok || numKeys <= 0 {
+		return nil, false, 0, fmt.Errorf("numkeys should be greater than 0")
+	}
+	if numKeys+1 >= len(args) {
+		return nil, false, 0, fmt.Errorf("syntax error")
 	}
+	keys = args[1 : numKeys+1]
 
-	// check if new ID is greater than last ID
-	if newTimestamp < lastTimestamp ||
-		(newTimestamp == lastTimestamp && newSequence <= lastSequence) {
-		return fmt.Errorf("The ID specified in XADD is equal or smaller than the target stream top item")
+	left, ok = parseSide(args[numKeys+1])
+	if !ok {
+		return nil, false, 0, fmt.Errorf("syntax error")
 	}
 
-	return nil
+	count = 1
+	rest := args[numKeys+2:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "COUNT":
+		count, ok = parseIntArg(rest[1])
+		if !ok || count <= 0 {
+			return nil, false, 0, fmt.Errorf("count should be greater than 0")
+		}
+	default:
+		return nil, false, 0, fmt.Errorf("syntax error")
+	}
+	return keys, left, count, nil
 }
 
-// handleXAdd implements the XADD command for Redis streams
-func handleXAdd(args []string, conn net.Conn) {
-	if len(args) < 4 {
-		writeError(conn, "wrong number of arguments for 'xadd' command")
-		return
+// mpopFirst pops up to count elements from the first non-empty list among
+// keys, replying with the key and the popped elements. It reports whether
+// it found anything, and writes WRONGTYPE for a key that isn't a list.
+func mpopFirst(conn net.Conn, keys []string, left bool, count int) (done bool) {
+	for _, key := range keys {
+		value, exists := lookupKey(key)
+		if !exists {
+			continue
+		}
+		if _, ok := value.(ListEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return true
+		}
+		if popped := popListElements(key, left, count); popped != nil {
+			writeValue(conn, []any{key, popped})
+			return true
+		}
 	}
+	return false
+}
 
-	// XADD syntax: XADD key ID field value [field value ...]
-	key := args[1]
-	entryID := args[2]
-
-	// Check if we have an even number of field-value pairs
-	if (len(args)-3)%2 != 0 {
-		writeError(conn, "wrong number of arguments for 'xadd' command")
+// handleLMPop implements LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func handleLMPop(args []string, conn net.Conn) {
+	keys, left, count, err := parseMPopArgs(args[1:], parseListSide)
+	if err != nil {
+		writeError(conn, err.Error())
 		return
 	}
 
-	// Parse field-value pairs
-	data := make(map[string]string)
-	for i := 3; i < len(args); i += 2 {
-		field := args[i]
-		value := args[i+1]
-		data[field] = value
-	}
-
-	// Get or create the stream
-	value, exists := DB.Load(key)
-	var streamEntry StreamEntry
+	unlock := DB.Lock(keys...)
+	defer unlock()
 
-	if exists {
-		var ok bool
-		streamEntry, ok = value.(StreamEntry)
-		if !ok {
-			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
-			return
-		}
-	} else {
-		// key doesn't exist, create new stream
-		streamEntry = StreamEntry{entries: make([]StreamEntryData, 0)}
+	if !mpopFirst(conn, keys, left, count) {
+		writeNullArray(conn)
 	}
+}
 
-	// Validate the entry ID
-	if err := validateEntryID(entryID, streamEntry); err != nil {
+// handleBLMPop implements BLMPOP timeout numkeys key [key ...] LEFT|RIGHT
+// [COUNT count], blocking on every key until one of them gets elements
+func handleBLMPop(args []string, conn net.Conn) {
+	deadline, err := parseBlockTimeout(args[1])
+	if err != nil {
 		writeError(conn, err.Error())
 		return
 	}
-
-	// Create new stream entry data
-	newEntry := StreamEntryData{
-		id:   entryID,
-		data: data,
+	keys, left, count, err := parseMPopArgs(args[2:], parseListSide)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
 	}
 
-	// Add the entry to the stream
-	streamEntry.entries = append(streamEntry.entries, newEntry)
-
-	// Store the updated stream
-	DB.Store(key, streamEntry)
+	unlock := DB.Lock(keys...)
+	if mpopFirst(conn, keys, left, count) {
+		unlock()
+		return
+	}
 
-	// Return the entry ID as a bulk string
-	writeBulkString(conn, entryID)
+	client := blockClient(conn, keys, func(key string) (string, bool) {
+		popped := popListElements(key, left, count)
+		if popped == nil {
+			return "", false
+		}
+		return encodeValue([]any{key, popped}), true
+	})
+	unlock()
+	waitBlocked(client, deadline)
 }
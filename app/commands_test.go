@@ -0,0 +1,449 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCAS(t *testing.T) {
+	c := newTestClient(t)
+
+	tests := []struct {
+		name  string
+		setup []string
+		args  []string
+		want  string
+		value string
+	}{
+		// without NX a missing key matches no expected value, not even an
+		// empty one, and stays missing
+		{"missing key", nil, []string{"CAS", "cas", "", "new"}, ":0\r\n", "$-1\r\n"},
+		{"missing key nil spelling", nil, []string{"CAS", "cas", "(nil)", "new"}, ":0\r\n", "$-1\r\n"},
+		{"missing key nx", nil, []string{"CAS", "cas", "old", "new", "NX"}, ":1\r\n", "$3\r\nnew\r\n"},
+		{"existing key nx match", []string{"SET", "cas", "old"}, []string{"CAS", "cas", "old", "new", "nx"}, ":1\r\n", "$3\r\nnew\r\n"},
+		{"existing key nx mismatch", []string{"SET", "cas", "old"}, []string{"CAS", "cas", "other", "new", "NX"}, ":0\r\n", "$3\r\nold\r\n"},
+		{"value spelled like an option", []string{"SET", "cas", "NX"}, []string{"CAS", "cas", "NX", "new"}, ":1\r\n", "$3\r\nnew\r\n"},
+		{"unknown option", []string{"SET", "cas", "old"}, []string{"CAS", "cas", "old", "new", "XX"}, "-ERR syntax error\r\n", "$3\r\nold\r\n"},
+		{"extra argument", []string{"SET", "cas", "old"}, []string{"CAS", "cas", "old", "new", "NX", "NX"}, "-ERR syntax error\r\n", "$3\r\nold\r\n"},
+		{"match", []string{"SET", "cas", "old"}, []string{"CAS", "cas", "old", "new"}, ":1\r\n", "$3\r\nnew\r\n"},
+		{"mismatch", []string{"SET", "cas", "old"}, []string{"CAS", "cas", "other", "new"}, ":0\r\n", "$3\r\nold\r\n"},
+		{"empty value", []string{"SET", "cas", ""}, []string{"CAS", "cas", "", "new"}, ":1\r\n", "$3\r\nnew\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.do("DEL", "cas"); got[0] != ':' {
+				t.Fatalf("DEL cas = %q", got)
+			}
+			if tt.setup != nil {
+				if got := c.do(tt.setup...); got != "+OK\r\n" {
+					t.Fatalf("%v = %q, want +OK", tt.setup, got)
+				}
+			}
+			if got := c.do(tt.args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+			if got := c.do("GET", "cas"); got != tt.value {
+				t.Errorf("GET after %v = %q, want %q", tt.args, got, tt.value)
+			}
+			if tt.value == "$-1\r\n" {
+				if got := c.do("EXISTS", "cas"); got != ":0\r\n" {
+					t.Errorf("EXISTS after %v = %q, want :0", tt.args, got)
+				}
+			}
+		})
+	}
+
+	t.Run("wrong type", func(t *testing.T) {
+		c.do("DEL", "cas")
+		if got := c.do("RPUSH", "cas", "a"); got != ":1\r\n" {
+			t.Fatalf("RPUSH cas a = %q, want :1", got)
+		}
+		if got := c.do("CAS", "cas", "a", "b"); got[0] != '-' {
+			t.Errorf("CAS on a list = %q, want an error", got)
+		}
+	})
+
+	t.Run("keeps expiry", func(t *testing.T) {
+		c.do("DEL", "cas")
+		if got := c.do("SET", "cas", "old", "EX", "100"); got != "+OK\r\n" {
+			t.Fatalf("SET cas old EX 100 = %q, want +OK", got)
+		}
+		if got := c.do("CAS", "cas", "old", "new"); got != ":1\r\n" {
+			t.Fatalf("CAS cas old new = %q, want :1", got)
+		}
+		if got := c.do("TTL", "cas"); got == ":-1\r\n" || got == ":-2\r\n" {
+			t.Errorf("TTL after CAS = %q, want the expiry kept", got)
+		}
+	})
+
+	t.Run("create has no expiry", func(t *testing.T) {
+		c.do("DEL", "cas")
+		if got := c.do("CAS", "cas", "old", "new", "NX"); got != ":1\r\n" {
+			t.Fatalf("CAS cas old new NX = %q, want :1", got)
+		}
+		if got := c.do("TTL", "cas"); got != ":-1\r\n" {
+			t.Errorf("TTL after creating with CAS = %q, want :-1", got)
+		}
+	})
+}
+
+// TestCASConcurrent races many clients swapping the same key, both from an
+// existing value and from a missing key, and checks that exactly one wins
+func TestCASConcurrent(t *testing.T) {
+	const clients = 50
+	setup := newTestClient(t)
+	for _, tt := range []struct {
+		name   string
+		exists bool
+		nx     []string
+	}{
+		{"existing key", true, nil},
+		{"missing key", false, []string{"NX"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setup.do("DEL", "cas-race")
+			if tt.exists {
+				setup.do("SET", "cas-race", "start")
+			}
+
+			var wg sync.WaitGroup
+			results := make([]string, clients)
+			for i := range clients {
+				c := newTestClient(t)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					args := []string{"CAS", "cas-race", "start", "client-" + strconv.Itoa(i)}
+					results[i] = c.do(append(args, tt.nx...)...)
+				}()
+			}
+			wg.Wait()
+
+			winner := -1
+			for i, reply := range results {
+				switch reply {
+				case ":1\r\n":
+					if winner >= 0 {
+						t.Fatalf("clients %d and %d both won the CAS", winner, i)
+					}
+					winner = i
+				case ":0\r\n":
+				default:
+					t.Fatalf("client %d got %q", i, reply)
+				}
+			}
+			if winner < 0 {
+				t.Fatal("no client won the CAS")
+			}
+
+			want := "client-" + strconv.Itoa(winner)
+			if got := setup.do("GET", "cas-race"); got != "$"+strconv.Itoa(len(want))+"\r\n"+want+"\r\n" {
+				t.Errorf("GET = %q, want the winner's value %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIncrDecr(t *testing.T) {
+	const (
+		notInt   = "-ERR value is not an integer or out of range\r\n"
+		overflow = "-ERR increment or decrement would overflow\r\n"
+	)
+	maxInt := strconv.FormatInt(math.MaxInt64, 10)
+	minInt := strconv.FormatInt(math.MinInt64, 10)
+
+	tests := []struct {
+		name    string
+		initial *string
+		args    []string
+		want    string
+		value   string
+	}{
+		{"incr missing", nil, []string{"INCR"}, ":1\r\n", "1"},
+		{"decr missing", nil, []string{"DECR"}, ":-1\r\n", "-1"},
+		{"incrby", ptr("10"), []string{"INCRBY", "5"}, ":15\r\n", "15"},
+		{"decrby", ptr("10"), []string{"DECRBY", "15"}, ":-5\r\n", "-5"},
+		{"incr to max", ptr(strconv.FormatInt(math.MaxInt64-1, 10)), []string{"INCR"}, ":" + maxInt + "\r\n", maxInt},
+		{"incr past max", ptr(maxInt), []string{"INCR"}, overflow, maxInt},
+		{"incrby past max", ptr("1"), []string{"INCRBY", maxInt}, overflow, "1"},
+		{"decr to min", ptr(strconv.FormatInt(math.MinInt64+1, 10)), []string{"DECR"}, ":" + minInt + "\r\n", minInt},
+		{"decr past min", ptr(minInt), []string{"DECR"}, overflow, minInt},
+		{"incrby past min", ptr("-2"), []string{"INCRBY", minInt}, overflow, "-2"},
+		{"decrby min", ptr("0"), []string{"DECRBY", minInt}, "-ERR decrement would overflow\r\n", "0"},
+		{"incrby value past max", nil, []string{"INCRBY", "9223372036854775808"}, notInt, ""},
+		{"leading space", ptr(" 1"), []string{"INCR"}, notInt, " 1"},
+		{"trailing space", ptr("1 "), []string{"INCR"}, notInt, "1 "},
+		{"trailing newline", ptr("1\n"), []string{"INCR"}, notInt, "1\n"},
+		{"increment with space", ptr("1"), []string{"INCRBY", " 2"}, notInt, "1"},
+		{"plus sign", ptr("+1"), []string{"INCR"}, notInt, "+1"},
+		{"increment with plus sign", ptr("1"), []string{"INCRBY", "+2"}, notInt, "1"},
+		{"leading zero", ptr("01"), []string{"INCR"}, notInt, "01"},
+		{"negative leading zero", ptr("-01"), []string{"DECR"}, notInt, "-01"},
+		{"negative zero", ptr("-0"), []string{"INCR"}, notInt, "-0"},
+		{"increment with leading zero", ptr("1"), []string{"DECRBY", "02"}, notInt, "1"},
+		{"zero", ptr("0"), []string{"INCR"}, ":1\r\n", "1"},
+		{"empty string", ptr(""), []string{"INCR"}, notInt, ""},
+		{"empty increment", ptr("1"), []string{"INCRBY", ""}, notInt, "1"},
+		{"not an integer", ptr("abc"), []string{"INCR"}, notInt, "abc"},
+		{"float", ptr("1.5"), []string{"INCRBY", "1"}, notInt, "1.5"},
+		{"float increment", ptr("1"), []string{"INCRBY", "1.5"}, notInt, "1"},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "incr")
+			if tt.initial != nil {
+				if got := c.do("SET", "incr", *tt.initial); got != "+OK\r\n" {
+					t.Fatalf("SET incr %q = %q", *tt.initial, got)
+				}
+			}
+			args := slices.Concat([]string{tt.args[0], "incr"}, tt.args[1:])
+			if got := c.do(args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", args, got, tt.want)
+			}
+
+			want := encodeValue(tt.value)
+			if tt.initial == nil && tt.want[0] == '-' {
+				want = "$-1\r\n"
+			}
+			if got := c.do("GET", "incr"); got != want {
+				t.Errorf("GET after %v = %q, want %q", args, got, want)
+			}
+		})
+	}
+
+	t.Run("wrong type", func(t *testing.T) {
+		c.do("DEL", "incr")
+		c.do("RPUSH", "incr", "1")
+		if got := c.do("INCR", "incr"); got != "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+			t.Errorf("INCR on a list = %q, want WRONGTYPE", got)
+		}
+	})
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// TestIntegerArguments checks that index, count and range arguments are
+// parsed as strictly as INCRBY's: the spellings strconv.Atoi would take but
+// Redis doesn't are refused, and plain ones still work
+func TestIntegerArguments(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "ia-str", "ia-list", "ia-zset")
+	c.do("SET", "ia-str", "hello")
+	c.do("RPUSH", "ia-list", "a", "b", "c")
+	c.do("ZADD", "ia-zset", "1", "a", "2", "b")
+
+	// each command with N standing for the argument under test
+	commands := []struct {
+		args []string
+		ok   string // the reply with N = 1
+		err  string
+	}{
+		{[]string{"GETRANGE", "ia-str", "N", "1"}, "$1\r\ne\r\n", "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"GETRANGE", "ia-str", "0", "N"}, "$2\r\nhe\r\n", "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"LRANGE", "ia-list", "N", "1"}, encodeValue([]string{"b"}), "-ERR invalid start index\r\n"},
+		{[]string{"LRANGE", "ia-list", "0", "N"}, encodeValue([]string{"a", "b"}), "-ERR invalid stop index\r\n"},
+		{[]string{"LSET", "ia-list", "N", "b"}, "+OK\r\n", "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"LTRIM", "ia-list", "0", "N"}, "+OK\r\n", "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"LPOS", "ia-list", "a", "RANK", "N"}, ":0\r\n", "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"LPOS", "ia-list", "a", "COUNT", "N"}, encodeValue([]any{0}), "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"SCAN", "0", "MATCH", "ia-list", "COUNT", "N"}, "", "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"ZRANGE", "ia-zset", "0", "N"}, encodeValue([]string{"a", "b"}), "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"ZRANGE", "ia-zset", "0", "10", "BYSCORE", "LIMIT", "0", "N"}, encodeValue([]string{"a"}), "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"ZRANGE", "ia-zset", "0", "10", "BYSCORE", "LIMIT", "N", "1"}, encodeValue([]string{"b"}), "-ERR value is not an integer or out of range\r\n"},
+	}
+	with := func(args []string, n string) []string {
+		out := slices.Clone(args)
+		out[slices.Index(out, "N")] = n
+		return out
+	}
+	for _, cmd := range commands {
+		if got := c.do(with(cmd.args, "1")...); cmd.ok != "" && got != cmd.ok {
+			t.Errorf("%v = %q, want %q", with(cmd.args, "1"), got, cmd.ok)
+		}
+		for _, n := range []string{"+1", "01", " 1", "1 ", "0x1", "1.0", "", "-0", "9223372036854775808"} {
+			args := with(cmd.args, n)
+			if got := c.do(args...); got != cmd.err {
+				t.Errorf("%q = %q, want %q", args, got, cmd.err)
+			}
+		}
+	}
+}
+
+func TestParseStrictFloat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"1", 1, true},
+		{"-2.5", -2.5, true},
+		{"+3", 3, true},
+		{".5", 0.5, true},
+		{"1e3", 1000, true},
+		{"1E-2", 0.01, true},
+		{"inf", math.Inf(1), true},
+		{"+inf", math.Inf(1), true},
+		{"-INF", math.Inf(-1), true},
+		{"", 0, false},
+		{" 1", 0, false},
+		{"1 ", 0, false},
+		{"nan", 0, false},
+		{"-NaN", 0, false},
+		{"infinity", 0, false},
+		{"-Infinity", 0, false},
+		{"0x1p3", 0, false},
+		{"0X10", 0, false},
+		{"1_000", 0, false},
+		{"1e", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseStrictFloat(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseStrictFloat(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestBLMPopThirdKey blocks on three lists and wakes the client by pushing
+// to the last of them
+func TestBLMPopThirdKey(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "bm1", "bm2", "bm3")
+
+	reader := newTestClient(t)
+	reply := make(chan string, 1)
+	go func() {
+		reply <- reader.do("BLMPOP", "0", "3", "bm1", "bm2", "bm3", "RIGHT", "COUNT", "2")
+	}()
+	for _, key := range []string{"bm1", "bm2", "bm3"} {
+		waitForBlocked(t, key, 1)
+	}
+
+	if got := c.do("RPUSH", "bm3", "a", "b", "c"); got != ":3\r\n" {
+		t.Fatalf("RPUSH bm3 = %q, want :3", got)
+	}
+	select {
+	case got := <-reply:
+		if want := encodeValue([]any{"bm3", []string{"c", "b"}}); got != want {
+			t.Errorf("BLMPOP woken by bm3 = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BLMPOP wasn't woken by a push to its third key")
+	}
+
+	if got := c.do("LRANGE", "bm3", "0", "-1"); got != encodeValue([]string{"a"}) {
+		t.Errorf("bm3 after BLMPOP = %q, want [a]", got)
+	}
+	// the client is served, so it no longer waits on the other keys
+	blockedClientsMutex.Lock()
+	left := len(blockedClients["bm1"]) + len(blockedClients["bm2"])
+	blockedClientsMutex.Unlock()
+	if left != 0 {
+		t.Errorf("%d clients still blocked on bm1 and bm2 after BLMPOP was served", left)
+	}
+	if got := c.do("RPUSH", "bm1", "x"); got != ":1\r\n" {
+		t.Errorf("RPUSH bm1 after BLMPOP = %q, want :1, the element left in place", got)
+	}
+}
+
+func TestLRange(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "lr", "lr-missing")
+	c.do("RPUSH", "lr", "a", "b", "c")
+
+	tests := []struct {
+		start, stop string
+		want        []string
+	}{
+		{"0", "-1", []string{"a", "b", "c"}},
+		{"0", "2", []string{"a", "b", "c"}},
+		{"0", "0", []string{"a"}},
+		{"1", "1", []string{"b"}},
+		{"1", "-1", []string{"b", "c"}},
+		{"-1", "-1", []string{"c"}},
+		{"-2", "-1", []string{"b", "c"}},
+		{"-3", "-1", []string{"a", "b", "c"}},
+		{"-100", "-1", []string{"a", "b", "c"}},
+		{"-100", "0", []string{"a"}},
+		{"-100", "100", []string{"a", "b", "c"}},
+		{"0", "100", []string{"a", "b", "c"}},
+		{"2", "100", []string{"c"}},
+		{"3", "100", []string{}},
+		{"5", "10", []string{}},
+		{"2", "1", []string{}},
+		{"-1", "-2", []string{}},
+		{"0", "-4", []string{}},
+		{"-100", "-4", []string{}},
+		{"-100", "-100", []string{}},
+	}
+	for _, tt := range tests {
+		if got, want := c.do("LRANGE", "lr", tt.start, tt.stop), encodeValue(tt.want); got != want {
+			t.Errorf("LRANGE lr %s %s = %q, want %q", tt.start, tt.stop, got, want)
+		}
+	}
+
+	if got := c.do("LRANGE", "lr-missing", "0", "-1"); got != "*0\r\n" {
+		t.Errorf("LRANGE on a missing key = %q, want an empty array", got)
+	}
+	if got := c.do("LRANGE", "lr", "x", "1"); got[0] != '-' {
+		t.Errorf("LRANGE with a non-integer start = %q, want an error", got)
+	}
+}
+
+func TestCommandSpecs(t *testing.T) {
+	seen := make(map[string]bool, len(commandSpecs))
+	for _, cmd := range commandSpecs {
+		if seen[cmd.name] {
+			t.Errorf("%s is registered twice", cmd.name)
+		}
+		seen[cmd.name] = true
+
+		if cmd.name != strings.ToUpper(cmd.name) {
+			t.Errorf("%s is not upper case, so the dispatcher can't find it", cmd.name)
+		}
+		if cmd.handler == nil {
+			t.Errorf("%s has no handler", cmd.name)
+		}
+		if cmd.arity == 0 {
+			t.Errorf("%s has arity 0", cmd.name)
+		}
+		if commandTable[cmd.name] == nil || commandTable[cmd.name].name != cmd.name {
+			t.Errorf("%s is missing from the command table", cmd.name)
+		}
+		// commands with keys at fixed positions need a step to walk them by,
+		// and a fixed arity must leave room for the first one
+		if cmd.firstKey > 0 && (cmd.keyStep <= 0 || (cmd.arity > 0 && cmd.firstKey >= cmd.arity)) {
+			t.Errorf("%s has key positions first=%d last=%d step=%d that don't fit its arity %d",
+				cmd.name, cmd.firstKey, cmd.lastKey, cmd.keyStep, cmd.arity)
+		}
+	}
+	if len(commandTable) != len(commandSpecs) {
+		t.Errorf("command table has %d commands, specs list %d", len(commandTable), len(commandSpecs))
+	}
+}
+
+func TestCommandCount(t *testing.T) {
+	c := newTestClient(t)
+	if got, want := c.do("COMMAND", "COUNT"), ":"+strconv.Itoa(len(commandSpecs))+"\r\n"; got != want {
+		t.Errorf("COMMAND COUNT = %q, want %q", got, want)
+	}
+
+	for _, name := range []string{"get", "ZRANGEBYSCORE", "xadd"} {
+		cmd := commandTable[strings.ToUpper(name)]
+		want := encodeValue([]any{commandInfo(cmd)})
+		if got := c.do("COMMAND", "INFO", name); got != want {
+			t.Errorf("COMMAND INFO %s = %q, want %q", name, got, want)
+		}
+	}
+	if got := c.do("COMMAND", "INFO", "nope"); got != "*1\r\n$-1\r\n" {
+		t.Errorf("COMMAND INFO of an unknown command = %q, want a null entry", got)
+	}
+}
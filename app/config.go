@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// configParam is a server setting that can be read and changed at runtime
+// through CONFIG GET / CONFIG SET
+type configParam interface {
+	get() string
+	set(value string) error
+}
+
+// intConfig holds an integer setting bounded by [min, max]
+type intConfig struct {
+	value  atomic.Int64
+	min    int64
+	max    int64
+	memory bool // accept memory units such as 512mb
+}
+
+func newIntConfig(value, min, max int64, memory bool) *intConfig {
+	c := &intConfig{min: min, max: max, memory: memory}
+	c.value.Store(value)
+	return c
+}
+
+func (c *intConfig) get() string {
+	return strconv.FormatInt(c.value.Load(), 10)
+}
+
+func (c *intConfig) set(value string) error {
+	var n int64
+	var err error
+	if c.memory {
+		n, err = parseMemory(value)
+	} else {
+		n, err = strconv.ParseInt(value, 10, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("argument couldn't be parsed into an integer")
+	}
+	if n < c.min || n > c.max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", c.min, c.max)
+	}
+	c.value.Store(n)
+	return nil
+}
+
+// boolConfig holds a yes/no setting
+type boolConfig struct {
+	value atomic.Bool
+}
+
+func newBoolConfig(value bool) *boolConfig {
+	c := &boolConfig{}
+	c.value.Store(value)
+	return c
+}
+
+func (c *boolConfig) get() string {
+	if c.value.Load() {
+		return "yes"
+	}
+	return "no"
+}
+
+func (c *boolConfig) set(value string) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		c.value.Store(true)
+	case "no":
+		c.value.Store(false)
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+// parseMemory parses a byte count with an optional k/kb/m/mb/g/gb unit
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	}
+
+	lower := strings.ToLower(value)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			n, err := strconv.ParseInt(lower[:len(lower)-len(u.suffix)], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			if n > math.MaxInt64/u.mul || n < math.MinInt64/u.mul {
+				return 0, fmt.Errorf("memory value %q is out of range", value)
+			}
+			return n * u.mul, nil
+		}
+	}
+	return strconv.ParseInt(lower, 10, 64)
+}
+
+// server settings
+var (
+	// protoMaxBulkLen limits the declared length of a single bulk string
+	protoMaxBulkLen = newIntConfig(512*1024*1024, 1024*1024, 1<<62, true)
+
+	// latencyMonitorThreshold is the command duration in milliseconds from
+	// which the latency monitor records a spike; 0 disables it
+	latencyMonitorThreshold = newIntConfig(0, 0, 1<<62, false)
+
+	// tcpKeepAlive is the TCP keepalive period in seconds for new
+	// connections; 0 disables keepalive
+	tcpKeepAlive = newIntConfig(300, 0, 1<<31-1, false)
+
+	// tcpNoDelay disables Nagle's algorithm on new connections so small
+	// replies are sent without delay
+	tcpNoDelay = newBoolConfig(true)
+
+	// data structure thresholds: collections at or below these sizes report
+	// the compact encoding, larger ones the general-purpose one. A negative
+	// list-max-listpack-size limits each node by size (-1 = 4kb ... -5 = 64kb).
+	listMaxListpackSize    = newIntConfig(-2, -5, 1<<31-1, false)
+	hashMaxListpackEntries = newIntConfig(128, 0, 1<<31-1, false)
+	hashMaxListpackValue   = newIntConfig(64, 0, 1<<31-1, false)
+	setMaxIntsetEntries    = newIntConfig(512, 0, 1<<31-1, false)
+	setMaxListpackEntries  = newIntConfig(128, 0, 1<<31-1, false)
+	setMaxListpackValue    = newIntConfig(64, 0, 1<<31-1, false)
+	zsetMaxListpackEntries = newIntConfig(128, 0, 1<<31-1, false)
+	zsetMaxListpackValue   = newIntConfig(64, 0, 1<<31-1, false)
+
+	// approximate stream trimming only removes whole nodes of this many
+	// entries, as Redis does with its listpack nodes
+	streamNodeMaxEntries = newIntConfig(100, 0, 1<<31-1, false)
+
+	// the access frequency counter OBJECT FREQ reports grows more slowly the
+	// higher lfu-log-factor is, and loses one for every lfu-decay-time
+	// minutes the key goes unaccessed
+	lfuLogFactor = newIntConfig(10, 0, 1<<31-1, false)
+	lfuDecayTime = newIntConfig(1, 0, 1<<31-1, false)
+
+	// collection limits guard against a single client growing one list,
+	// set, hash or sorted set without bound; 0 means unlimited
+	collectionMaxElementSize = newIntConfig(0, 0, 1<<62, true)
+	collectionMaxEntries     = newIntConfig(0, 0, 1<<62, false)
+)
+
+// maxMultiBulkLen limits the number of elements in a single request array
+const maxMultiBulkLen = 1024 * 1024
+
+// configParams maps parameter names to their settings
+var configParams = map[string]configParam{
+	"proto-max-bulk-len":          protoMaxBulkLen,
+	"latency-monitor-threshold":   latencyMonitorThreshold,
+	"tcp-keepalive":               tcpKeepAlive,
+	"tcp-nodelay":                 tcpNoDelay,
+	"list-max-listpack-size":      listMaxListpackSize,
+	"hash-max-listpack-entries":   hashMaxListpackEntries,
+	"hash-max-listpack-value":     hashMaxListpackValue,
+	"set-max-intset-entries":      setMaxIntsetEntries,
+	"set-max-listpack-entries":    setMaxListpackEntries,
+	"set-max-listpack-value":      setMaxListpackValue,
+	"zset-max-listpack-entries":   zsetMaxListpackEntries,
+	"zset-max-listpack-value":     zsetMaxListpackValue,
+	"stream-node-max-entries":     streamNodeMaxEntries,
+	"lfu-log-factor":              lfuLogFactor,
+	"lfu-decay-time":              lfuDecayTime,
+	"collection-max-element-size": collectionMaxElementSize,
+	"collection-max-entries":      collectionMaxEntries,
+}
+
+// handleConfig implements CONFIG GET, CONFIG SET and CONFIG RESETSTAT
+func handleConfig(args []string, conn net.Conn) {
+	switch strings.ToUpper(args[1]) {
+	case "GET":
+		if len(args) < 3 {
+			writeError(conn, "wrong number of arguments for 'config|get' command")
+			return
+		}
+
+		// collect each matching parameter once, even if several patterns match it
+		matched := make(map[string]bool)
+		for _, pattern := range args[2:] {
+			for name := range configParams {
+				if stringMatch(pattern, name, true) {
+					matched[name] = true
+				}
+			}
+		}
+
+		names := make([]string, 0, len(matched))
+		for name := range matched {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		result := make(respMap, 0, len(names)*2)
+		for _, name := range names {
+			result = append(result, name, configParams[name].get())
+		}
+		writeValue(conn, result)
+	case "SET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			writeError(conn, "wrong number of arguments for 'config|set' command")
+			return
+		}
+
+		// validate every parameter name before applying anything
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := configParams[strings.ToLower(args[i])]; !ok {
+				writeError(conn, fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", args[i]))
+				return
+			}
+		}
+
+		for i := 2; i < len(args); i += 2 {
+			name := strings.ToLower(args[i])
+			if err := configParams[name].set(args[i+1]); err != nil {
+				writeError(conn, fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %s", name, err.Error()))
+				return
+			}
+		}
+		writeSimpleString(conn, "OK")
+	case "RESETSTAT":
+		resetCommandStats()
+		writeSimpleString(conn, "OK")
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try CONFIG HELP.", args[1]))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"100", 100, true},
+		{"1k", 1000, true},
+		{"1kb", 1024, true},
+		{"2MB", 2 * 1024 * 1024, true},
+		{"3g", 3_000_000_000, true},
+		{"-1mb", -1024 * 1024, true},
+		{"8589934591gb", 8589934591 * 1024 * 1024 * 1024, true},
+		{"8589934592gb", 0, false},
+		{"99999999999gb", 0, false},
+		{"-8589934593gb", 0, false},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9223372036854775808", 0, false},
+		{"", 0, false},
+		{"mb", 0, false},
+		{"1.5mb", 0, false},
+		{"1tb", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMemory(tt.value)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseMemory(%q) = %d, %v, want %d, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+
+	c := newTestClient(t)
+	if got := c.do("CONFIG", "SET", "proto-max-bulk-len", "99999999999gb"); got[0] != '-' {
+		t.Errorf("CONFIG SET of an overflowing memory value = %q, want an error", got)
+	}
+	if got := c.do("CONFIG", "GET", "proto-max-bulk-len"); got != encodeValue([]string{"proto-max-bulk-len", "536870912"}) {
+		t.Errorf("proto-max-bulk-len after the rejected CONFIG SET = %q", got)
+	}
+}
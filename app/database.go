@@ -1,117 +1,476 @@
 package main
 
 import (
+	"fmt"
+	"maps"
+	"math"
 	"net"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
 
-var DB sync.Map
+var DB *Keyspace
 
-// blockedClients stores clients blocked on BLPOP, organized by list key
+// blockedClients stores clients blocked by blocking commands, organized by key
 var blockedClients = make(map[string][]*BlockedClient)
 var blockedClientsMutex sync.RWMutex
 
-// InitDB initializes the database
+// InitDB initializes the database and starts the active expiry cycle
 func InitDB() {
-	DB = sync.Map{}
+	DB = NewKeyspace()
+	go activeExpireCycle()
 }
 
-// blockClient blocks a client waiting for an element to be available
-func blockClient(conn net.Conn, listKey string, timeout float64) {
+// expiresAtOf returns the expiry deadline of a stored value, or the zero
+// time if the value never expires
+func expiresAtOf(value any) time.Time {
+	switch v := value.(type) {
+	case Entry:
+		return v.expiresAt
+	case ListEntry:
+		return v.expiresAt
+	case HashEntry:
+		return v.expiresAt
+	case SetEntry:
+		return v.expiresAt
+	case ZSetEntry:
+		return v.expiresAt
+	case StreamEntry:
+		return v.expiresAt
+	}
+	return time.Time{}
+}
+
+// withExpiresAt returns a copy of a stored value with its expiry deadline
+// replaced; the zero time removes the TTL
+func withExpiresAt(value any, expiresAt time.Time) any {
+	switch v := value.(type) {
+	case Entry:
+		v.expiresAt = expiresAt
+		return v
+	case ListEntry:
+		v.expiresAt = expiresAt
+		return v
+	case HashEntry:
+		v.expiresAt = expiresAt
+		return v
+	case SetEntry:
+		v.expiresAt = expiresAt
+		return v
+	case ZSetEntry:
+		v.expiresAt = expiresAt
+		return v
+	case StreamEntry:
+		v.expiresAt = expiresAt
+		return v
+	}
+	return value
+}
+
+// replaceValue is the write path of the STORE-style commands. value replaces
+// whatever key held as a brand new value, with no TTL and fresh access
+// metadata, and the clients blocked on key get their chance at it. A nil
+// value, which is what an empty result stores, deletes key instead. The
+// caller must hold the lock of key.
+func replaceValue(key string, value any) {
+	DB.Delete(key)
+	if value == nil {
+		return
+	}
+	DB.Store(key, withEncoding(withExpiresAt(value, time.Time{})))
+	notifyBlockedClients(key)
+}
+
+// copyValue returns a deep copy of a stored value, TTL included, that shares
+// no slices with the original
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case ListEntry:
+		v.list = v.list.clone()
+		return v
+	case HashEntry:
+		v.fields = maps.Clone(v.fields)
+		v.fieldExpires = maps.Clone(v.fieldExpires)
+		return v
+	case SetEntry:
+		v.members = maps.Clone(v.members)
+		return v
+	case ZSetEntry:
+		v.zset = v.zset.clone()
+		return v
+	case StreamEntry:
+		entries := make([]StreamEntryData, len(v.entries))
+		for i, e := range v.entries {
+			entries[i] = StreamEntryData{id: e.id, fields: append([]string(nil), e.fields...)}
+		}
+		v.entries = entries
+		if v.groups != nil {
+			groups := make(map[string]*streamGroup, len(v.groups))
+			for name, group := range v.groups {
+				groups[name] = group.clone()
+			}
+			v.groups = groups
+		}
+		return v
+	}
+	// strings are immutable, so Entry copies by value
+	return value
+}
+
+// isExpired reports whether a stored value has passed its expiry deadline
+func isExpired(value any) bool {
+	expiresAt := expiresAtOf(value)
+	return !expiresAt.IsZero() && clock.Now().After(expiresAt)
+}
+
+// hasExpiredFields reports whether a stored value is a hash with fields
+// whose TTL has elapsed
+func hasExpiredFields(value any) bool {
+	hash, ok := value.(HashEntry)
+	if !ok {
+		return false
+	}
+	now := clock.Now()
+	for _, deadline := range hash.fieldExpires {
+		if now.After(deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFieldExpired reports whether a hash field's TTL has elapsed, for
+// readers that can't delete it
+func hashFieldExpired(hash HashEntry, field string) bool {
+	deadline, ok := hash.fieldExpires[field]
+	return ok && clock.Now().After(deadline)
+}
+
+// deleteExpiredFields removes the fields of a hash whose TTL has elapsed
+// and returns how many it removed. The caller must hold the lock of the
+// hash's key.
+func deleteExpiredFields(hash HashEntry) int {
+	now := clock.Now()
+	removed := 0
+	for field, deadline := range hash.fieldExpires {
+		if now.After(deadline) {
+			delete(hash.fields, field)
+			delete(hash.fieldExpires, field)
+			removed++
+		}
+	}
+	expiredFields.Add(int64(removed))
+	return removed
+}
+
+// lookupKey returns the value stored at key, lazily deleting it if it has
+// expired. Expired hash fields are deleted too, and with them the hash if
+// no field is left.
+func lookupKey(key string) (any, bool) {
+	value, ok := DB.Load(key)
+	if !ok {
+		return nil, false
+	}
+	if isExpired(value) {
+		DB.Delete(key)
+		expiredKeys.Add(1)
+		return nil, false
+	}
+	if hash, ok := value.(HashEntry); ok && len(hash.fieldExpires) > 0 {
+		if deleteExpiredFields(hash) > 0 && len(hash.fields) == 0 {
+			DB.Delete(key)
+			return nil, false
+		}
+	}
+	DB.Access(key).touch()
+	return value, true
+}
+
+// peekKey returns the value stored at key if it hasn't expired, without
+// deleting it if it has or counting an access, so it is safe under a read
+// lock taken by LockAll
+func peekKey(key string) (any, bool) {
+	value, ok := DB.Load(key)
+	if !ok || isExpired(value) {
+		return nil, false
+	}
+	return value, true
+}
+
+// lookupKeyRead looks up key for a read-only command, taking only a read
+// lock on its shard so concurrent readers don't serialize. Lazy expiry is a
+// write, so if the key turns out to have expired the read lock is traded
+// for a write lock before it is deleted. The returned function releases
+// whichever lock is held at the end.
+func lookupKeyRead(key string) (any, bool, func()) {
+	unlock := DB.RLock(key)
+	value, ok := DB.Load(key)
+	if !ok {
+		return nil, false, unlock
+	}
+	if !isExpired(value) && !hasExpiredFields(value) {
+		DB.Access(key).touch()
+		return value, true, unlock
+	}
+	unlock()
+
+	// another client may have replaced the key while no lock was held, so
+	// look it up again rather than deleting blindly
+	unlock = DB.Lock(key)
+	value, ok = lookupKey(key)
+	return value, ok, unlock
+}
+
+// typeName returns the name TYPE reports for a stored value
+func typeName(value any) string {
+	switch value.(type) {
+	case Entry:
+		return "string"
+	case ListEntry:
+		return "list"
+	case HashEntry:
+		return "hash"
+	case SetEntry:
+		return "set"
+	case ZSetEntry:
+		return "zset"
+	case StreamEntry:
+		return "stream"
+	}
+	return "none"
+}
+
+// checkCollectionLimits validates a write that adds elements to a list, set,
+// hash or sorted set, leaving it with newLen entries, against the configured
+// collection-max-element-size and collection-max-entries limits
+func checkCollectionLimits(newLen int, elements ...string) error {
+	if maxSize := collectionMaxElementSize.value.Load(); maxSize > 0 {
+		for _, e := range elements {
+			if int64(len(e)) > maxSize {
+				return fmt.Errorf("element exceeds collection-max-element-size")
+			}
+		}
+	}
+	if maxEntries := collectionMaxEntries.value.Load(); maxEntries > 0 && int64(newLen) > maxEntries {
+		return fmt.Errorf("collection would exceed collection-max-entries")
+	}
+	return nil
+}
+
+// parseBlockTimeout parses the timeout of a blocking command, in seconds
+// with an optional fraction, into a deadline. A timeout of 0 blocks
+// indefinitely and gives the zero time.
+func parseBlockTimeout(arg string) (time.Time, error) {
+	timeout, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return time.Time{}, fmt.Errorf("timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return time.Time{}, fmt.Errorf("timeout is negative")
+	}
+	if timeout == 0 {
+		return time.Time{}, nil
+	}
+	return clock.Now().Add(time.Duration(timeout * float64(time.Second))), nil
+}
+
+// blockClient registers a client as waiting for data on keys. It must be
+// called with the locks of keys held, so that no push can slip in between
+// the caller finding the keys empty and the client being registered.
+//
+// serve, if not nil, is called by notifyBlockedClients with the lock of the
+// key that received data held; it takes what the client asked for, returns
+// the encoded reply and reports whether it could serve it. The reply is
+// written by the client's own goroutine once it is released, so that a slow
+// reader never holds up the pusher or its locks. Clients without serve are
+// woken instead, and retry with whatever locks they need.
+func blockClient(conn net.Conn, keys []string, serve func(key string) (string, bool)) *BlockedClient {
+	// index the client once under each distinct key, so that a key named
+	// twice doesn't leave a stale entry behind when it is removed
+	seen := make(map[string]bool, len(keys))
+	distinct := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, key)
+		}
+	}
+
 	client := &BlockedClient{
-		conn:      conn,
-		listKey:   listKey,
-		timeout:   timeout,
-		startTime: time.Now(),
-		done:      make(chan struct{}),
+		conn:  conn,
+		keys:  distinct,
+		serve: serve,
+		done:  make(chan struct{}),
 	}
 
-	// add client to blocked clients list
 	blockedClientsMutex.Lock()
-	blockedClients[listKey] = append(blockedClients[listKey], client)
+	for _, key := range distinct {
+		blockedClients[key] = append(blockedClients[key], client)
+	}
 	blockedClientsMutex.Unlock()
+	return client
+}
+
+// waitBlocked waits until a blocked client is served, woken, unblocked or
+// reaches its deadline; the zero deadline waits forever, and inside EXEC
+// there is no wait at all. It reports true if the client was woken and
+// should retry. Otherwise it writes the client's reply: the one left by
+// whoever served or unblocked it, or a null reply on timeout.
+func waitBlocked(client *BlockedClient, deadline time.Time) bool {
+	if c, ok := client.conn.(*Client); ok {
+		start := time.Now()
+		defer func() { c.blockedTime += time.Since(start) }()
+	}
+
+	var timedOut <-chan time.Time
+	if c, ok := client.conn.(*Client); ok && c.inExec {
+		// nothing else can run for the client during EXEC, so a blocking
+		// command there times out at once, as in Redis
+		expired := make(chan time.Time)
+		close(expired)
+		timedOut = expired
+	} else if !deadline.IsZero() {
+		timedOut = clock.After(deadline.Sub(clock.Now()))
+	}
+
+	select {
+	case <-client.done:
+	case <-timedOut:
+		// only reply if nobody served the client in the meantime
+		blockedClientsMutex.Lock()
+		removed := removeBlockedClient(client)
+		blockedClientsMutex.Unlock()
+		if removed {
+			writeNullArray(client.conn)
+			return false
+		}
+		<-client.done
+	}
+
+	blockedClientsMutex.RLock()
+	woken, reply := client.woken, client.reply
+	blockedClientsMutex.RUnlock()
+	if reply != "" {
+		client.conn.Write([]byte(reply))
+	}
+	return woken
+}
 
-	// start a goroutine to handle the blocking
-	go func() {
-		defer func() {
-			// remove client from blocked clients when done
-			blockedClientsMutex.Lock()
-			clients := blockedClients[listKey]
-			for i, c := range clients {
-				if c == client {
-					blockedClients[listKey] = append(clients[:i], clients[i+1:]...)
-					if len(blockedClients[listKey]) == 0 {
-						delete(blockedClients, listKey)
-					}
-					break
+// removeBlockedClient removes a client from the blocked clients registry
+// under every key it waits on, and reports whether it was still
+// registered. Whoever removes a client owns its reply. The caller must hold
+// blockedClientsMutex.
+func removeBlockedClient(client *BlockedClient) bool {
+	registered := false
+	for _, key := range client.keys {
+		clients := blockedClients[key]
+		for i, c := range clients {
+			if c == client {
+				blockedClients[key] = append(clients[:i:i], clients[i+1:]...)
+				if len(blockedClients[key]) == 0 {
+					delete(blockedClients, key)
 				}
-			}
-			blockedClientsMutex.Unlock()
-		}()
-
-		if timeout == 0 {
-			// block indefinitely
-			<-client.done
-		} else {
-			// block with timeout
-			timeoutDuration := time.Duration(timeout * float64(time.Second))
-			select {
-			case <-client.done:
-				// element became available
-			case <-time.After(timeoutDuration):
-				// timeout reached, send null response
-				writeNullBulkString(conn)
+				registered = true
+				break
 			}
 		}
-	}()
+	}
+	return registered
 }
 
-// notifyBlockedClients checks if there are blocked clients waiting for the given list key
-// and notifies the longest-waiting client
-func notifyBlockedClients(listKey string) {
+// unblockClient releases a blocked client as CLIENT UNBLOCK does: either as if
+// its timeout fired, or with an UNBLOCKED error. It reports whether conn was
+// blocked.
+func unblockClient(conn net.Conn, withError bool) bool {
 	blockedClientsMutex.Lock()
 	defer blockedClientsMutex.Unlock()
 
-	clients, exists := blockedClients[listKey]
-	if !exists || len(clients) == 0 {
-		return
-	}
+	for _, clients := range blockedClients {
+		for _, client := range clients {
+			if client.conn != conn {
+				continue
+			}
 
-	// find the longest-waiting client (first in the slice)
-	client := clients[0]
+			removeBlockedClient(client)
+			client.reply = "*-1\r\n"
+			if withError {
+				client.reply = "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n"
+			}
+			close(client.done)
+			return true
+		}
+	}
+	return false
+}
 
-	// try to pop an element for this client
-	value, exists := DB.Load(listKey)
-	if !exists {
-		return
+// blockingLen returns the number of elements a blocking pop could take from
+// value: the length of a list or sorted set, and 0 for anything else
+func blockingLen(value any) int {
+	switch v := value.(type) {
+	case ListEntry:
+		return v.list.len()
+	case ZSetEntry:
+		return v.zset.len()
 	}
+	return 0
+}
 
-	listEntry, ok := value.(ListEntry)
-	if !ok || len(listEntry.elements) == 0 {
-		return
+// serveBlockedClient calls the serve function of a client and keeps the
+// reply for the client to write once released. The caller must hold
+// blockedClientsMutex and the lock of key.
+func serveBlockedClient(client *BlockedClient, key string) bool {
+	reply, ok := client.serve(key)
+	if ok {
+		client.reply = reply
 	}
+	return ok
+}
 
-	// pop the first element
-	poppedElement := listEntry.elements[0]
-	listEntry.elements = listEntry.elements[1:]
+// notifyBlockedClients hands the data now available at key to the clients
+// waiting on it, longest-waiting first, for as long as the list or sorted
+// set has elements, skipping the clients that can't take what the key
+// holds. Reading a stream doesn't consume it, so every client waiting on a
+// stream gets the chance to read it. The caller must hold the lock of key.
+func notifyBlockedClients(key string) {
+	blockedClientsMutex.Lock()
+	defer blockedClientsMutex.Unlock()
 
-	// update or delete the list
-	if len(listEntry.elements) == 0 {
-		DB.Delete(listKey)
-	} else {
-		DB.Store(listKey, listEntry)
+	if value, exists := lookupKey(key); exists {
+		if _, ok := value.(StreamEntry); ok {
+			for _, client := range slices.Clone(blockedClients[key]) {
+				if client.serve == nil {
+					client.woken = true
+				} else if !serveBlockedClient(client, key) {
+					continue
+				}
+				removeBlockedClient(client)
+				close(client.done)
+			}
+			return
+		}
 	}
 
-	// send response to the blocked client
-	writeArray(client.conn, []string{listKey, poppedElement})
+	// woken clients retry later, so count the elements they will want
+	// rather than letting every waiter chase the same element
+	reserved := 0
+	for _, client := range slices.Clone(blockedClients[key]) {
+		value, exists := lookupKey(key)
+		if !exists || blockingLen(value) <= reserved {
+			return
+		}
 
-	// remove client from blocked clients list
-	blockedClients[listKey] = clients[1:]
-	if len(blockedClients[listKey]) == 0 {
-		delete(blockedClients, listKey)
+		if client.serve == nil {
+			client.woken = true
+			reserved++
+		} else if !serveBlockedClient(client, key) {
+			// the client waits for another type, like a list pop on a key
+			// that now holds a sorted set, so let the ones behind it have
+			// their turn
+			continue
+		}
+		removeBlockedClient(client)
+		close(client.done)
 	}
-
-	// signal the client to stop blocking
-	close(client.done)
 }
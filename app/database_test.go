@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReadCommandsLazyExpiry checks that the read-locked list commands
+// still reclaim an expired list, and still reject other types
+func TestReadCommandsLazyExpiry(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+
+	tests := []struct {
+		args          []string
+		live, expired string
+	}{
+		{[]string{"LLEN", "ro-list"}, ":2\r\n", ":0\r\n"},
+		{[]string{"LRANGE", "ro-list", "0", "-1"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "*0\r\n"},
+		{[]string{"LPOS", "ro-list", "b"}, ":1\r\n", "$-1\r\n"},
+	}
+	for _, tt := range tests {
+		c.do("DEL", "ro-list", "ro-str")
+		c.do("RPUSH", "ro-list", "a", "b")
+		c.do("PEXPIRE", "ro-list", "100")
+		c.do("SET", "ro-str", "v")
+
+		if got := c.do(tt.args...); got != tt.live {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.live)
+		}
+		wrongType := append([]string{tt.args[0], "ro-str"}, tt.args[2:]...)
+		if got := c.do(wrongType...); got != "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+			t.Errorf("%v = %q, want WRONGTYPE", wrongType, got)
+		}
+
+		fake.advance(101 * time.Millisecond)
+		if got := c.do(tt.args...); got != tt.expired {
+			t.Errorf("%v after the TTL = %q, want %q", tt.args, got, tt.expired)
+		}
+		if _, ok := DB.Load("ro-list"); ok {
+			t.Errorf("%v left the expired list stored", tt.args)
+		}
+	}
+}
+
+// discardConn is a connection that throws replies away, so benchmarks
+// measure the command rather than the network
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// BenchmarkLRangeConcurrent compares concurrent LRANGE calls on one list
+// taking the shard's read lock, as they do now, against running them one at
+// a time behind a single mutex, as they did before the sharded store
+func BenchmarkLRangeConcurrent(b *testing.B) {
+	DB.Delete("bench-list")
+	args := []string{"RPUSH", "bench-list"}
+	for i := range 100 {
+		args = append(args, "element-"+strconv.Itoa(i))
+	}
+	handleRPush(args, discardConn{})
+	lrange := []string{"LRANGE", "bench-list", "0", "-1"}
+
+	b.Run("read-lock", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				handleLRange(lrange, discardConn{})
+			}
+		})
+	})
+
+	b.Run("single-mutex", func(b *testing.B) {
+		var mu sync.Mutex
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				handleLRange(lrange, discardConn{})
+				mu.Unlock()
+			}
+		})
+	})
+}
+
+func TestCollectionLimits(t *testing.T) {
+	const (
+		errSize    = "-ERR element exceeds collection-max-element-size\r\n"
+		errEntries = "-ERR collection would exceed collection-max-entries\r\n"
+	)
+	// each command adds the given elements to key "limit"
+	adds := []struct {
+		name string
+		add  func(elems ...string) []string
+		size func(c *testClient) string
+	}{
+		{"RPUSH", func(e ...string) []string { return append([]string{"RPUSH", "limit"}, e...) }, func(c *testClient) string { return c.do("LLEN", "limit") }},
+		{"LPUSH", func(e ...string) []string { return append([]string{"LPUSH", "limit"}, e...) }, func(c *testClient) string { return c.do("LLEN", "limit") }},
+		{"SADD", func(e ...string) []string { return append([]string{"SADD", "limit"}, e...) }, func(c *testClient) string { return c.do("SCARD", "limit") }},
+		{"HSET", func(e ...string) []string {
+			args := []string{"HSET", "limit"}
+			for _, f := range e {
+				args = append(args, f, "v")
+			}
+			return args
+		}, func(c *testClient) string { return c.do("HLEN", "limit") }},
+		{"ZADD", func(e ...string) []string {
+			args := []string{"ZADD", "limit"}
+			for _, m := range e {
+				args = append(args, "1", m)
+			}
+			return args
+		}, func(c *testClient) string { return c.do("ZCARD", "limit") }},
+	}
+
+	c := newTestClient(t)
+	defer c.do("CONFIG", "SET", "collection-max-element-size", "0")
+	defer c.do("CONFIG", "SET", "collection-max-entries", "0")
+
+	for _, cmd := range adds {
+		t.Run(cmd.name, func(t *testing.T) {
+			// off by default
+			c.do("DEL", "limit")
+			if got := c.do(cmd.add("a", "b", "c", "d", "eeeeeeeeee")...); got[0] == '-' {
+				t.Errorf("%s with no limits = %q", cmd.name, got)
+			}
+
+			c.do("CONFIG", "SET", "collection-max-element-size", "5")
+			c.do("CONFIG", "SET", "collection-max-entries", "3")
+			c.do("DEL", "limit")
+
+			if got := c.do(cmd.add("aaaaa")...); got[0] == '-' {
+				t.Errorf("%s of an element at the size limit = %q", cmd.name, got)
+			}
+			if got := c.do(cmd.add("bbbbbb")...); got != errSize {
+				t.Errorf("%s of an element over the size limit = %q, want %q", cmd.name, got, errSize)
+			}
+			if got := c.do(cmd.add("b", "c")...); got[0] == '-' {
+				t.Errorf("%s up to the entries limit = %q", cmd.name, got)
+			}
+			if got := c.do(cmd.add("d")...); got != errEntries {
+				t.Errorf("%s over the entries limit = %q, want %q", cmd.name, got, errEntries)
+			}
+			if got := cmd.size(c); got != ":3\r\n" {
+				t.Errorf("size after the rejected %s = %q, want 3", cmd.name, got)
+			}
+
+			c.do("CONFIG", "SET", "collection-max-element-size", "0")
+			c.do("CONFIG", "SET", "collection-max-entries", "0")
+		})
+	}
+}
+
+// TestStoreClearsTTL checks that every STORE-style command writes its
+// destination as a new value, without the TTL the old one had
+func TestStoreClearsTTL(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "st-set", "st-zset", "st-list")
+	c.do("SADD", "st-set", "a", "b")
+	c.do("ZADD", "st-zset", "1", "a", "2", "b")
+	c.do("RPUSH", "st-list", "2", "1")
+
+	for _, args := range [][]string{
+		{"SINTERSTORE", "st-dst", "st-set"},
+		{"SUNIONSTORE", "st-dst", "st-set"},
+		{"SDIFFSTORE", "st-dst", "st-set"},
+		{"ZUNIONSTORE", "st-dst", "1", "st-zset"},
+		{"ZINTERSTORE", "st-dst", "1", "st-zset"},
+		{"ZDIFFSTORE", "st-dst", "1", "st-zset"},
+		{"ZRANGESTORE", "st-dst", "st-zset", "0", "-1"},
+		{"SORT", "st-list", "STORE", "st-dst"},
+	} {
+		// the old destination is a string or, for a different type, a list
+		for _, setup := range []string{"SET", "RPUSH"} {
+			c.do("DEL", "st-dst")
+			c.do(setup, "st-dst", "old")
+			if got := c.do("EXPIRE", "st-dst", "100"); got != ":1\r\n" {
+				t.Fatalf("EXPIRE st-dst 100 = %q, want :1", got)
+			}
+			if got := c.do(args...); got != ":2\r\n" {
+				t.Errorf("%v = %q, want :2", args, got)
+			}
+			if got := c.do("TTL", "st-dst"); got != ":-1\r\n" {
+				t.Errorf("TTL after %v overwrote a %s = %q, want :-1", args, setup, got)
+			}
+		}
+	}
+}
+
+// TestStalledBlockedReader checks that serving a blocked client whose
+// connection isn't being read doesn't hold up the client that pushed, nor
+// anyone else
+func TestStalledBlockedReader(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "stall", "stall-other")
+
+	// the stalled client never reads the reply BLPOP gets, and the pipe
+	// under it has no buffer, so writing that reply blocks for good
+	stalled := newTestClient(t)
+	if _, err := stalled.conn.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$5\r\nstall\r\n$1\r\n0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitForBlocked(t, "stall", 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if got := c.do("RPUSH", "stall", "a"); got != ":1\r\n" {
+			t.Errorf("RPUSH stall a = %q, want :1", got)
+		}
+		if got := c.do("RPUSH", "stall", "b"); got != ":1\r\n" {
+			t.Errorf("second RPUSH stall = %q, want :1", got)
+		}
+
+		// other clients can still block and be served
+		other := newTestClient(t)
+		reply := make(chan string, 1)
+		go func() { reply <- other.do("BLPOP", "stall-other", "0") }()
+		waitForBlocked(t, "stall-other", 1)
+		c.do("RPUSH", "stall-other", "x")
+		if got, want := <-reply, encodeValue([]string{"stall-other", "x"}); got != want {
+			t.Errorf("BLPOP stall-other = %q, want %q", got, want)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a blocked client that doesn't read its reply held up the server")
+	}
+
+	got, err := readReply(stalled.reader)
+	if want := encodeValue([]string{"stall", "a"}); err != nil || got != want {
+		t.Errorf("reply the stalled client finally read = %q, %v, want %q", got, err, want)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// handleDebug implements the DEBUG subcommands used to inspect and test
+// server internals
+func handleDebug(args []string, conn net.Conn) {
+	switch strings.ToUpper(args[1]) {
+	case "STRINGMATCH-LEN":
+		// DEBUG STRINGMATCH-LEN pattern string
+		if len(args) != 4 {
+			writeError(conn, "wrong number of arguments for 'debug|stringmatch-len' command")
+			return
+		}
+		if stringMatch(args[2], args[3], false) {
+			writeInteger(conn, 1)
+		} else {
+			writeInteger(conn, 0)
+		}
+	case "SET-ACTIVE-EXPIRE":
+		// DEBUG SET-ACTIVE-EXPIRE 0|1
+		if len(args) != 3 || (args[2] != "0" && args[2] != "1") {
+			writeError(conn, "syntax error")
+			return
+		}
+		activeExpireEnabled.Store(args[2] == "1")
+		writeSimpleString(conn, "OK")
+	case "OBJECT":
+		// DEBUG OBJECT key
+		if len(args) != 3 {
+			writeError(conn, "wrong number of arguments for 'debug|object' command")
+			return
+		}
+		key := args[2]
+		unlock := DB.Lock(key)
+		defer unlock()
+
+		// look at the raw value so an expired key that hasn't been reclaimed
+		// yet can still be inspected
+		value, ok := DB.Load(key)
+		if !ok {
+			writeError(conn, "no such key")
+			return
+		}
+
+		expiresAt := int64(-1)
+		if deadline := expiresAtOf(value); !deadline.IsZero() {
+			expiresAt = deadline.UnixMilli()
+		}
+		writeSimpleString(conn, fmt.Sprintf("refcount:1 type:%s encoding:%s lru_seconds_idle:%d expires_at_ms:%d expired:%t",
+			typeName(value), objectEncoding(value), int(DB.Access(key).idleTime()/time.Second), expiresAt, isExpired(value)))
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try DEBUG HELP.", args[1]))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDebugLazyExpiryAtDeadline sets a PX expiry with active expiry off and
+// moves the fake clock across the deadline, checking that the key lives up
+// to the deadline and is reclaimed lazily on the first access after it
+func TestDebugLazyExpiryAtDeadline(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	if got := c.do("DEBUG", "SET-ACTIVE-EXPIRE", "0"); got != "+OK\r\n" {
+		t.Fatalf("DEBUG SET-ACTIVE-EXPIRE 0 = %q", got)
+	}
+	c.do("DEL", "lazy")
+	c.do("SET", "lazy", "v", "PX", "1000")
+	deadline := fake.Now().Add(time.Second).UnixMilli()
+
+	object := c.do("DEBUG", "OBJECT", "lazy")
+	if want := "expires_at_ms:" + strconv.FormatInt(deadline, 10) + " expired:false"; !strings.Contains(object, want) {
+		t.Errorf("DEBUG OBJECT = %q, want it to contain %q", object, want)
+	}
+
+	fake.advance(time.Second)
+	if got := c.do("GET", "lazy"); got != "$1\r\nv\r\n" {
+		t.Errorf("GET at the deadline = %q, want the value", got)
+	}
+
+	fake.advance(time.Millisecond)
+	// nothing has touched the key since the deadline passed, so it is still
+	// stored, but reported as expired
+	object = c.do("DEBUG", "OBJECT", "lazy")
+	if !strings.Contains(object, " expired:true") {
+		t.Errorf("DEBUG OBJECT past the deadline = %q, want expired:true", object)
+	}
+	if got := c.do("GET", "lazy"); got != "$-1\r\n" {
+		t.Errorf("GET past the deadline = %q, want null", got)
+	}
+	if got := c.do("DEBUG", "OBJECT", "lazy"); got != "-ERR no such key\r\n" {
+		t.Errorf("DEBUG OBJECT after the lazy expiry = %q, want no such key", got)
+	}
+}
+
+func TestDebugObject(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "dbg")
+	c.do("RPUSH", "dbg", "a")
+
+	first := c.do("DEBUG", "OBJECT", "dbg")
+	if !strings.HasPrefix(first, "+refcount:1 type:list encoding:") || !strings.Contains(first, "expires_at_ms:-1 expired:false") {
+		t.Errorf("DEBUG OBJECT = %q", first)
+	}
+	if second := c.do("DEBUG", "OBJECT", "dbg"); second != first {
+		t.Errorf("DEBUG OBJECT changed between calls: %q, then %q", first, second)
+	}
+}
+
+func TestDebugArguments(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"DEBUG", "SET-ACTIVE-EXPIRE", "2"}, "-ERR syntax error\r\n"},
+		{[]string{"DEBUG", "SET-ACTIVE-EXPIRE"}, "-ERR syntax error\r\n"},
+		{[]string{"DEBUG", "OBJECT"}, "-ERR wrong number of arguments for 'debug|object' command\r\n"},
+		{[]string{"DEBUG", "SLEEP", "0"}, "-ERR unknown subcommand 'SLEEP'. Try DEBUG HELP.\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// activeExpireInterval is how often the active expiry cycle runs
+	activeExpireInterval = 100 * time.Millisecond
+
+	// activeExpireShardsPerCycle is how many shards each cycle sweeps, so the
+	// whole keyspace is covered every numShards/activeExpireShardsPerCycle cycles
+	activeExpireShardsPerCycle = 16
+)
+
+// activeExpireEnabled can be turned off with DEBUG SET-ACTIVE-EXPIRE 0 so
+// that expiry only happens lazily, when a key is accessed
+var activeExpireEnabled atomic.Bool
+
+// expiredKeys counts keys removed because their TTL elapsed, and
+// expiredFields hash fields removed because theirs did
+var (
+	expiredKeys   atomic.Int64
+	expiredFields atomic.Int64
+)
+
+func init() {
+	activeExpireEnabled.Store(true)
+}
+
+// deleteExpired removes every expired key owned by shard i, and the expired
+// fields of its hashes, and returns how many keys were removed. A hash left
+// without fields is removed too, but isn't counted as an expired key.
+func (ks *Keyspace) deleteExpired(i int) int {
+	s := ks.shards[i]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for key, value := range s.items {
+		if isExpired(value) {
+			delete(s.items, key)
+			delete(s.access, key)
+			removed++
+			continue
+		}
+		if hash, ok := value.(HashEntry); ok && len(hash.fieldExpires) > 0 {
+			if deleteExpiredFields(hash) > 0 && len(hash.fields) == 0 {
+				delete(s.items, key)
+				delete(s.access, key)
+			}
+		}
+	}
+	return removed
+}
+
+// activeExpireCycle periodically sweeps the keyspace a few shards at a time,
+// reclaiming expired keys that are never accessed again
+func activeExpireCycle() {
+	next := 0
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !activeExpireEnabled.Load() {
+			continue
+		}
+		for i := 0; i < activeExpireShardsPerCycle; i++ {
+			expiredKeys.Add(int64(DB.deleteExpired(next)))
+			next = (next + 1) % numShards
+		}
+	}
+}
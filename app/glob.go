@@ -0,0 +1,134 @@
+package main
+
+// stringMatch reports whether str matches the glob-style pattern, following
+// the rules of Redis's stringmatchlen: '*' matches any run of characters,
+// '?' matches exactly one, "[abc]" matches one of the listed characters,
+// "[^abc]" negates the class, "[a-z]" matches a range (in either order) and
+// a backslash matches the next character literally.
+//
+// KEYS, SCAN, CONFIG GET and any other pattern-taking command must go
+// through this function so they all agree on the edge cases.
+func stringMatch(pattern, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return matchBytes([]byte(pattern), []byte(str), nocase, &skipLongerMatches)
+}
+
+// matchBytes does the matching for stringMatch. Once the part of a pattern
+// after a '*' has failed to match anywhere in the rest of the string, it
+// sets skipLongerMatches: an earlier '*' matching more characters would
+// only leave less of the string for that same part to match, so every
+// enclosing '*' gives up at once instead of backtracking exponentially on
+// patterns such as "*a*a*a*a*a*b".
+func matchBytes(pattern, str []byte, nocase bool, skipLongerMatches *bool) bool {
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			// collapse runs of '*'
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if matchBytes(pattern[1:], str, nocase, skipLongerMatches) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				str = str[1:]
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			str = str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			match := false
+			for {
+				if len(pattern) == 0 {
+					// unterminated class: treat the end of the pattern as ']'
+					break
+				}
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					c := str[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(pattern[0], str[0], nocase) {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+			if len(pattern) == 0 {
+				// the class consumed the rest of the pattern
+				return len(str) == 0
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			if !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		default:
+			if !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+
+	// trailing '*' can match the empty remainder
+	if len(str) == 0 {
+		for len(pattern) > 0 && pattern[0] == '*' {
+			pattern = pattern[1:]
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		nocase       bool
+		want         bool
+	}{
+		// literals, '?' and '*'
+		{"", "", false, true},
+		{"", "a", false, false},
+		{"abc", "abc", false, true},
+		{"abc", "abd", false, false},
+		{"abc", "ab", false, false},
+		{"a?c", "abc", false, true},
+		{"a?c", "ac", false, false},
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"**", "", false, true},
+		{"a*", "a", false, true},
+		{"a*", "abc", false, true},
+		{"*c", "abc", false, true},
+		{"*c", "abd", false, false},
+		{"a*c", "ac", false, true},
+		{"a*c", "abbbc", false, true},
+		{"a*c", "abbbd", false, false},
+		{"a**c", "abc", false, true},
+		{"*a*b*", "xxaxxbxx", false, true},
+		{"*a*b*", "xxbxxaxx", false, false},
+		{"h?llo*", "hello world", false, true},
+
+		// classes, ranges and negation
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"h[b-a]llo", "hallo", false, true},
+		{"[^a-c]", "d", false, true},
+		{"[^a-c]", "b", false, false},
+		{"[a-]", "-", false, false},
+		{"[]", "a", false, false},
+		{"[a", "a", false, true},
+		{"[a", "ab", false, false},
+		{"a[", "a", false, false},
+		{"[[]", "[", false, true},
+		{"[]]", "]", false, false},
+		{"[\\]]", "]", false, true},
+		{"[\\-]", "-", false, true},
+		{"[\\^a]", "^", false, true},
+
+		// escapes
+		{"\\*", "*", false, true},
+		{"\\*", "a", false, false},
+		{"\\?", "?", false, true},
+		{"\\[a]", "[a]", false, true},
+		{"\\\\", "\\", false, true},
+		{"a\\", "a\\", false, true},
+		{"*\\*", "abc*", false, true},
+
+		// case folding
+		{"HELLO", "hello", false, false},
+		{"HELLO", "hello", true, true},
+		{"h[A-Z]llo", "hello", true, true},
+		{"h[a-z]llo", "hEllo", true, true},
+		{"h[a-z]llo", "hEllo", false, false},
+		{"h[^E]llo", "hello", true, false},
+
+		// nested stars that must not backtrack exponentially
+		{"*a*a*a*a*a*b", strings.Repeat("a", 50), false, false},
+		{"*a*a*a*a*a*b", strings.Repeat("a", 50) + "b", false, true},
+		{"a*b*c*d*e*", "axbxcxdxe", false, true},
+		{"a*b*c*d*e*f", "axbxcxdxe", false, false},
+	}
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.str, tt.nocase); got != tt.want {
+			t.Errorf("stringMatch(%q, %q, %v) = %v, want %v", tt.pattern, tt.str, tt.nocase, got, tt.want)
+		}
+	}
+}
+
+// TestStringMatchLongNestedLoops mirrors Redis's regression test for
+// patterns with many stars against a long string, which take forever
+// without the skipLongerMatches early exit
+func TestStringMatchLongNestedLoops(t *testing.T) {
+	pattern := strings.Repeat("a*", 20) + "b"
+	str := strings.Repeat("a", 3000)
+
+	start := time.Now()
+	if stringMatch(pattern, str, false) {
+		t.Errorf("stringMatch(%q, a*3000) = true, want false", pattern)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stringMatch took %v", elapsed)
+	}
+}
+
+func TestDebugStringMatchLen(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"DEBUG", "STRINGMATCH-LEN", "h*o", "hello"}, ":1\r\n"},
+		{[]string{"DEBUG", "STRINGMATCH-LEN", "h[^e]llo", "hello"}, ":0\r\n"},
+		{[]string{"DEBUG", "STRINGMATCH-LEN", "h*o"}, "-ERR wrong number of arguments for 'debug|stringmatch-len' command\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
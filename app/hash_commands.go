@@ -0,0 +1,767 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleHSet sets one or more field/value pairs in a hash, creating the key
+// if needed, and replies with the number of fields that were added rather
+// than updated
+func handleHSet(args []string, conn net.Conn) {
+	if len(args)%2 != 0 {
+		writeError(conn, fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+		return
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var hash HashEntry
+	if value, exists := lookupKey(key); exists {
+		var ok bool
+		if hash, ok = value.(HashEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	} else {
+		hash = HashEntry{fields: make(map[string]string)}
+	}
+
+	// count the new fields up front, so the limits can be checked before
+	// anything changes; a field named twice is only new once
+	added := 0
+	seen := make(map[string]bool)
+	for i := 2; i < len(args); i += 2 {
+		if _, exists := hash.fields[args[i]]; !exists && !seen[args[i]] {
+			added++
+		}
+		seen[args[i]] = true
+	}
+	if err := checkCollectionLimits(len(hash.fields)+added, args[2:]...); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	// setting a field clears its TTL
+	for i := 2; i < len(args); i += 2 {
+		hash.fields[args[i]] = args[i+1]
+		delete(hash.fieldExpires, args[i])
+	}
+	DB.Store(key, withEncoding(hash))
+	writeInteger(conn, added)
+}
+
+// handleHGet replies with the value of a hash field, or null if the field or
+// the key doesn't exist
+func handleHGet(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeNullBulkString(conn)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	fieldValue, ok := hash.fields[args[2]]
+	if !ok {
+		writeNullBulkString(conn)
+		return
+	}
+	writeBulkString(conn, fieldValue)
+}
+
+// handleHDel removes fields from a hash, deleting the key once the last one
+// is gone, and replies with the number of fields removed
+func handleHDel(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	removed := 0
+	for _, field := range args[2:] {
+		if _, exists := hash.fields[field]; exists {
+			delete(hash.fields, field)
+			delete(hash.fieldExpires, field)
+			removed++
+		}
+	}
+
+	if len(hash.fields) == 0 {
+		DB.Delete(key)
+	} else if removed > 0 {
+		DB.Store(key, hash)
+	}
+	writeInteger(conn, removed)
+}
+
+// handleHExists replies 1 if the hash has the field, 0 otherwise
+func handleHExists(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	if _, ok := hash.fields[args[2]]; ok {
+		writeInteger(conn, 1)
+		return
+	}
+	writeInteger(conn, 0)
+}
+
+// handleHLen replies with the number of fields in a hash
+func handleHLen(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	writeInteger(conn, len(hash.fields))
+}
+
+// hashFields returns the fields of a hash in sorted order. Go randomizes map
+// iteration, so sorting keeps HKEYS, HVALS and HGETALL consistent with each
+// other for as long as the hash doesn't change.
+func hashFields(hash HashEntry) []string {
+	return slices.Sorted(maps.Keys(hash.fields))
+}
+
+// hashReadGeneric implements HGETALL, HKEYS and HVALS, replying with the
+// fields, the values or both. Fields and values together make a map, which
+// RESP2 clients get as a flat field/value array.
+func hashReadGeneric(args []string, conn net.Conn, withFields, withValues bool) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		if withFields && withValues {
+			writeValue(conn, respMap{})
+		} else {
+			writeArray(conn, []string{})
+		}
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	if withFields && withValues {
+		result := make(respMap, 0, len(hash.fields)*2)
+		for _, field := range hashFields(hash) {
+			result = append(result, field, hash.fields[field])
+		}
+		writeValue(conn, result)
+		return
+	}
+
+	result := make([]string, 0, len(hash.fields))
+	for _, field := range hashFields(hash) {
+		if withFields {
+			result = append(result, field)
+		} else {
+			result = append(result, hash.fields[field])
+		}
+	}
+	writeArray(conn, result)
+}
+
+// handleHGetAll replies with every field and value of a hash
+func handleHGetAll(args []string, conn net.Conn) {
+	hashReadGeneric(args, conn, true, true)
+}
+
+// handleHKeys replies with the field names of a hash
+func handleHKeys(args []string, conn net.Conn) {
+	hashReadGeneric(args, conn, true, false)
+}
+
+// handleHVals replies with the values of a hash
+func handleHVals(args []string, conn net.Conn) {
+	hashReadGeneric(args, conn, false, true)
+}
+
+// handleHMGet replies with the values of the given fields, with nulls for
+// fields that don't exist
+func handleHMGet(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	values := make([]*string, len(args)-2)
+	if !exists {
+		writeNullableArray(conn, values)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	for i, field := range args[2:] {
+		if fieldValue, ok := hash.fields[field]; ok {
+			values[i] = &fieldValue
+		}
+	}
+	writeNullableArray(conn, values)
+}
+
+// handleHSetNX sets a hash field only if it doesn't exist yet, replying 1 if
+// it was set and 0 otherwise
+func handleHSetNX(args []string, conn net.Conn) {
+	key, field := args[1], args[2]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var hash HashEntry
+	if value, exists := lookupKey(key); exists {
+		var ok bool
+		if hash, ok = value.(HashEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		if _, exists := hash.fields[field]; exists {
+			writeInteger(conn, 0)
+			return
+		}
+	} else {
+		hash = HashEntry{fields: make(map[string]string)}
+	}
+
+	if err := checkCollectionLimits(len(hash.fields)+1, field, args[3]); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	hash.fields[field] = args[3]
+	DB.Store(key, withEncoding(hash))
+	writeInteger(conn, 1)
+}
+
+// sampleKeys returns count distinct keys of m picked uniformly at random,
+// in random order, or all of them if m has no more than count. It takes at
+// most a single pass over the map with reservoir sampling, so that picking
+// a few keys doesn't cost collecting, let alone sorting, all of them.
+func sampleKeys[V any](m map[string]V, count int) []string {
+	count = min(count, len(m))
+	picked := make([]string, 0, count)
+	switch count {
+	case 0:
+		return picked
+	case 1:
+		// a single key needs no reservoir: stop at a random position
+		// rather than walking the whole map, or popping keys one at a
+		// time would take quadratic time
+		skip := rand.IntN(len(m))
+		for key := range m {
+			if skip == 0 {
+				return append(picked, key)
+			}
+			skip--
+		}
+	}
+	seen := 0
+	for key := range m {
+		if len(picked) < count {
+			picked = append(picked, key)
+		} else if j := rand.IntN(seen + 1); j < count {
+			picked[j] = key
+		}
+		seen++
+	}
+	rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	return picked
+}
+
+// sampleKeysWithRepeats returns count keys of m, each picked uniformly at
+// random on its own, so the same key may come up more than once
+func sampleKeysWithRepeats[V any](m map[string]V, count int) []string {
+	keys := slices.Collect(maps.Keys(m))
+	picked := make([]string, count)
+	for i := range picked {
+		picked[i] = keys[rand.IntN(len(keys))]
+	}
+	return picked
+}
+
+// handleHRandField implements HRANDFIELD key [count [WITHVALUES]]. Without a
+// count it replies with one random field; a positive count asks for up to
+// that many distinct fields, a negative one for exactly -count fields that
+// may repeat.
+func handleHRandField(args []string, conn net.Conn) {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3], "WITHVALUES")) {
+		writeError(conn, "syntax error")
+		return
+	}
+	withValues := len(args) == 4
+
+	count, hasCount := 1, len(args) >= 3
+	if hasCount {
+		var ok bool
+		count, ok = parseIntArg(args[2])
+		if !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+		// keep -count, and twice that with values, from overflowing
+		if count == math.MinInt || (withValues && count < -math.MaxInt/2) {
+			writeError(conn, "value is out of range")
+			return
+		}
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		if hasCount {
+			writeArray(conn, []string{})
+		} else {
+			writeNullBulkString(conn)
+		}
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	var picked []string
+	if count >= 0 {
+		picked = sampleKeys(hash.fields, count)
+	} else {
+		picked = sampleKeysWithRepeats(hash.fields, -count)
+	}
+
+	if !hasCount {
+		writeBulkString(conn, picked[0])
+		return
+	}
+	if !withValues {
+		writeArray(conn, picked)
+		return
+	}
+	result := make([]string, 0, len(picked)*2)
+	for _, field := range picked {
+		result = append(result, field, hash.fields[field])
+	}
+	writeArray(conn, result)
+}
+
+// handleHScan implements HSCAN key cursor [MATCH pattern] [COUNT count]
+// [NOVALUES], replying with the next cursor and a flat field/value array, or
+// just the fields with NOVALUES. Hashes still encoded as a listpack
+// are returned whole in a single call as Redis does.
+func handleHScan(args []string, conn net.Conn) {
+	opts, err := parseScanArgs(args, true)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeValue(conn, []any{"0", []string{}})
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	fields, next := hashFields(hash), uint64(0)
+	if objectEncoding(hash) == "hashtable" {
+		fields, next = scanPage(fields, opts.cursor, opts.count)
+	}
+
+	result := make([]string, 0, len(fields)*2)
+	for _, field := range fields {
+		if !opts.matches(field) {
+			continue
+		}
+		result = append(result, field)
+		if !opts.noValues {
+			result = append(result, hash.fields[field])
+		}
+	}
+	writeValue(conn, []any{strconv.FormatUint(next, 10), result})
+}
+
+// hashFieldMaxExpire is the latest deadline a hash field can have, in unix
+// milliseconds; Redis keeps field deadlines in 48 bits
+const hashFieldMaxExpire = 1<<48 - 1
+
+// parseHashFields parses the FIELDS numfields field ... arguments, starting
+// at args[i], that end the hash field expiry commands
+func parseHashFields(args []string, i int) ([]string, error) {
+	if i+1 >= len(args) || !strings.EqualFold(args[i], "FIELDS") {
+		return nil, errors.New("Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, ok := parseIntArg(args[i+1])
+	if !ok || n < 1 {
+		return nil, errors.New("Number of fields must be a positive integer")
+	}
+	if n != len(args)-i-2 {
+		return nil, errors.New("The `numfields` parameter must match the number of arguments")
+	}
+	return args[i+2:], nil
+}
+
+// hashFieldDeadline parses the time argument of a hash field expiry command
+// into a deadline in unix milliseconds. unit is the unit of the argument and
+// absolute tells whether it is a unix timestamp rather than a TTL.
+func hashFieldDeadline(arg string, unit time.Duration, absolute bool, command string) (int64, error) {
+	n, ok := parseStrictInt(arg)
+	if !ok {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+
+	invalid := errors.New("invalid expire time in '" + command + "' command")
+	scale := int64(unit / time.Millisecond)
+	if n < 0 || n > hashFieldMaxExpire/scale {
+		return 0, invalid
+	}
+	when := n * scale
+	if !absolute {
+		when += clock.Now().UnixMilli()
+	}
+	if when > hashFieldMaxExpire {
+		return 0, invalid
+	}
+	return when, nil
+}
+
+// hexpireGeneric implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT:
+// key time [NX | XX | GT | LT] FIELDS numfields field ... It replies with
+// one code per field: -2 if there is no such field, 0 if the condition
+// wasn't met, 1 if the TTL was set and 2 if the field was deleted because
+// the deadline has already passed.
+func hexpireGeneric(args []string, conn net.Conn, unit time.Duration, absolute bool) {
+	key := args[1]
+	when, err := hashFieldDeadline(args[2], unit, absolute, strings.ToLower(args[0]))
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	condition, next := "", 3
+	switch option := strings.ToUpper(args[3]); option {
+	case "NX", "XX", "GT", "LT":
+		condition, next = option, 4
+	}
+	fields, err := parseHashFields(args, next)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	results := make([]any, len(fields))
+	value, exists := lookupKey(key)
+	if !exists {
+		for i := range results {
+			results[i] = -2
+		}
+		writeValue(conn, results)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	now := clock.Now().UnixMilli()
+	for i, field := range fields {
+		if _, exists := hash.fields[field]; !exists {
+			results[i] = -2
+			continue
+		}
+
+		// a field without a TTL counts as never expiring for GT and LT
+		current, hasTTL := hash.fieldExpires[field]
+		if (condition == "NX" && hasTTL) || (condition == "XX" && !hasTTL) ||
+			(condition == "GT" && (!hasTTL || when <= current.UnixMilli())) ||
+			(condition == "LT" && hasTTL && when >= current.UnixMilli()) {
+			results[i] = 0
+			continue
+		}
+
+		// a deadline that has already passed deletes the field right away
+		if when <= now {
+			delete(hash.fields, field)
+			delete(hash.fieldExpires, field)
+			results[i] = 2
+			continue
+		}
+		if hash.fieldExpires == nil {
+			hash.fieldExpires = make(map[string]time.Time)
+		}
+		hash.fieldExpires[field] = time.UnixMilli(when)
+		results[i] = 1
+	}
+
+	if len(hash.fields) == 0 {
+		DB.Delete(key)
+	} else {
+		DB.Store(key, hash)
+	}
+	writeValue(conn, results)
+}
+
+func handleHExpire(args []string, conn net.Conn) {
+	hexpireGeneric(args, conn, time.Second, false)
+}
+
+func handleHPExpire(args []string, conn net.Conn) {
+	hexpireGeneric(args, conn, time.Millisecond, false)
+}
+
+func handleHExpireAt(args []string, conn net.Conn) {
+	hexpireGeneric(args, conn, time.Second, true)
+}
+
+func handleHPExpireAt(args []string, conn net.Conn) {
+	hexpireGeneric(args, conn, time.Millisecond, true)
+}
+
+// httlGeneric implements HTTL, HPTTL, HEXPIRETIME and HPEXPIRETIME: for
+// each field the remaining TTL, or with absolute the unix time at which it
+// expires, -1 for a field without a TTL and -2 for a missing field
+func httlGeneric(args []string, conn net.Conn, unit time.Duration, absolute bool) {
+	fields, err := parseHashFields(args, 2)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	results := make([]any, len(fields))
+	for i := range results {
+		results[i] = -2
+	}
+	if !exists {
+		writeValue(conn, results)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	for i, field := range fields {
+		if _, exists := hash.fields[field]; !exists {
+			continue
+		}
+		deadline, hasTTL := hash.fieldExpires[field]
+		switch {
+		case !hasTTL:
+			results[i] = -1
+		case absolute:
+			results[i] = int(deadline.UnixMilli() / int64(unit/time.Millisecond))
+		default:
+			// unlike TTL, Redis rounds a field's remaining time up. It is
+			// worked out in milliseconds, since a deadline can be further
+			// out than a time.Duration reaches.
+			remaining := deadline.UnixMilli() - clock.Now().UnixMilli()
+			scale := int64(unit / time.Millisecond)
+			results[i] = int((remaining + scale - 1) / scale)
+		}
+	}
+	writeValue(conn, results)
+}
+
+func handleHTTL(args []string, conn net.Conn) {
+	httlGeneric(args, conn, time.Second, false)
+}
+
+func handleHPTTL(args []string, conn net.Conn) {
+	httlGeneric(args, conn, time.Millisecond, false)
+}
+
+func handleHExpireTime(args []string, conn net.Conn) {
+	httlGeneric(args, conn, time.Second, true)
+}
+
+func handleHPExpireTime(args []string, conn net.Conn) {
+	httlGeneric(args, conn, time.Millisecond, true)
+}
+
+// handleHPersist removes the TTL of hash fields, replying for each with 1
+// if it had one, -1 if it didn't and -2 if there is no such field
+func handleHPersist(args []string, conn net.Conn) {
+	key := args[1]
+	fields, err := parseHashFields(args, 2)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	results := make([]any, len(fields))
+	for i := range results {
+		results[i] = -2
+	}
+	value, exists := lookupKey(key)
+	if !exists {
+		writeValue(conn, results)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	for i, field := range fields {
+		if _, exists := hash.fields[field]; !exists {
+			continue
+		}
+		if _, hasTTL := hash.fieldExpires[field]; !hasTTL {
+			results[i] = -1
+			continue
+		}
+		delete(hash.fieldExpires, field)
+		results[i] = 1
+	}
+	DB.Store(key, hash)
+	writeValue(conn, results)
+}
+
+// handleHGetEx implements HGETEX key [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST] FIELDS
+// numfields field ... It replies with the values of the fields, like HMGET,
+// and sets or removes the TTL of those that exist. A deadline that has
+// already passed deletes the fields after they are read.
+func handleHGetEx(args []string, conn net.Conn) {
+	key := args[1]
+
+	var when int64
+	setTTL, persist := false, false
+	i := 2
+	for ; i < len(args) && !strings.EqualFold(args[i], "FIELDS"); i++ {
+		option := strings.ToUpper(args[i])
+		if setTTL || persist {
+			writeError(conn, "Only one of EX, PX, EXAT, PXAT or PERSIST arguments can be specified")
+			return
+		}
+		switch option {
+		case "PERSIST":
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				writeError(conn, "syntax error")
+				return
+			}
+			unit := time.Second
+			if option[0] == 'P' {
+				unit = time.Millisecond
+			}
+			var err error
+			when, err = hashFieldDeadline(args[i+1], unit, strings.HasSuffix(option, "AT"), "hgetex")
+			if err != nil {
+				writeError(conn, err.Error())
+				return
+			}
+			setTTL = true
+			i++
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+	fields, err := parseHashFields(args, i)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	values := make([]*string, len(fields))
+	value, exists := lookupKey(key)
+	if !exists {
+		writeNullableArray(conn, values)
+		return
+	}
+	hash, ok := value.(HashEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	now := clock.Now().UnixMilli()
+	for i, field := range fields {
+		fieldValue, exists := hash.fields[field]
+		if !exists {
+			continue
+		}
+		values[i] = &fieldValue
+
+		switch {
+		case persist:
+			delete(hash.fieldExpires, field)
+		case setTTL && when <= now:
+			delete(hash.fields, field)
+			delete(hash.fieldExpires, field)
+		case setTTL:
+			if hash.fieldExpires == nil {
+				hash.fieldExpires = make(map[string]time.Time)
+			}
+			hash.fieldExpires[field] = time.UnixMilli(when)
+		}
+	}
+
+	if len(hash.fields) == 0 {
+		DB.Delete(key)
+	} else {
+		DB.Store(key, hash)
+	}
+	writeNullableArray(conn, values)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHashFieldTTLs walks a hash through setting, reading, conditionally
+// updating and removing field TTLs, and through fields expiring
+func TestHashFieldTTLs(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("DEL", "hf", "hf-missing", "hf-str")
+	c.do("HSET", "hf", "a", "1", "b", "2", "c", "3")
+	c.do("SET", "hf-str", "v")
+	now := fake.Now().UnixMilli()
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HPEXPIRE", "hf", "1500", "FIELDS", "2", "a", "x"}, "*2\r\n:1\r\n:-2\r\n"},
+		{[]string{"HPTTL", "hf", "FIELDS", "3", "a", "b", "x"}, "*3\r\n:1500\r\n:-1\r\n:-2\r\n"},
+		// HTTL rounds up, unlike TTL
+		{[]string{"HTTL", "hf", "FIELDS", "1", "a"}, "*1\r\n:2\r\n"},
+		{[]string{"HPEXPIRETIME", "hf", "FIELDS", "1", "a"}, encodeValue([]any{int(now + 1500)})},
+		{[]string{"HEXPIRETIME", "hf", "FIELDS", "1", "a"}, encodeValue([]any{int((now + 1500) / 1000)})},
+		{[]string{"HPTTL", "hf-missing", "FIELDS", "1", "a"}, "*1\r\n:-2\r\n"},
+		{[]string{"HPTTL", "hf-str", "FIELDS", "1", "a"}, "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		// conditions: a field without a TTL never expires for GT and LT
+		{[]string{"HPEXPIRE", "hf", "5000", "NX", "FIELDS", "2", "a", "b"}, "*2\r\n:0\r\n:1\r\n"},
+		{[]string{"HPEXPIRE", "hf", "9000", "XX", "FIELDS", "2", "a", "c"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"HPEXPIRE", "hf", "1000", "GT", "FIELDS", "2", "a", "c"}, "*2\r\n:0\r\n:0\r\n"},
+		{[]string{"HPEXPIRE", "hf", "1000", "LT", "FIELDS", "2", "a", "c"}, "*2\r\n:1\r\n:1\r\n"},
+		{[]string{"HPTTL", "hf", "FIELDS", "3", "a", "b", "c"}, "*3\r\n:1000\r\n:5000\r\n:1000\r\n"},
+
+		{[]string{"HPERSIST", "hf", "FIELDS", "3", "c", "c", "x"}, "*3\r\n:1\r\n:-1\r\n:-2\r\n"},
+		// writing a field drops its TTL
+		{[]string{"HSET", "hf", "b", "two"}, ":0\r\n"},
+		{[]string{"HPTTL", "hf", "FIELDS", "3", "a", "b", "c"}, "*3\r\n:1000\r\n:-1\r\n:-1\r\n"},
+
+		// a deadline in the past deletes the field
+		{[]string{"HPEXPIREAT", "hf", "1", "FIELDS", "1", "c"}, "*1\r\n:2\r\n"},
+		{[]string{"HMGET", "hf", "a", "b", "c"}, "*3\r\n$1\r\n1\r\n$3\r\ntwo\r\n$-1\r\n"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Fatalf("%v = %q, want %q", step.args, got, step.want)
+		}
+	}
+
+	// a expires, b stays
+	fake.advance(1001 * time.Millisecond)
+	if got := c.do("HGETALL", "hf"); got != "*2\r\n$1\r\nb\r\n$3\r\ntwo\r\n" {
+		t.Errorf("HGETALL after a expired = %q, want just b", got)
+	}
+	if got := c.do("HLEN", "hf"); got != ":1\r\n" {
+		t.Errorf("HLEN after a expired = %q, want :1", got)
+	}
+
+	// the key goes once its last field expires
+	c.do("HPEXPIRE", "hf", "10", "FIELDS", "1", "b")
+	fake.advance(time.Second)
+	if got := c.do("EXISTS", "hf"); got != ":0\r\n" {
+		t.Errorf("EXISTS once every field expired = %q, want :0", got)
+	}
+}
+
+func TestHashFieldTTLErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "hfe")
+	c.do("HSET", "hfe", "a", "1")
+
+	maxExpire := strconv.Itoa(hashFieldMaxExpire)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HEXPIRE", "hfe", "10", "a", "b", "c"}, "-ERR Mandatory argument FIELDS is missing or not at the right position\r\n"},
+		{[]string{"HEXPIRE", "hfe", "10", "NX", "XX", "FIELDS", "1", "a"}, "-ERR Mandatory argument FIELDS is missing or not at the right position\r\n"},
+		{[]string{"HEXPIRE", "hfe", "10", "FIELDS", "0", "a"}, "-ERR Number of fields must be a positive integer\r\n"},
+		{[]string{"HEXPIRE", "hfe", "10", "FIELDS", "2", "a"}, "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{[]string{"HEXPIRE", "hfe", "x", "FIELDS", "1", "a"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"HEXPIRE", "hfe", "-1", "FIELDS", "1", "a"}, "-ERR invalid expire time in 'hexpire' command\r\n"},
+		{[]string{"HPEXPIREAT", "hfe", maxExpire + "0", "FIELDS", "1", "a"}, "-ERR invalid expire time in 'hpexpireat' command\r\n"},
+		{[]string{"HGETEX", "hfe", "EX", "10", "PERSIST", "FIELDS", "1", "a"}, "-ERR Only one of EX, PX, EXAT, PXAT or PERSIST arguments can be specified\r\n"},
+		{[]string{"HGETEX", "hfe", "EX", "FIELDS", "1", "a"}, "-ERR value is not an integer or out of range\r\n"},
+		// the latest deadline a field can have
+		{[]string{"HPEXPIREAT", "hfe", maxExpire, "FIELDS", "1", "a"}, "*1\r\n:1\r\n"},
+		{[]string{"HPEXPIRETIME", "hfe", "FIELDS", "1", "a"}, "*1\r\n:" + maxExpire + "\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	// far enough out that the remaining time overflows a time.Duration
+	got := c.do("HPTTL", "hfe", "FIELDS", "1", "a")
+	if ms, _ := strconv.ParseInt(got[5:len(got)-2], 10, 64); ms < hashFieldMaxExpire/2 {
+		t.Errorf("HPTTL of a field expiring at the latest deadline = %q", got)
+	}
+}
+
+func TestHGetEx(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("DEL", "hgx")
+	c.do("HSET", "hgx", "a", "1", "b", "2")
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HGETEX", "hgx", "PX", "2000", "FIELDS", "2", "a", "x"}, "*2\r\n$1\r\n1\r\n$-1\r\n"},
+		{[]string{"HPTTL", "hgx", "FIELDS", "2", "a", "b"}, "*2\r\n:2000\r\n:-1\r\n"},
+		{[]string{"HGETEX", "hgx", "FIELDS", "1", "a"}, "*1\r\n$1\r\n1\r\n"},
+		{[]string{"HPTTL", "hgx", "FIELDS", "1", "a"}, "*1\r\n:2000\r\n"},
+		{[]string{"HGETEX", "hgx", "PERSIST", "FIELDS", "1", "a"}, "*1\r\n$1\r\n1\r\n"},
+		{[]string{"HPTTL", "hgx", "FIELDS", "1", "a"}, "*1\r\n:-1\r\n"},
+		{[]string{"HGETEX", "hgx", "EXAT", strconv.FormatInt(fake.Now().Unix()+10, 10), "FIELDS", "1", "b"}, "*1\r\n$1\r\n2\r\n"},
+		{[]string{"HEXPIRETIME", "hgx", "FIELDS", "1", "b"}, encodeValue([]any{int(fake.Now().Unix() + 10)})},
+		// a past deadline returns the values, then deletes the fields
+		{[]string{"HGETEX", "hgx", "PXAT", "1", "FIELDS", "2", "a", "b"}, "*2\r\n$1\r\n1\r\n$1\r\n2\r\n"},
+		{[]string{"EXISTS", "hgx"}, ":0\r\n"},
+		{[]string{"HGETEX", "hgx", "PX", "10", "FIELDS", "1", "a"}, "*1\r\n$-1\r\n"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Errorf("%v = %q, want %q", step.args, got, step.want)
+		}
+	}
+}
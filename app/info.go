@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// serverStartTime is used to report uptime
+var serverStartTime = time.Now()
+
+// infoSection is one section of the INFO reply
+type infoSection struct {
+	name      string
+	inDefault bool // included when INFO is called without arguments
+	render    func() string
+}
+
+// infoSections lists the INFO sections in the order they are reported
+var infoSections = []infoSection{
+	{"server", true, serverInfo},
+	{"clients", true, clientsInfo},
+	{"stats", true, statsInfo},
+	{"commandstats", false, commandStatsInfo},
+	{"keyspace", true, keyspaceInfo},
+}
+
+func serverInfo() string {
+	uptime := time.Since(serverStartTime)
+	return fmt.Sprintf("redis_version:7.2.0\r\nprocess_id:%d\r\ntcp_port:6379\r\nuptime_in_seconds:%d\r\nuptime_in_days:%d\r\n",
+		os.Getpid(), int64(uptime.Seconds()), int64(uptime.Hours()/24))
+}
+
+func clientsInfo() string {
+	return fmt.Sprintf("connected_clients:%d\r\n", connectedClients.Load())
+}
+
+// statsInfo renders the stats section of INFO
+func statsInfo() string {
+	return fmt.Sprintf("total_connections_received:%d\r\ntotal_commands_processed:%d\r\nexpired_keys:%d\r\nexpired_subkeys:%d\r\n",
+		totalConnectionsReceived.Load(), totalCommandsProcessed.Load(), expiredKeys.Load(), expiredFields.Load())
+}
+
+func keyspaceInfo() string {
+	keys, expires := 0, 0
+	DB.Range(func(key string, value any) bool {
+		if isExpired(value) {
+			return true
+		}
+		keys++
+		if !expiresAtOf(value).IsZero() {
+			expires++
+		}
+		return true
+	})
+	if keys == 0 {
+		return ""
+	}
+	return fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+}
+
+// handleInfo implements INFO [section ...]
+func handleInfo(args []string, conn net.Conn) {
+	requested := make(map[string]bool)
+	for _, section := range args[1:] {
+		requested[strings.ToLower(section)] = true
+	}
+	all := requested["all"] || requested["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		include := requested[section.name] || all
+		if len(requested) == 0 || requested["default"] {
+			include = include || section.inDefault
+		}
+		if !include {
+			continue
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		b.WriteString(section.render())
+	}
+	writeBulkString(conn, b.String())
+}
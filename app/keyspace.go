@@ -0,0 +1,241 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// numShards is the number of buckets the keyspace is split into. Keys never
+// move between shards, which is what lets a shard index double as a cursor.
+const numShards = 256
+
+// shard is one bucket of the keyspace
+type shard struct {
+	// lock is held by commands for the whole time they work on a key in this
+	// shard, so that a read-modify-write is atomic with respect to other
+	// commands touching the same key
+	lock sync.RWMutex
+
+	// mu only guards items and access and is never held across calls
+	mu     sync.RWMutex
+	items  map[string]any
+	access map[string]*keyAccess
+}
+
+// Keyspace is the server's key/value store, split into fixed shards
+type Keyspace struct {
+	shards [numShards]*shard
+}
+
+// NewKeyspace returns an empty keyspace
+func NewKeyspace() *Keyspace {
+	ks := &Keyspace{}
+	for i := range ks.shards {
+		ks.shards[i] = &shard{items: make(map[string]any), access: make(map[string]*keyAccess)}
+	}
+	return ks
+}
+
+// shardIndex returns the index of the shard that owns key
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % numShards)
+}
+
+func (ks *Keyspace) shardFor(key string) *shard {
+	return ks.shards[shardIndex(key)]
+}
+
+// Load returns the value stored at key
+func (ks *Keyspace) Load(key string) (any, bool) {
+	s := ks.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.items[key]
+	return value, ok
+}
+
+// Store sets the value stored at key. A key that already exists keeps its
+// access metadata.
+func (ks *Keyspace) Store(key string, value any) {
+	s := ks.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = value
+	if _, ok := s.access[key]; !ok {
+		s.access[key] = newKeyAccess()
+	}
+}
+
+// Delete removes key from the keyspace
+func (ks *Keyspace) Delete(key string) {
+	s := ks.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	delete(s.access, key)
+}
+
+// Access returns the access metadata of key, or nil if it doesn't exist
+func (ks *Keyspace) Access(key string) *keyAccess {
+	s := ks.shardFor(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.access[key]
+}
+
+// Range calls f for every key in the keyspace until f returns false. Each
+// shard is snapshotted before f is called, so f may modify the keyspace.
+func (ks *Keyspace) Range(f func(key string, value any) bool) {
+	for i := range ks.shards {
+		if !ks.RangeShard(i, f) {
+			return
+		}
+	}
+}
+
+// RandomKey returns a key picked at random with its value, or false if the
+// keyspace is empty. It picks a shard with probability proportional to its
+// size, then a random position inside it, so every key is equally likely
+// and only one shard is walked. A shard emptied between the two steps is
+// counted again.
+func (ks *Keyspace) RandomKey() (string, any, bool) {
+	for {
+		var sizes [numShards]int
+		total := 0
+		for i, s := range ks.shards {
+			s.mu.RLock()
+			sizes[i] = len(s.items)
+			s.mu.RUnlock()
+			total += sizes[i]
+		}
+		if total == 0 {
+			return "", nil, false
+		}
+
+		pick := rand.IntN(total)
+		i := 0
+		for pick >= sizes[i] {
+			pick -= sizes[i]
+			i++
+		}
+		s := ks.shards[i]
+		s.mu.RLock()
+		if n := len(s.items); n > 0 {
+			// the shard may have changed size since it was counted
+			skip := pick
+			if skip >= n {
+				skip = rand.IntN(n)
+			}
+			for key, value := range s.items {
+				if skip == 0 {
+					s.mu.RUnlock()
+					return key, value, true
+				}
+				skip--
+			}
+		}
+		s.mu.RUnlock()
+	}
+}
+
+// RangeShard calls f for every key owned by shard i until f returns false,
+// and reports whether it got through the whole shard. Like Range, it works
+// on a snapshot of the shard.
+func (ks *Keyspace) RangeShard(i int, f func(key string, value any) bool) bool {
+	s := ks.shards[i]
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.items))
+	values := make([]any, 0, len(s.items))
+	for k, v := range s.items {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	s.mu.RUnlock()
+
+	for j := range keys {
+		if !f(keys[j], values[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// lockedShards returns the distinct shards owning keys, in index order so
+// that every command acquires shard locks in the same order
+func (ks *Keyspace) lockedShards(keys []string) []*shard {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := shardIndex(key)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+
+	shards := make([]*shard, len(indexes))
+	for i, idx := range indexes {
+		shards[i] = ks.shards[idx]
+	}
+	return shards
+}
+
+// Lock takes the write lock of every shard owning one of keys and returns a
+// function that releases them
+func (ks *Keyspace) Lock(keys ...string) func() {
+	shards := ks.lockedShards(keys)
+	for _, s := range shards {
+		s.lock.Lock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].lock.Unlock()
+		}
+	}
+}
+
+// RLock takes the read lock of every shard owning one of keys and returns a
+// function that releases them
+func (ks *Keyspace) RLock(keys ...string) func() {
+	shards := ks.lockedShards(keys)
+	for _, s := range shards {
+		s.lock.RLock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].lock.RUnlock()
+		}
+	}
+}
+
+// LockAll takes the write lock of every shard owning one of writeKeys and
+// the read lock of every other shard, for commands such as SORT BY whose
+// keys aren't known up front. The returned function releases them.
+func (ks *Keyspace) LockAll(writeKeys ...string) func() {
+	write := make(map[int]bool, len(writeKeys))
+	for _, key := range writeKeys {
+		write[shardIndex(key)] = true
+	}
+
+	for i, s := range ks.shards {
+		if write[i] {
+			s.lock.Lock()
+		} else {
+			s.lock.RLock()
+		}
+	}
+	return func() {
+		for i := numShards - 1; i >= 0; i-- {
+			if write[i] {
+				ks.shards[i].lock.Unlock()
+			} else {
+				ks.shards[i].lock.RUnlock()
+			}
+		}
+	}
+}
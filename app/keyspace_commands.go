@@ -0,0 +1,628 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleDel removes the given keys, whatever their type, and replies with
+// the number of keys that were actually removed. It also serves UNLINK: a
+// removed value is left to the garbage collector either way, so there is no
+// freeing to move off the connection.
+func handleDel(args []string, conn net.Conn) {
+	keys := args[1:]
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	removed := 0
+	for _, key := range keys {
+		if _, exists := lookupKey(key); exists {
+			DB.Delete(key)
+			removed++
+		}
+	}
+	writeInteger(conn, removed)
+}
+
+// handleExists replies with how many of the given keys exist. A key named
+// more than once is counted each time.
+func handleExists(args []string, conn net.Conn) {
+	keys := args[1:]
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	count := 0
+	for _, key := range keys {
+		if _, exists := lookupKey(key); exists {
+			count++
+		}
+	}
+	writeInteger(conn, count)
+}
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. unit is
+// the unit of the time argument and absolute tells whether it is a unix
+// timestamp rather than a TTL.
+func expireGeneric(args []string, conn net.Conn, unit time.Duration, absolute bool) {
+	key := args[1]
+	command := strings.ToLower(args[0])
+
+	n, ok := parseStrictInt(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+
+	var nx, xx, gt, lt bool
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			writeError(conn, "Unsupported option "+arg)
+			return
+		}
+	}
+	if nx && (xx || gt || lt) {
+		writeError(conn, "NX and XX, GT or LT options at the same time are not compatible")
+		return
+	}
+	if gt && lt {
+		writeError(conn, "GT and LT options at the same time are not compatible")
+		return
+	}
+
+	// work out the deadline in unix milliseconds, refusing anything that
+	// doesn't fit in an int64
+	invalid := "invalid expire time in '" + command + "' command"
+	scale := int64(unit / time.Millisecond)
+	if n > math.MaxInt64/scale || n < math.MinInt64/scale {
+		writeError(conn, invalid)
+		return
+	}
+	when := n * scale
+	now := clock.Now().UnixMilli()
+	if !absolute {
+		if (when > 0 && now > math.MaxInt64-when) || (when < 0 && now < math.MinInt64-when) {
+			writeError(conn, invalid)
+			return
+		}
+		when += now
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+
+	// a key without a TTL counts as never expiring for GT and LT
+	current := expiresAtOf(value)
+	hasTTL := !current.IsZero()
+	if (nx && hasTTL) || (xx && !hasTTL) ||
+		(gt && (!hasTTL || when <= current.UnixMilli())) ||
+		(lt && hasTTL && when >= current.UnixMilli()) {
+		writeInteger(conn, 0)
+		return
+	}
+
+	// a deadline that has already passed deletes the key right away
+	if when <= now {
+		DB.Delete(key)
+		writeInteger(conn, 1)
+		return
+	}
+
+	DB.Store(key, withExpiresAt(value, time.UnixMilli(when)))
+	writeInteger(conn, 1)
+}
+
+func handleExpire(args []string, conn net.Conn) {
+	expireGeneric(args, conn, time.Second, false)
+}
+
+func handlePExpire(args []string, conn net.Conn) {
+	expireGeneric(args, conn, time.Millisecond, false)
+}
+
+func handleExpireAt(args []string, conn net.Conn) {
+	expireGeneric(args, conn, time.Second, true)
+}
+
+func handlePExpireAt(args []string, conn net.Conn) {
+	expireGeneric(args, conn, time.Millisecond, true)
+}
+
+// ttlGeneric implements TTL and PTTL: the remaining time to live in the
+// given unit, -1 for a key without a TTL and -2 for a missing key
+func ttlGeneric(args []string, conn net.Conn, unit time.Duration) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeInteger(conn, -2)
+		return
+	}
+
+	expiresAt := expiresAtOf(value)
+	if expiresAt.IsZero() {
+		writeInteger(conn, -1)
+		return
+	}
+
+	// round to the nearest unit like Redis does, working in milliseconds
+	// since a deadline far enough out doesn't fit in a time.Duration
+	remaining := expiresAt.UnixMilli() - clock.Now().UnixMilli()
+	scale := int64(unit / time.Millisecond)
+	writeInteger(conn, int((remaining+scale/2)/scale))
+}
+
+func handleTTL(args []string, conn net.Conn) {
+	ttlGeneric(args, conn, time.Second)
+}
+
+func handlePTTL(args []string, conn net.Conn) {
+	ttlGeneric(args, conn, time.Millisecond)
+}
+
+// handlePersist removes the TTL of a key, replying 1 if there was one to
+// remove and 0 otherwise
+func handlePersist(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists || expiresAtOf(value).IsZero() {
+		writeInteger(conn, 0)
+		return
+	}
+
+	DB.Store(key, withExpiresAt(value, time.Time{}))
+	writeInteger(conn, 1)
+}
+
+// expireTimeGeneric implements EXPIRETIME and PEXPIRETIME: the absolute
+// unix time at which the key expires, -1 for a key without a TTL and -2 for
+// a missing key
+func expireTimeGeneric(args []string, conn net.Conn, unit time.Duration) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeInteger(conn, -2)
+		return
+	}
+
+	expiresAt := expiresAtOf(value)
+	if expiresAt.IsZero() {
+		writeInteger(conn, -1)
+		return
+	}
+	// rounded to the nearest unit, as TTL does
+	scale := int64(unit / time.Millisecond)
+	writeInteger(conn, int((expiresAt.UnixMilli()+scale/2)/scale))
+}
+
+func handleExpireTime(args []string, conn net.Conn) {
+	expireTimeGeneric(args, conn, time.Second)
+}
+
+func handlePExpireTime(args []string, conn net.Conn) {
+	expireTimeGeneric(args, conn, time.Millisecond)
+}
+
+// handleKeys replies with every key matching a glob-style pattern. Expired
+// keys that haven't been reclaimed yet are left out.
+func handleKeys(args []string, conn net.Conn) {
+	pattern := args[1]
+	matchAll := pattern == "*"
+
+	keys := []string{}
+	DB.Range(func(key string, value any) bool {
+		if isExpired(value) {
+			return true
+		}
+		if matchAll || stringMatch(pattern, key, false) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	writeArray(conn, keys)
+}
+
+// handleScan implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+// The cursor is the index of the next shard to visit: every call walks
+// whole shards until it has looked at about count keys, so a key that is
+// present for the whole iteration is always returned, however much the
+// keyspace changes in between.
+func handleScan(args []string, conn net.Conn) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		writeError(conn, "invalid cursor")
+		return
+	}
+
+	pattern, typeFilter := "", ""
+	count := 10
+	for i := 2; i < len(args); i++ {
+		if i+1 >= len(args) {
+			writeError(conn, "syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			n, ok := parseIntArg(args[i+1])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if n < 1 {
+				writeError(conn, "syntax error")
+				return
+			}
+			count = n
+		case "TYPE":
+			typeFilter = strings.ToLower(args[i+1])
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+		i++
+	}
+
+	keys := []string{}
+	visited := 0
+	next := cursor
+	for next < numShards && visited < count {
+		DB.RangeShard(int(next), func(key string, value any) bool {
+			visited++
+			if isExpired(value) {
+				return true
+			}
+			if pattern != "" && pattern != "*" && !stringMatch(pattern, key, false) {
+				return true
+			}
+			if typeFilter != "" && typeName(value) != typeFilter {
+				return true
+			}
+			keys = append(keys, key)
+			return true
+		})
+		next++
+	}
+	if next >= numShards {
+		next = 0
+	}
+
+	writeValue(conn, []any{strconv.FormatUint(next, 10), keys})
+}
+
+// scanArgs are the arguments of HSCAN, SSCAN and ZSCAN
+type scanArgs struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	noValues bool
+}
+
+// parseScanArgs parses key cursor [MATCH pattern] [COUNT count], plus
+// NOVALUES if allowed, the arguments collection scans take
+func parseScanArgs(args []string, allowNoValues bool) (scanArgs, error) {
+	opts := scanArgs{count: 10}
+	cursor, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return opts, errors.New("invalid cursor")
+	}
+	opts.cursor = cursor
+
+	syntaxErr := errors.New("syntax error")
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "NOVALUES" && allowNoValues {
+			opts.noValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, syntaxErr
+		}
+		switch option {
+		case "MATCH":
+			opts.pattern = args[i+1]
+		case "COUNT":
+			n, ok := parseIntArg(args[i+1])
+			if !ok {
+				return opts, errors.New("value is not an integer or out of range")
+			}
+			if n < 1 {
+				return opts, syntaxErr
+			}
+			opts.count = n
+		default:
+			return opts, syntaxErr
+		}
+		i++
+	}
+	return opts, nil
+}
+
+// matches reports whether a member passes the scan's MATCH pattern
+func (opts scanArgs) matches(member string) bool {
+	return opts.pattern == "" || opts.pattern == "*" || stringMatch(opts.pattern, member, false)
+}
+
+// scanPage returns the page of members that a collection scan (HSCAN and
+// friends) visits from cursor, and the cursor to continue from, 0 once the
+// scan is done. Members are visited in the order of a hash of their names
+// and the cursor is the hash to resume at, so like SCAN's shard cursor it
+// doesn't shift when other members come and go: a member present for the
+// whole iteration is always returned. Members sharing a hash are returned
+// together, so a page may run past count.
+func scanPage(members []string, cursor uint64, count int) ([]string, uint64) {
+	hashOf := func(member string) uint64 {
+		h := fnv.New32a()
+		h.Write([]byte(member))
+		return uint64(h.Sum32())
+	}
+
+	type hashed struct {
+		member string
+		hash   uint64
+	}
+	var pending []hashed
+	for _, m := range members {
+		if h := hashOf(m); h >= cursor {
+			pending = append(pending, hashed{m, h})
+		}
+	}
+	slices.SortFunc(pending, func(a, b hashed) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	page := make([]string, 0, min(count, len(pending)))
+	for i, p := range pending {
+		if len(page) >= count && p.hash != pending[i-1].hash {
+			// the first member not returned always has a larger hash than
+			// one that was, so the next cursor is never 0
+			return page, p.hash
+		}
+		page = append(page, p.member)
+	}
+	return page, 0
+}
+
+// randomKeyTries bounds how many expired keys RANDOMKEY samples before it
+// falls back to scanning the whole keyspace, so that a keyspace of keys
+// about to expire can't keep it looping
+const randomKeyTries = 100
+
+// handleRandomKey replies with a random live key, or null if there is none.
+// An expired key it samples is reclaimed before it tries again.
+func handleRandomKey(args []string, conn net.Conn) {
+	for range randomKeyTries {
+		key, value, ok := DB.RandomKey()
+		if !ok {
+			writeNullBulkString(conn)
+			return
+		}
+		if !isExpired(value) {
+			writeBulkString(conn, key)
+			return
+		}
+		unlock := DB.Lock(key)
+		lookupKey(key)
+		unlock()
+	}
+
+	// mostly expired keys: pick among the live ones with reservoir sampling
+	var picked string
+	live := 0
+	DB.Range(func(key string, value any) bool {
+		if !isExpired(value) {
+			live++
+			if rand.IntN(live) == 0 {
+				picked = key
+			}
+		}
+		return true
+	})
+	if live == 0 {
+		writeNullBulkString(conn)
+		return
+	}
+	writeBulkString(conn, picked)
+}
+
+// handleCopy implements COPY source destination [DB db] [REPLACE]. The copy
+// keeps the source's TTL. Only database 0 exists, so DB accepts nothing else.
+func handleCopy(args []string, conn net.Conn) {
+	src, dst := args[1], args[2]
+
+	replace := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				writeError(conn, "syntax error")
+				return
+			}
+			db, ok := parseIntArg(args[i+1])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if db != 0 {
+				writeError(conn, "DB index is out of range")
+				return
+			}
+			i++
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	if src == dst {
+		writeError(conn, "source and destination objects are the same")
+		return
+	}
+
+	unlock := DB.Lock(src, dst)
+	defer unlock()
+
+	value, exists := lookupKey(src)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	if _, taken := lookupKey(dst); taken && !replace {
+		writeInteger(conn, 0)
+		return
+	}
+
+	// the copy is a new key, so a replaced destination's access metadata
+	// goes with it
+	DB.Delete(dst)
+	DB.Store(dst, copyValue(value))
+	notifyBlockedClients(dst)
+	writeInteger(conn, 1)
+}
+
+// handleDump replies with the value stored at key serialized in the Redis
+// DUMP format, or null if the key doesn't exist
+func handleDump(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeNullBulkString(conn)
+		return
+	}
+	writeBulkString(conn, string(dumpValue(value)))
+}
+
+// handleRestore implements RESTORE key ttl payload [REPLACE] [ABSTTL]
+// [IDLETIME seconds] [FREQ frequency]. A ttl of 0 restores the key without
+// a TTL. IDLETIME and FREQ set the access metadata OBJECT reports.
+func handleRestore(args []string, conn net.Conn) {
+	key := args[1]
+
+	var replace, absTTL bool
+	idleTime, freq := int64(-1), int64(-1)
+	for i := 4; i < len(args); i++ {
+		additional := i+1 < len(args)
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "ABSTTL":
+			absTTL = true
+		case opt == "IDLETIME" && additional && freq < 0:
+			n, ok := parseStrictInt(args[i+1])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if n < 0 {
+				writeError(conn, "Invalid IDLETIME value, must be >= 0")
+				return
+			}
+			idleTime = n
+			i++
+		case opt == "FREQ" && additional && idleTime < 0:
+			n, ok := parseStrictInt(args[i+1])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if n < 0 || n > 255 {
+				writeError(conn, "Invalid FREQ value, must be >= 0 and <= 255")
+				return
+			}
+			freq = n
+			i++
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	ttl, ok := parseStrictInt(args[2])
+	if !ok {
+		writeError(conn, "value is not an integer or out of range")
+		return
+	}
+	if ttl < 0 {
+		writeError(conn, "Invalid TTL value, must be >= 0")
+		return
+	}
+	// a relative ttl becomes a deadline in unix milliseconds, which must
+	// still fit in an int64
+	when := ttl
+	if ttl > 0 && !absTTL {
+		now := clock.Now().UnixMilli()
+		if now > math.MaxInt64-ttl {
+			writeError(conn, "invalid expire time in 'restore' command")
+			return
+		}
+		when += now
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	if _, exists := lookupKey(key); exists && !replace {
+		writeRawError(conn, "BUSYKEY Target key name already exists.")
+		return
+	}
+
+	value, err := restoreValue([]byte(args[3]))
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	if ttl > 0 {
+		expiresAt := time.UnixMilli(when)
+
+		// an absolute deadline in the past only removes what was there
+		if !expiresAt.After(clock.Now()) {
+			DB.Delete(key)
+			writeSimpleString(conn, "OK")
+			return
+		}
+		value = withExpiresAt(value, expiresAt)
+	}
+
+	// a restored key starts with fresh access metadata, even when it
+	// replaces an existing one
+	DB.Delete(key)
+	DB.Store(key, withEncoding(value))
+	access := DB.Access(key)
+	if idleTime >= 0 {
+		access.lastAccess.Store(clock.Now().UnixMilli() - idleTime*1000)
+	}
+	if freq >= 0 {
+		access.lfu.Store(lfuMinutes()<<8 | uint32(freq))
+	}
+
+	notifyBlockedClients(key)
+	writeSimpleString(conn, "OK")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyspaceRandomKey(t *testing.T) {
+	ks := NewKeyspace()
+	if key, _, ok := ks.RandomKey(); ok {
+		t.Fatalf("RandomKey of an empty keyspace = %q, want none", key)
+	}
+
+	// every key comes up at some point, whichever shard it lands in
+	const keys = 20
+	for i := range keys {
+		ks.Store("k"+strconv.Itoa(i), Entry{value: strconv.Itoa(i)})
+	}
+	seen := map[string]bool{}
+	for range 10000 {
+		key, value, ok := ks.RandomKey()
+		if !ok {
+			t.Fatal("RandomKey found no key")
+		}
+		if entry := value.(Entry); "k"+entry.value != key {
+			t.Fatalf("RandomKey paired %q with %q", key, entry.value)
+		}
+		seen[key] = true
+	}
+	if len(seen) != keys {
+		t.Errorf("RandomKey picked %d distinct keys out of %d", len(seen), keys)
+	}
+}
+
+// TestKeyspaceRandomKeyUniform checks that keys come up equally often even
+// though they spread unevenly over the shards, some of which stay empty
+func TestKeyspaceRandomKeyUniform(t *testing.T) {
+	ks := NewKeyspace()
+	const keys, rounds = 200, 100
+	for i := range keys {
+		ks.Store("u"+strconv.Itoa(i), Entry{value: "v"})
+	}
+	counts := map[string]int{}
+	for range keys * rounds {
+		key, _, _ := ks.RandomKey()
+		counts[key]++
+	}
+	for i := range keys {
+		key := "u" + strconv.Itoa(i)
+		// about ten standard deviations either way
+		if n := counts[key]; n < rounds/2 || n > rounds*3/2 {
+			t.Errorf("%s picked %d times, want about %d", key, n, rounds)
+		}
+	}
+}
+
+func TestRandomKeySkipsExpired(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("SET", "rk-live", "v")
+	for i := range 50 {
+		c.do("SET", "rk-expiring-"+strconv.Itoa(i), "v", "PX", "10")
+	}
+	fake.advance(time.Second)
+
+	for range 20 {
+		got := c.do("RANDOMKEY")
+		if got == "$-1\r\n" || strings.Contains(got, "rk-expiring") {
+			t.Fatalf("RANDOMKEY = %q, want a live key", got)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "cp-src", "cp-dst", "cp-missing")
+	c.do("SET", "cp-src", "v")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"COPY", "cp-missing", "cp-dst"}, ":0\r\n"},
+		{[]string{"COPY", "cp-src", "cp-src"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"COPY", "cp-src", "cp-dst", "DB", "1"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"COPY", "cp-src", "cp-dst", "DB", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"COPY", "cp-src", "cp-dst", "DB"}, "-ERR syntax error\r\n"},
+		{[]string{"COPY", "cp-src", "cp-dst", "NOPE"}, "-ERR syntax error\r\n"},
+		{[]string{"COPY", "cp-src", "cp-dst", "DB", "0"}, ":1\r\n"},
+		// the destination now exists
+		{[]string{"COPY", "cp-src", "cp-dst"}, ":0\r\n"},
+		{[]string{"COPY", "cp-src", "cp-dst", "REPLACE"}, ":1\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestCopyValues copies a value of every type, then checks that the copy
+// reads the same, keeps the source's TTL and shares nothing with it
+func TestCopyValues(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		name   string
+		setup  []string
+		read   []string
+		modify []string
+	}{
+		{"string", []string{"SET", "KEY", "v"}, []string{"GET", "KEY"}, []string{"APPEND", "KEY", "x"}},
+		{"list", []string{"RPUSH", "KEY", "a", "b"}, []string{"LRANGE", "KEY", "0", "-1"}, []string{"LSET", "KEY", "0", "x"}},
+		{"hash", []string{"HSET", "KEY", "f", "v"}, []string{"HGET", "KEY", "f"}, []string{"HSET", "KEY", "f", "x"}},
+		{"set", []string{"SADD", "KEY", "a", "b"}, []string{"SMEMBERS", "KEY"}, []string{"SADD", "KEY", "x"}},
+		{"zset", []string{"ZADD", "KEY", "1", "a"}, []string{"ZRANGE", "KEY", "0", "-1", "WITHSCORES"}, []string{"ZINCRBY", "KEY", "1", "a"}},
+		{"stream", []string{"XADD", "KEY", "1-1", "f", "v"}, []string{"XRANGE", "KEY", "-", "+"}, []string{"XADD", "KEY", "2-1", "f", "x"}},
+	}
+	with := func(args []string, key string) []string {
+		out := slices.Clone(args)
+		for i, arg := range out {
+			if arg == "KEY" {
+				out[i] = key
+			}
+		}
+		return out
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "cv-src", "cv-dst")
+			c.do(with(tt.setup, "cv-src")...)
+			c.do("PEXPIRE", "cv-src", "100000")
+			// a destination of another type with its own TTL
+			c.do("SET", "cv-dst", "old", "PX", "5")
+
+			if got := c.do("COPY", "cv-src", "cv-dst", "REPLACE"); got != ":1\r\n" {
+				t.Fatalf("COPY = %q, want :1", got)
+			}
+			want := c.do(with(tt.read, "cv-src")...)
+			if got := c.do(with(tt.read, "cv-dst")...); got != want {
+				t.Errorf("copy reads %q, want %q", got, want)
+			}
+			got := c.do("PTTL", "cv-dst")
+			if ms, _ := strconv.Atoi(strings.Trim(got, ":\r\n")); ms < 99000 {
+				t.Errorf("PTTL of the copy = %q, want the source's", got)
+			}
+
+			c.do(with(tt.modify, "cv-dst")...)
+			if got := c.do(with(tt.read, "cv-src")...); got != want {
+				t.Errorf("changing the copy changed the source to %q", got)
+			}
+		})
+	}
+}
+
+func TestCopyReplaceResetsAccess(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "ca-src", "ca-dst")
+	c.do("SET", "ca-src", "v")
+	c.do("RESTORE", "ca-dst", "0", dumpPayload(t, c, "ca-src"), "FREQ", "200")
+
+	// the copy is a new key, which starts from a new key's frequency
+	c.do("COPY", "ca-src", "ca-dst", "REPLACE")
+	got := c.do("OBJECT", "FREQ", "ca-dst")
+	if freq, _ := strconv.Atoi(strings.Trim(got, ":\r\n")); freq > lfuInitVal+5 {
+		t.Errorf("OBJECT FREQ of the replaced destination = %q, want about %d", got, lfuInitVal)
+	}
+}
+
+// scanAll runs a full SCAN iteration with the given options and returns
+// every key it replied with, counting duplicates, and the number of calls
+// it took. between runs after every call but the last.
+func scanAll(t *testing.T, c *testClient, between func(), opts ...string) (map[string]int, int) {
+	t.Helper()
+	seen := map[string]int{}
+	cursor, calls := "0", 0
+	for {
+		reply := c.do(slices.Concat([]string{"SCAN", cursor}, opts)...)
+		calls++
+		// the reply is a cursor followed by an array of keys
+		lines := strings.SplitN(reply, "\r\n", 4)
+		if len(lines) != 4 || lines[0] != "*2" {
+			t.Fatalf("SCAN %s %v = %q", cursor, opts, reply)
+		}
+		cursor = lines[2]
+		for _, key := range parseArrayReply(t, lines[3]) {
+			seen[key]++
+		}
+		if cursor == "0" {
+			return seen, calls
+		}
+		if calls > numShards+1 {
+			t.Fatalf("SCAN didn't finish within %d calls", calls)
+		}
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	c := newTestClient(t)
+	var keys []string
+	for i := range 100 {
+		key := "scn-" + strconv.Itoa(i)
+		keys = append(keys, key)
+		c.do("DEL", key)
+		switch i % 3 {
+		case 0:
+			c.do("SET", key, "v")
+		case 1:
+			c.do("RPUSH", key, "v")
+		default:
+			c.do("SADD", key, "v")
+		}
+	}
+
+	t.Run("every key once", func(t *testing.T) {
+		for _, count := range []string{"1", "10", "1000"} {
+			seen, calls := scanAll(t, c, nil, "MATCH", "scn-*", "COUNT", count)
+			for _, key := range keys {
+				if seen[key] != 1 {
+					t.Errorf("COUNT %s: %s returned %d times, want once", count, key, seen[key])
+				}
+			}
+			if len(seen) != len(keys) {
+				t.Errorf("COUNT %s: returned %d keys, want %d", count, len(seen), len(keys))
+			}
+			if count == "1000" && calls != 1 {
+				t.Errorf("COUNT 1000 took %d calls over a small keyspace, want 1", calls)
+			}
+		}
+	})
+
+	t.Run("type", func(t *testing.T) {
+		seen, _ := scanAll(t, c, nil, "MATCH", "scn-*", "TYPE", "LIST")
+		for i, key := range keys {
+			if want := i%3 == 1; (seen[key] == 1) != want {
+				t.Errorf("TYPE list returned %s %d times", key, seen[key])
+			}
+		}
+	})
+
+	t.Run("keys changing during the scan", func(t *testing.T) {
+		// keys present for the whole scan are returned even as others come
+		// and go between calls
+		added := 0
+		seen, _ := scanAll(t, c, func() {
+			c.do("SET", "scn-new-"+strconv.Itoa(added), "v")
+			c.do("DEL", "scn-new-"+strconv.Itoa(added-1))
+			added++
+		}, "MATCH", "scn-*", "COUNT", "5")
+		c.do("DEL", "scn-new-"+strconv.Itoa(added-1))
+		for _, key := range keys {
+			if seen[key] != 1 {
+				t.Errorf("%s returned %d times, want once", key, seen[key])
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			args []string
+			want string
+		}{
+			{[]string{"SCAN", "x"}, "-ERR invalid cursor\r\n"},
+			{[]string{"SCAN", "-1"}, "-ERR invalid cursor\r\n"},
+			{[]string{"SCAN", "0", "COUNT", "0"}, "-ERR syntax error\r\n"},
+			{[]string{"SCAN", "0", "COUNT", "x"}, "-ERR value is not an integer or out of range\r\n"},
+			{[]string{"SCAN", "0", "MATCH"}, "-ERR syntax error\r\n"},
+			{[]string{"SCAN", "0", "NOPE", "x"}, "-ERR syntax error\r\n"},
+		}
+		for _, tt := range tests {
+			if got := c.do(tt.args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+		}
+	})
+
+	// a cursor past the last shard ends the scan
+	if got := c.do("SCAN", strconv.Itoa(numShards+5), "MATCH", "scn-*"); got != "*2\r\n$1\r\n0\r\n*0\r\n" {
+		t.Errorf("SCAN past the last shard = %q, want an empty final page", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyHistoryLen is the number of samples kept per latency event
+const latencyHistoryLen = 160
+
+// latencySample is one latency spike; spikes within the same second are
+// merged, keeping the largest
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+// latencyEvent is the recorded history of one kind of latency spike
+type latencyEvent struct {
+	samples []latencySample
+	max     int64
+}
+
+var (
+	latencyMu     sync.Mutex
+	latencyEvents = make(map[string]*latencyEvent)
+)
+
+// latencyAddSampleIfNeeded records a latency spike for event when duration
+// reaches the configured latency-monitor-threshold. A threshold of 0 turns
+// the monitor off.
+func latencyAddSampleIfNeeded(event string, duration time.Duration) {
+	threshold := latencyMonitorThreshold.value.Load()
+	ms := duration.Milliseconds()
+	if threshold == 0 || ms < threshold {
+		return
+	}
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	ev, ok := latencyEvents[event]
+	if !ok {
+		ev = &latencyEvent{}
+		latencyEvents[event] = ev
+	}
+	ev.max = max(ev.max, ms)
+
+	now := time.Now().Unix()
+	if n := len(ev.samples); n > 0 && ev.samples[n-1].time == now {
+		ev.samples[n-1].latency = max(ev.samples[n-1].latency, ms)
+		return
+	}
+	ev.samples = append(ev.samples, latencySample{time: now, latency: ms})
+	if len(ev.samples) > latencyHistoryLen {
+		ev.samples = ev.samples[len(ev.samples)-latencyHistoryLen:]
+	}
+}
+
+// handleLatency implements LATENCY LATEST, LATENCY HISTORY and LATENCY RESET
+func handleLatency(args []string, conn net.Conn) {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	switch strings.ToUpper(args[1]) {
+	case "LATEST":
+		names := make([]string, 0, len(latencyEvents))
+		for name := range latencyEvents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// each reply is [event, time of latest spike, latest latency, all-time max]
+		result := make([]any, 0, len(names))
+		for _, name := range names {
+			ev := latencyEvents[name]
+			last := ev.samples[len(ev.samples)-1]
+			result = append(result, []any{name, last.time, last.latency, ev.max})
+		}
+		writeValue(conn, result)
+	case "HISTORY":
+		if len(args) != 3 {
+			writeError(conn, "wrong number of arguments for 'latency|history' command")
+			return
+		}
+
+		result := make([]any, 0)
+		if ev, ok := latencyEvents[args[2]]; ok {
+			for _, sample := range ev.samples {
+				result = append(result, []any{sample.time, sample.latency})
+			}
+		}
+		writeValue(conn, result)
+	case "RESET":
+		// with no arguments every event is reset
+		reset := 0
+		if len(args) == 2 {
+			reset = len(latencyEvents)
+			latencyEvents = make(map[string]*latencyEvent)
+		} else {
+			for _, name := range args[2:] {
+				if _, ok := latencyEvents[name]; ok {
+					delete(latencyEvents, name)
+					reset++
+				}
+			}
+		}
+		writeInteger(conn, reset)
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try LATENCY HELP.", args[1]))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"time"
 )
 
 func main() {
@@ -24,7 +25,29 @@ func main() {
 			fmt.Println("Error accepting connection: ", err.Error())
 			os.Exit(1)
 		}
+		configureConnection(conn)
+
 		// handle commands
 		go handleConnection(conn)
 	}
 }
+
+// configureConnection applies the TCP socket options from the config to a
+// newly accepted connection. Changing them with CONFIG SET affects only
+// connections accepted afterwards.
+func configureConnection(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	// small request/reply exchanges would otherwise wait on Nagle batching
+	tcpConn.SetNoDelay(tcpNoDelay.value.Load())
+
+	if period := tcpKeepAlive.value.Load(); period > 0 {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(time.Duration(period) * time.Second)
+	} else {
+		tcpConn.SetKeepAlive(false)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// acceptConfigured accepts a loopback TCP connection, applies the socket
+// options to it the way main does and returns it
+func acceptConfigured(t *testing.T) *net.TCPConn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	configureConnection(conn)
+	return conn.(*net.TCPConn)
+}
+
+// sockopt reads an integer socket option of conn
+func sockopt(t *testing.T, conn *net.TCPConn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil || optErr != nil {
+		t.Fatalf("getsockopt(%d, %d): %v %v", level, opt, err, optErr)
+	}
+	return value
+}
+
+func TestConfigureConnection(t *testing.T) {
+	c := newTestClient(t)
+	defer c.do("CONFIG", "SET", "tcp-nodelay", "yes")
+	defer c.do("CONFIG", "SET", "tcp-keepalive", "300")
+
+	tests := []struct {
+		nodelay, keepalive string
+		wantNoDelay        bool
+		wantKeepAlive      int // seconds, 0 for off
+	}{
+		{"yes", "300", true, 300},
+		{"yes", "60", true, 60},
+		{"no", "0", false, 0},
+	}
+	for _, tt := range tests {
+		c.do("CONFIG", "SET", "tcp-nodelay", tt.nodelay)
+		c.do("CONFIG", "SET", "tcp-keepalive", tt.keepalive)
+		conn := acceptConfigured(t)
+
+		if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0; got != tt.wantNoDelay {
+			t.Errorf("tcp-nodelay %s: TCP_NODELAY = %v, want %v", tt.nodelay, got, tt.wantNoDelay)
+		}
+		keepAlive := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) != 0
+		if keepAlive != (tt.wantKeepAlive > 0) {
+			t.Errorf("tcp-keepalive %s: SO_KEEPALIVE = %v", tt.keepalive, keepAlive)
+		}
+		if tt.wantKeepAlive > 0 {
+			if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != tt.wantKeepAlive {
+				t.Errorf("tcp-keepalive %s: TCP_KEEPIDLE = %d", tt.keepalive, got)
+			}
+		}
+	}
+}
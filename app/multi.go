@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MULTI, EXEC and DISCARD queue a client's commands and run them in one go.
+// The queued commands run back to back in the client's own goroutine, each
+// taking its own locks, so other clients' commands may run between them.
+
+// multiControl lists the commands that still run at once inside MULTI
+// instead of being queued
+var multiControl = map[string]bool{"MULTI": true, "EXEC": true, "DISCARD": true}
+
+// queueCommand queues a command sent inside MULTI and replies QUEUED. It
+// reports false, leaving the command to run at once, outside MULTI and for
+// the commands in multiControl.
+func queueCommand(client *Client, args []string) bool {
+	if !client.multi || multiControl[strings.ToUpper(args[0])] {
+		return false
+	}
+	client.queued = append(client.queued, args)
+	writeSimpleString(client, "QUEUED")
+	return true
+}
+
+// flagMultiError notes that a command sent inside MULTI was rejected before
+// it could be queued, so that EXEC discards the transaction
+func flagMultiError(client *Client) {
+	if client.multi {
+		client.multiAborted = true
+	}
+}
+
+// resetMulti leaves MULTI, dropping the queued commands
+func (c *Client) resetMulti() {
+	c.multi, c.multiAborted, c.queued = false, false, nil
+}
+
+// handleMulti starts queuing the client's commands
+func handleMulti(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	if client.multi {
+		writeError(conn, "MULTI calls can not be nested")
+		return
+	}
+	client.multi = true
+	writeSimpleString(conn, "OK")
+}
+
+// handleExec runs the commands queued since MULTI and replies with an array
+// of their replies, or aborts if any of them was rejected while queuing.
+// Blocking commands don't wait inside EXEC: they time out at once.
+func handleExec(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	if !client.multi {
+		writeError(conn, "EXEC without MULTI")
+		return
+	}
+	queued, aborted := client.queued, client.multiAborted
+	client.resetMulti()
+	if aborted {
+		writeRawError(conn, "EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+
+	// each handler writes its own reply, so the array header goes first
+	conn.Write([]byte(fmt.Sprintf("*%d\r\n", len(queued))))
+	client.inExec = true
+	defer func() { client.inExec = false }()
+	for _, args := range queued {
+		call(commandTable[strings.ToUpper(args[0])], args, client)
+	}
+}
+
+// handleDiscard drops the commands queued since MULTI
+func handleDiscard(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	if !client.multi {
+		writeError(conn, "DISCARD without MULTI")
+		return
+	}
+	client.resetMulti()
+	writeSimpleString(conn, "OK")
+}
@@ -0,0 +1,103 @@
+package main
+
+import "testing"
+
+func TestMultiExec(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "tx-counter", "tx-list")
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"INCR", "tx-counter"}, "+QUEUED\r\n"},
+		{[]string{"RPUSH", "tx-list", "a", "b"}, "+QUEUED\r\n"},
+		// a command that fails when it runs doesn't abort the others
+		{[]string{"LPOP", "tx-counter"}, "+QUEUED\r\n"},
+		{[]string{"INCR", "tx-counter"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*4\r\n:1\r\n:2\r\n-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n:2\r\n"},
+		{[]string{"GET", "tx-counter"}, "$1\r\n2\r\n"},
+
+		// an empty transaction
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"EXEC"}, "*0\r\n"},
+
+		// blocking commands time out at once inside EXEC
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"BLPOP", "tx-missing", "0"}, "+QUEUED\r\n"},
+		{[]string{"BLPOP", "tx-list", "0"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*2\r\n*-1\r\n*2\r\n$7\r\ntx-list\r\n$1\r\na\r\n"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Fatalf("%q = %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+func TestMultiDiscard(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "tx-discard")
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"SET", "tx-discard", "v"}, "+QUEUED\r\n"},
+		{[]string{"DISCARD"}, "+OK\r\n"},
+		{[]string{"EXISTS", "tx-discard"}, ":0\r\n"},
+		// the queue doesn't survive into the next transaction
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"EXEC"}, "*0\r\n"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Fatalf("%q = %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+// TestMultiWrongContext checks the errors for transaction commands sent in
+// the wrong state, and that commands rejected while queuing abort EXEC
+func TestMultiWrongContext(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "tx-ctx")
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"EXEC"}, "-ERR EXEC without MULTI\r\n"},
+		{[]string{"DISCARD"}, "-ERR DISCARD without MULTI\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		// nesting is refused without affecting the open transaction
+		{[]string{"MULTI"}, "-ERR MULTI calls can not be nested\r\n"},
+		{[]string{"SET", "tx-ctx", "v"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*1\r\n+OK\r\n"},
+		{[]string{"EXEC"}, "-ERR EXEC without MULTI\r\n"},
+
+		// an unknown command and a wrong argument count abort the
+		// transaction; neither runs nor does anything queued with them
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"DEL", "tx-ctx"}, "+QUEUED\r\n"},
+		{[]string{"NOSUCHCOMMAND"}, "-ERR unknown command 'NOSUCHCOMMAND'\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"DEL", "tx-ctx"}, "+QUEUED\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{[]string{"EXISTS", "tx-ctx"}, ":1\r\n"},
+
+		// outside MULTI the same errors leave the next transaction alone
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"EXEC"}, "*0\r\n"},
+	}
+	for _, step := range steps {
+		if got := c.do(step.args...); got != step.want {
+			t.Fatalf("%q = %q, want %q", step.args, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// lfuInitVal is the access frequency counter of a new key, high enough that
+// a fresh key isn't immediately the least frequently used one
+const lfuInitVal = 5
+
+// sharedIntegers mirrors Redis's pool of shared small integer objects, which
+// OBJECT REFCOUNT reports as referenced "forever"
+const sharedIntegers = 10000
+
+// keyAccess is the access metadata kept for every key: when it was last
+// accessed, and Redis's logarithmic LFU counter with the minute it was last
+// decremented packed as minutes<<8 | counter
+type keyAccess struct {
+	lastAccess atomic.Int64 // unix milliseconds
+	lfu        atomic.Uint32
+}
+
+func newKeyAccess() *keyAccess {
+	a := &keyAccess{}
+	a.lastAccess.Store(clock.Now().UnixMilli())
+	a.lfu.Store(lfuMinutes()<<8 | lfuInitVal)
+	return a
+}
+
+// lfuMinutes returns the current time in minutes, wrapped to 16 bits
+func lfuMinutes() uint32 {
+	return uint32(clock.Now().Unix()/60) & 0xffff
+}
+
+// decayedCounter returns the LFU counter of lfu after taking one off for
+// every lfu-decay-time minutes elapsed since it was last updated
+func decayedCounter(lfu uint32) uint32 {
+	last, counter := lfu>>8, lfu&0xff
+	now := lfuMinutes()
+
+	elapsed := now - last
+	if now < last {
+		elapsed = 0xffff - last + now
+	}
+
+	decay := uint32(lfuDecayTime.value.Load())
+	if decay == 0 {
+		return counter
+	}
+	if periods := elapsed / decay; periods < counter {
+		return counter - periods
+	}
+	return 0
+}
+
+// touch records an access to the key, bumping the LFU counter with a
+// probability that falls as the counter grows
+func (a *keyAccess) touch() {
+	if a == nil {
+		return
+	}
+	a.lastAccess.Store(clock.Now().UnixMilli())
+
+	for {
+		old := a.lfu.Load()
+		counter := decayedCounter(old)
+		if counter < 255 {
+			base := float64(max(int(counter)-lfuInitVal, 0))
+			if rand.Float64() < 1/(base*float64(lfuLogFactor.value.Load())+1) {
+				counter++
+			}
+		}
+		if a.lfu.CompareAndSwap(old, lfuMinutes()<<8|counter) {
+			return
+		}
+	}
+}
+
+// idleTime returns how long ago the key was last accessed
+func (a *keyAccess) idleTime() time.Duration {
+	return clock.Now().Sub(time.UnixMilli(a.lastAccess.Load()))
+}
+
+// frequency returns the key's decayed LFU counter
+func (a *keyAccess) frequency() int {
+	return int(decayedCounter(a.lfu.Load()))
+}
+
+// objectEncoding returns the name of the encoding Redis would use for a
+// stored value, as OBJECT ENCODING reports it. Lists, hashes and sets
+// report the encoding stored with them.
+func objectEncoding(value any) string {
+	switch v := value.(type) {
+	case Entry:
+		if v.raw {
+			return "raw"
+		}
+		if len(v.value) <= 20 {
+			if _, ok := parseStrictInt(v.value); ok {
+				return "int"
+			}
+		}
+		if len(v.value) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case ListEntry:
+		if v.encoding == "" {
+			v = withEncoding(v).(ListEntry)
+		}
+		return v.encoding
+	case HashEntry:
+		if v.encoding == "" {
+			v = withEncoding(v).(HashEntry)
+		}
+		if v.encoding == "listpack" && len(v.fieldExpires) > 0 {
+			return "listpackex"
+		}
+		return v.encoding
+	case SetEntry:
+		if v.encoding == "" {
+			v = withEncoding(v).(SetEntry)
+		}
+		return v.encoding
+	case ZSetEntry:
+		return v.zset.encoding()
+	case StreamEntry:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// setEncodings lists the encodings of a set from the most compact up
+var setEncodings = []string{"intset", "listpack", "hashtable"}
+
+// withEncoding returns a list, hash or set with its stored encoding updated
+// after a write: one that has outgrown its compact encoding is converted.
+// As with sorted sets, the conversion is one way, so a value keeps its
+// encoding however small it gets again, and lowering a threshold with
+// CONFIG SET changes nothing until the next write. Other values are
+// returned unchanged.
+func withEncoding(value any) any {
+	switch v := value.(type) {
+	case ListEntry:
+		if v.encoding != "quicklist" {
+			v.encoding = "listpack"
+			if !listFitsListpack(v.list) {
+				v.encoding = "quicklist"
+			}
+		}
+		return v
+	case HashEntry:
+		if v.encoding != "hashtable" {
+			v.encoding = "listpack"
+			if !hashFitsListpack(v.fields) {
+				v.encoding = "hashtable"
+			}
+		}
+		return v
+	case SetEntry:
+		if v.encoding == "hashtable" {
+			return v
+		}
+		if encoding := setEncoding(v.members); slices.Index(setEncodings, encoding) > slices.Index(setEncodings, v.encoding) {
+			v.encoding = encoding
+		}
+		return v
+	}
+	return value
+}
+
+// listFitsListpack reports whether a list is small enough for a single
+// listpack under list-max-listpack-size: a positive setting limits the
+// number of elements, a negative one the listpack's size in bytes, from
+// -1 for 4kb to -5 for 64kb
+func listFitsListpack(list *quicklist) bool {
+	limit := listMaxListpackSize.value.Load()
+	if limit > 0 {
+		return int64(list.len()) <= limit
+	}
+
+	maxBytes := 4096 << (-limit - 1)
+	size := 7 // header and terminator
+	fits := true
+	list.each(false, func(_ int, e string) bool {
+		size += len(e) + 2
+		if len(e) >= 64 {
+			size += 3
+		}
+		fits = size <= maxBytes
+		return fits
+	})
+	return fits
+}
+
+// hashFitsListpack reports whether a hash is small enough for a listpack:
+// at most hash-max-listpack-entries fields, none of them and none of their
+// values longer than hash-max-listpack-value
+func hashFitsListpack(fields map[string]string) bool {
+	if int64(len(fields)) > hashMaxListpackEntries.value.Load() {
+		return false
+	}
+	maxValue := hashMaxListpackValue.value.Load()
+	for field, value := range fields {
+		if int64(len(field)) > maxValue || int64(len(value)) > maxValue {
+			return false
+		}
+	}
+	return true
+}
+
+// setEncoding returns the encoding Redis would use for a set: an intset if
+// every member is an integer and there are at most set-max-intset-entries
+// of them, a listpack if there are at most set-max-listpack-entries and
+// none is longer than set-max-listpack-value, a hashtable otherwise
+func setEncoding(members map[string]struct{}) string {
+	allInts := int64(len(members)) <= setMaxIntsetEntries.value.Load()
+	fitsListpack := int64(len(members)) <= setMaxListpackEntries.value.Load()
+	maxValue := setMaxListpackValue.value.Load()
+	for member := range members {
+		if !allInts && !fitsListpack {
+			break
+		}
+		if allInts {
+			_, allInts = parseStrictInt(member)
+		}
+		fitsListpack = fitsListpack && int64(len(member)) <= maxValue
+	}
+
+	switch {
+	case allInts:
+		return "intset"
+	case fitsListpack:
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// handleObject implements OBJECT ENCODING, REFCOUNT, IDLETIME and FREQ.
+// Inspecting a key doesn't count as an access to it.
+func handleObject(args []string, conn net.Conn) {
+	subcommand := strings.ToUpper(args[1])
+	if subcommand == "HELP" {
+		writeArray(conn, []string{
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+			"HELP",
+			"    Print this help.",
+		})
+		return
+	}
+
+	switch subcommand {
+	case "ENCODING", "REFCOUNT", "IDLETIME", "FREQ":
+		if len(args) != 3 {
+			writeError(conn, fmt.Sprintf("wrong number of arguments for 'object|%s' command", strings.ToLower(args[1])))
+			return
+		}
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try OBJECT HELP.", args[1]))
+		return
+	}
+
+	key := args[2]
+	unlock := DB.RLock(key)
+	defer unlock()
+
+	value, exists := DB.Load(key)
+	if !exists || isExpired(value) {
+		writeNullBulkString(conn)
+		return
+	}
+
+	switch subcommand {
+	case "ENCODING":
+		writeBulkString(conn, objectEncoding(value))
+	case "REFCOUNT":
+		if entry, ok := value.(Entry); ok {
+			if n, isInt := parseStrictInt(entry.value); isInt && n >= 0 && n < sharedIntegers {
+				writeInteger(conn, 1<<31-1)
+				return
+			}
+		}
+		writeInteger(conn, 1)
+	case "IDLETIME":
+		writeInteger(conn, int(DB.Access(key).idleTime()/time.Second))
+	case "FREQ":
+		writeInteger(conn, DB.Access(key).frequency())
+	}
+}
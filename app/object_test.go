@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEncodingThresholds lowers each conversion threshold with CONFIG SET
+// and checks that writes past it change the encoding OBJECT ENCODING
+// reports, while writes up to it keep the compact one. The conversion is
+// one way: shrinking a converted value doesn't undo it, and CONFIG SET
+// leaves existing values alone until they are next written.
+func TestEncodingThresholds(t *testing.T) {
+	tests := []struct {
+		config, limit, def string
+		add, remove        func(c *testClient, key string, i int)
+		compact, big       string
+	}{
+		{
+			"hash-max-listpack-entries", "3", "128",
+			func(c *testClient, key string, i int) { c.do("HSET", key, "f"+strconv.Itoa(i), "v") },
+			func(c *testClient, key string, i int) { c.do("HDEL", key, "f"+strconv.Itoa(i)) },
+			"listpack", "hashtable",
+		},
+		{
+			"set-max-intset-entries", "3", "512",
+			func(c *testClient, key string, i int) { c.do("SADD", key, strconv.Itoa(i)) },
+			func(c *testClient, key string, i int) { c.do("SREM", key, strconv.Itoa(i)) },
+			"intset", "listpack",
+		},
+		{
+			"set-max-listpack-entries", "3", "128",
+			func(c *testClient, key string, i int) { c.do("SADD", key, "m"+strconv.Itoa(i)) },
+			func(c *testClient, key string, i int) { c.do("SREM", key, "m"+strconv.Itoa(i)) },
+			"listpack", "hashtable",
+		},
+		{
+			"zset-max-listpack-entries", "3", "128",
+			func(c *testClient, key string, i int) { c.do("ZADD", key, strconv.Itoa(i), "m"+strconv.Itoa(i)) },
+			func(c *testClient, key string, i int) { c.do("ZREM", key, "m"+strconv.Itoa(i)) },
+			"listpack", "skiplist",
+		},
+		{
+			"list-max-listpack-size", "3", "-2",
+			func(c *testClient, key string, i int) { c.do("RPUSH", key, "e"+strconv.Itoa(i)) },
+			func(c *testClient, key string, i int) { c.do("LPOP", key) },
+			"listpack", "quicklist",
+		},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			key, old := "enc-"+tt.config, "enc-old-"+tt.config
+			c.do("DEL", key, old)
+
+			limit, _ := strconv.Atoi(tt.limit)
+			for i := range limit + 1 {
+				tt.add(c, old, i)
+			}
+			if got := c.do("CONFIG", "SET", tt.config, tt.limit); got != "+OK\r\n" {
+				t.Fatalf("CONFIG SET %s %s = %q", tt.config, tt.limit, got)
+			}
+			defer c.do("CONFIG", "SET", tt.config, tt.def)
+
+			if got := c.do("OBJECT", "ENCODING", old); got != encodeValue(tt.compact) {
+				t.Errorf("existing key encoding after CONFIG SET = %q, want %s", got, tt.compact)
+			}
+
+			for i := range limit {
+				tt.add(c, key, i)
+			}
+			if got := c.do("OBJECT", "ENCODING", key); got != encodeValue(tt.compact) {
+				t.Errorf("encoding with %d entries = %q, want %s", limit, got, tt.compact)
+			}
+
+			tt.add(c, key, limit)
+			if got := c.do("OBJECT", "ENCODING", key); got != encodeValue(tt.big) {
+				t.Errorf("encoding with %d entries = %q, want %s", limit+1, got, tt.big)
+			}
+
+			for i := range limit {
+				tt.remove(c, key, i)
+			}
+			if got := c.do("OBJECT", "ENCODING", key); got != encodeValue(tt.big) {
+				t.Errorf("encoding shrunk to 1 entry = %q, want %s", got, tt.big)
+			}
+
+			tt.add(c, old, limit+1)
+			if got := c.do("OBJECT", "ENCODING", old); got != encodeValue(tt.big) {
+				t.Errorf("existing key encoding after a write = %q, want %s", got, tt.big)
+			}
+		})
+	}
+}
+
+// TestStringEncodingAfterEdits checks the encoding OBJECT ENCODING reports
+// once a string has been edited in place, and that commands writing a fresh
+// value go back to classifying it by content
+func TestStringEncodingAfterEdits(t *testing.T) {
+	long := strings.Repeat("x", 45)
+	tests := []struct {
+		name  string
+		setup []string
+		edit  []string
+		reply string
+		want  string
+	}{
+		{"append to int", []string{"SET", "se", "12"}, []string{"APPEND", "se", "3"}, ":3\r\n", "raw"},
+		{"append to embstr", []string{"SET", "se", "abc"}, []string{"APPEND", "se", "def"}, ":6\r\n", "raw"},
+		{"append empty", []string{"SET", "se", "abc"}, []string{"APPEND", "se", ""}, ":3\r\n", "raw"},
+		{"append to missing int", nil, []string{"APPEND", "se", "42"}, ":2\r\n", "int"},
+		{"append to missing embstr", nil, []string{"APPEND", "se", "abc"}, ":3\r\n", "embstr"},
+		{"append to missing raw", nil, []string{"APPEND", "se", long}, ":45\r\n", "raw"},
+		{"setrange int", []string{"SET", "se", "12"}, []string{"SETRANGE", "se", "0", "3"}, ":2\r\n", "raw"},
+		{"setrange embstr", []string{"SET", "se", "abc"}, []string{"SETRANGE", "se", "1", "x"}, ":3\r\n", "raw"},
+		{"setrange empty patch", []string{"SET", "se", "abc"}, []string{"SETRANGE", "se", "1", ""}, ":3\r\n", "embstr"},
+		{"setrange missing", nil, []string{"SETRANGE", "se", "0", "abc"}, ":3\r\n", "raw"},
+		{"getex after setrange", []string{"SETRANGE", "se", "0", "abc"}, []string{"GETEX", "se", "PX", "100000"}, "$3\r\nabc\r\n", "raw"},
+		{"getex persist after setrange", []string{"SETRANGE", "se", "0", "12"}, []string{"GETEX", "se", "PERSIST"}, "$2\r\n12\r\n", "raw"},
+		{"incr after append", []string{"APPEND", "se", "1"}, []string{"INCR", "se"}, ":2\r\n", "int"},
+		{"set after setrange", []string{"SETRANGE", "se", "0", "abc"}, []string{"SET", "se", "abc"}, "+OK\r\n", "embstr"},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "se")
+			if tt.setup != nil {
+				if got := c.do(tt.setup...); got[0] == '-' {
+					t.Fatalf("%v = %q", tt.setup, got)
+				}
+			}
+			if got := c.do(tt.edit...); got != tt.reply {
+				t.Errorf("%v = %q, want %q", tt.edit, got, tt.reply)
+			}
+			if got := c.do("OBJECT", "ENCODING", "se"); got != encodeValue(tt.want) {
+				t.Errorf("encoding after %v = %q, want %s", tt.edit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "ap", "ap-list")
+	c.do("RPUSH", "ap-list", "a")
+	c.do("SET", "ap", "hello", "EX", "100")
+
+	if got := c.do("APPEND", "ap", " world"); got != ":11\r\n" {
+		t.Errorf("APPEND = %q, want :11", got)
+	}
+	if got := c.do("GET", "ap"); got != encodeValue("hello world") {
+		t.Errorf("GET after APPEND = %q", got)
+	}
+	if got := c.do("TTL", "ap"); got == ":-1\r\n" || got == ":-2\r\n" {
+		t.Errorf("TTL after APPEND = %q, want the expiry kept", got)
+	}
+	if got := c.do("APPEND", "ap-list", "b"); got != "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n" {
+		t.Errorf("APPEND to a list = %q, want WRONGTYPE", got)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pubsubMu guards the channel and pattern registries as well as the
+// subscriptions recorded on each client
+var (
+	pubsubMu sync.RWMutex
+	channels = make(map[string]map[*Client]struct{}) // channel -> subscribers
+	patterns = make(map[string]map[*Client]struct{}) // pattern -> subscribers
+)
+
+// subscribedCommands lists the commands a RESP2 client may still send once
+// it has subscribed to something, since its connection now carries messages
+var subscribedCommands = map[string]bool{
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+	"PING": true, "QUIT": true, "RESET": true,
+}
+
+// subscriptionCount returns the number of channels and patterns the client
+// is subscribed to
+func subscriptionCount(client *Client) int {
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+	return len(client.channels) + len(client.patterns)
+}
+
+// rejectInSubscribedMode refuses a command a subscribed RESP2 client may
+// not send, reporting whether it did. RESP3 clients get their messages as
+// push replies, which can't be mistaken for command replies, so they may
+// send anything.
+func rejectInSubscribedMode(client *Client, command string) bool {
+	if client.proto == 3 || subscribedCommands[command] || subscriptionCount(client) == 0 {
+		return false
+	}
+	writeError(client, fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(command)))
+	return true
+}
+
+// subscribe adds the client to the subscribers of each name in registry,
+// replying for every one with the client's subscription count after it.
+// kind is the reply's message type, subscribe or psubscribe. The replies
+// are written under pubsubMu, so that no message published to a new
+// subscription can overtake its confirmation.
+func subscribe(client *Client, registry map[string]map[*Client]struct{}, own *map[string]struct{}, names []string, kind string) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	for _, name := range names {
+		if *own == nil {
+			*own = make(map[string]struct{})
+		}
+		(*own)[name] = struct{}{}
+		if registry[name] == nil {
+			registry[name] = make(map[*Client]struct{})
+		}
+		registry[name][client] = struct{}{}
+		writeValue(client, respPush{kind, name, len(client.channels) + len(client.patterns)})
+	}
+}
+
+// unsubscribe removes the client from the subscribers of each name in
+// registry, or of everything it subscribed to there if names is empty,
+// replying for every one with the client's remaining subscription count
+func unsubscribe(client *Client, registry map[string]map[*Client]struct{}, own map[string]struct{}, names []string, kind string) {
+	pubsubMu.Lock()
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	counts := make([]int, len(names))
+	for i, name := range names {
+		delete(own, name)
+		removeSubscriber(registry, name, client)
+		counts[i] = len(client.channels) + len(client.patterns)
+	}
+	pubsubMu.Unlock()
+
+	if len(names) == 0 {
+		// nothing to unsubscribe from still gets a reply
+		writeValue(client, respPush{kind, nil, subscriptionCount(client)})
+		return
+	}
+	for i, name := range names {
+		writeValue(client, respPush{kind, name, counts[i]})
+	}
+}
+
+// removeSubscriber removes client from the subscribers of name, dropping
+// the entry once nobody is left. The caller must hold pubsubMu.
+func removeSubscriber(registry map[string]map[*Client]struct{}, name string, client *Client) {
+	delete(registry[name], client)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+}
+
+// unsubscribeAll removes every subscription of a client, without replying.
+// It is called once the client has disconnected or its connection failed.
+func unsubscribeAll(client *Client) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+	for name := range client.channels {
+		removeSubscriber(channels, name, client)
+	}
+	for pattern := range client.patterns {
+		removeSubscriber(patterns, pattern, client)
+	}
+	client.channels, client.patterns = nil, nil
+}
+
+// handleSubscribe subscribes the client to channels
+func handleSubscribe(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	subscribe(client, channels, &client.channels, args[1:], "subscribe")
+}
+
+// handlePSubscribe subscribes the client to glob-style channel patterns
+func handlePSubscribe(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	subscribe(client, patterns, &client.patterns, args[1:], "psubscribe")
+}
+
+// handleUnsubscribe unsubscribes the client from channels, or from every
+// channel if none is given
+func handleUnsubscribe(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	unsubscribe(client, channels, client.channels, args[1:], "unsubscribe")
+}
+
+// handlePUnsubscribe unsubscribes the client from patterns, or from every
+// pattern if none is given
+func handlePUnsubscribe(args []string, conn net.Conn) {
+	client := conn.(*Client)
+	unsubscribe(client, patterns, client.patterns, args[1:], "punsubscribe")
+}
+
+// handlePublish sends a message to the subscribers of a channel and of the
+// patterns matching it, replying with the number of deliveries. A
+// subscriber whose connection fails is dropped from every registry and
+// disconnected, so that dead subscriptions don't pile up. The writes happen
+// outside pubsubMu, and the failed subscribers are pruned only once they
+// are all done.
+func handlePublish(args []string, conn net.Conn) {
+	channel, message := args[1], args[2]
+
+	type delivery struct {
+		client *Client
+		push   respPush
+	}
+	var deliveries []delivery
+	pubsubMu.RLock()
+	for client := range channels[channel] {
+		deliveries = append(deliveries, delivery{client, respPush{"message", channel, message}})
+	}
+	for pattern, subscribers := range patterns {
+		if !stringMatch(pattern, channel, false) {
+			continue
+		}
+		for client := range subscribers {
+			deliveries = append(deliveries, delivery{client, respPush{"pmessage", pattern, channel, message}})
+		}
+	}
+	pubsubMu.RUnlock()
+
+	received := 0
+	var dead []*Client
+	for _, d := range deliveries {
+		if err := writeValue(d.client, d.push); err != nil {
+			dead = append(dead, d.client)
+			continue
+		}
+		received++
+	}
+
+	for _, client := range dead {
+		unsubscribeAll(client)
+		client.Conn.Close()
+	}
+	writeInteger(conn, received)
+}
+
+// handlePubSub implements PUBSUB CHANNELS, NUMSUB and NUMPAT
+func handlePubSub(args []string, conn net.Conn) {
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	switch strings.ToUpper(args[1]) {
+	case "CHANNELS":
+		if len(args) > 3 {
+			writeError(conn, "wrong number of arguments for 'pubsub|channels' command")
+			return
+		}
+		names := make([]string, 0, len(channels))
+		for name := range channels {
+			if len(args) == 2 || stringMatch(args[2], name, false) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		writeArray(conn, names)
+	case "NUMSUB":
+		result := make([]any, 0, (len(args)-2)*2)
+		for _, name := range args[2:] {
+			result = append(result, name, len(channels[name]))
+		}
+		writeValue(conn, result)
+	case "NUMPAT":
+		writeInteger(conn, len(patterns))
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try PUBSUB HELP.", args[1]))
+	}
+}
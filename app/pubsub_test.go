@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// read returns the next reply sent to the client, such as a message
+func (c *testClient) read() string {
+	c.t.Helper()
+	reply, err := readReply(c.reader)
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	return reply
+}
+
+// readAsync reads the next n replies sent to the client in the background,
+// since the in-memory pipe holds up a publisher until the subscriber reads
+// what it was sent. The channel gets each reply in turn, then is closed.
+func (c *testClient) readAsync(n int) <-chan string {
+	replies := make(chan string, n)
+	go func() {
+		defer close(replies)
+		for range n {
+			reply, err := readReply(c.reader)
+			if err != nil {
+				return
+			}
+			replies <- reply
+		}
+	}()
+	return replies
+}
+
+func TestPubSub(t *testing.T) {
+	sub, pub := newTestClient(t), newTestClient(t)
+
+	if got, want := sub.do("SUBSCRIBE", "ps-a", "ps-b"), encodeValue([]any{"subscribe", "ps-a", 1}); got != want {
+		t.Fatalf("SUBSCRIBE = %q, want %q", got, want)
+	}
+	if got, want := sub.read(), encodeValue([]any{"subscribe", "ps-b", 2}); got != want {
+		t.Fatalf("second SUBSCRIBE reply = %q, want %q", got, want)
+	}
+	if got, want := sub.do("PSUBSCRIBE", "ps-*"), encodeValue([]any{"psubscribe", "ps-*", 3}); got != want {
+		t.Fatalf("PSUBSCRIBE = %q, want %q", got, want)
+	}
+
+	// the channel subscriber and the pattern subscriber each get a copy
+	messages := sub.readAsync(2)
+	if got := pub.do("PUBLISH", "ps-a", "hello"); got != ":2\r\n" {
+		t.Errorf("PUBLISH = %q, want 2 receivers", got)
+	}
+	if got, want := <-messages, encodeValue([]any{"message", "ps-a", "hello"}); got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if got, want := <-messages, encodeValue([]any{"pmessage", "ps-*", "ps-a", "hello"}); got != want {
+		t.Errorf("pmessage = %q, want %q", got, want)
+	}
+	messages = sub.readAsync(1)
+	if got := pub.do("PUBLISH", "ps-nobody", "x"); got != ":1\r\n" {
+		t.Errorf("PUBLISH to a channel only the pattern matches = %q, want 1", got)
+	}
+	<-messages
+	if got := pub.do("PUBLISH", "other", "x"); got != ":0\r\n" {
+		t.Errorf("PUBLISH without subscribers = %q, want 0", got)
+	}
+
+	if got, want := pub.do("PUBSUB", "CHANNELS", "ps-?"), encodeValue([]string{"ps-a", "ps-b"}); got != want {
+		t.Errorf("PUBSUB CHANNELS = %q, want %q", got, want)
+	}
+	if got, want := pub.do("PUBSUB", "NUMSUB", "ps-a", "ps-c"), encodeValue([]any{"ps-a", 1, "ps-c", 0}); got != want {
+		t.Errorf("PUBSUB NUMSUB = %q, want %q", got, want)
+	}
+
+	// a subscribed RESP2 client may only manage its subscriptions and PING
+	if got, want := sub.do("GET", "ps-a"), "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"; got != want {
+		t.Errorf("GET while subscribed = %q, want %q", got, want)
+	}
+	if got, want := sub.do("PING"), encodeValue([]any{"pong", ""}); got != want {
+		t.Errorf("PING while subscribed = %q, want %q", got, want)
+	}
+
+	if got, want := sub.do("UNSUBSCRIBE"), encodeValue([]any{"unsubscribe", "ps-a", 2}); got != want {
+		t.Errorf("UNSUBSCRIBE = %q, want %q", got, want)
+	}
+	if got, want := sub.read(), encodeValue([]any{"unsubscribe", "ps-b", 1}); got != want {
+		t.Errorf("second UNSUBSCRIBE reply = %q, want %q", got, want)
+	}
+	if got, want := sub.do("PUNSUBSCRIBE", "ps-*"), encodeValue([]any{"punsubscribe", "ps-*", 0}); got != want {
+		t.Errorf("PUNSUBSCRIBE = %q, want %q", got, want)
+	}
+	if got, want := sub.do("UNSUBSCRIBE"), encodeValue([]any{"unsubscribe", nil, 0}); got != want {
+		t.Errorf("UNSUBSCRIBE with no subscriptions = %q, want %q", got, want)
+	}
+	if got := sub.do("PING"); got != "+PONG\r\n" {
+		t.Errorf("PING after unsubscribing = %q", got)
+	}
+}
+
+// TestPubSubRESP3 checks that RESP3 clients get messages as pushes and may
+// keep sending any command while subscribed
+func TestPubSubRESP3(t *testing.T) {
+	sub, pub := newTestClient(t), newTestClient(t)
+	sub.do("HELLO", "3")
+
+	if got := sub.do("SUBSCRIBE", "ps3"); got != ">3\r\n$9\r\nsubscribe\r\n$3\r\nps3\r\n:1\r\n" {
+		t.Fatalf("SUBSCRIBE = %q", got)
+	}
+	messages := sub.readAsync(1)
+	pub.do("PUBLISH", "ps3", "hi")
+	if got := <-messages; got != ">3\r\n$7\r\nmessage\r\n$3\r\nps3\r\n$2\r\nhi\r\n" {
+		t.Errorf("message = %q", got)
+	}
+	if got := sub.do("EXISTS", "ps3"); got != ":0\r\n" {
+		t.Errorf("EXISTS while subscribed = %q", got)
+	}
+	if got := sub.do("PING"); got != "+PONG\r\n" {
+		t.Errorf("PING while subscribed = %q", got)
+	}
+}
+
+// TestPublishPrunesDeadSubscribers registers a subscriber whose connection
+// is already dead and checks that publishing to it drops every one of its
+// subscriptions, while the live subscribers still get the message
+func TestPublishPrunesDeadSubscribers(t *testing.T) {
+	server, peer := net.Pipe()
+	peer.Close()
+	dead := newClient(server)
+	defer unregisterClient(dead)
+	handleSubscribe([]string{"SUBSCRIBE", "ps-dead", "ps-dead-other"}, dead)
+	handlePSubscribe([]string{"PSUBSCRIBE", "ps-dead*"}, dead)
+
+	live, pub := newTestClient(t), newTestClient(t)
+	live.do("SUBSCRIBE", "ps-dead")
+
+	messages := live.readAsync(1)
+	if got := pub.do("PUBLISH", "ps-dead", "x"); got != ":1\r\n" {
+		t.Errorf("PUBLISH = %q, want only the live subscriber counted", got)
+	}
+	if got, want := <-messages, encodeValue([]any{"message", "ps-dead", "x"}); got != want {
+		t.Errorf("live subscriber got %q, want %q", got, want)
+	}
+
+	pubsubMu.RLock()
+	_, inChannel := channels["ps-dead"][dead]
+	_, otherChannel := channels["ps-dead-other"]
+	_, pattern := patterns["ps-dead*"]
+	left := len(dead.channels) + len(dead.patterns)
+	pubsubMu.RUnlock()
+	if inChannel || otherChannel || pattern || left != 0 {
+		t.Errorf("dead subscriber still registered: channel %v, other channel %v, pattern %v, %d subscriptions", inChannel, otherChannel, pattern, left)
+	}
+	if got, want := pub.do("PUBSUB", "NUMSUB", "ps-dead", "ps-dead-other"), encodeValue([]any{"ps-dead", 1, "ps-dead-other", 0}); got != want {
+		t.Errorf("PUBSUB NUMSUB = %q, want %q", got, want)
+	}
+}
+
+func TestDisconnectUnsubscribes(t *testing.T) {
+	sub := newTestClient(t)
+	sub.do("SUBSCRIBE", "ps-gone")
+	sub.do("PSUBSCRIBE", "ps-gone*")
+	sub.conn.Close()
+
+	for i := 0; ; i++ {
+		pubsubMu.RLock()
+		_, channel := channels["ps-gone"]
+		_, pattern := patterns["ps-gone*"]
+		pubsubMu.RUnlock()
+		if !channel && !pattern {
+			return
+		}
+		if i == 5000 {
+			t.Fatalf("subscriptions outlive the connection: channel %v, pattern %v", channel, pattern)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import "slices"
+
+// quicklistNodeSize is the number of elements a quicklist node holds before
+// a push starts a new one. Small nodes keep inserts and head pushes cheap,
+// large ones keep the node overhead and index walks down.
+const quicklistNodeSize = 128
+
+// quicklistNode is one block of consecutive list elements
+type quicklistNode struct {
+	prev, next *quicklistNode
+	elements   []string
+}
+
+// quicklist is the list backend: a doubly linked list of element blocks, in
+// the spirit of Redis's quicklist. Pushing and popping at either end is O(1)
+// and reading by index or range only walks the nodes it needs.
+type quicklist struct {
+	head, tail *quicklistNode
+	count      int
+}
+
+// newQuicklist returns a list holding elements, in order
+func newQuicklist(elements ...string) *quicklist {
+	l := &quicklist{}
+	for _, e := range elements {
+		l.pushTail(e)
+	}
+	return l
+}
+
+// len returns the number of elements in the list
+func (l *quicklist) len() int {
+	return l.count
+}
+
+func (l *quicklist) pushHead(element string) {
+	if l.head == nil || len(l.head.elements) >= quicklistNodeSize {
+		l.insertNodeBefore(l.head, &quicklistNode{elements: make([]string, 0, 8)})
+	}
+	l.head.elements = slices.Insert(l.head.elements, 0, element)
+	l.count++
+}
+
+func (l *quicklist) pushTail(element string) {
+	if l.tail == nil || len(l.tail.elements) >= quicklistNodeSize {
+		l.insertNodeAfter(l.tail, &quicklistNode{elements: make([]string, 0, 8)})
+	}
+	l.tail.elements = append(l.tail.elements, element)
+	l.count++
+}
+
+// push adds an element to the head (left) or tail of the list
+func (l *quicklist) push(element string, left bool) {
+	if left {
+		l.pushHead(element)
+	} else {
+		l.pushTail(element)
+	}
+}
+
+// pop removes and returns the first (left) or last element of the list
+func (l *quicklist) pop(left bool) (string, bool) {
+	if l.count == 0 {
+		return "", false
+	}
+
+	var element string
+	if left {
+		n := l.head
+		element = n.elements[0]
+		n.elements[0] = "" // don't keep the popped element reachable
+		n.elements = n.elements[1:]
+		l.removeNodeIfEmpty(n)
+	} else {
+		n := l.tail
+		last := len(n.elements) - 1
+		element = n.elements[last]
+		n.elements[last] = ""
+		n.elements = n.elements[:last]
+		l.removeNodeIfEmpty(n)
+	}
+	l.count--
+	return element, true
+}
+
+// peek returns the first (left) or last element without removing it
+func (l *quicklist) peek(left bool) (string, bool) {
+	if l.count == 0 {
+		return "", false
+	}
+	if left {
+		return l.head.elements[0], true
+	}
+	return l.tail.elements[len(l.tail.elements)-1], true
+}
+
+// locate returns the node holding the element at index, which must be in
+// range, and the element's offset within it. It walks from whichever end
+// is nearer.
+func (l *quicklist) locate(index int) (*quicklistNode, int) {
+	if index < l.count/2 {
+		n := l.head
+		for index >= len(n.elements) {
+			index -= len(n.elements)
+			n = n.next
+		}
+		return n, index
+	}
+
+	n := l.tail
+	fromTail := l.count - 1 - index
+	for fromTail >= len(n.elements) {
+		fromTail -= len(n.elements)
+		n = n.prev
+	}
+	return n, len(n.elements) - 1 - fromTail
+}
+
+// index returns the element at index, which must be in range
+func (l *quicklist) index(index int) string {
+	n, i := l.locate(index)
+	return n.elements[i]
+}
+
+// set replaces the element at index, which must be in range
+func (l *quicklist) set(index int, element string) {
+	n, i := l.locate(index)
+	n.elements[i] = element
+}
+
+// insert inserts element so that it ends up at index, which may be at most
+// the length of the list. A full node is split in two first.
+func (l *quicklist) insert(index int, element string) {
+	switch index {
+	case 0:
+		l.pushHead(element)
+		return
+	case l.count:
+		l.pushTail(element)
+		return
+	}
+
+	n, i := l.locate(index)
+	if len(n.elements) >= quicklistNodeSize {
+		half := len(n.elements) / 2
+		split := &quicklistNode{elements: slices.Clone(n.elements[half:])}
+		clear(n.elements[half:])
+		n.elements = n.elements[:half]
+		l.insertNodeAfter(n, split)
+		if i >= half {
+			n, i = split, i-half
+		}
+	}
+	n.elements = slices.Insert(n.elements, i, element)
+	l.count++
+}
+
+// rangeElements returns a copy of the elements from start to stop, both
+// inclusive and within range
+func (l *quicklist) rangeElements(start, stop int) []string {
+	result := make([]string, 0, stop-start+1)
+	n, i := l.locate(start)
+	for len(result) < cap(result) {
+		take := min(len(n.elements)-i, cap(result)-len(result))
+		result = append(result, n.elements[i:i+take]...)
+		n, i = n.next, 0
+	}
+	return result
+}
+
+// elements returns a copy of every element in the list
+func (l *quicklist) elements() []string {
+	if l.count == 0 {
+		return []string{}
+	}
+	return l.rangeElements(0, l.count-1)
+}
+
+// each calls f for every element, from the head or from the tail if
+// reverse is set, with the element's index, until f returns false
+func (l *quicklist) each(reverse bool, f func(index int, element string) bool) {
+	if !reverse {
+		index := 0
+		for n := l.head; n != nil; n = n.next {
+			for _, e := range n.elements {
+				if !f(index, e) {
+					return
+				}
+				index++
+			}
+		}
+		return
+	}
+
+	index := l.count - 1
+	for n := l.tail; n != nil; n = n.prev {
+		for i := len(n.elements) - 1; i >= 0; i-- {
+			if !f(index, n.elements[i]) {
+				return
+			}
+			index--
+		}
+	}
+}
+
+// trim keeps only the elements from start to stop, both inclusive and
+// within range
+func (l *quicklist) trim(start, stop int) {
+	for range start {
+		l.pop(true)
+	}
+	for range l.count - (stop - start + 1) {
+		l.pop(false)
+	}
+}
+
+// clone returns a deep copy of the list
+func (l *quicklist) clone() *quicklist {
+	c := &quicklist{}
+	for n := l.head; n != nil; n = n.next {
+		c.insertNodeAfter(c.tail, &quicklistNode{elements: slices.Clone(n.elements)})
+	}
+	c.count = l.count
+	return c
+}
+
+// insertNodeBefore links node in front of at, or as the only node if the
+// list is empty
+func (l *quicklist) insertNodeBefore(at, node *quicklistNode) {
+	if at == nil {
+		l.head, l.tail = node, node
+		return
+	}
+	node.next, node.prev = at, at.prev
+	if at.prev != nil {
+		at.prev.next = node
+	} else {
+		l.head = node
+	}
+	at.prev = node
+}
+
+// insertNodeAfter links node behind at, or as the only node if the list is
+// empty
+func (l *quicklist) insertNodeAfter(at, node *quicklistNode) {
+	if at == nil {
+		l.head, l.tail = node, node
+		return
+	}
+	node.prev, node.next = at, at.next
+	if at.next != nil {
+		at.next.prev = node
+	} else {
+		l.tail = node
+	}
+	at.next = node
+}
+
+// removeNodeIfEmpty unlinks node once its last element is gone
+func (l *quicklist) removeNodeIfEmpty(node *quicklistNode) {
+	if len(node.elements) > 0 {
+		return
+	}
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+}
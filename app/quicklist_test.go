@@ -0,0 +1,176 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// checkQuicklist fails the test unless l holds want and its nodes are
+// consistently linked, none of them empty or over quicklistNodeSize
+func checkQuicklist(t *testing.T, l *quicklist, want []string) {
+	t.Helper()
+	if l.len() != len(want) {
+		t.Fatalf("len = %d, want %d", l.len(), len(want))
+	}
+	if (l.head == nil) != (len(want) == 0) || (l.tail == nil) != (len(want) == 0) {
+		t.Fatalf("head %p and tail %p of a list of %d elements", l.head, l.tail, len(want))
+	}
+
+	var got []string
+	var prev *quicklistNode
+	for n := l.head; n != nil; prev, n = n, n.next {
+		if n.prev != prev {
+			t.Fatalf("node %d links back to the wrong node", len(got))
+		}
+		if len(n.elements) == 0 || len(n.elements) > quicklistNodeSize {
+			t.Fatalf("node at element %d holds %d elements", len(got), len(n.elements))
+		}
+		got = append(got, n.elements...)
+	}
+	if prev != l.tail {
+		t.Fatal("the last node isn't the tail")
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("elements = %q, want %q", got, want)
+	}
+	if len(want) > 0 && !slices.Equal(l.elements(), want) {
+		t.Fatalf("elements() = %q, want %q", l.elements(), want)
+	}
+	for i, e := range want {
+		if got := l.index(i); got != e {
+			t.Fatalf("index(%d) = %q, want %q", i, got, e)
+		}
+	}
+}
+
+func TestQuicklistPushStartsNewNodes(t *testing.T) {
+	l := newQuicklist()
+	var want []string
+	for i := range quicklistNodeSize*2 + 1 {
+		e := strconv.Itoa(i)
+		l.pushTail(e)
+		want = append(want, e)
+	}
+	checkQuicklist(t, l, want)
+	if l.head.next == nil || l.head.next.next != l.tail || len(l.tail.elements) != 1 {
+		t.Errorf("%d tail pushes didn't fill two nodes and start a third", len(want))
+	}
+
+	l.pushHead("h")
+	checkQuicklist(t, l, slices.Concat([]string{"h"}, want))
+	if len(l.head.elements) != 1 {
+		t.Errorf("a head push onto a full node left %d elements in the head", len(l.head.elements))
+	}
+}
+
+func TestQuicklistInsertSplitsFullNode(t *testing.T) {
+	var want []string
+	for i := range quicklistNodeSize {
+		want = append(want, strconv.Itoa(i))
+	}
+
+	for _, at := range []int{1, quicklistNodeSize / 2, quicklistNodeSize - 1} {
+		l := newQuicklist(want...)
+		if l.head != l.tail {
+			t.Fatalf("%d elements span more than one node", quicklistNodeSize)
+		}
+		l.insert(at, "x")
+		checkQuicklist(t, l, slices.Insert(slices.Clone(want), at, "x"))
+		if l.head == l.tail {
+			t.Errorf("insert at %d didn't split the full node", at)
+		}
+	}
+}
+
+func TestQuicklistPopUnlinksEmptyNodes(t *testing.T) {
+	var want []string
+	for i := range quicklistNodeSize + 2 {
+		want = append(want, strconv.Itoa(i))
+	}
+	l := newQuicklist(want...)
+
+	// popping the tail down to the first node drops the second
+	for range 2 {
+		l.pop(false)
+	}
+	checkQuicklist(t, l, want[:quicklistNodeSize])
+	if l.head != l.tail {
+		t.Error("the emptied tail node is still linked")
+	}
+
+	for i := range quicklistNodeSize {
+		if got, ok := l.pop(true); !ok || got != want[i] {
+			t.Fatalf("pop %d = %q, %v, want %q", i, got, ok, want[i])
+		}
+	}
+	checkQuicklist(t, l, nil)
+	if _, ok := l.pop(true); ok {
+		t.Error("pop of an empty list succeeded")
+	}
+
+	// an emptied list takes pushes again
+	l.pushHead("a")
+	checkQuicklist(t, l, []string{"a"})
+}
+
+// TestQuicklistRandomOps runs random operations against both a quicklist
+// and a plain slice, checking after each that they agree
+func TestQuicklistRandomOps(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	l := newQuicklist()
+	var want []string
+	for op := range 5000 {
+		e := strconv.Itoa(op)
+		switch k := r.IntN(10); {
+		case k < 3:
+			l.pushHead(e)
+			want = slices.Insert(want, 0, e)
+		case k < 6:
+			l.pushTail(e)
+			want = append(want, e)
+		case k < 8:
+			at := r.IntN(len(want) + 1)
+			l.insert(at, e)
+			want = slices.Insert(want, at, e)
+		case len(want) > 0 && k == 8:
+			left := r.IntN(2) == 0
+			got, _ := l.pop(left)
+			wantPopped := want[len(want)-1]
+			if left {
+				wantPopped, want = want[0], want[1:]
+			} else {
+				want = want[:len(want)-1]
+			}
+			if got != wantPopped {
+				t.Fatalf("op %d: pop(%v) = %q, want %q", op, left, got, wantPopped)
+			}
+		case len(want) > 0:
+			start := r.IntN(len(want))
+			stop := start + r.IntN(len(want)-start)
+			if r.IntN(4) != 0 {
+				// mostly trim a little, so that the list keeps growing
+				start, stop = min(start, 2), max(stop, len(want)-3)
+			}
+			l.trim(start, stop)
+			want = want[start : stop+1]
+		}
+		checkQuicklist(t, l, want)
+	}
+}
+
+func TestQuicklistClone(t *testing.T) {
+	var want []string
+	for i := range quicklistNodeSize * 3 {
+		want = append(want, strconv.Itoa(i))
+	}
+	l := newQuicklist(want...)
+	c := l.clone()
+	checkQuicklist(t, c, want)
+
+	// the copy shares no node with the original
+	c.set(0, "changed")
+	c.pushTail("more")
+	checkQuicklist(t, l, want)
+}
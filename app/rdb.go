@@ -0,0 +1,1519 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RDB object types used by DUMP and RESTORE
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZSet             = 3
+	rdbTypeHash             = 4
+	rdbTypeZSet2            = 5
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZSetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZSetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+	rdbTypeHashMetadata     = 24
+	rdbTypeHashListpackEx   = 25
+)
+
+const (
+	// rdbVersion is the RDB version DUMP writes into its footer, the one of
+	// Redis 7.2, the first release that reads every type DUMP produces but
+	// hashes with field TTLs
+	rdbVersion = 11
+
+	// rdbVersionHashFieldTTL is the version DUMP writes for a hash with
+	// field TTLs, whose type only Redis 7.4 and later read
+	rdbVersionHashFieldTTL = 12
+
+	// rdbMaxLoadVersion is the newest RDB version RESTORE accepts
+	rdbMaxLoadVersion = 12
+
+	// quicklist node containers
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+
+	// stream entry flags
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+
+	// dumpNodeEntries caps the elements DUMP packs into one listpack, keeping
+	// nodes around the sizes Redis itself creates
+	dumpNodeEntries = 128
+)
+
+// errBadFormat is returned for any payload RESTORE can't decode
+var errBadFormat = errors.New("Bad data format")
+
+// crc64Table is the lookup table for the reflected CRC-64/Jones checksum that
+// Redis appends to DUMP payloads
+var crc64Table = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x95ac9329ac4bc9b5
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc64 returns the checksum Redis uses for DUMP payloads
+func crc64(data []byte) uint64 {
+	var crc uint64
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// lzfDecompress expands LZF-compressed data into exactly outLen bytes
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 32 {
+			// literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > outLen {
+				return nil, errBadFormat
+			}
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		// back reference; copied byte by byte as the ranges may overlap
+		n := ctrl >> 5
+		if n == 7 {
+			if ip >= len(in) {
+				return nil, errBadFormat
+			}
+			n += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errBadFormat
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+		n += 2
+		if ref < 0 || len(out)+n > outLen {
+			return nil, errBadFormat
+		}
+		for i := 0; i < n; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errBadFormat
+	}
+	return out, nil
+}
+
+// rdbWriter builds an RDB-encoded object
+type rdbWriter struct {
+	bytes.Buffer
+}
+
+// writeLen writes a length with the RDB variable-size length encoding
+func (w *rdbWriter) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.WriteByte(byte(n))
+	case n < 1<<14:
+		w.WriteByte(byte(n>>8) | 0x40)
+		w.WriteByte(byte(n))
+	case n <= 0xffffffff:
+		w.WriteByte(0x80)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		w.WriteByte(0x81)
+		w.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func (w *rdbWriter) writeString(s string) {
+	w.writeLen(uint64(len(s)))
+	w.WriteString(s)
+}
+
+// writeMillis writes a millisecond timestamp as 8 little-endian bytes
+func (w *rdbWriter) writeMillis(ms int64) {
+	w.Write(binary.LittleEndian.AppendUint64(nil, uint64(ms)))
+}
+
+// rdbReader decodes an RDB-encoded object
+type rdbReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errBadFormat
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *rdbReader) readRaw(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errBadFormat
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// readLenOrEncoding reads a length, or for the special string encodings
+// their encoding type with encoded set
+func (r *rdbReader) readLenOrEncoding() (n uint64, encoded bool, err error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := r.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 3:
+		return uint64(b & 0x3f), true, nil
+	}
+
+	switch b {
+	case 0x80:
+		raw, err := r.readRaw(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(raw)), false, nil
+	case 0x81:
+		raw, err := r.readRaw(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(raw), false, nil
+	}
+	return 0, false, errBadFormat
+}
+
+func (r *rdbReader) readLen() (uint64, error) {
+	n, encoded, err := r.readLenOrEncoding()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, errBadFormat
+	}
+	return n, nil
+}
+
+// readString reads a string in any of its RDB encodings: raw, as an 8, 16
+// or 32 bit integer, or LZF-compressed
+func (r *rdbReader) readString() (string, error) {
+	n, encoded, err := r.readLenOrEncoding()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		raw, err := r.readRaw(n)
+		return string(raw), err
+	}
+
+	switch n {
+	case 0, 1, 2:
+		size := uint64(1) << n
+		raw, err := r.readRaw(size)
+		if err != nil {
+			return "", err
+		}
+		var v int64
+		switch size {
+		case 1:
+			v = int64(int8(raw[0]))
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(raw)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(raw)))
+		}
+		return strconv.FormatInt(v, 10), nil
+	case 3:
+		clen, err := r.readLen()
+		if err != nil {
+			return "", err
+		}
+		length, err := r.readLen()
+		if err != nil {
+			return "", err
+		}
+		compressed, err := r.readRaw(clen)
+		if err != nil {
+			return "", err
+		}
+		if length > uint64(protoMaxBulkLen.value.Load()) {
+			return "", errBadFormat
+		}
+		out, err := lzfDecompress(compressed, int(length))
+		return string(out), err
+	}
+	return "", errBadFormat
+}
+
+// readMillis reads a millisecond timestamp, stored as 8 little-endian bytes
+func (r *rdbReader) readMillis() (int64, error) {
+	raw, err := r.readRaw(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(raw)), nil
+}
+
+// listpackWriter builds a listpack, the compact list encoding Redis uses for
+// small collections and stream nodes
+type listpackWriter struct {
+	entries []byte
+	count   int
+}
+
+// appendString adds an element, using an integer encoding when the string
+// is the canonical form of an integer as Redis does
+func (lp *listpackWriter) appendString(s string) {
+	if n, ok := parseStrictInt(s); ok {
+		lp.appendInt(n)
+		return
+	}
+
+	var enc []byte
+	switch n := len(s); {
+	case n < 64:
+		enc = append(enc, 0x80|byte(n))
+	case n < 4096:
+		enc = append(enc, 0xe0|byte(n>>8), byte(n))
+	default:
+		enc = append(enc, 0xf0)
+		enc = binary.LittleEndian.AppendUint32(enc, uint32(n))
+	}
+	lp.appendEntry(append(enc, s...))
+}
+
+func (lp *listpackWriter) appendInt(n int64) {
+	var enc []byte
+	switch {
+	case n >= 0 && n <= 127:
+		enc = []byte{byte(n)}
+	case n >= -4096 && n <= 4095:
+		u := uint16(n) & 0x1fff
+		enc = []byte{byte(u>>8) | 0xc0, byte(u)}
+	case n >= -1<<15 && n < 1<<15:
+		enc = binary.LittleEndian.AppendUint16([]byte{0xf1}, uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		u := uint32(n)
+		enc = []byte{0xf2, byte(u), byte(u >> 8), byte(u >> 16)}
+	case n >= -1<<31 && n < 1<<31:
+		enc = binary.LittleEndian.AppendUint32([]byte{0xf3}, uint32(n))
+	default:
+		enc = binary.LittleEndian.AppendUint64([]byte{0xf4}, uint64(n))
+	}
+	lp.appendEntry(enc)
+}
+
+// appendEntry adds an encoded element followed by its back-length, which
+// lets a listpack be walked from the tail. The back-length is written big
+// end first, every byte but the first flagged with the high bit.
+func (lp *listpackWriter) appendEntry(enc []byte) {
+	lp.entries = append(lp.entries, enc...)
+
+	l := uint64(len(enc))
+	n := backlenSize(len(enc))
+	for i := n - 1; i >= 0; i-- {
+		b := byte(l >> (7 * i))
+		if i < n-1 {
+			b = b&127 | 128
+		}
+		lp.entries = append(lp.entries, b)
+	}
+	lp.count++
+}
+
+// backlenSize returns the size of the back-length of a listpack entry of
+// l bytes. Redis's lpEncodeBacklen switches to a longer back-length one
+// short of each power of two, so an entry of exactly 16383 bytes takes
+// three bytes rather than two, and both ends must agree on it.
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// bytes returns the finished listpack: total size and element count, the
+// elements, then the 0xff terminator. Counts past 65534 are stored as
+// 65535, meaning unknown.
+func (lp *listpackWriter) bytes() []byte {
+	out := binary.LittleEndian.AppendUint32(nil, uint32(6+len(lp.entries)+1))
+	out = binary.LittleEndian.AppendUint16(out, uint16(min(lp.count, 65535)))
+	out = append(out, lp.entries...)
+	return append(out, 0xff)
+}
+
+// decodeListpack returns the elements of a listpack, integers in decimal
+func decodeListpack(lp []byte) ([]string, error) {
+	if len(lp) < 7 || binary.LittleEndian.Uint32(lp) != uint32(len(lp)) || lp[len(lp)-1] != 0xff {
+		return nil, errBadFormat
+	}
+
+	var elements []string
+	for p := 6; lp[p] != 0xff; {
+		b := lp[p]
+		var header, size int
+		var value string
+		isInt, n := false, int64(0)
+
+		switch {
+		case b&0x80 == 0:
+			header, isInt, n = 1, true, int64(b)
+		case b&0xc0 == 0x80:
+			header, size = 1, int(b&0x3f)
+		case b&0xe0 == 0xc0:
+			if p+1 >= len(lp) {
+				return nil, errBadFormat
+			}
+			u := int64(b&0x1f)<<8 | int64(lp[p+1])
+			if u >= 1<<12 {
+				u -= 1 << 13
+			}
+			header, isInt, n = 2, true, u
+		case b&0xf0 == 0xe0:
+			if p+1 >= len(lp) {
+				return nil, errBadFormat
+			}
+			header, size = 2, int(b&0x0f)<<8|int(lp[p+1])
+		case b == 0xf0:
+			if p+5 > len(lp) {
+				return nil, errBadFormat
+			}
+			header, size = 5, int(binary.LittleEndian.Uint32(lp[p+1:]))
+		case b >= 0xf1 && b <= 0xf4:
+			width := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[b]
+			if p+1+width > len(lp) {
+				return nil, errBadFormat
+			}
+			var u uint64
+			for i := width - 1; i >= 0; i-- {
+				u = u<<8 | uint64(lp[p+1+i])
+			}
+			// sign-extend from width bytes
+			shift := 64 - 8*width
+			header, isInt, n = 1+width, true, int64(u<<shift)>>shift
+		default:
+			return nil, errBadFormat
+		}
+
+		if size > len(lp)-p-header {
+			return nil, errBadFormat
+		}
+		if isInt {
+			value = strconv.FormatInt(n, 10)
+		} else {
+			value = string(lp[p+header : p+header+size])
+		}
+		elements = append(elements, value)
+
+		// skip the entry and its back-length
+		l := header + size
+		p += l + backlenSize(l)
+		if p >= len(lp) {
+			return nil, errBadFormat
+		}
+	}
+	return elements, nil
+}
+
+// decodeZiplist returns the elements of a ziplist, the compact encoding older
+// Redis versions used before listpacks
+func decodeZiplist(zl []byte) ([]string, error) {
+	if len(zl) < 11 || binary.LittleEndian.Uint32(zl) != uint32(len(zl)) || zl[len(zl)-1] != 0xff {
+		return nil, errBadFormat
+	}
+
+	var elements []string
+	p := 10
+	for p < len(zl) && zl[p] != 0xff {
+		// previous entry length: 1 byte, or 0xfe and 4 more
+		if zl[p] == 0xfe {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(zl) {
+			return nil, errBadFormat
+		}
+
+		b := zl[p]
+		var header, size int
+		isInt, n := false, int64(0)
+		switch {
+		case b>>6 == 0:
+			header, size = 1, int(b&0x3f)
+		case b>>6 == 1:
+			if p+2 > len(zl) {
+				return nil, errBadFormat
+			}
+			header, size = 2, int(b&0x3f)<<8|int(zl[p+1])
+		case b == 0x80:
+			if p+5 > len(zl) {
+				return nil, errBadFormat
+			}
+			header, size = 5, int(binary.BigEndian.Uint32(zl[p+1:]))
+		case b >= 0xf1 && b <= 0xfd:
+			header, isInt, n = 1, true, int64(b&0x0f)-1
+		default:
+			widths := map[byte]int{0xc0: 2, 0xd0: 4, 0xe0: 8, 0xf0: 3, 0xfe: 1}
+			width, ok := widths[b]
+			if !ok || p+1+width > len(zl) {
+				return nil, errBadFormat
+			}
+			var u uint64
+			for i := width - 1; i >= 0; i-- {
+				u = u<<8 | uint64(zl[p+1+i])
+			}
+			shift := 64 - 8*width
+			header, isInt, n = 1+width, true, int64(u<<shift)>>shift
+		}
+
+		if size > len(zl)-p-header {
+			return nil, errBadFormat
+		}
+		if isInt {
+			elements = append(elements, strconv.FormatInt(n, 10))
+		} else {
+			elements = append(elements, string(zl[p+header:p+header+size]))
+		}
+		p += header + size
+	}
+	return elements, nil
+}
+
+// dumpValue serializes a stored value in the DUMP format: the RDB type and
+// encoding of the value, the RDB version and a CRC64 of everything before it
+func dumpValue(value any) []byte {
+	w := &rdbWriter{}
+	switch v := value.(type) {
+	case Entry:
+		w.WriteByte(rdbTypeString)
+		w.writeString(v.value)
+	case ListEntry:
+		w.WriteByte(rdbTypeListQuicklist2)
+		dumpList(w, v.list.elements())
+	case HashEntry:
+		dumpHash(w, v)
+	case SetEntry:
+		dumpSet(w, v)
+	case ZSetEntry:
+		dumpZSet(w, v.zset)
+	case StreamEntry:
+		w.WriteByte(rdbTypeStreamListpacks3)
+		dumpStream(w, v)
+	}
+
+	version := uint16(rdbVersion)
+	if hash, ok := value.(HashEntry); ok && len(hash.fieldExpires) > 0 {
+		version = rdbVersionHashFieldTTL
+	}
+	w.Write(binary.LittleEndian.AppendUint16(nil, version))
+	w.Write(binary.LittleEndian.AppendUint64(nil, crc64(w.Bytes())))
+	return w.Bytes()
+}
+
+// dumpList writes a list as a quicklist of packed listpack nodes
+func dumpList(w *rdbWriter, elements []string) {
+	w.writeLen(uint64((len(elements) + dumpNodeEntries - 1) / dumpNodeEntries))
+	for start := 0; start < len(elements); start += dumpNodeEntries {
+		lp := &listpackWriter{}
+		for _, e := range elements[start:min(start+dumpNodeEntries, len(elements))] {
+			lp.appendString(e)
+		}
+		w.writeLen(quicklistNodePacked)
+		w.writeString(string(lp.bytes()))
+	}
+}
+
+// dumpHash writes a hash as a single listpack of field/value pairs if it is
+// encoded as one, or as a plain list of pairs otherwise. A hash with
+// field TTLs is written with each field's deadline, relative to the
+// earliest one plus one so that 0 can stand for no TTL.
+func dumpHash(w *rdbWriter, hash HashEntry) {
+	fields := hash.fields
+	if len(hash.fieldExpires) > 0 {
+		var minExpire int64 = math.MaxInt64
+		for _, deadline := range hash.fieldExpires {
+			minExpire = min(minExpire, deadline.UnixMilli())
+		}
+
+		w.WriteByte(rdbTypeHashMetadata)
+		w.writeMillis(minExpire)
+		w.writeLen(uint64(len(fields)))
+		for field, value := range fields {
+			ttl := uint64(0)
+			if deadline, ok := hash.fieldExpires[field]; ok {
+				ttl = uint64(deadline.UnixMilli()-minExpire) + 1
+			}
+			w.writeLen(ttl)
+			w.writeString(field)
+			w.writeString(value)
+		}
+		return
+	}
+
+	if objectEncoding(hash) == "listpack" {
+		w.WriteByte(rdbTypeHashListpack)
+		lp := &listpackWriter{}
+		for field, value := range fields {
+			lp.appendString(field)
+			lp.appendString(value)
+		}
+		w.writeString(string(lp.bytes()))
+		return
+	}
+
+	w.WriteByte(rdbTypeHash)
+	w.writeLen(uint64(len(fields)))
+	for field, value := range fields {
+		w.writeString(field)
+		w.writeString(value)
+	}
+}
+
+// dumpSet writes a set in the encoding OBJECT ENCODING reports for it: an
+// intset of sorted integers, a listpack, or a plain list of members
+func dumpSet(w *rdbWriter, set SetEntry) {
+	switch objectEncoding(set) {
+	case "intset":
+		ints := make([]int64, 0, len(set.members))
+		for member := range set.members {
+			n, _ := parseStrictInt(member)
+			ints = append(ints, n)
+		}
+		slices.Sort(ints)
+
+		// every integer is stored with the smallest width that fits them all
+		width := 2
+		for _, n := range ints {
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				width = 8
+			} else if (n < math.MinInt16 || n > math.MaxInt16) && width < 4 {
+				width = 4
+			}
+		}
+		is := binary.LittleEndian.AppendUint32(nil, uint32(width))
+		is = binary.LittleEndian.AppendUint32(is, uint32(len(ints)))
+		for _, n := range ints {
+			switch width {
+			case 2:
+				is = binary.LittleEndian.AppendUint16(is, uint16(n))
+			case 4:
+				is = binary.LittleEndian.AppendUint32(is, uint32(n))
+			default:
+				is = binary.LittleEndian.AppendUint64(is, uint64(n))
+			}
+		}
+		w.WriteByte(rdbTypeSetIntset)
+		w.writeString(string(is))
+	case "listpack":
+		lp := &listpackWriter{}
+		for member := range set.members {
+			lp.appendString(member)
+		}
+		w.WriteByte(rdbTypeSetListpack)
+		w.writeString(string(lp.bytes()))
+	default:
+		w.WriteByte(rdbTypeSet)
+		w.writeLen(uint64(len(set.members)))
+		for member := range set.members {
+			w.writeString(member)
+		}
+	}
+}
+
+// dumpZSet writes a sorted set as a listpack of member/score pairs in score
+// order if that is its encoding, or else as members each followed by its
+// score as a binary double
+func dumpZSet(w *rdbWriter, z *zset) {
+	items := z.items()
+	if z.encoding() == "listpack" {
+		lp := &listpackWriter{}
+		for _, item := range items {
+			lp.appendString(item.member)
+			// integral scores format as integers, which appendString
+			// stores as listpack integers like Redis does
+			lp.appendString(formatScore(item.score))
+		}
+		w.WriteByte(rdbTypeZSetListpack)
+		w.writeString(string(lp.bytes()))
+		return
+	}
+
+	w.WriteByte(rdbTypeZSet2)
+	w.writeLen(uint64(len(items)))
+	for _, item := range items {
+		w.writeString(item.member)
+		w.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(item.score)))
+	}
+}
+
+// dumpStream writes a stream as a radix tree of listpack nodes, each keyed
+// by the big-endian ID of its first (master) entry. Entries whose field
+// names match the master entry's are written with the SAMEFIELDS flag.
+func dumpStream(w *rdbWriter, stream StreamEntry) {
+	w.writeLen(uint64((len(stream.entries) + dumpNodeEntries - 1) / dumpNodeEntries))
+	for start := 0; start < len(stream.entries); start += dumpNodeEntries {
+		node := stream.entries[start:min(start+dumpNodeEntries, len(stream.entries))]
+		master := node[0].id
+
+		var masterFields []string
+		for i := 0; i < len(node[0].fields); i += 2 {
+			masterFields = append(masterFields, node[0].fields[i])
+		}
+
+		lp := &listpackWriter{}
+		lp.appendInt(int64(len(node)))
+		lp.appendInt(0)
+		lp.appendInt(int64(len(masterFields)))
+		for _, f := range masterFields {
+			lp.appendString(f)
+		}
+		lp.appendInt(0)
+
+		for _, entry := range node {
+			numFields := len(entry.fields) / 2
+
+			sameFields := numFields == len(masterFields)
+			for i := 0; sameFields && i < numFields; i++ {
+				sameFields = entry.fields[2*i] == masterFields[i]
+			}
+
+			if sameFields {
+				lp.appendInt(streamItemSameFields)
+			} else {
+				lp.appendInt(0)
+			}
+			lp.appendInt(int64(entry.id.ms - master.ms))
+			lp.appendInt(int64(entry.id.seq - master.seq))
+			if sameFields {
+				for i := 1; i < len(entry.fields); i += 2 {
+					lp.appendString(entry.fields[i])
+				}
+				lp.appendInt(int64(numFields + 3))
+			} else {
+				lp.appendInt(int64(numFields))
+				for _, f := range entry.fields {
+					lp.appendString(f)
+				}
+				lp.appendInt(int64(2*numFields + 4))
+			}
+		}
+
+		w.writeString(string(rawStreamID(master)))
+		w.writeString(string(lp.bytes()))
+	}
+
+	w.writeLen(uint64(len(stream.entries)))
+	w.writeLen(stream.lastID.ms)
+	w.writeLen(stream.lastID.seq)
+	w.writeLen(stream.firstID().ms)
+	w.writeLen(stream.firstID().seq)
+	w.writeLen(stream.maxDeleted.ms)
+	w.writeLen(stream.maxDeleted.seq)
+	w.writeLen(uint64(stream.added))
+
+	w.writeLen(uint64(len(stream.groups)))
+	for name, group := range stream.groups {
+		dumpStreamGroup(w, name, group)
+	}
+}
+
+// rawStreamID encodes a stream ID as the 16 big-endian bytes that key the
+// nodes of a stream and its PELs
+func rawStreamID(id streamID) []byte {
+	raw := binary.BigEndian.AppendUint64(nil, id.ms)
+	return binary.BigEndian.AppendUint64(raw, id.seq)
+}
+
+// parseRawStreamID decodes a stream ID encoded by rawStreamID
+func parseRawStreamID(raw []byte) streamID {
+	return streamID{binary.BigEndian.Uint64(raw), binary.BigEndian.Uint64(raw[8:])}
+}
+
+// dumpStreamGroup writes a consumer group: its name and last delivered ID,
+// its PEL with each entry's delivery time and count, and its consumers
+// with the IDs of the entries pending for each
+func dumpStreamGroup(w *rdbWriter, name string, group *streamGroup) {
+	w.writeString(name)
+	w.writeLen(group.lastID.ms)
+	w.writeLen(group.lastID.seq)
+	w.writeLen(uint64(group.entriesRead))
+
+	// 0-0 is never an entry ID, so every pending entry comes after it
+	pending := pendingAfter(group.pending, streamID{}, 0)
+	w.writeLen(uint64(len(pending)))
+	for _, id := range pending {
+		p := group.pending[id]
+		w.Write(rawStreamID(id))
+		w.writeMillis(p.deliveryTime.UnixMilli())
+		w.writeLen(uint64(p.deliveryCount))
+	}
+
+	w.writeLen(uint64(len(group.consumers)))
+	for name, consumer := range group.consumers {
+		w.writeString(name)
+		w.writeMillis(consumer.seenTime.UnixMilli())
+		if consumer.activeTime.IsZero() {
+			w.writeMillis(-1)
+		} else {
+			w.writeMillis(consumer.activeTime.UnixMilli())
+		}
+		owned := pendingAfter(consumer.pending, streamID{}, 0)
+		w.writeLen(uint64(len(owned)))
+		for _, id := range owned {
+			w.Write(rawStreamID(id))
+		}
+	}
+}
+
+// restoreValue decodes a DUMP payload, checking its version and checksum
+// first. The returned value has no TTL.
+func restoreValue(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, errors.New("DUMP payload version or checksum are wrong")
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	checksum := binary.LittleEndian.Uint64(payload[footer+2:])
+	if version > rdbMaxLoadVersion || checksum != crc64(payload[:footer+2]) {
+		return nil, errors.New("DUMP payload version or checksum are wrong")
+	}
+
+	r := &rdbReader{data: payload[:footer]}
+	value, err := restoreObject(r)
+	if err != nil {
+		return nil, errBadFormat
+	}
+	if r.pos != len(r.data) {
+		return nil, errBadFormat
+	}
+	return value, nil
+}
+
+func restoreObject(r *rdbReader) (any, error) {
+	objType, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch objType {
+	case rdbTypeString:
+		s, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		return Entry{value: s}, nil
+	case rdbTypeList, rdbTypeListZiplist, rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		elements, err := restoreList(r, objType)
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) == 0 {
+			return nil, errBadFormat
+		}
+		return ListEntry{list: newQuicklist(elements...)}, nil
+	case rdbTypeHash, rdbTypeHashZiplist, rdbTypeHashListpack:
+		fields, err := restoreHash(r, objType)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			return nil, errBadFormat
+		}
+		return HashEntry{fields: fields}, nil
+	case rdbTypeHashMetadata, rdbTypeHashListpackEx:
+		return restoreHashWithTTLs(r, objType)
+	case rdbTypeZSet, rdbTypeZSet2, rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		z, err := restoreZSet(r, objType)
+		if err != nil {
+			return nil, err
+		}
+		if z.len() == 0 {
+			return nil, errBadFormat
+		}
+		return ZSetEntry{zset: z}, nil
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack:
+		members, err := restoreSet(r, objType)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 0 {
+			return nil, errBadFormat
+		}
+		return SetEntry{members: members}, nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return restoreStream(r, objType)
+	}
+	return nil, errBadFormat
+}
+
+// restoreHash reads the fields of a hash, stored either as a list of
+// field/value pairs or packed into one ziplist or listpack. A field that
+// appears twice makes the payload invalid.
+func restoreHash(r *rdbReader, objType byte) (map[string]string, error) {
+	var pairs []string
+	if objType == rdbTypeHash {
+		n, err := r.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n*2; i++ {
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, s)
+		}
+	} else {
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if objType == rdbTypeHashZiplist {
+			pairs, err = decodeZiplist([]byte(blob))
+		} else {
+			pairs, err = decodeListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pairs)%2 != 0 {
+		return nil, errBadFormat
+	}
+	fields := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		if _, dup := fields[pairs[i]]; dup {
+			return nil, errBadFormat
+		}
+		fields[pairs[i]] = pairs[i+1]
+	}
+	return fields, nil
+}
+
+// restoreList reads the elements of a list in any of the encodings Redis has
+// used for lists
+func restoreList(r *rdbReader, objType byte) ([]string, error) {
+	if objType == rdbTypeListZiplist {
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		return decodeZiplist([]byte(blob))
+	}
+
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []string
+	for i := uint64(0); i < n; i++ {
+		container := uint64(quicklistNodePacked)
+		if objType == rdbTypeListQuicklist2 {
+			if container, err = r.readLen(); err != nil {
+				return nil, err
+			}
+		}
+
+		s, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case objType == rdbTypeList || container == quicklistNodePlain:
+			elements = append(elements, s)
+		case objType == rdbTypeListQuicklist:
+			node, err := decodeZiplist([]byte(s))
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, node...)
+		case container == quicklistNodePacked:
+			node, err := decodeListpack([]byte(s))
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, node...)
+		default:
+			return nil, errBadFormat
+		}
+	}
+	return elements, nil
+}
+
+// restoreSet reads the members of a set stored as a plain list, an intset
+// or a listpack. A member that appears twice makes the payload invalid.
+func restoreSet(r *rdbReader, objType byte) (map[string]struct{}, error) {
+	var members []string
+	switch objType {
+	case rdbTypeSet:
+		n, err := r.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			s, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, s)
+		}
+	case rdbTypeSetIntset:
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if members, err = decodeIntset([]byte(blob)); err != nil {
+			return nil, err
+		}
+	default:
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		if members, err = decodeListpack([]byte(blob)); err != nil {
+			return nil, err
+		}
+	}
+
+	set := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		if _, dup := set[m]; dup {
+			return nil, errBadFormat
+		}
+		set[m] = struct{}{}
+	}
+	return set, nil
+}
+
+// restoreZSet reads a sorted set stored as member and score pairs, either
+// one after the other or packed into a ziplist or listpack. Old payloads
+// store scores as strings behind a length byte, with 253 to 255 standing
+// for NaN, inf and -inf; newer ones as binary doubles. A NaN score or a
+// member that appears twice makes the payload invalid.
+func restoreZSet(r *rdbReader, objType byte) (*zset, error) {
+	z := newZSet()
+	add := func(member string, score float64) error {
+		if math.IsNaN(score) {
+			return errBadFormat
+		}
+		if _, dup := z.score(member); dup {
+			return errBadFormat
+		}
+		z.set(member, score)
+		return nil
+	}
+
+	if objType == rdbTypeZSetZiplist || objType == rdbTypeZSetListpack {
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var pairs []string
+		if objType == rdbTypeZSetZiplist {
+			pairs, err = decodeZiplist([]byte(blob))
+		} else {
+			pairs, err = decodeListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs)%2 != 0 {
+			return nil, errBadFormat
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			score, err := strconv.ParseFloat(pairs[i+1], 64)
+			if err != nil {
+				return nil, errBadFormat
+			}
+			if err := add(pairs[i], score); err != nil {
+				return nil, err
+			}
+		}
+		return z, nil
+	}
+
+	n, err := r.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		member, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if objType == rdbTypeZSet2 {
+			raw, err := r.readRaw(8)
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		} else {
+			size, err := r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			switch size {
+			case 253:
+				score = math.NaN()
+			case 254:
+				score = math.Inf(1)
+			case 255:
+				score = math.Inf(-1)
+			default:
+				raw, err := r.readRaw(uint64(size))
+				if err != nil {
+					return nil, err
+				}
+				if score, err = strconv.ParseFloat(string(raw), 64); err != nil {
+					return nil, errBadFormat
+				}
+			}
+		}
+		if err := add(member, score); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+// decodeIntset returns the integers of an intset in decimal: a header with
+// the width of each integer and their count, then the integers themselves
+func decodeIntset(is []byte) ([]string, error) {
+	if len(is) < 8 {
+		return nil, errBadFormat
+	}
+	width := binary.LittleEndian.Uint32(is)
+	count := binary.LittleEndian.Uint32(is[4:])
+	if (width != 2 && width != 4 && width != 8) || uint64(len(is)-8) != uint64(width)*uint64(count) {
+		return nil, errBadFormat
+	}
+
+	members := make([]string, 0, count)
+	for p := 8; p < len(is); p += int(width) {
+		var n int64
+		switch width {
+		case 2:
+			n = int64(int16(binary.LittleEndian.Uint16(is[p:])))
+		case 4:
+			n = int64(int32(binary.LittleEndian.Uint32(is[p:])))
+		default:
+			n = int64(binary.LittleEndian.Uint64(is[p:]))
+		}
+		members = append(members, strconv.FormatInt(n, 10))
+	}
+	return members, nil
+}
+
+// restoreHashWithTTLs reads a hash whose fields may have TTLs, either as a
+// list of relative deadline, field and value triplets or as a listpack of
+// field, value and absolute deadline triplets, with 0 for no TTL in both
+func restoreHashWithTTLs(r *rdbReader, objType byte) (HashEntry, error) {
+	hash := HashEntry{fields: make(map[string]string), fieldExpires: make(map[string]time.Time)}
+	minExpire, err := r.readMillis()
+	if err != nil {
+		return hash, err
+	}
+
+	add := func(field, value string, deadline int64) error {
+		if _, dup := hash.fields[field]; dup {
+			return errBadFormat
+		}
+		hash.fields[field] = value
+		if deadline != 0 {
+			hash.fieldExpires[field] = time.UnixMilli(deadline)
+		}
+		return nil
+	}
+
+	if objType == rdbTypeHashMetadata {
+		n, err := r.readLen()
+		if err != nil {
+			return hash, err
+		}
+		for i := uint64(0); i < n; i++ {
+			ttl, err := r.readLen()
+			if err != nil {
+				return hash, err
+			}
+			field, err := r.readString()
+			if err != nil {
+				return hash, err
+			}
+			value, err := r.readString()
+			if err != nil {
+				return hash, err
+			}
+			deadline := int64(0)
+			if ttl != 0 {
+				deadline = minExpire + int64(ttl) - 1
+			}
+			if err := add(field, value, deadline); err != nil {
+				return hash, err
+			}
+		}
+	} else {
+		blob, err := r.readString()
+		if err != nil {
+			return hash, err
+		}
+		items, err := decodeListpack([]byte(blob))
+		if err != nil {
+			return hash, err
+		}
+		if len(items)%3 != 0 {
+			return hash, errBadFormat
+		}
+		for i := 0; i < len(items); i += 3 {
+			deadline, err := strconv.ParseInt(items[i+2], 10, 64)
+			if err != nil {
+				return hash, errBadFormat
+			}
+			if err := add(items[i], items[i+1], deadline); err != nil {
+				return hash, err
+			}
+		}
+	}
+
+	if len(hash.fields) == 0 {
+		return hash, errBadFormat
+	}
+	return hash, nil
+}
+
+// restoreStream reads a stream's entries and consumer groups
+func restoreStream(r *rdbReader, objType byte) (StreamEntry, error) {
+	stream := StreamEntry{entries: make([]StreamEntryData, 0)}
+
+	nodes, err := r.readLen()
+	if err != nil {
+		return stream, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := r.readString()
+		if err != nil {
+			return stream, err
+		}
+		if len(key) != 16 {
+			return stream, errBadFormat
+		}
+		master := parseRawStreamID([]byte(key))
+
+		blob, err := r.readString()
+		if err != nil {
+			return stream, err
+		}
+		items, err := decodeListpack([]byte(blob))
+		if err != nil {
+			return stream, err
+		}
+		entries, err := decodeStreamNode(items, master)
+		if err != nil {
+			return stream, err
+		}
+		stream.entries = append(stream.entries, entries...)
+	}
+
+	// length and last ID
+	var header [3]uint64
+	for i := range header {
+		if header[i], err = r.readLen(); err != nil {
+			return stream, err
+		}
+	}
+	stream.lastID = streamID{header[1], header[2]}
+	if n := len(stream.entries); n > 0 && compareStreamIDs(stream.lastID, stream.entries[n-1].id) < 0 {
+		return stream, errBadFormat
+	}
+	if objType >= rdbTypeStreamListpacks2 {
+		// first ID, max deleted ID and entries added
+		var ids [5]uint64
+		for i := range ids {
+			if ids[i], err = r.readLen(); err != nil {
+				return stream, err
+			}
+		}
+		stream.maxDeleted = streamID{ids[2], ids[3]}
+		stream.added = int64(ids[4])
+	} else {
+		stream.added = int64(len(stream.entries))
+	}
+
+	groups, err := r.readLen()
+	if err != nil {
+		return stream, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		name, group, err := restoreStreamGroup(r, objType)
+		if err != nil {
+			return stream, err
+		}
+		if stream.groups == nil {
+			stream.groups = make(map[string]*streamGroup)
+		}
+		if stream.groups[name] != nil {
+			return stream, errBadFormat
+		}
+		if objType < rdbTypeStreamListpacks2 {
+			group.entriesRead = stream.entriesUpTo(group.lastID)
+		}
+		stream.groups[name] = group
+	}
+	return stream, nil
+}
+
+// decodeStreamNode turns the elements of one stream listpack node into
+// entries, skipping the ones flagged as deleted
+func decodeStreamNode(items []string, master streamID) ([]StreamEntryData, error) {
+	next := func() (string, error) {
+		if len(items) == 0 {
+			return "", errBadFormat
+		}
+		s := items[0]
+		items = items[1:]
+		return s, nil
+	}
+	nextInt := func() (int64, error) {
+		s, err := next()
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, errBadFormat
+		}
+		return n, nil
+	}
+
+	// master entry: count, deleted count, field names, terminating 0
+	if _, err := nextInt(); err != nil {
+		return nil, err
+	}
+	if _, err := nextInt(); err != nil {
+		return nil, err
+	}
+	numMaster, err := nextInt()
+	if err != nil || numMaster < 0 || numMaster > int64(len(items)) {
+		return nil, errBadFormat
+	}
+	masterFields := make([]string, numMaster)
+	for i := range masterFields {
+		masterFields[i], _ = next()
+	}
+	if _, err := nextInt(); err != nil {
+		return nil, err
+	}
+
+	var entries []StreamEntryData
+	for len(items) > 0 {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			for _, f := range masterFields {
+				v, err := next()
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, f, v)
+			}
+		} else {
+			n, err := nextInt()
+			if err != nil || n < 0 || 2*n > int64(len(items)) {
+				return nil, errBadFormat
+			}
+			for j := int64(0); j < 2*n; j++ {
+				s, _ := next()
+				fields = append(fields, s)
+			}
+		}
+
+		// lp-count, the number of elements the entry used
+		if _, err := nextInt(); err != nil {
+			return nil, err
+		}
+
+		if flags&streamItemDeleted == 0 {
+			id := streamID{master.ms + uint64(msDiff), master.seq + uint64(seqDiff)}
+			entries = append(entries, StreamEntryData{id: id, fields: fields})
+		}
+	}
+	return entries, nil
+}
+
+// restoreStreamGroup reads one consumer group. Every entry a consumer owns
+// must be in the group's PEL, and be owned by that consumer alone.
+func restoreStreamGroup(r *rdbReader, objType byte) (string, *streamGroup, error) {
+	name, err := r.readString()
+	if err != nil {
+		return "", nil, err
+	}
+	var lastID [2]uint64
+	for i := range lastID {
+		if lastID[i], err = r.readLen(); err != nil {
+			return "", nil, err
+		}
+	}
+	group := newStreamGroup(streamID{lastID[0], lastID[1]}, entriesReadUnknown)
+	if objType >= rdbTypeStreamListpacks2 {
+		read, err := r.readLen()
+		if err != nil {
+			return "", nil, err
+		}
+		group.entriesRead = int64(read) // all ones for unknown
+	}
+
+	// pending entries: raw ID, delivery time, delivery count
+	pending, err := r.readLen()
+	if err != nil {
+		return "", nil, err
+	}
+	for i := uint64(0); i < pending; i++ {
+		raw, err := r.readRaw(16)
+		if err != nil {
+			return "", nil, err
+		}
+		deliveryTime, err := r.readMillis()
+		if err != nil {
+			return "", nil, err
+		}
+		deliveryCount, err := r.readLen()
+		if err != nil {
+			return "", nil, err
+		}
+		id := parseRawStreamID(raw)
+		if group.pending[id] != nil {
+			return "", nil, errBadFormat
+		}
+		group.pending[id] = &streamPending{
+			deliveryTime:  time.UnixMilli(deliveryTime),
+			deliveryCount: int(deliveryCount),
+		}
+	}
+
+	consumers, err := r.readLen()
+	if err != nil {
+		return "", nil, err
+	}
+	claimed := make(map[streamID]bool, len(group.pending))
+	for i := uint64(0); i < consumers; i++ {
+		consumerName, err := r.readString()
+		if err != nil {
+			return "", nil, err
+		}
+		if group.consumers[consumerName] != nil {
+			return "", nil, errBadFormat
+		}
+		consumer := group.consumer(consumerName)
+		seenTime, err := r.readMillis()
+		if err != nil {
+			return "", nil, err
+		}
+		consumer.seenTime = time.UnixMilli(seenTime)
+		if objType >= rdbTypeStreamListpacks3 {
+			activeTime, err := r.readMillis()
+			if err != nil {
+				return "", nil, err
+			}
+			if activeTime >= 0 {
+				consumer.activeTime = time.UnixMilli(activeTime)
+			}
+		}
+
+		owned, err := r.readLen()
+		if err != nil {
+			return "", nil, err
+		}
+		for j := uint64(0); j < owned; j++ {
+			raw, err := r.readRaw(16)
+			if err != nil {
+				return "", nil, err
+			}
+			id := parseRawStreamID(raw)
+			p := group.pending[id]
+			if p == nil || claimed[id] {
+				return "", nil, errBadFormat
+			}
+			claimed[id] = true
+			p.consumer = consumerName
+			consumer.pending[id] = p
+		}
+	}
+
+	// a pending entry nobody owns would be unreachable from the consumers
+	if len(claimed) != len(group.pending) {
+		return "", nil, errBadFormat
+	}
+	return name, group, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dumpPayload returns the DUMP payload of a key, which can hold any byte
+func dumpPayload(t *testing.T, c *testClient, key string) string {
+	t.Helper()
+	reply := c.do("DUMP", key)
+	header, payload, ok := strings.Cut(reply, "\r\n")
+	n, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+	if !ok || err != nil || !strings.HasPrefix(header, "$") || len(payload) != n+2 {
+		t.Fatalf("DUMP %s = %q, want a payload", key, reply)
+	}
+	return payload[:n]
+}
+
+// repeated returns args followed by n generated arguments
+func repeated(n int, gen func(i int) []string, args ...string) []string {
+	for i := range n {
+		args = append(args, gen(i)...)
+	}
+	return args
+}
+
+func TestRestoreTTL(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("DEL", "rt-src", "rt")
+	c.do("SET", "rt-src", "v")
+	payload := dumpPayload(t, c, "rt-src")
+	now := fake.Now().UnixMilli()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+		pttl string
+	}{
+		{"no ttl", []string{"0", payload}, "+OK\r\n", ":-1\r\n"},
+		{"relative", []string{"5000", payload}, "+OK\r\n", ":5000\r\n"},
+		{"absolute", []string{strconv.FormatInt(now+3000, 10), payload, "ABSTTL"}, "+OK\r\n", ":3000\r\n"},
+		{"absolute in the past", []string{strconv.FormatInt(now-1, 10), payload, "ABSTTL"}, "+OK\r\n", ":-2\r\n"},
+		// larger than a time.Duration holds, but the deadline still fits
+		{"relative beyond a duration", []string{"9300000000000000", payload}, "+OK\r\n", ":9300000000000000\r\n"},
+		{"relative deadline overflows", []string{"9223372036854775000", payload}, "-ERR invalid expire time in 'restore' command\r\n", ":-2\r\n"},
+		{"negative", []string{"-1", payload}, "-ERR Invalid TTL value, must be >= 0\r\n", ":-2\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "rt")
+			args := append([]string{"RESTORE", "rt"}, tt.args...)
+			if got := c.do(args...); got != tt.want {
+				t.Errorf("RESTORE rt %v = %q, want %q", tt.args[0], got, tt.want)
+			}
+			if got := c.do("PTTL", "rt"); got != tt.pttl {
+				t.Errorf("PTTL after RESTORE = %q, want %q", got, tt.pttl)
+			}
+		})
+	}
+}
+
+// TestDumpRestoreRoundTrip dumps a value of every type in each of its
+// encodings, restores it under another key and checks that the copy reads
+// back the same and keeps the encoding
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	useFakeClock(t)
+	long := strings.Repeat("x", 100)
+	member := func(i int) []string { return []string{"m" + strconv.Itoa(i)} }
+
+	tests := []struct {
+		name     string
+		setup    [][]string
+		encoding string
+		probes   [][]string
+	}{
+		{"int string", [][]string{{"SET", "KEY", "-12345"}}, "int", [][]string{{"GET", "KEY"}}},
+		{"embstr string", [][]string{{"SET", "KEY", "hello"}}, "embstr", [][]string{{"GET", "KEY"}}},
+		{"raw string", [][]string{{"SET", "KEY", long}}, "raw", [][]string{{"GET", "KEY"}}},
+		{"binary string", [][]string{{"SET", "KEY", "a\x00\r\n\xff"}}, "embstr", [][]string{{"STRLEN", "KEY"}, {"GETRANGE", "KEY", "0", "-1"}}},
+		{
+			"listpack list",
+			[][]string{{"RPUSH", "KEY", "a", "12", "-7", long}},
+			"listpack", [][]string{{"LRANGE", "KEY", "0", "-1"}},
+		},
+		{
+			"quicklist list over several nodes",
+			[][]string{repeated(300, func(i int) []string { return []string{strconv.Itoa(i) + long} }, "RPUSH", "KEY")},
+			"quicklist", [][]string{{"LLEN", "KEY"}, {"LRANGE", "KEY", "0", "-1"}},
+		},
+		{
+			"listpack hash",
+			[][]string{{"HSET", "KEY", "a", "1", "b", "two", "c", "-3"}},
+			"listpack", [][]string{{"HLEN", "KEY"}, {"HMGET", "KEY", "a", "b", "c"}},
+		},
+		{
+			"hashtable hash",
+			[][]string{{"HSET", "KEY", "a", long, "b", "2"}},
+			"hashtable", [][]string{{"HLEN", "KEY"}, {"HMGET", "KEY", "a", "b"}},
+		},
+		{
+			"hash with field TTLs",
+			[][]string{{"HSET", "KEY", "a", "1", "b", "2", "c", "3"}, {"HPEXPIRE", "KEY", "5000", "FIELDS", "1", "a"}, {"HPEXPIRE", "KEY", "9000", "FIELDS", "1", "c"}},
+			"listpackex", [][]string{{"HMGET", "KEY", "a", "b", "c"}, {"HPTTL", "KEY", "FIELDS", "3", "a", "b", "c"}},
+		},
+		{
+			"16-bit intset",
+			[][]string{{"SADD", "KEY", "1", "-2", "300"}},
+			"intset", [][]string{{"SMEMBERS", "KEY"}},
+		},
+		{
+			"32-bit intset",
+			[][]string{{"SADD", "KEY", "1", "-70000", "300"}},
+			"intset", [][]string{{"SMEMBERS", "KEY"}},
+		},
+		{
+			"64-bit intset",
+			[][]string{{"SADD", "KEY", "1", "70000", "-9223372036854775808", "9223372036854775807"}},
+			"intset", [][]string{{"SMEMBERS", "KEY"}},
+		},
+		{
+			"listpack set",
+			[][]string{{"SADD", "KEY", "a", "1", "b"}},
+			"listpack", [][]string{{"SMEMBERS", "KEY"}},
+		},
+		{
+			"hashtable set",
+			[][]string{repeated(200, member, "SADD", "KEY")},
+			"hashtable", [][]string{{"SCARD", "KEY"}, {"SMEMBERS", "KEY"}},
+		},
+		{
+			"listpack zset",
+			[][]string{{"ZADD", "KEY", "1", "a", "2.5", "b", "-3", "c", "1e20", "d"}},
+			"listpack", [][]string{{"ZRANGE", "KEY", "0", "-1", "WITHSCORES"}},
+		},
+		{
+			"skiplist zset",
+			[][]string{repeated(200, func(i int) []string { return []string{strconv.Itoa(i) + ".25", "m" + strconv.Itoa(i)} }, "ZADD", "KEY")},
+			"skiplist", [][]string{{"ZCARD", "KEY"}, {"ZRANGE", "KEY", "0", "-1", "WITHSCORES"}, {"ZRANK", "KEY", "m150"}},
+		},
+		{
+			"stream",
+			[][]string{
+				{"XADD", "KEY", "1-1", "a", "1", "b", "2"},
+				{"XADD", "KEY", "1-2", "a", "3", "b", "4"},
+				{"XADD", "KEY", "2-0", "other", "5"},
+				{"XADD", "KEY", "3-0", "a", "6", "b", "7"},
+				{"XDEL", "KEY", "1-2"},
+			},
+			"stream", [][]string{{"XLEN", "KEY"}, {"XRANGE", "KEY", "-", "+"}, {"XINFO", "STREAM", "KEY"}},
+		},
+		{
+			"stream over several nodes",
+			func() (cmds [][]string) {
+				for i := range 300 {
+					cmds = append(cmds, []string{"XADD", "KEY", strconv.Itoa(i+1) + "-0", "f", strconv.Itoa(i)})
+				}
+				return cmds
+			}(),
+			"stream", [][]string{{"XLEN", "KEY"}, {"XRANGE", "KEY", "-", "+"}},
+		},
+		{
+			"stream with consumer groups",
+			[][]string{
+				{"XADD", "KEY", "1-0", "a", "1"},
+				{"XADD", "KEY", "2-0", "a", "2"},
+				{"XADD", "KEY", "3-0", "a", "3"},
+				{"XGROUP", "CREATE", "KEY", "g1", "0"},
+				{"XGROUP", "CREATE", "KEY", "g2", "$"},
+				{"XREADGROUP", "GROUP", "g1", "alice", "COUNT", "2", "STREAMS", "KEY", ">"},
+				{"XREADGROUP", "GROUP", "g1", "bob", "STREAMS", "KEY", ">"},
+				{"XACK", "KEY", "g1", "1-0"},
+			},
+			"stream", [][]string{
+				{"XINFO", "GROUPS", "KEY"},
+				{"XINFO", "CONSUMERS", "KEY", "g1"},
+				{"XPENDING", "KEY", "g1"},
+				{"XPENDING", "KEY", "g1", "-", "+", "10"},
+			},
+		},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "rd-src", "rd-dst")
+			for _, cmd := range tt.setup {
+				cmd = slices.Clone(cmd)
+				for i, arg := range cmd {
+					if arg == "KEY" {
+						cmd[i] = "rd-src"
+					}
+				}
+				if got := c.do(cmd...); got[0] == '-' {
+					t.Fatalf("%v = %q", cmd, got)
+				}
+			}
+
+			if got := c.do("RESTORE", "rd-dst", "0", dumpPayload(t, c, "rd-src")); got != "+OK\r\n" {
+				t.Fatalf("RESTORE = %q", got)
+			}
+			if got := c.do("OBJECT", "ENCODING", "rd-dst"); got != encodeValue(tt.encoding) {
+				t.Errorf("encoding of the restored key = %q, want %s", got, tt.encoding)
+			}
+			for _, probe := range tt.probes {
+				src, dst := slices.Clone(probe), slices.Clone(probe)
+				for i, arg := range probe {
+					if arg == "KEY" {
+						src[i], dst[i] = "rd-src", "rd-dst"
+					}
+				}
+				want := c.do(src...)
+				if got := c.do(dst...); got != want {
+					t.Errorf("%v on the restored key = %q, want %q", probe, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreRejectsBadPayloads(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "rb-src", "rb")
+	c.do("RPUSH", "rb-src", "a", "b", "c")
+	payload := dumpPayload(t, c, "rb-src")
+
+	// withFooter replaces the version and checksum of a payload body
+	withFooter := func(body string, version uint16, fixChecksum bool) string {
+		out := binary.LittleEndian.AppendUint16([]byte(body), version)
+		sum := crc64(out)
+		if !fixChecksum {
+			sum++
+		}
+		return string(binary.LittleEndian.AppendUint64(out, sum))
+	}
+	body := payload[:len(payload)-10]
+	flipped := []byte(payload)
+	flipped[3] ^= 0xff
+
+	const wrong = "-ERR DUMP payload version or checksum are wrong\r\n"
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"intact", payload, "+OK\r\n"},
+		{"oldest version", withFooter(body, 1, true), "+OK\r\n"},
+		{"newest version", withFooter(body, rdbMaxLoadVersion, true), "+OK\r\n"},
+		{"too new a version", withFooter(body, rdbMaxLoadVersion+1, true), wrong},
+		{"bad checksum", withFooter(body, rdbVersion, false), wrong},
+		{"corrupted body", string(flipped), wrong},
+		{"truncated", payload[:9], wrong},
+		{"empty", "", wrong},
+		{"unknown type", withFooter("\xff"+body[1:], rdbVersion, true), "-ERR Bad data format\r\n"},
+		{"trailing bytes", withFooter(body+"x", rdbVersion, true), "-ERR Bad data format\r\n"},
+		{"cut short", withFooter(body[:len(body)-2], rdbVersion, true), "-ERR Bad data format\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "rb")
+			if got := c.do("RESTORE", "rb", "0", tt.payload); got != tt.want {
+				t.Errorf("RESTORE = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net"
+	"strings"
 )
 
 // RESP protocol response helpers
@@ -23,6 +24,13 @@ func writeNullBulkString(conn net.Conn) error {
 	return err
 }
 
+// writeNullArray writes the null array, the "no result" reply of commands
+// that otherwise reply with an array, such as blocking pops
+func writeNullArray(conn net.Conn) error {
+	_, err := conn.Write([]byte("*-1\r\n"))
+	return err
+}
+
 func writeInteger(conn net.Conn, val int) error {
 	_, err := conn.Write([]byte(fmt.Sprintf(":%d\r\n", val)))
 	return err
@@ -42,3 +50,124 @@ func writeArray(conn net.Conn, elems []string) error {
 	_, err := conn.Write([]byte(out))
 	return err
 }
+
+// writeNullableArray writes an RESP array in which nil elements are sent as
+// null bulk strings
+func writeNullableArray(conn net.Conn, elems []*string) error {
+	out := fmt.Sprintf("*%d\r\n", len(elems))
+	for _, e := range elems {
+		if e == nil {
+			out += "$-1\r\n"
+			continue
+		}
+		out += fmt.Sprintf("$%d\r\n%s\r\n", len(*e), *e)
+	}
+	_, err := conn.Write([]byte(out))
+	return err
+}
+
+// nullArray stands for the null array inside a value passed to writeValue,
+// where a plain nil is the null bulk string
+type nullArray struct{}
+
+// respSet is a set of strings, sent as a RESP3 set to clients that
+// negotiated RESP3 with HELLO and as a plain array to the others
+type respSet []string
+
+// respMap is a map given as alternating keys and values, sent as a RESP3
+// map to RESP3 clients and as a flat array to the others
+type respMap []any
+
+// respPush is an out-of-band message such as a pub/sub message, sent as a
+// RESP3 push to RESP3 clients and as a plain array to the others
+type respPush []any
+
+// encodeValue encodes a Go value as RESP2: strings become bulk strings,
+// integers become RESP integers, nil becomes a null bulk string, nullArray
+// becomes the null array and slices, respSet, respMap and respPush become
+// (possibly nested) arrays
+func encodeValue(v any) string {
+	return encodeRESP(v, false)
+}
+
+// encodeRESP encodes a Go value as encodeValue does, but with respSet,
+// respMap and respPush as RESP3 sets, maps and pushes if resp3 is set
+func encodeRESP(v any, resp3 bool) string {
+	var b strings.Builder
+	appendValue(&b, v, resp3)
+	return b.String()
+}
+
+func appendValue(b *strings.Builder, v any, resp3 bool) {
+	switch val := v.(type) {
+	case nil:
+		b.WriteString("$-1\r\n")
+	case nullArray:
+		b.WriteString("*-1\r\n")
+	case string:
+		fmt.Fprintf(b, "$%d\r\n%s\r\n", len(val), val)
+	case int:
+		fmt.Fprintf(b, ":%d\r\n", val)
+	case int64:
+		fmt.Fprintf(b, ":%d\r\n", val)
+	case []string:
+		fmt.Fprintf(b, "*%d\r\n", len(val))
+		for _, e := range val {
+			appendValue(b, e, resp3)
+		}
+	case []any:
+		fmt.Fprintf(b, "*%d\r\n", len(val))
+		for _, e := range val {
+			appendValue(b, e, resp3)
+		}
+	case respSet:
+		if resp3 {
+			fmt.Fprintf(b, "~%d\r\n", len(val))
+		} else {
+			fmt.Fprintf(b, "*%d\r\n", len(val))
+		}
+		for _, e := range val {
+			appendValue(b, e, resp3)
+		}
+	case respPush:
+		if resp3 {
+			fmt.Fprintf(b, ">%d\r\n", len(val))
+		} else {
+			fmt.Fprintf(b, "*%d\r\n", len(val))
+		}
+		for _, e := range val {
+			appendValue(b, e, resp3)
+		}
+	case respMap:
+		if resp3 {
+			fmt.Fprintf(b, "%%%d\r\n", len(val)/2)
+		} else {
+			fmt.Fprintf(b, "*%d\r\n", len(val))
+		}
+		for _, e := range val {
+			appendValue(b, e, resp3)
+		}
+	default:
+		panic(fmt.Sprintf("encodeValue: unsupported type %T", v))
+	}
+}
+
+// writeValue writes a Go value, including nested arrays, as RESP, in the
+// protocol version the client negotiated
+func writeValue(conn net.Conn, v any) error {
+	_, err := conn.Write([]byte(encodeRESP(v, usesRESP3(conn))))
+	return err
+}
+
+// usesRESP3 reports whether the client on conn switched to RESP3 with HELLO
+func usesRESP3(conn net.Conn) bool {
+	client, ok := conn.(*Client)
+	return ok && client.proto == 3
+}
+
+// writeRawError writes an error reply whose message already starts with its
+// error code, such as WRONGTYPE or NOSCRIPT
+func writeRawError(conn net.Conn, msg string) error {
+	_, err := conn.Write([]byte("-" + msg + "\r\n"))
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// errNoScripting is returned by every command that would execute a script
+const errNoScripting = "This RegoDB build does not support scripting"
+
+var (
+	scriptsMu sync.RWMutex
+	scripts   = make(map[string]string) // SHA1 -> script body
+)
+
+// scriptSHA returns the lowercase hex SHA1 digest of a script body
+func scriptSHA(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleEval rejects EVAL, EVAL_RO, FCALL and FCALL_RO
+func handleEval(args []string, conn net.Conn) {
+	writeError(conn, errNoScripting)
+}
+
+// handleEvalSha rejects EVALSHA, reporting unknown digests the way Redis does
+func handleEvalSha(args []string, conn net.Conn) {
+	scriptsMu.RLock()
+	_, ok := scripts[strings.ToLower(args[1])]
+	scriptsMu.RUnlock()
+
+	if !ok {
+		writeRawError(conn, "NOSCRIPT No matching script. Please use EVAL.")
+		return
+	}
+	writeError(conn, errNoScripting)
+}
+
+// handleScript implements SCRIPT LOAD, EXISTS and FLUSH. Scripts can be
+// loaded and looked up, but not executed.
+func handleScript(args []string, conn net.Conn) {
+	switch strings.ToUpper(args[1]) {
+	case "LOAD":
+		if len(args) != 3 {
+			writeError(conn, "wrong number of arguments for 'script|load' command")
+			return
+		}
+		sha := scriptSHA(args[2])
+		scriptsMu.Lock()
+		scripts[sha] = args[2]
+		scriptsMu.Unlock()
+		writeBulkString(conn, sha)
+	case "EXISTS":
+		if len(args) < 3 {
+			writeError(conn, "wrong number of arguments for 'script|exists' command")
+			return
+		}
+		scriptsMu.RLock()
+		result := make([]any, len(args)-2)
+		for i, sha := range args[2:] {
+			if _, ok := scripts[strings.ToLower(sha)]; ok {
+				result[i] = 1
+			} else {
+				result[i] = 0
+			}
+		}
+		scriptsMu.RUnlock()
+		writeValue(conn, result)
+	case "FLUSH":
+		scriptsMu.Lock()
+		scripts = make(map[string]string)
+		scriptsMu.Unlock()
+		writeSimpleString(conn, "OK")
+	case "KILL":
+		writeRawError(conn, "NOTBUSY No scripts in execution right now.")
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try SCRIPT HELP.", args[1]))
+	}
+}
+
+// handleFunction rejects every FUNCTION subcommand
+func handleFunction(args []string, conn net.Conn) {
+	writeError(conn, errNoScripting)
+}
@@ -2,12 +2,29 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
 )
 
+// protocolError reports a malformed request frame. Once one is seen the
+// reader can no longer be trusted to sit on a frame boundary, so the
+// connection must be closed rather than resumed.
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+func newProtocolError(format string, a ...any) error {
+	return &protocolError{msg: fmt.Sprintf(format, a...)}
+}
+
 // parseRESPArray parses a RESP array and returns the arguments
 func parseRESPArray(reader *bufio.Reader) ([]string, error) {
 	// Read the array header line
@@ -18,13 +35,13 @@ func parseRESPArray(reader *bufio.Reader) ([]string, error) {
 	line = strings.TrimSpace(line)
 
 	if !strings.HasPrefix(line, "*") {
-		return nil, fmt.Errorf("protocol error: expected array, got '%s'", line)
+		return nil, newProtocolError("expected '*', got '%s'", line)
 	}
 
 	// Parse array length
 	argCount, err := strconv.Atoi(line[1:])
-	if err != nil || argCount < 1 {
-		return nil, fmt.Errorf("invalid array length")
+	if err != nil || argCount < 1 || argCount > maxMultiBulkLen {
+		return nil, newProtocolError("invalid multibulk length")
 	}
 
 	// Read each bulk string in the array
@@ -32,22 +49,40 @@ func parseRESPArray(reader *bufio.Reader) ([]string, error) {
 	for i := 0; i < argCount; i++ {
 		// Read the bulk string header
 		lenLine, err := reader.ReadString('\n')
-		if err != nil || !strings.HasPrefix(lenLine, "$") {
-			return nil, fmt.Errorf("invalid bulk string header")
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(lenLine, "$") {
+			return nil, newProtocolError("expected '$', got '%s'", strings.TrimSpace(lenLine))
 		}
 
 		// Parse bulk string length
 		strLen, err := strconv.Atoi(strings.TrimSpace(lenLine[1:]))
 		if err != nil {
-			return nil, fmt.Errorf("invalid bulk string length")
+			return nil, newProtocolError("invalid bulk length")
+		}
+
+		// $-1 is a null bulk string and carries no data
+		if strLen == -1 {
+			args = append(args, "")
+			continue
+		}
+
+		// reject the length before allocating anything for it
+		if strLen < 0 || int64(strLen) > protoMaxBulkLen.value.Load() {
+			return nil, newProtocolError("invalid bulk length")
 		}
 
 		// read the actual string data
 		buf := make([]byte, strLen+2)
 		// +2 for CRLF - (Carriage Return Line Feed) i.e. \r\n
-		_, err = reader.Read(buf)
+		// a single Read may return a partial frame, so wait for all of it
+		_, err = io.ReadFull(reader, buf)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read argument data")
+			return nil, err
+		}
+		if buf[strLen] != '\r' || buf[strLen+1] != '\n' {
+			return nil, newProtocolError("bulk string is not terminated by CRLF")
 		}
 
 		args = append(args, string(buf[:strLen]))
@@ -59,12 +94,21 @@ func parseRESPArray(reader *bufio.Reader) ([]string, error) {
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
 	reader := bufio.NewReader(conn)
+	client := newClient(conn)
+	defer unregisterClient(client)
+
+	connectedClients.Add(1)
+	totalConnectionsReceived.Add(1)
+	defer connectedClients.Add(-1)
 
 	for {
 		args, err := parseRESPArray(reader)
 		if err != nil {
-			if err.Error() != "EOF" {
-				writeError(conn, err.Error())
+			// protocol errors are reported before closing; I/O errors such as
+			// EOF mean the client is gone and there is no one to tell
+			var protoErr *protocolError
+			if errors.As(err, &protoErr) {
+				writeError(conn, protoErr.Error())
 			}
 			return
 		}
@@ -75,12 +119,28 @@ func handleConnection(conn net.Conn) {
 		}
 
 		command := strings.ToUpper(args[0])
-		handler, exists := commandHandlers[command]
-
-		if exists {
-			handler(args, conn)
-		} else {
+		cmd, exists := commandTable[command]
+		if !exists {
+			flagMultiError(client)
 			writeError(conn, fmt.Sprintf("unknown command '%s'", command))
+			continue
+		}
+
+		// calls with the wrong number of arguments are rejected before the
+		// handler runs
+		if !cmd.checkArity(len(args)) {
+			statFor(cmd.name).rejectedCalls.Add(1)
+			flagMultiError(client)
+			writeError(conn, fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(cmd.name)))
+			continue
+		}
+
+		if rejectInSubscribedMode(client, cmd.name) {
+			continue
+		}
+		if queueCommand(client, args) {
+			continue
 		}
+		call(cmd, args, client)
 	}
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	InitDB()
+	os.Exit(m.Run())
+}
+
+// testClient talks RESP to a connection served by handleConnection over an
+// in-memory pipe
+type testClient struct {
+	t      testing.TB
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestClient(t testing.TB) *testClient {
+	t.Helper()
+	client, server := net.Pipe()
+	go handleConnection(server)
+	t.Cleanup(func() { client.Close() })
+	return &testClient{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+// do sends a command and returns its raw RESP reply
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.t.Fatalf("write %v: %v", args, err)
+	}
+	reply, err := readReply(c.reader)
+	if err != nil {
+		c.t.Fatalf("read reply to %v: %v", args, err)
+	}
+	return reply
+}
+
+// readReply reads one complete RESP reply, nested arrays and RESP3 sets,
+// maps and pushes included
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", fmt.Errorf("short reply line %q", line)
+	}
+	switch line[0] {
+	case '$':
+		n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || n < 0 {
+			return line, err
+		}
+		body := make([]byte, n+2)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return "", err
+		}
+		return line + string(body), nil
+	case '*', '~', '%', '>':
+		n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || n < 0 {
+			return line, err
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		reply := line
+		for range n {
+			elem, err := readReply(r)
+			if err != nil {
+				return "", err
+			}
+			reply += elem
+		}
+		return reply, nil
+	}
+	return line, nil
+}
+
+func TestParseRESPArray(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{"command", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}, ""},
+		{"empty bulk", "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", []string{"ECHO", ""}, ""},
+		{"null bulk", "*2\r\n$4\r\nECHO\r\n$-1\r\n", []string{"ECHO", ""}, ""},
+		{"binary bulk", "*1\r\n$4\r\na\r\nb\r\n", []string{"a\r\nb"}, ""},
+		{"inline", "GET k\r\n", nil, "Protocol error: expected '*', got 'GET k'"},
+		{"zero multibulk", "*0\r\n", nil, "Protocol error: invalid multibulk length"},
+		{"negative multibulk", "*-1\r\n", nil, "Protocol error: invalid multibulk length"},
+		{"huge multibulk", "*" + strconv.Itoa(maxMultiBulkLen+1) + "\r\n", nil, "Protocol error: invalid multibulk length"},
+		{"not a bulk", "*1\r\n:1\r\n", nil, "Protocol error: expected '$', got ':1'"},
+		{"bad bulk length", "*1\r\n$x\r\n", nil, "Protocol error: invalid bulk length"},
+		{"negative bulk length", "*1\r\n$-2\r\n", nil, "Protocol error: invalid bulk length"},
+		{"oversized bulk", "*1\r\n$1000000000\r\n", nil, "Protocol error: invalid bulk length"},
+		{"missing CRLF", "*1\r\n$3\r\nGETXX", nil, "Protocol error: bulk string is not terminated by CRLF"},
+		{"bare LF", "*1\r\n$3\r\nGET\nX", nil, "Protocol error: bulk string is not terminated by CRLF"},
+		{"short bulk", "*1\r\n$3\r\nGE", nil, io.ErrUnexpectedEOF.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseRESPArray(bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseRESPArray(%q) error = %v, want %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRESPArray(%q) error = %v", tt.input, err)
+			}
+			if !slices.Equal(args, tt.want) {
+				t.Errorf("parseRESPArray(%q) = %q, want %q", tt.input, args, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseRESPArrayOversizedNoAlloc checks that a declared length over
+// proto-max-bulk-len is rejected before a buffer for it is allocated
+func TestParseRESPArrayOversizedNoAlloc(t *testing.T) {
+	input := "*1\r\n$1000000000\r\n"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := parseRESPArray(bufio.NewReader(strings.NewReader(input)))
+	runtime.ReadMemStats(&after)
+
+	var protoErr *protocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("parseRESPArray(%q) error = %v, want a protocol error", input, err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("rejecting %q allocated %d bytes", input, allocated)
+	}
+}
+
+func TestProtoMaxBulkLen(t *testing.T) {
+	c := newTestClient(t)
+	c.do("CONFIG", "SET", "proto-max-bulk-len", "2mb")
+	defer c.do("CONFIG", "SET", "proto-max-bulk-len", "512mb")
+
+	input := "*1\r\n$" + strconv.Itoa(2*1024*1024+1) + "\r\n"
+	_, err := parseRESPArray(bufio.NewReader(strings.NewReader(input)))
+	if err == nil || err.Error() != "Protocol error: invalid bulk length" {
+		t.Errorf("parseRESPArray over the configured limit error = %v, want invalid bulk length", err)
+	}
+
+	value := strings.Repeat("x", 2*1024*1024)
+	input = "*1\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	if _, err := parseRESPArray(bufio.NewReader(strings.NewReader(input))); err != nil {
+		t.Errorf("parseRESPArray at the configured limit error = %v", err)
+	}
+}
+
+// TestProtocolErrorClosesConnection checks that a malformed frame gets an
+// error reply and then the connection closed, while command errors leave it
+// open for the next command
+func TestProtocolErrorClosesConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		closes bool
+	}{
+		{"inline command", "PING\r\n", "-ERR Protocol error: expected '*', got 'PING'\r\n", true},
+		{"bad multibulk length", "*x\r\n", "-ERR Protocol error: invalid multibulk length\r\n", true},
+		{"bad bulk length", "*1\r\n$x\r\n", "-ERR Protocol error: invalid bulk length\r\n", true},
+		{"missing CRLF", "*1\r\n$4\r\nPINGxx", "-ERR Protocol error: bulk string is not terminated by CRLF\r\n", true},
+		{"unknown command", "*1\r\n$4\r\nNOPE\r\n", "-ERR unknown command 'NOPE'\r\n", false},
+		{"wrong arity", "*1\r\n$3\r\nGET\r\n", "-ERR wrong number of arguments for 'get' command\r\n", false},
+		{"wrong type", "*3\r\n$5\r\nLPUSH\r\n$9\r\nproto-str\r\n$1\r\na\r\n", "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n", false},
+	}
+	setup := newTestClient(t)
+	setup.do("SET", "proto-str", "v")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			if _, err := io.WriteString(c.conn, tt.input); err != nil {
+				t.Fatalf("write %q: %v", tt.input, err)
+			}
+			reply, err := readReply(c.reader)
+			if err != nil {
+				t.Fatalf("read reply to %q: %v", tt.input, err)
+			}
+			if reply != tt.want {
+				t.Errorf("reply to %q = %q, want %q", tt.input, reply, tt.want)
+			}
+
+			if tt.closes {
+				if _, err := c.reader.ReadByte(); err != io.EOF {
+					t.Errorf("after %q the connection is still open (read error %v)", tt.input, err)
+				}
+				return
+			}
+			if got := c.do("PING"); got != "+PONG\r\n" {
+				t.Errorf("PING after %q = %q, want +PONG", tt.input, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// setMembers returns the members of a set in sorted order, so that replies
+// listing them don't change from call to call while the set doesn't
+func setMembers(set SetEntry) []string {
+	return slices.Sorted(maps.Keys(set.members))
+}
+
+// handleSAdd adds members to a set, creating the key if needed, and replies
+// with the number of members that weren't already in it
+func handleSAdd(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var set SetEntry
+	if value, exists := lookupKey(key); exists {
+		var ok bool
+		if set, ok = value.(SetEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	} else {
+		set = SetEntry{members: make(map[string]struct{})}
+	}
+
+	// count the new members up front, so the limits can be checked before
+	// anything changes; a member named twice is only new once
+	added := 0
+	seen := make(map[string]bool)
+	for _, member := range args[2:] {
+		if _, exists := set.members[member]; !exists && !seen[member] {
+			added++
+		}
+		seen[member] = true
+	}
+	if err := checkCollectionLimits(len(set.members)+added, args[2:]...); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	for _, member := range args[2:] {
+		set.members[member] = struct{}{}
+	}
+	DB.Store(key, withEncoding(set))
+	writeInteger(conn, added)
+}
+
+// handleSRem removes members from a set, deleting the key once the last one
+// is gone, and replies with the number of members removed
+func handleSRem(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	removed := 0
+	for _, member := range args[2:] {
+		if _, exists := set.members[member]; exists {
+			delete(set.members, member)
+			removed++
+		}
+	}
+
+	if len(set.members) == 0 {
+		DB.Delete(key)
+	} else if removed > 0 {
+		DB.Store(key, set)
+	}
+	writeInteger(conn, removed)
+}
+
+// handleSMembers replies with every member of a set
+func handleSMembers(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeValue(conn, respSet{})
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	writeValue(conn, respSet(setMembers(set)))
+}
+
+// handleSIsMember replies 1 if the set has the member, 0 otherwise
+func handleSIsMember(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	if _, ok := set.members[args[2]]; ok {
+		writeInteger(conn, 1)
+		return
+	}
+	writeInteger(conn, 0)
+}
+
+// handleSCard replies with the number of members in a set
+func handleSCard(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	writeInteger(conn, len(set.members))
+}
+
+// set operations combining several sets
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+// lookupSets returns the sets stored at keys, with nil for missing keys,
+// or errWrongType if any key holds something else. The caller must hold
+// the locks of keys.
+func lookupSets(keys []string) ([]map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		value, exists := lookupKey(key)
+		if !exists {
+			continue
+		}
+		set, ok := value.(SetEntry)
+		if !ok {
+			return nil, errWrongType
+		}
+		sets[i] = set.members
+	}
+	return sets, nil
+}
+
+// intersectSets calls f for every member of the intersection of sets until
+// f returns false. It walks the smallest set, checking its members against
+// the others from the smallest up, so that a small or empty set cuts the
+// work short.
+func intersectSets(sets []map[string]struct{}, f func(member string) bool) {
+	sorted := slices.Clone(sets)
+	slices.SortFunc(sorted, func(a, b map[string]struct{}) int {
+		return len(a) - len(b)
+	})
+
+members:
+	for member := range sorted[0] {
+		for _, other := range sorted[1:] {
+			if _, ok := other[member]; !ok {
+				continue members
+			}
+		}
+		if !f(member) {
+			return
+		}
+	}
+}
+
+// combineSets computes the intersection, union or difference of sets, a
+// missing set counting as empty. The difference is the first set minus all
+// the others.
+func combineSets(sets []map[string]struct{}, op int) map[string]struct{} {
+	result := make(map[string]struct{})
+	switch op {
+	case setInter:
+		intersectSets(sets, func(member string) bool {
+			result[member] = struct{}{}
+			return true
+		})
+	case setUnion:
+		for _, set := range sets {
+			for member := range set {
+				result[member] = struct{}{}
+			}
+		}
+	case setDiff:
+		for member := range sets[0] {
+			result[member] = struct{}{}
+		}
+		for _, set := range sets[1:] {
+			if len(result) == 0 {
+				break
+			}
+			for member := range set {
+				delete(result, member)
+			}
+		}
+	}
+	return result
+}
+
+// setOpGeneric implements SINTER, SUNION and SDIFF, replying with the
+// members of the combined set
+func setOpGeneric(args []string, conn net.Conn, op int) {
+	keys := args[1:]
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	sets, err := lookupSets(keys)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	writeValue(conn, respSet(setMembers(SetEntry{members: combineSets(sets, op)})))
+}
+
+// setOpStoreGeneric implements SINTERSTORE, SUNIONSTORE and SDIFFSTORE: the
+// combined set replaces whatever destination held, with no TTL, or deletes
+// it if the result is empty. It replies with the size of the result.
+func setOpStoreGeneric(args []string, conn net.Conn, op int) {
+	dst, keys := args[1], args[2:]
+	unlock := DB.Lock(append([]string{dst}, keys...)...)
+	defer unlock()
+
+	sets, err := lookupSets(keys)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	result := combineSets(sets, op)
+	if len(result) > 0 {
+		replaceValue(dst, SetEntry{members: result})
+	} else {
+		replaceValue(dst, nil)
+	}
+	writeInteger(conn, len(result))
+}
+
+func handleSInter(args []string, conn net.Conn) {
+	setOpGeneric(args, conn, setInter)
+}
+
+func handleSUnion(args []string, conn net.Conn) {
+	setOpGeneric(args, conn, setUnion)
+}
+
+func handleSDiff(args []string, conn net.Conn) {
+	setOpGeneric(args, conn, setDiff)
+}
+
+func handleSInterStore(args []string, conn net.Conn) {
+	setOpStoreGeneric(args, conn, setInter)
+}
+
+func handleSUnionStore(args []string, conn net.Conn) {
+	setOpStoreGeneric(args, conn, setUnion)
+}
+
+func handleSDiffStore(args []string, conn net.Conn) {
+	setOpStoreGeneric(args, conn, setDiff)
+}
+
+// handleSPop removes and returns random members of a set: one as a bulk
+// string without a count, or up to count distinct members as an array
+func handleSPop(args []string, conn net.Conn) {
+	if len(args) > 3 {
+		writeError(conn, "syntax error")
+		return
+	}
+
+	count, hasCount := 1, len(args) == 3
+	if hasCount {
+		var ok bool
+		count, ok = parseIntArg(args[2])
+		if !ok || count < 0 {
+			writeError(conn, "value is out of range, must be positive")
+			return
+		}
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		if hasCount {
+			writeArray(conn, []string{})
+		} else {
+			writeNullBulkString(conn)
+		}
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	popped := sampleKeys(set.members, count)
+	for _, member := range popped {
+		delete(set.members, member)
+	}
+	if len(set.members) == 0 {
+		DB.Delete(key)
+	} else {
+		DB.Store(key, set)
+	}
+
+	if !hasCount {
+		writeBulkString(conn, popped[0])
+		return
+	}
+	writeArray(conn, popped)
+}
+
+// handleSRandMember replies with random members of a set without removing
+// them. Without a count it replies with one member; a positive count asks
+// for up to that many distinct members, a negative one for exactly -count
+// members that may repeat.
+func handleSRandMember(args []string, conn net.Conn) {
+	if len(args) > 3 {
+		writeError(conn, "syntax error")
+		return
+	}
+
+	count, hasCount := 1, len(args) == 3
+	if hasCount {
+		var ok bool
+		count, ok = parseIntArg(args[2])
+		if !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+		if count == math.MinInt {
+			writeError(conn, "value is out of range")
+			return
+		}
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		if hasCount {
+			writeArray(conn, []string{})
+		} else {
+			writeNullBulkString(conn)
+		}
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	var picked []string
+	if count >= 0 {
+		picked = sampleKeys(set.members, count)
+	} else {
+		picked = sampleKeysWithRepeats(set.members, -count)
+	}
+
+	if !hasCount {
+		writeBulkString(conn, picked[0])
+		return
+	}
+	writeArray(conn, picked)
+}
+
+// handleSMove moves a member from one set to another as a single step,
+// creating the destination if needed, and replies 1 if the member was moved
+// or 0 if the source doesn't have it
+func handleSMove(args []string, conn net.Conn) {
+	src, dst, member := args[1], args[2], args[3]
+	unlock := DB.Lock(src, dst)
+	defer unlock()
+
+	// check both keys before touching anything
+	var source, dest SetEntry
+	if value, exists := lookupKey(src); exists {
+		var ok bool
+		if source, ok = value.(SetEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	}
+	if value, exists := lookupKey(dst); exists {
+		var ok bool
+		if dest, ok = value.(SetEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	} else {
+		dest = SetEntry{members: make(map[string]struct{})}
+	}
+
+	if _, ok := source.members[member]; !ok {
+		writeInteger(conn, 0)
+		return
+	}
+	if src == dst {
+		writeInteger(conn, 1)
+		return
+	}
+
+	_, alreadyThere := dest.members[member]
+	if !alreadyThere {
+		if err := checkCollectionLimits(len(dest.members)+1, member); err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+	}
+
+	delete(source.members, member)
+	if len(source.members) == 0 {
+		DB.Delete(src)
+	} else {
+		DB.Store(src, source)
+	}
+	dest.members[member] = struct{}{}
+	DB.Store(dst, withEncoding(dest))
+	writeInteger(conn, 1)
+}
+
+// handleSMIsMember replies with 1 or 0 for each member, telling whether the
+// set has it
+func handleSMIsMember(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	var set SetEntry
+	if exists {
+		var ok bool
+		if set, ok = value.(SetEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	}
+
+	results := make([]any, len(args)-2)
+	for i, member := range args[2:] {
+		results[i] = 0
+		if _, ok := set.members[member]; ok {
+			results[i] = 1
+		}
+	}
+	writeValue(conn, results)
+}
+
+// handleSScan implements SSCAN key cursor [MATCH pattern] [COUNT count].
+// Like HSCAN, it returns sets not yet converted to a hashtable whole
+// in a single call.
+func handleSScan(args []string, conn net.Conn) {
+	opts, err := parseScanArgs(args, false)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeValue(conn, []any{"0", []string{}})
+		return
+	}
+	set, ok := value.(SetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	members, next := setMembers(set), uint64(0)
+	if objectEncoding(set) == "hashtable" {
+		members, next = scanPage(members, opts.cursor, opts.count)
+	}
+
+	result := make([]string, 0, len(members))
+	for _, member := range members {
+		if opts.matches(member) {
+			result = append(result, member)
+		}
+	}
+	writeValue(conn, []any{strconv.FormatUint(next, 10), result})
+}
+
+// handleSInterCard implements SINTERCARD numkeys key [key ...] [LIMIT
+// limit], replying with the size of the intersection without building it.
+// A non-zero limit stops the count once it is reached.
+func handleSInterCard(args []string, conn net.Conn) {
+	numKeys, ok := parseIntArg(args[1])
+	if !ok || numKeys <= 0 {
+		writeError(conn, "numkeys should be greater than 0")
+		return
+	}
+	if numKeys > len(args)-2 {
+		writeError(conn, "Number of keys can't be greater than number of args")
+		return
+	}
+	keys := args[2 : numKeys+2]
+
+	limit := 0
+	rest := args[numKeys+2:]
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "LIMIT":
+		limit, ok = parseIntArg(rest[1])
+		if !ok || limit < 0 {
+			writeError(conn, "LIMIT can't be negative")
+			return
+		}
+	default:
+		writeError(conn, "syntax error")
+		return
+	}
+
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	sets, err := lookupSets(keys)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	count := 0
+	intersectSets(sets, func(string) bool {
+		count++
+		return limit == 0 || count < limit
+	})
+	writeInteger(conn, count)
+}
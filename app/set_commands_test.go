@@ -0,0 +1,241 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSPopSRandMemberCount(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "sr", "sr-missing")
+	c.do("SADD", "sr", "a", "b", "c")
+	members := map[string]bool{"a": true, "b": true, "c": true}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SRANDMEMBER", "sr-missing"}, "$-1\r\n"},
+		{[]string{"SRANDMEMBER", "sr-missing", "3"}, "*0\r\n"},
+		{[]string{"SRANDMEMBER", "sr", "0"}, "*0\r\n"},
+		{[]string{"SRANDMEMBER", "sr", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"SPOP", "sr-missing"}, "$-1\r\n"},
+		{[]string{"SPOP", "sr-missing", "3"}, "*0\r\n"},
+		{[]string{"SPOP", "sr", "0"}, "*0\r\n"},
+		{[]string{"SPOP", "sr", "-1"}, "-ERR value is out of range, must be positive\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		count   string
+		n       int
+		repeats bool
+	}{
+		{"2", 2, false},
+		{"3", 3, false},
+		{"10", 3, false},
+		{"-2", 2, true},
+		// more than the set holds, so members must repeat
+		{"-10", 10, true},
+	} {
+		reply := c.do("SRANDMEMBER", "sr", tt.count)
+		got := parseArrayReply(t, reply)
+		if len(got) != tt.n {
+			t.Fatalf("SRANDMEMBER sr %s = %q, want %d members", tt.count, reply, tt.n)
+		}
+		seen := map[string]bool{}
+		for _, m := range got {
+			if !members[m] {
+				t.Errorf("SRANDMEMBER sr %s returned %q, not a member", tt.count, m)
+			}
+			if seen[m] && !tt.repeats {
+				t.Errorf("SRANDMEMBER sr %s repeated %q", tt.count, m)
+			}
+			seen[m] = true
+		}
+	}
+	popped := parseArrayReply(t, c.do("SPOP", "sr", "2"))
+	if len(popped) != 2 || popped[0] == popped[1] {
+		t.Fatalf("SPOP sr 2 = %q, want 2 distinct members", popped)
+	}
+	if got := c.do("SCARD", "sr"); got != ":1\r\n" {
+		t.Errorf("SCARD after SPOP sr 2 = %q, want :1", got)
+	}
+	last := parseArrayReply(t, c.do("SPOP", "sr", "10"))
+	if len(last) != 1 || last[0] == popped[0] || last[0] == popped[1] {
+		t.Errorf("SPOP sr 10 = %q, want the one member left", last)
+	}
+	if got := c.do("EXISTS", "sr"); got != ":0\r\n" {
+		t.Errorf("EXISTS after popping every member = %q, want :0", got)
+	}
+}
+
+// TestSPopSRandMemberUniform checks that every member comes up about as
+// often as the others, for both set encodings and with and without a count
+func TestSPopSRandMemberUniform(t *testing.T) {
+	c := newTestClient(t)
+	for _, size := range []int{10, 200} {
+		all := []string{"SADD", "su"}
+		for i := range size {
+			all = append(all, "m"+strconv.Itoa(i))
+		}
+
+		const rounds = 400
+		pickers := []struct {
+			name string
+			pick func() []string
+		}{
+			{"SRANDMEMBER count", func() []string {
+				return parseArrayReply(t, c.do("SRANDMEMBER", "su", strconv.Itoa(size/10)))
+			}},
+			{"SRANDMEMBER negative count", func() []string {
+				return parseArrayReply(t, c.do("SRANDMEMBER", "su", strconv.Itoa(-size/10)))
+			}},
+			{"SPOP count", func() []string {
+				c.do(all...)
+				return parseArrayReply(t, c.do("SPOP", "su", strconv.Itoa(size/10)))
+			}},
+			{"SPOP", func() []string {
+				c.do(all...)
+				var picked []string
+				for range size / 10 {
+					reply := c.do("SPOP", "su")
+					picked = append(picked, parseArrayReply(t, "*1\r\n"+reply)...)
+				}
+				return picked
+			}},
+		}
+		for _, p := range pickers {
+			c.do("DEL", "su")
+			c.do(all...)
+			counts := map[string]int{}
+			for range rounds {
+				for _, member := range p.pick() {
+					counts[member]++
+				}
+			}
+			// each member is expected rounds/10 times; allow a wide margin
+			for _, member := range all[2:] {
+				if n := counts[member]; n < rounds/10/3 || n > rounds/10*3 {
+					t.Errorf("%s, size %d: %s picked %d times in %d rounds, want about %d", p.name, size, member, n, rounds, rounds/10)
+				}
+			}
+		}
+	}
+}
+
+func TestSMove(t *testing.T) {
+	c := newTestClient(t)
+	const wrongType = "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+
+	// src and dst are the sets' members afterwards, sorted as SMEMBERS
+	// replies with them; nil skips the check
+	tests := []struct {
+		name     string
+		setup    [][]string
+		args     []string
+		want     string
+		src, dst []string
+	}{
+		{
+			name:  "moves the member",
+			setup: [][]string{{"SADD", "smv-src", "a", "b"}, {"SADD", "smv-dst", "c"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "a"},
+			want:  ":1\r\n", src: []string{"b"}, dst: []string{"a", "c"},
+		},
+		{
+			name:  "member already in the destination",
+			setup: [][]string{{"SADD", "smv-src", "a", "b"}, {"SADD", "smv-dst", "a"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "a"},
+			want:  ":1\r\n", src: []string{"b"}, dst: []string{"a"},
+		},
+		{
+			name:  "missing source",
+			setup: [][]string{{"SADD", "smv-dst", "c"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "a"},
+			want:  ":0\r\n", src: []string{}, dst: []string{"c"},
+		},
+		{
+			name:  "missing member",
+			setup: [][]string{{"SADD", "smv-src", "a"}, {"SADD", "smv-dst", "c"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "x"},
+			want:  ":0\r\n", src: []string{"a"}, dst: []string{"c"},
+		},
+		{
+			name:  "source and destination are the same",
+			setup: [][]string{{"SADD", "smv-src", "a", "b"}},
+			args:  []string{"SMOVE", "smv-src", "smv-src", "a"},
+			want:  ":1\r\n", src: []string{"a", "b"},
+		},
+		{
+			name:  "emptied source is deleted",
+			setup: [][]string{{"SADD", "smv-src", "a"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "a"},
+			want:  ":1\r\n", src: []string{}, dst: []string{"a"},
+		},
+		{
+			name:  "destination of the wrong type",
+			setup: [][]string{{"SADD", "smv-src", "a"}, {"SET", "smv-dst", "v"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "a"},
+			want:  wrongType, src: []string{"a"},
+		},
+		{
+			name:  "source of the wrong type",
+			setup: [][]string{{"SET", "smv-src", "v"}, {"SADD", "smv-dst", "c"}},
+			args:  []string{"SMOVE", "smv-src", "smv-dst", "a"},
+			want:  wrongType, dst: []string{"c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "smv-src", "smv-dst")
+			for _, cmd := range tt.setup {
+				c.do(cmd...)
+			}
+			if got := c.do(tt.args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+			for _, check := range []struct {
+				key     string
+				members []string
+			}{{"smv-src", tt.src}, {"smv-dst", tt.dst}} {
+				if check.members == nil {
+					continue
+				}
+				if got, want := c.do("SMEMBERS", check.key), encodeValue(check.members); got != want {
+					t.Errorf("SMEMBERS %s = %q, want %q", check.key, got, want)
+				}
+				if len(check.members) == 0 {
+					if got := c.do("EXISTS", check.key); got != ":0\r\n" {
+						t.Errorf("EXISTS %s = %q, want :0", check.key, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSMIsMember(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "smis", "smis-missing", "smis-str")
+	c.do("SADD", "smis", "a", "b")
+	c.do("SET", "smis-str", "v")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SMISMEMBER", "smis", "a", "x", "b"}, "*3\r\n:1\r\n:0\r\n:1\r\n"},
+		{[]string{"SMISMEMBER", "smis-missing", "a", "b"}, "*2\r\n:0\r\n:0\r\n"},
+		{[]string{"SMISMEMBER", "smis-str", "a"}, "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
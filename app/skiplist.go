@@ -0,0 +1,190 @@
+package main
+
+import "math/rand/v2"
+
+const (
+	// skiplistMaxLevel caps the height of a node, enough for 2^64 elements
+	// at skiplistP
+	skiplistMaxLevel = 32
+
+	// skiplistP is the chance of a node reaching each next level
+	skiplistP = 0.25
+)
+
+// skiplistLevel is a node's link at one level, with the number of level 0
+// steps it skips, which is what makes rank lookups logarithmic
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+type skiplistNode struct {
+	item     zsetItem
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+// skiplist keeps the members of a large sorted set in order, as Redis's
+// zskiplist does: inserts, deletes, rank lookups and seeking to a score or
+// member are O(log n), and walking on from there is O(1) per member in
+// either direction.
+type skiplist struct {
+	header, tail *skiplistNode
+	length       int
+	level        int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// randomLevel picks the height of a new node, each level being skiplistP
+// times as likely as the one below
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// insert adds an item, which must not be in the list yet
+func (sl *skiplist) insert(item zsetItem) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	// find where the item goes at each level, and the rank of the node it
+	// goes after
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && compareZSetItems(x.level[i].forward.item, item) < 0 {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skiplistNode{item: item, level: make([]skiplistLevel, level)}
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// the levels above the new node skip one more element now
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// delete removes an item, reporting whether it was in the list
+func (sl *skiplist) delete(item zsetItem) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && compareZSetItems(x.level[i].forward.item, item) < 0 {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.item != item {
+		return false
+	}
+
+	for i := range sl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// rank returns the 1-based rank of an item, or 0 if it isn't in the list
+func (sl *skiplist) rank(item zsetItem) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && compareZSetItems(x.level[i].forward.item, item) <= 0 {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.item == item {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at a 1-based rank, or nil if it is out of range
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank && x != sl.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// seek returns the first node for which start holds, walking forward, or
+// the last one if reverse is set. start must hold for a suffix of the list,
+// or for a prefix if reverse is set.
+func (sl *skiplist) seek(reverse bool, start func(zsetItem) bool) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && start(x.level[i].forward.item) == reverse {
+			x = x.level[i].forward
+		}
+	}
+	if reverse {
+		if x == sl.header {
+			return nil
+		}
+		return x
+	}
+	return x.level[0].forward
+}
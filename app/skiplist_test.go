@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// checkSkiplist fails the test unless sl holds want, in order, with every
+// link's span matching the number of level 0 steps it skips
+func checkSkiplist(t *testing.T, sl *skiplist, want []zsetItem) {
+	t.Helper()
+	if sl.length != len(want) {
+		t.Fatalf("length = %d, want %d", sl.length, len(want))
+	}
+
+	// ranks of every node, by walking level 0
+	ranks := map[*skiplistNode]int{sl.header: 0}
+	var prev *skiplistNode
+	i := 0
+	for x := sl.header.level[0].forward; x != nil; prev, x = x, x.level[0].forward {
+		if i >= len(want) || x.item != want[i] {
+			t.Fatalf("level 0 element %d is %v, want %v", i, x.item, want[i:min(i+1, len(want))])
+		}
+		if x.backward != prev {
+			t.Fatalf("%v links back to the wrong node", x.item)
+		}
+		if len(x.level) > sl.level {
+			t.Fatalf("%v is %d levels high in a list of %d", x.item, len(x.level), sl.level)
+		}
+		i++
+		ranks[x] = i
+	}
+	if i != len(want) || sl.tail != prev {
+		t.Fatalf("level 0 holds %d elements ending in %p, want %d ending in the tail %p", i, prev, len(want), sl.tail)
+	}
+
+	for level := range sl.level {
+		x := sl.header
+		for x.level[level].forward != nil {
+			next := x.level[level].forward
+			if span := ranks[next] - ranks[x]; x.level[level].span != span {
+				t.Fatalf("level %d link from rank %d to %d has span %d, want %d", level, ranks[x], ranks[next], x.level[level].span, span)
+			}
+			x = next
+		}
+	}
+	if sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		t.Fatalf("top level %d is empty", sl.level)
+	}
+
+	for rank, item := range want {
+		if got := sl.rank(item); got != rank+1 {
+			t.Fatalf("rank(%v) = %d, want %d", item, got, rank+1)
+		}
+		if x := sl.byRank(rank + 1); x == nil || x.item != item {
+			t.Fatalf("byRank(%d) = %v, want %v", rank+1, x, item)
+		}
+	}
+	if x := sl.byRank(len(want) + 1); x != nil {
+		t.Fatalf("byRank past the end = %v", x.item)
+	}
+}
+
+// TestSkiplistRandomOps inserts and deletes random items, checking the order,
+// spans and ranks against a sorted slice after each operation
+func TestSkiplistRandomOps(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	sl := newSkiplist()
+	var want []zsetItem
+	for op := range 3000 {
+		// small score and member ranges, so that items share scores and
+		// deletes often hit
+		item := zsetItem{member: "m" + strconv.Itoa(r.IntN(300)), score: float64(r.IntN(20))}
+		i, found := slices.BinarySearchFunc(want, item, compareZSetItems)
+
+		if r.IntN(3) == 0 {
+			if got := sl.delete(item); got != found {
+				t.Fatalf("op %d: delete(%v) = %v, want %v", op, item, got, found)
+			}
+			if found {
+				want = slices.Delete(want, i, i+1)
+			}
+		} else if !found && !slices.ContainsFunc(want, func(it zsetItem) bool { return it.member == item.member }) {
+			sl.insert(item)
+			want = slices.Insert(want, i, item)
+		}
+		checkSkiplist(t, sl, want)
+	}
+
+	// deleting everything brings the list back to a single level
+	for _, item := range slices.Clone(want) {
+		if !sl.delete(item) {
+			t.Fatalf("delete(%v) missed", item)
+		}
+		want = want[1:]
+		checkSkiplist(t, sl, want)
+	}
+	if sl.level != 1 || sl.tail != nil {
+		t.Errorf("emptied list has level %d and tail %v", sl.level, sl.tail)
+	}
+}
+
+func TestSkiplistRankMisses(t *testing.T) {
+	sl := newSkiplist()
+	for i := range 10 {
+		sl.insert(zsetItem{member: "m" + strconv.Itoa(i), score: float64(i)})
+	}
+	for _, item := range []zsetItem{
+		{member: "m3", score: 4}, // member in the list under another score
+		{member: "x", score: 3},
+		{member: "m0", score: -1},
+		{member: "m9", score: 100},
+	} {
+		if got := sl.rank(item); got != 0 {
+			t.Errorf("rank(%v) = %d, want 0", item, got)
+		}
+		if sl.delete(item) {
+			t.Errorf("delete(%v) removed something", item)
+		}
+	}
+	if x := sl.byRank(0); x != nil {
+		t.Errorf("byRank(0) = %v, want nil", x.item)
+	}
+}
+
+func TestSkiplistSeek(t *testing.T) {
+	sl := newSkiplist()
+	for i := range 100 {
+		sl.insert(zsetItem{member: "m" + strconv.Itoa(i), score: float64(i / 2)})
+	}
+	tests := []struct {
+		name    string
+		reverse bool
+		start   func(zsetItem) bool
+		want    string // member, or "" for no node
+	}{
+		{"first at a score", false, func(it zsetItem) bool { return it.score >= 10 }, "m20"},
+		{"last at a score", true, func(it zsetItem) bool { return it.score <= 10 }, "m21"},
+		{"first of all", false, func(zsetItem) bool { return true }, "m0"},
+		{"last of all", true, func(zsetItem) bool { return true }, "m99"},
+		{"past the end", false, func(it zsetItem) bool { return it.score > 100 }, ""},
+		{"before the start", true, func(it zsetItem) bool { return it.score < 0 }, ""},
+	}
+	for _, tt := range tests {
+		x := sl.seek(tt.reverse, tt.start)
+		got := ""
+		if x != nil {
+			got = x.item.member
+		}
+		if got != tt.want {
+			t.Errorf("%s: seek = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// sortItem is one element being sorted, with the weight it is sorted by
+type sortItem struct {
+	element string
+	score   float64
+	cmpWith *string // ALPHA weight; nil when the BY key is missing
+}
+
+// sortElements returns a copy of the elements SORT works on for a value.
+// Set members come back sorted and sorted set members in score order, so
+// that BY nosort is deterministic for them too.
+func sortElements(value any) ([]string, bool) {
+	switch v := value.(type) {
+	case ListEntry:
+		return v.list.elements(), true
+	case SetEntry:
+		return setMembers(v), true
+	case ZSetEntry:
+		items := v.zset.items()
+		members := make([]string, len(items))
+		for i, item := range items {
+			members[i] = item.member
+		}
+		return members, true
+	}
+	return nil, false
+}
+
+// lookupByPattern resolves a BY or GET pattern for one element: the first
+// '*' is replaced by the element and the resulting key looked up. "#"
+// stands for the element itself. A pattern ending in "->field" looks up a
+// hash field instead. It returns nil when the key is missing or holds the
+// wrong type.
+func lookupByPattern(pattern, element string) *string {
+	if pattern == "#" {
+		return &element
+	}
+
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star:], "->"); arrow >= 0 && star+arrow+2 < len(pattern) {
+		keyPattern = pattern[:star+arrow]
+		field = pattern[star+arrow+2:]
+	}
+	key := keyPattern[:star] + element + keyPattern[star+1:]
+
+	value, exists := peekKey(key)
+	if !exists {
+		return nil
+	}
+	if field != "" {
+		hash, ok := value.(HashEntry)
+		if !ok {
+			return nil
+		}
+		fieldValue, ok := hash.fields[field]
+		if !ok || hashFieldExpired(hash, field) {
+			return nil
+		}
+		return &fieldValue
+	}
+	entry, ok := value.(Entry)
+	if !ok {
+		return nil
+	}
+	return &entry.value
+}
+
+// handleSort implements SORT key [BY pattern] [LIMIT offset count]
+// [GET pattern ...] [ASC | DESC] [ALPHA] [STORE destination]
+func handleSort(args []string, conn net.Conn) {
+	sortGeneric(args, conn, false)
+}
+
+// handleSortRO implements SORT_RO, the read-only variant of SORT without
+// STORE
+func handleSortRO(args []string, conn net.Conn) {
+	sortGeneric(args, conn, true)
+}
+
+func sortGeneric(args []string, conn net.Conn, readOnly bool) {
+	key := args[1]
+
+	var desc, alpha, dontSort bool
+	var sortBy, storeKey string
+	var getPatterns []string
+	limitStart, limitCount := int64(0), int64(-1)
+	for i := 2; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "ASC":
+			desc = false
+		case opt == "DESC":
+			desc = true
+		case opt == "ALPHA":
+			alpha = true
+		case opt == "LIMIT" && remaining >= 2:
+			start, ok1 := parseStrictInt(args[i+1])
+			count, ok2 := parseStrictInt(args[i+2])
+			if !ok1 || !ok2 {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			limitStart, limitCount = start, count
+			i += 2
+		case opt == "STORE" && remaining >= 1 && !readOnly:
+			storeKey = args[i+1]
+			i++
+		case opt == "BY" && remaining >= 1:
+			sortBy = args[i+1]
+			// a pattern without '*' would give every element the same
+			// weight, so don't sort at all
+			if !strings.Contains(sortBy, "*") {
+				dontSort = true
+			}
+			i++
+		case opt == "GET" && remaining >= 1:
+			getPatterns = append(getPatterns, args[i+1])
+			i++
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	// BY and GET patterns can name any key, so they need the whole keyspace
+	// held still for the sort to be atomic
+	needsLookups := sortBy != "" && !dontSort
+	for _, pattern := range getPatterns {
+		needsLookups = needsLookups || pattern != "#"
+	}
+
+	var writeKeys []string
+	if storeKey != "" {
+		writeKeys = append(writeKeys, storeKey)
+	}
+	var unlock func()
+	switch {
+	case needsLookups:
+		unlock = DB.LockAll(writeKeys...)
+	case storeKey != "":
+		unlock = DB.Lock(key, storeKey)
+	default:
+		unlock = DB.RLock(key)
+	}
+	defer unlock()
+
+	var elements []string
+	if value, exists := peekKey(key); exists {
+		var ok bool
+		if elements, ok = sortElements(value); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	}
+
+	items := make([]sortItem, len(elements))
+	for i, e := range elements {
+		items[i].element = e
+	}
+
+	if !dontSort {
+		for i := range items {
+			// copy the element: a pointer into items would follow the
+			// swaps the sort makes
+			element := items[i].element
+			weight := &element
+			if sortBy != "" {
+				weight = lookupByPattern(sortBy, items[i].element)
+			}
+
+			if alpha {
+				items[i].cmpWith = weight
+				continue
+			}
+			// a missing BY key weighs 0
+			if weight != nil {
+				score, ok := parseStrictFloat(*weight)
+				if !ok {
+					writeError(conn, "One or more scores can't be converted into double")
+					return
+				}
+				items[i].score = score
+			}
+		}
+
+		sort.SliceStable(items, func(a, b int) bool {
+			cmp := compareSortItems(&items[a], &items[b], alpha)
+			if desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	// apply LIMIT; a negative count means up to the end
+	n := int64(len(items))
+	start := min(max(limitStart, 0), n)
+	end := n
+	if limitCount >= 0 && limitCount < n-start {
+		end = start + limitCount
+	}
+	items = items[start:end]
+
+	var output []*string
+	for i := range items {
+		if len(getPatterns) == 0 {
+			output = append(output, &items[i].element)
+			continue
+		}
+		for _, pattern := range getPatterns {
+			output = append(output, lookupByPattern(pattern, items[i].element))
+		}
+	}
+
+	if storeKey == "" {
+		writeNullableArray(conn, output)
+		return
+	}
+
+	// STORE writes a fresh list, missing GET lookups becoming empty strings;
+	// an empty result deletes the destination
+	if len(output) == 0 {
+		replaceValue(storeKey, nil)
+		writeInteger(conn, 0)
+		return
+	}
+	stored := make([]string, len(output))
+	for i, s := range output {
+		if s != nil {
+			stored[i] = *s
+		}
+	}
+	replaceValue(storeKey, ListEntry{list: newQuicklist(stored...)})
+	writeInteger(conn, len(stored))
+}
+
+// compareSortItems orders two items by weight, breaking ties by comparing
+// the elements themselves so the result is deterministic
+func compareSortItems(a, b *sortItem, alpha bool) int {
+	cmp := 0
+	if alpha {
+		switch {
+		case a.cmpWith == nil && b.cmpWith == nil:
+		case a.cmpWith == nil:
+			cmp = -1
+		case b.cmpWith == nil:
+			cmp = 1
+		default:
+			cmp = strings.Compare(*a.cmpWith, *b.cmpWith)
+		}
+	} else if a.score < b.score {
+		cmp = -1
+	} else if a.score > b.score {
+		cmp = 1
+	}
+
+	if cmp == 0 {
+		cmp = strings.Compare(a.element, b.element)
+	}
+	return cmp
+}
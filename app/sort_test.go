@@ -0,0 +1,130 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "sl", "sa", "ss", "sw_1", "sw_2", "sw_3", "so_1", "so_3", "sh_1", "sh_2")
+	c.do("RPUSH", "sl", "3", "1", "2")
+	c.do("RPUSH", "sa", "c", "a", "b")
+	c.do("SADD", "ss", "1", "2", "3")
+	c.do("MSET", "sw_1", "30", "sw_2", "10", "sw_3", "20")
+	c.do("MSET", "so_1", "one", "so_3", "three")
+	c.do("HSET", "sh_1", "name", "first")
+	c.do("HSET", "sh_2", "name", "second")
+
+	tests := []struct {
+		name string
+		args []string
+		want any
+	}{
+		{"numeric", []string{"sl"}, []string{"1", "2", "3"}},
+		{"desc", []string{"sl", "DESC"}, []string{"3", "2", "1"}},
+		{"alpha", []string{"sa", "ALPHA"}, []string{"a", "b", "c"}},
+		{"alpha desc", []string{"sa", "ALPHA", "DESC"}, []string{"c", "b", "a"}},
+		{"set alpha desc", []string{"ss", "ALPHA", "DESC"}, []string{"3", "2", "1"}},
+		{"limit", []string{"sl", "LIMIT", "1", "2"}, []string{"2", "3"}},
+		{"limit past the end", []string{"sl", "LIMIT", "2", "10"}, []string{"3"}},
+		{"limit to the end", []string{"sl", "LIMIT", "1", "-1"}, []string{"2", "3"}},
+		{"by pattern", []string{"sl", "BY", "sw_*"}, []string{"2", "3", "1"}},
+		{"by pattern desc", []string{"sl", "BY", "sw_*", "DESC"}, []string{"1", "3", "2"}},
+		{"by without star", []string{"sl", "BY", "nosort"}, []string{"3", "1", "2"}},
+		{"get", []string{"sl", "GET", "so_*"}, []any{"one", nil, "three"}},
+		{"get hash field", []string{"sl", "GET", "sh_*->name"}, []any{"first", "second", nil}},
+		{"get element and key", []string{"sl", "LIMIT", "0", "2", "GET", "#", "GET", "so_*"}, []any{"1", "one", "2", nil}},
+		{"missing key", []string{"sort-missing"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := slices.Concat([]string{"SORT"}, tt.args)
+			if got, want := c.do(args...), encodeValue(tt.want); got != want {
+				t.Errorf("%v = %q, want %q", args, got, want)
+			}
+		})
+	}
+
+	t.Run("store", func(t *testing.T) {
+		c.do("DEL", "sort-dst")
+		if got := c.do("SORT", "sa", "ALPHA", "STORE", "sort-dst"); got != ":3\r\n" {
+			t.Fatalf("SORT STORE = %q, want :3", got)
+		}
+		if got, want := c.do("LRANGE", "sort-dst", "0", "-1"), encodeValue([]string{"a", "b", "c"}); got != want {
+			t.Errorf("LRANGE of the stored list = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"SORT", "sa"},
+			{"SORT", "sl", "LIMIT", "x", "1"},
+			{"SORT", "sl", "LIMIT", "0"},
+			{"SORT", "sl", "BY"},
+			{"SORT", "sw_1"},
+			{"SORT_RO", "sl", "STORE", "sort-dst"},
+		} {
+			if got := c.do(args...); got[0] != '-' {
+				t.Errorf("%v = %q, want an error", args, got)
+			}
+		}
+	})
+}
+
+func TestSortStore(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "ss-set", "ss-dst", "ss-empty", "ss-o_1", "ss-o_2")
+	c.do("SADD", "ss-set", "3", "1", "2")
+	c.do("MSET", "ss-o_1", "one", "ss-o_2", "two")
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"set into a list", []string{"ss-set", "STORE", "ss-dst"}, []string{"1", "2", "3"}},
+		{"desc limit", []string{"ss-set", "DESC", "LIMIT", "0", "2", "STORE", "ss-dst"}, []string{"3", "2"}},
+		// GET values are what get stored, a missing one as an empty string
+		{"get", []string{"ss-set", "GET", "ss-o_*", "STORE", "ss-dst"}, []string{"one", "two", ""}},
+		{"get element and key", []string{"ss-set", "GET", "#", "GET", "ss-o_*", "LIMIT", "0", "1", "STORE", "ss-dst"}, []string{"1", "one"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the destination holds a hash with a TTL, which the list replaces
+			c.do("DEL", "ss-dst")
+			c.do("HSET", "ss-dst", "f", "v")
+			c.do("EXPIRE", "ss-dst", "100")
+
+			args := slices.Concat([]string{"SORT"}, tt.args)
+			if got, want := c.do(args...), ":"+strconv.Itoa(len(tt.want))+"\r\n"; got != want {
+				t.Errorf("%v = %q, want %q", args, got, want)
+			}
+			if got := c.do("TYPE", "ss-dst"); got != "+list\r\n" {
+				t.Errorf("TYPE of the destination = %q, want list", got)
+			}
+			if got, want := c.do("LRANGE", "ss-dst", "0", "-1"), encodeValue(tt.want); got != want {
+				t.Errorf("LRANGE of the destination = %q, want %q", got, want)
+			}
+			if got := c.do("TTL", "ss-dst"); got != ":-1\r\n" {
+				t.Errorf("TTL of the destination = %q, want :-1", got)
+			}
+		})
+	}
+
+	t.Run("empty result deletes the destination", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"SORT", "ss-empty", "STORE", "ss-dst"},
+			{"SORT", "ss-set", "LIMIT", "5", "1", "STORE", "ss-dst"},
+		} {
+			c.do("SET", "ss-dst", "old")
+			if got := c.do(args...); got != ":0\r\n" {
+				t.Errorf("%v = %q, want :0", args, got)
+			}
+			if got := c.do("EXISTS", "ss-dst"); got != ":0\r\n" {
+				t.Errorf("EXISTS after %v = %q, want :0", args, got)
+			}
+		}
+	})
+}
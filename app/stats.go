@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// totalCommandsProcessed counts every command executed since startup
+var totalCommandsProcessed atomic.Int64
+
+var (
+	commandStatsMu sync.Mutex
+	commandStats   = make(map[string]*commandStat)
+)
+
+// statFor returns the statistics of a command, creating them on first use
+func statFor(name string) *commandStat {
+	commandStatsMu.Lock()
+	defer commandStatsMu.Unlock()
+
+	stat, ok := commandStats[name]
+	if !ok {
+		stat = &commandStat{}
+		commandStats[name] = stat
+	}
+	return stat
+}
+
+// resetCommandStats clears the statistics of every command
+func resetCommandStats() {
+	commandStatsMu.Lock()
+	defer commandStatsMu.Unlock()
+	commandStats = make(map[string]*commandStat)
+	totalCommandsProcessed.Store(0)
+}
+
+// call runs a command on behalf of a client and records how long it took
+// and whether it replied with an error
+func call(cmd *Command, args []string, client *Client) {
+	errorsBefore := client.errorReplies.Load()
+	client.blockedTime = 0
+	start := time.Now()
+	cmd.handler(args, client)
+
+	// time spent blocked waiting for data isn't work done by the command
+	duration := time.Since(start) - client.blockedTime
+
+	stat := statFor(cmd.name)
+	stat.calls.Add(1)
+	stat.usec.Add(duration.Microseconds())
+	if client.errorReplies.Load() > errorsBefore {
+		stat.failedCalls.Add(1)
+	}
+	totalCommandsProcessed.Add(1)
+
+	latencyAddSampleIfNeeded("command", duration)
+}
+
+// commandStatsInfo renders the commandstats section of INFO
+func commandStatsInfo() string {
+	commandStatsMu.Lock()
+	names := make([]string, 0, len(commandStats))
+	stats := make(map[string]*commandStat, len(commandStats))
+	for name, stat := range commandStats {
+		names = append(names, name)
+		stats[name] = stat
+	}
+	commandStatsMu.Unlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		stat := stats[name]
+		calls := stat.calls.Load()
+		usec := stat.usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		fmt.Fprintf(&b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			strings.ToLower(name), calls, usec, perCall, stat.rejectedCalls.Load(), stat.failedCalls.Load())
+	}
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// cmdstatGet matches the INFO commandstats line of GET, capturing its
+// calls, rejected_calls and failed_calls
+var cmdstatGet = regexp.MustCompile(`cmdstat_get:calls=(\d+),usec=\d+,usec_per_call=[\d.]+,rejected_calls=(\d+),failed_calls=(\d+)\r\n`)
+
+func TestCommandStats(t *testing.T) {
+	const gets = 25
+	c := newTestClient(t)
+	c.do("DEL", "stat-list")
+	c.do("RPUSH", "stat-list", "a")
+	c.do("CONFIG", "RESETSTAT")
+
+	for i := range gets {
+		c.do("GET", "stat-"+strconv.Itoa(i))
+	}
+	c.do("GET")                   // rejected: wrong number of arguments
+	c.do("GET", "stat-list", "x") // rejected
+	c.do("GET", "stat-list")      // failed: WRONGTYPE
+
+	info := c.do("INFO", "commandstats")
+	m := cmdstatGet.FindStringSubmatch(info)
+	if m == nil {
+		t.Fatalf("INFO commandstats has no cmdstat_get line:\n%s", info)
+	}
+
+	// the WRONGTYPE GET ran, so it counts as a call as well as a failure;
+	// rejected calls never reach the handler
+	want := []string{strconv.Itoa(gets + 1), "2", "1"}
+	for i, field := range []string{"calls", "rejected_calls", "failed_calls"} {
+		if m[i+1] != want[i] {
+			t.Errorf("cmdstat_get %s = %s, want %s", field, m[i+1], want[i])
+		}
+	}
+}
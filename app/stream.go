@@ -0,0 +1,487 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errInvalidStreamID is the error for a stream ID argument that doesn't
+// parse
+var errInvalidStreamID = errors.New("Invalid stream ID specified as stream command argument")
+
+// streamID is the ID of a stream entry: a millisecond timestamp and a
+// sequence number ordering the entries added within the same millisecond
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// compareStreamIDs orders stream IDs by timestamp, then sequence number
+func compareStreamIDs(a, b streamID) int {
+	if c := cmp.Compare(a.ms, b.ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.seq, b.seq)
+}
+
+// next returns the smallest ID greater than id, or false if id is the
+// greatest possible one
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the greatest ID smaller than id, or false if id is 0-0
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses an ID given as ms-seq, or as just ms, in which case
+// the sequence number is missingSeq
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseStreamRange parses the start and end of a range of IDs. "-" and "+"
+// are the smallest and greatest IDs, an ID with no sequence number covers
+// the whole millisecond, and a leading "(" excludes the ID from the range.
+func parseStreamRange(start, end string) (streamID, streamID, error) {
+	from, err := parseStreamRangeBound(start, false)
+	if err != nil {
+		return from, from, err
+	}
+	to, err := parseStreamRangeBound(end, true)
+	return from, to, err
+}
+
+func parseStreamRangeBound(bound string, end bool) (streamID, error) {
+	switch bound {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return streamID{math.MaxUint64, math.MaxUint64}, nil
+	}
+
+	exclusive := strings.HasPrefix(bound, "(")
+	if exclusive {
+		bound = bound[1:]
+	}
+	var missingSeq uint64
+	if end {
+		missingSeq = math.MaxUint64
+	}
+	id, err := parseStreamID(bound, missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+
+	var ok bool
+	if end {
+		if id, ok = id.prev(); !ok {
+			return id, errors.New("invalid end ID for the interval")
+		}
+	} else if id, ok = id.next(); !ok {
+		return id, errors.New("invalid start ID for the interval")
+	}
+	return id, nil
+}
+
+// entriesAfter returns the entries of a stream with IDs greater than id, at
+// most count of them if count is positive
+func (s StreamEntry) entriesAfter(id streamID, count int) []StreamEntryData {
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return compareStreamIDs(s.entries[i].id, id) > 0
+	})
+	entries := s.entries[i:]
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+// entry returns the entry with the given ID, if it is still in the stream
+func (s StreamEntry) entry(id streamID) (StreamEntryData, bool) {
+	i, found := slices.BinarySearchFunc(s.entries, id, func(e StreamEntryData, id streamID) int {
+		return compareStreamIDs(e.id, id)
+	})
+	if !found {
+		return StreamEntryData{}, false
+	}
+	return s.entries[i], true
+}
+
+// streamEntriesReply is the reply listing stream entries: each one's ID,
+// then its field/value pairs
+func streamEntriesReply(entries []StreamEntryData) []any {
+	reply := make([]any, len(entries))
+	for i, e := range entries {
+		reply[i] = []any{e.id.String(), e.fields}
+	}
+	return reply
+}
+
+// streamEntryIDs returns the IDs of stream entries, as JUSTID replies list
+// them
+func streamEntryIDs(entries []StreamEntryData) []string {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.id.String()
+	}
+	return ids
+}
+
+// stream trimming strategies
+const (
+	streamTrimNone = iota
+	streamTrimMaxLen
+	streamTrimMinID
+)
+
+// streamTrim is how XTRIM, or XADD with MAXLEN or MINID, trims a stream:
+// down to maxLen entries, or dropping the entries below minID. An
+// approximate trim only removes whole nodes, and at most limit entries
+// unless limit is 0.
+type streamTrim struct {
+	strategy int
+	approx   bool
+	maxLen   int
+	minID    streamID
+	limit    int
+	hasLimit bool
+}
+
+// parseArg parses the trimming option at args[i], if there is one there,
+// and returns the index of the argument after it
+func (t *streamTrim) parseArg(args []string, i int) (int, bool, error) {
+	syntaxErr := errors.New("syntax error")
+	switch option := strings.ToUpper(args[i]); option {
+	case "MAXLEN", "MINID":
+		strategy := streamTrimMaxLen
+		if option == "MINID" {
+			strategy = streamTrimMinID
+		}
+		if t.strategy != streamTrimNone && t.strategy != strategy {
+			return i, true, errors.New("syntax error, MAXLEN and MINID options at the same time are not compatible")
+		}
+		t.strategy = strategy
+
+		i++
+		if i < len(args) && (args[i] == "~" || args[i] == "=") {
+			t.approx = args[i] == "~"
+			i++
+		}
+		if i >= len(args) {
+			return i, true, syntaxErr
+		}
+		if strategy == streamTrimMinID {
+			id, err := parseStreamID(args[i], 0)
+			if err != nil {
+				return i, true, err
+			}
+			t.minID = id
+		} else {
+			n, ok := parseIntArg(args[i])
+			if !ok {
+				return i, true, errors.New("value is not an integer or out of range")
+			}
+			if n < 0 {
+				return i, true, errors.New("The MAXLEN argument must be >= 0.")
+			}
+			t.maxLen = n
+		}
+		return i + 1, true, nil
+	case "LIMIT":
+		if i+1 >= len(args) {
+			return i, true, syntaxErr
+		}
+		n, ok := parseIntArg(args[i+1])
+		if !ok {
+			return i, true, errors.New("value is not an integer or out of range")
+		}
+		if n < 0 {
+			return i, true, errors.New("The LIMIT argument must be >= 0.")
+		}
+		t.limit, t.hasLimit = n, true
+		return i + 2, true, nil
+	}
+	return i, false, nil
+}
+
+// validate checks the combination of trimming options once they have all
+// been parsed, and fills in the default limit of an approximate trim
+func (t *streamTrim) validate() error {
+	if t.hasLimit && !t.approx {
+		return errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if t.approx && !t.hasLimit {
+		t.limit = 100 * streamNodeSize()
+	}
+	return nil
+}
+
+// streamNodeSize returns the number of entries approximate trimming treats
+// as a node
+func streamNodeSize() int {
+	return max(int(streamNodeMaxEntries.value.Load()), 1)
+}
+
+// trim removes the oldest entries of a stream as t asks, returning the
+// number removed
+func (s *StreamEntry) trim(t streamTrim) int {
+	var excess int
+	switch t.strategy {
+	case streamTrimMaxLen:
+		excess = max(len(s.entries)-t.maxLen, 0)
+	case streamTrimMinID:
+		excess = sort.Search(len(s.entries), func(i int) bool {
+			return compareStreamIDs(s.entries[i].id, t.minID) >= 0
+		})
+	default:
+		return 0
+	}
+
+	if t.approx {
+		if t.limit > 0 {
+			excess = min(excess, t.limit)
+		}
+		excess -= excess % streamNodeSize()
+	}
+
+	// drop the references to the trimmed entries; appending past the end
+	// reallocates the slice, and the trimmed head with it, often enough
+	clear(s.entries[:excess])
+	s.entries = s.entries[excess:]
+	return excess
+}
+
+// streamGroup is a consumer group of a stream: the ID of the last entry
+// delivered to the group, its consumers, and its pending entries list
+// (PEL) of the entries delivered to a consumer and not acknowledged yet
+type streamGroup struct {
+	lastID      streamID
+	entriesRead int64 // entries delivered to the group, or entriesReadUnknown
+	pending     map[streamID]*streamPending
+	consumers   map[string]*streamConsumer
+}
+
+// entriesReadUnknown is the entries read count of a group that can't tell
+// how far into the stream its last ID is
+const entriesReadUnknown = -1
+
+// streamPending is an entry of a group's PEL. The same record is in the PEL
+// of the consumer it was delivered to.
+type streamPending struct {
+	consumer      string
+	deliveryTime  time.Time
+	deliveryCount int
+}
+
+// streamConsumer is a consumer of a group, with its own PEL
+type streamConsumer struct {
+	seenTime   time.Time // last time it tried to read
+	activeTime time.Time // last time it was delivered entries; zero if never
+	pending    map[streamID]*streamPending
+}
+
+func newStreamGroup(lastID streamID, entriesRead int64) *streamGroup {
+	return &streamGroup{
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pending:     make(map[streamID]*streamPending),
+		consumers:   make(map[string]*streamConsumer),
+	}
+}
+
+// consumer returns the named consumer, creating it if needed
+func (g *streamGroup) consumer(name string) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &streamConsumer{pending: make(map[streamID]*streamPending)}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// deliver records that an entry was delivered to a consumer
+func (g *streamGroup) deliver(id streamID, consumer string, now time.Time) {
+	g.assign(id, &streamPending{deliveryTime: now, deliveryCount: 1}, consumer)
+}
+
+// assign makes p the pending record of an entry, owned by a consumer,
+// taking the entry over from whichever consumer it was pending for before
+func (g *streamGroup) assign(id streamID, p *streamPending, consumer string) {
+	if old, ok := g.pending[id]; ok {
+		delete(g.consumers[old.consumer].pending, id)
+	}
+	p.consumer = consumer
+	g.pending[id] = p
+	g.consumer(consumer).pending[id] = p
+}
+
+// ack removes an entry from the PELs, reporting whether it was pending
+func (g *streamGroup) ack(id streamID) bool {
+	p, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	delete(g.pending, id)
+	delete(g.consumers[p.consumer].pending, id)
+	return true
+}
+
+// clone returns a copy of the group that shares nothing with it
+func (g *streamGroup) clone() *streamGroup {
+	c := newStreamGroup(g.lastID, g.entriesRead)
+	for name, consumer := range g.consumers {
+		c.consumers[name] = &streamConsumer{
+			seenTime:   consumer.seenTime,
+			activeTime: consumer.activeTime,
+			pending:    make(map[streamID]*streamPending, len(consumer.pending)),
+		}
+	}
+	for id, p := range g.pending {
+		copied := *p
+		c.pending[id] = &copied
+		c.consumers[p.consumer].pending[id] = &copied
+	}
+	return c
+}
+
+// pendingBetween returns the IDs in a PEL from start to end inclusive, in
+// order
+func pendingBetween(pending map[streamID]*streamPending, start, end streamID) []streamID {
+	var ids []streamID
+	for id := range pending {
+		if compareStreamIDs(id, start) >= 0 && compareStreamIDs(id, end) <= 0 {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, compareStreamIDs)
+	return ids
+}
+
+// pendingAfter returns the IDs in a PEL greater than id, in order, at most
+// count of them if count is positive
+func pendingAfter(pending map[streamID]*streamPending, id streamID, count int) []streamID {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	ids := pendingBetween(pending, start, streamID{math.MaxUint64, math.MaxUint64})
+	if count > 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+	return ids
+}
+
+// firstID returns the ID of the first entry, or 0-0 if there is none
+func (s StreamEntry) firstID() streamID {
+	if len(s.entries) == 0 {
+		return streamID{}
+	}
+	return s.entries[0].id
+}
+
+// hasTombstonesFrom reports whether an entry may have been deleted from the
+// stream at or after start, short of its first entry, which would throw
+// off counting entries by their IDs
+func (s StreamEntry) hasTombstonesFrom(start streamID) bool {
+	if len(s.entries) == 0 || s.maxDeleted == (streamID{}) {
+		return false
+	}
+	if compareStreamIDs(s.firstID(), s.maxDeleted) > 0 {
+		return false
+	}
+	return compareStreamIDs(start, s.maxDeleted) <= 0
+}
+
+// entriesUpTo estimates how many entries were ever added to the stream up
+// to and including id, as a group that has read up to id has read. It
+// returns entriesReadUnknown when deletions make that impossible to tell.
+func (s StreamEntry) entriesUpTo(id streamID) int64 {
+	if s.added == 0 {
+		return 0
+	}
+	c := compareStreamIDs(id, s.lastID)
+	switch {
+	case len(s.entries) == 0 && c <= 0, c == 0:
+		return s.added
+	case c > 0:
+		return entriesReadUnknown
+	}
+
+	first := s.firstID()
+	if s.maxDeleted == (streamID{}) || compareStreamIDs(s.maxDeleted, first) < 0 {
+		// nothing was deleted from the middle of the stream, so the entries
+		// before the first one were all trimmed
+		switch compareStreamIDs(id, first) {
+		case -1:
+			return s.added - int64(len(s.entries))
+		case 0:
+			return s.added - int64(len(s.entries)) + 1
+		}
+	}
+	return entriesReadUnknown
+}
+
+// advanceGroup moves a group's last ID up to id, an entry just delivered to
+// it, counting the entry as read while the count can be kept exactly
+func (s StreamEntry) advanceGroup(g *streamGroup, id streamID) {
+	if compareStreamIDs(id, g.lastID) <= 0 {
+		return
+	}
+	if g.entriesRead != entriesReadUnknown && !s.hasTombstonesFrom(id) {
+		g.entriesRead++
+	} else if s.added > 0 {
+		g.entriesRead = s.entriesUpTo(id)
+	}
+	g.lastID = id
+}
+
+// lag returns the number of entries in the stream a group has yet to read,
+// or false if that can't be told
+func (s StreamEntry) lag(g *streamGroup) (int64, bool) {
+	if s.added == 0 {
+		return 0, true
+	}
+	if g.entriesRead != entriesReadUnknown && !s.hasTombstonesFrom(g.lastID) {
+		return s.added - g.entriesRead, true
+	}
+	read := s.entriesUpTo(g.lastID)
+	if read == entriesReadUnknown {
+		return 0, false
+	}
+	return s.added - read, true
+}
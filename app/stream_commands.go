@@ -0,0 +1,1304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleXLen replies with the number of entries in a stream
+func handleXLen(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	writeInteger(conn, len(stream.entries))
+}
+
+// xaddIDArg is the ID argument of XADD: fully explicit, "*" for a
+// timestamp and sequence number picked by the server, or "ms-*" for an
+// explicit timestamp with the next free sequence number
+type xaddIDArg struct {
+	id              streamID
+	autoMs, autoSeq bool
+}
+
+func parseXAddID(arg string) (xaddIDArg, error) {
+	if arg == "*" {
+		return xaddIDArg{autoMs: true, autoSeq: true}, nil
+	}
+	if ms, ok := strings.CutSuffix(arg, "-*"); ok {
+		id, err := parseStreamID(ms, 0)
+		if err != nil || strings.Contains(ms, "-") {
+			return xaddIDArg{}, errInvalidStreamID
+		}
+		return xaddIDArg{id: id, autoSeq: true}, nil
+	}
+	id, err := parseStreamID(arg, 0)
+	return xaddIDArg{id: id}, err
+}
+
+// resolve returns the ID a new entry gets in a stream whose last generated
+// ID is last. A server-picked timestamp never goes below last's, so IDs keep
+// increasing even if the clock goes backwards.
+func (arg xaddIDArg) resolve(last streamID) (streamID, error) {
+	tooSmall := errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	switch {
+	case arg.autoMs:
+		if now := uint64(clock.Now().UnixMilli()); now > last.ms {
+			return streamID{now, 0}, nil
+		}
+		id, ok := last.next()
+		if !ok {
+			return id, errors.New("The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	case arg.autoSeq:
+		if arg.id.ms > last.ms {
+			return streamID{arg.id.ms, 0}, nil
+		}
+		if arg.id.ms < last.ms || last.seq == math.MaxUint64 {
+			return arg.id, tooSmall
+		}
+		return streamID{last.ms, last.seq + 1}, nil
+	}
+
+	if arg.id == (streamID{}) {
+		return arg.id, errors.New("The ID specified in XADD must be greater than 0-0")
+	}
+	if compareStreamIDs(arg.id, last) <= 0 {
+		return arg.id, tooSmall
+	}
+	return arg.id, nil
+}
+
+// handleXAdd implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~]
+// threshold [LIMIT count]] id field value [field value ...], creating the
+// stream if needed and trimming it after adding the entry. It replies with
+// the new entry's ID, or null if the stream doesn't exist and NOMKSTREAM
+// forbids creating it.
+func handleXAdd(args []string, conn net.Conn) {
+	key := args[1]
+
+	var trim streamTrim
+	noMkStream := false
+	i := 2
+	for i < len(args) {
+		if strings.ToUpper(args[i]) == "NOMKSTREAM" {
+			noMkStream = true
+			i++
+			continue
+		}
+		next, matched, err := trim.parseArg(args, i)
+		if err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+		if !matched {
+			break
+		}
+		i = next
+	}
+	if err := trim.validate(); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	if i >= len(args) {
+		writeError(conn, "syntax error")
+		return
+	}
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		writeError(conn, "wrong number of arguments for 'xadd' command")
+		return
+	}
+	idArg, err := parseXAddID(args[i])
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var stream StreamEntry
+	value, exists := lookupKey(key)
+	if exists {
+		var ok bool
+		if stream, ok = value.(StreamEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	} else if noMkStream {
+		writeNullBulkString(conn)
+		return
+	}
+
+	id, err := idArg.resolve(stream.lastID)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	stream.entries = append(stream.entries, StreamEntryData{id: id, fields: slices.Clone(fields)})
+	stream.lastID = id
+	stream.added++
+	stream.trim(trim)
+	DB.Store(key, stream)
+	notifyBlockedClients(key)
+	writeBulkString(conn, id.String())
+}
+
+// xreadArgs are the parsed arguments of XREAD and XREADGROUP. An ID given
+// as "$" to XREAD or ">" to XREADGROUP, asking for new entries, is left out
+// of ids and flagged in latest.
+type xreadArgs struct {
+	count    int
+	block    bool
+	deadline time.Time
+	keys     []string
+	ids      []streamID
+	latest   []bool
+
+	// XREADGROUP only
+	group, consumer string
+	noack           bool
+}
+
+// parseXRead parses XREAD [COUNT count] [BLOCK milliseconds] STREAMS key
+// [key ...] id [id ...], or XREADGROUP GROUP group consumer [COUNT count]
+// [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...] if
+// readGroup is set
+func parseXRead(args []string, readGroup bool) (xreadArgs, error) {
+	var opts xreadArgs
+	hasGroup := false
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "STREAMS" {
+			break
+		}
+		if option == "NOACK" && readGroup {
+			opts.noack = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, errors.New("syntax error")
+		}
+		switch option {
+		case "COUNT":
+			count, ok := parseIntArg(args[i+1])
+			if !ok {
+				return opts, errors.New("value is not an integer or out of range")
+			}
+			opts.count = max(count, 0)
+		case "BLOCK":
+			ms, ok := parseStrictInt(args[i+1])
+			if !ok {
+				return opts, errors.New("timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, errors.New("timeout is negative")
+			}
+			opts.block = true
+			if ms > 0 {
+				opts.deadline = clock.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+		case "GROUP":
+			if !readGroup {
+				return opts, errors.New("The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			if i+2 >= len(args) {
+				return opts, errors.New("syntax error")
+			}
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			hasGroup = true
+			i++
+		default:
+			return opts, errors.New("syntax error")
+		}
+		i++
+	}
+
+	streams := args[min(i+1, len(args)):]
+	if i == len(args) || len(streams) == 0 {
+		return opts, errors.New("syntax error")
+	}
+	if readGroup && !hasGroup {
+		return opts, errors.New("Missing GROUP option for XREADGROUP")
+	}
+	if len(streams)%2 != 0 {
+		if readGroup {
+			return opts, errors.New("Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+		}
+		return opts, errors.New("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+
+	n := len(streams) / 2
+	opts.keys = streams[:n]
+	opts.ids = make([]streamID, n)
+	opts.latest = make([]bool, n)
+	for j, arg := range streams[n:] {
+		switch {
+		case arg == "$" && !readGroup:
+			opts.latest[j] = true
+			continue
+		case arg == "$":
+			return opts, errors.New("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		case arg == ">" && readGroup:
+			opts.latest[j] = true
+			continue
+		case arg == ">":
+			return opts, errors.New("The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		}
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return opts, err
+		}
+		opts.ids[j] = id
+	}
+	return opts, nil
+}
+
+// handleXRead replies with the entries of each stream that come after the
+// ID given for it, leaving out streams that have none, or null if no stream
+// has any. With BLOCK it waits for an XADD to one of the streams instead of
+// replying null, and then replies with the entries of that stream alone.
+func handleXRead(args []string, conn net.Conn) {
+	opts, err := parseXRead(args, false)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(opts.keys...)
+
+	var reply []any
+	for i, key := range opts.keys {
+		value, exists := lookupKey(key)
+		if !exists {
+			continue
+		}
+		stream, ok := value.(StreamEntry)
+		if !ok {
+			unlock()
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		if opts.latest[i] {
+			opts.ids[i] = stream.lastID
+			continue
+		}
+		if entries := stream.entriesAfter(opts.ids[i], opts.count); len(entries) > 0 {
+			reply = append(reply, []any{key, streamEntriesReply(entries)})
+		}
+	}
+
+	if reply != nil || !opts.block {
+		unlock()
+		if reply == nil {
+			writeNullArray(conn)
+			return
+		}
+		writeValue(conn, reply)
+		return
+	}
+
+	client := blockClient(conn, opts.keys, func(key string) (string, bool) {
+		value, _ := lookupKey(key)
+		stream, ok := value.(StreamEntry)
+		if !ok {
+			return "", false
+		}
+		entries := stream.entriesAfter(opts.ids[slices.Index(opts.keys, key)], opts.count)
+		if len(entries) == 0 {
+			return "", false
+		}
+		return encodeValue([]any{[]any{key, streamEntriesReply(entries)}}), true
+	})
+	unlock()
+	waitBlocked(client, opts.deadline)
+}
+
+// readGroup reads the i-th stream of an XREADGROUP for the consumer,
+// creating the consumer if needed. New entries (">") move the group's last
+// ID past them and are added to the PEL, unless NOACK is given; an explicit
+// ID reads back the consumer's own pending entries after it, with null
+// fields for the ones deleted from the stream since. It returns nil if
+// there are no new entries, so that the stream is left out of the reply.
+func (opts xreadArgs) readGroup(stream StreamEntry, i int) []any {
+	group := stream.groups[opts.group]
+	consumer := group.consumer(opts.consumer)
+	now := clock.Now()
+	consumer.seenTime = now
+
+	if !opts.latest[i] {
+		ids := pendingAfter(consumer.pending, opts.ids[i], opts.count)
+		reply := make([]any, len(ids))
+		for j, id := range ids {
+			entry, ok := stream.entry(id)
+			if !ok {
+				reply[j] = []any{id.String(), nullArray{}}
+				continue
+			}
+			p := consumer.pending[id]
+			p.deliveryTime = now
+			p.deliveryCount++
+			reply[j] = []any{id.String(), entry.fields}
+		}
+		return reply
+	}
+
+	entries := stream.entriesAfter(group.lastID, opts.count)
+	if len(entries) == 0 {
+		return nil
+	}
+	consumer.activeTime = now
+	for _, e := range entries {
+		stream.advanceGroup(group, e.id)
+		if !opts.noack {
+			group.deliver(e.id, opts.consumer, now)
+		}
+	}
+	return streamEntriesReply(entries)
+}
+
+// handleXReadGroup reads streams as a consumer of a group, replying like
+// XREAD. Every stream must have the group. With BLOCK and no new entries
+// it waits for an XADD, and fails the wait if the group is destroyed.
+func handleXReadGroup(args []string, conn net.Conn) {
+	opts, err := parseXRead(args, true)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(opts.keys...)
+
+	// look up every group before delivering anything
+	streams := make([]StreamEntry, len(opts.keys))
+	for i, key := range opts.keys {
+		value, exists := lookupKey(key)
+		if exists {
+			var ok bool
+			if streams[i], ok = value.(StreamEntry); !ok {
+				unlock()
+				writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+				return
+			}
+		}
+		if streams[i].groups[opts.group] == nil {
+			unlock()
+			writeRawError(conn, fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, opts.group))
+			return
+		}
+	}
+
+	var reply []any
+	for i, key := range opts.keys {
+		if entries := opts.readGroup(streams[i], i); entries != nil {
+			reply = append(reply, []any{key, entries})
+		}
+	}
+
+	if reply != nil || !opts.block {
+		unlock()
+		if reply == nil {
+			writeNullArray(conn)
+			return
+		}
+		writeValue(conn, reply)
+		return
+	}
+
+	client := blockClient(conn, opts.keys, func(key string) (string, bool) {
+		value, _ := lookupKey(key)
+		stream, ok := value.(StreamEntry)
+		if !ok || stream.groups[opts.group] == nil {
+			return "-NOGROUP the consumer group this client was blocked on no longer exists\r\n", true
+		}
+		entries := opts.readGroup(stream, slices.Index(opts.keys, key))
+		if entries == nil {
+			return "", false
+		}
+		return encodeValue([]any{[]any{key, entries}}), true
+	})
+	unlock()
+	waitBlocked(client, opts.deadline)
+}
+
+// handleXDel deletes entries from a stream by ID and replies with the
+// number of entries deleted. The stream keeps its last ID, so the deleted
+// IDs are never handed out again.
+func handleXDel(args []string, conn net.Conn) {
+	ids := make(map[streamID]bool, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+		ids[id] = true
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	before := len(stream.entries)
+	stream.entries = slices.DeleteFunc(stream.entries, func(e StreamEntryData) bool {
+		if !ids[e.id] {
+			return false
+		}
+		if compareStreamIDs(e.id, stream.maxDeleted) > 0 {
+			stream.maxDeleted = e.id
+		}
+		return true
+	})
+
+	deleted := before - len(stream.entries)
+	if deleted > 0 {
+		DB.Store(key, stream)
+	}
+	writeInteger(conn, deleted)
+}
+
+// handleXTrim implements XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT
+// count], replying with the number of entries removed
+func handleXTrim(args []string, conn net.Conn) {
+	var trim streamTrim
+	for i := 2; i < len(args); {
+		next, matched, err := trim.parseArg(args, i)
+		if err == nil && !matched {
+			err = errors.New("syntax error")
+		}
+		if err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+		i = next
+	}
+	if trim.strategy == streamTrimNone {
+		writeError(conn, "syntax error")
+		return
+	}
+	if err := trim.validate(); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	removed := stream.trim(trim)
+	if removed > 0 {
+		DB.Store(key, stream)
+	}
+	writeInteger(conn, removed)
+}
+
+// handleXGroup implements XGROUP CREATE key group id|$ [MKSTREAM]
+// [ENTRIESREAD entries-read], XGROUP SETID key group id|$ [ENTRIESREAD
+// entries-read] and XGROUP DESTROY key group
+func handleXGroup(args []string, conn net.Conn) {
+	subcommand := strings.ToUpper(args[1])
+	switch {
+	case subcommand == "CREATE" && len(args) >= 5,
+		subcommand == "SETID" && len(args) >= 5,
+		subcommand == "DESTROY" && len(args) == 4:
+	case subcommand == "CREATE", subcommand == "SETID", subcommand == "DESTROY":
+		writeError(conn, fmt.Sprintf("wrong number of arguments for 'xgroup|%s' command", strings.ToLower(args[1])))
+		return
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try XGROUP HELP.", args[1]))
+		return
+	}
+
+	mkstream := false
+	entriesRead := int64(entriesReadUnknown)
+	for i := 5; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "MKSTREAM" && subcommand == "CREATE":
+			mkstream = true
+		case option == "ENTRIESREAD" && i+1 < len(args):
+			i++
+			n, ok := parseStrictInt(args[i])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if n < 0 && n != entriesReadUnknown {
+				writeError(conn, "value for ENTRIESREAD must be positive or -1")
+				return
+			}
+			entriesRead = n
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	key, name := args[2], args[3]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	stream, ok := value.(StreamEntry)
+	if exists && !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	if !exists && !mkstream {
+		writeError(conn, "The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		return
+	}
+
+	if subcommand == "DESTROY" {
+		if stream.groups[name] == nil {
+			writeInteger(conn, 0)
+			return
+		}
+		delete(stream.groups, name)
+		notifyBlockedClients(key)
+		writeInteger(conn, 1)
+		return
+	}
+
+	lastID := stream.lastID
+	if args[4] != "$" {
+		var err error
+		if lastID, err = parseStreamID(args[4], 0); err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+	}
+
+	if subcommand == "SETID" {
+		group := stream.groups[name]
+		if group == nil {
+			writeRawError(conn, fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", name, key))
+			return
+		}
+		group.lastID, group.entriesRead = lastID, entriesRead
+		writeSimpleString(conn, "OK")
+		return
+	}
+
+	if stream.groups[name] != nil {
+		writeRawError(conn, "BUSYGROUP Consumer Group name already exists")
+		return
+	}
+	if stream.groups == nil {
+		stream.groups = make(map[string]*streamGroup)
+	}
+	stream.groups[name] = newStreamGroup(lastID, entriesRead)
+	DB.Store(key, stream)
+	writeSimpleString(conn, "OK")
+}
+
+// handleXAck implements XACK key group id [id ...], removing entries from
+// the group's PEL. It replies with the number of entries that were
+// pending.
+func handleXAck(args []string, conn net.Conn) {
+	ids := make([]streamID, len(args)-3)
+	for i, arg := range args[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+		ids[i] = id
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	group := stream.groups[args[2]]
+	if group == nil {
+		writeInteger(conn, 0)
+		return
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if group.ack(id) {
+			acked++
+		}
+	}
+	writeInteger(conn, acked)
+}
+
+// streamGroupOf returns the stream looked up at key and its named group,
+// or writes the error reply and returns false if the key holds something
+// else or either is missing
+func streamGroupOf(conn net.Conn, value any, exists bool, key, name string) (StreamEntry, *streamGroup, bool) {
+	stream, ok := value.(StreamEntry)
+	if exists && !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return stream, nil, false
+	}
+	group := stream.groups[name]
+	if group == nil {
+		writeRawError(conn, fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, name))
+		return stream, nil, false
+	}
+	return stream, group, true
+}
+
+// handleXPending implements XPENDING key group [[IDLE min-idle-time] start
+// end count [consumer]]. The summary form replies with the number of
+// pending entries, the smallest and greatest pending IDs and the number
+// pending for each consumer. The extended form lists the pending entries
+// in the range, with their consumer, idle time in milliseconds and
+// delivery count.
+func handleXPending(args []string, conn net.Conn) {
+	rest := args[3:]
+	var minIdle int64
+	hasIdle := len(rest) > 0 && strings.ToUpper(rest[0]) == "IDLE"
+	if hasIdle {
+		if len(rest) < 2 {
+			writeError(conn, "syntax error")
+			return
+		}
+		n, ok := parseStrictInt(rest[1])
+		if !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+		minIdle = max(n, 0)
+		rest = rest[2:]
+	}
+	summary := len(rest) == 0 && !hasIdle
+	if !summary && len(rest) != 3 && len(rest) != 4 {
+		writeError(conn, "syntax error")
+		return
+	}
+
+	var start, end streamID
+	var count int64
+	if !summary {
+		var err error
+		if start, end, err = parseStreamRange(rest[0], rest[1]); err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+		var ok bool
+		if count, ok = parseStrictInt(rest[2]); !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+		count = max(count, 0)
+	}
+
+	key := args[1]
+	value, exists, unlock := lookupKeyRead(key)
+	defer unlock()
+
+	_, group, ok := streamGroupOf(conn, value, exists, key, args[2])
+	if !ok {
+		return
+	}
+
+	if summary {
+		ids := pendingAfter(group.pending, streamID{}, 0)
+		if len(ids) == 0 {
+			writeValue(conn, []any{0, nil, nil, nullArray{}})
+			return
+		}
+
+		names := make([]string, 0, len(group.consumers))
+		for name, consumer := range group.consumers {
+			if len(consumer.pending) > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		consumers := make([]any, len(names))
+		for i, name := range names {
+			consumers[i] = []string{name, strconv.Itoa(len(group.consumers[name].pending))}
+		}
+		writeValue(conn, []any{len(ids), ids[0].String(), ids[len(ids)-1].String(), consumers})
+		return
+	}
+
+	pending := group.pending
+	if len(rest) == 4 {
+		consumer := group.consumers[rest[3]]
+		if consumer == nil {
+			writeArray(conn, []string{})
+			return
+		}
+		pending = consumer.pending
+	}
+
+	now := clock.Now()
+	reply := []any{}
+	for _, id := range pendingBetween(pending, start, end) {
+		if int64(len(reply)) == count {
+			break
+		}
+		p := pending[id]
+		idle := now.Sub(p.deliveryTime).Milliseconds()
+		if idle < minIdle {
+			continue
+		}
+		reply = append(reply, []any{id.String(), p.consumer, idle, p.deliveryCount})
+	}
+	writeValue(conn, reply)
+}
+
+// claimPending transfers a pending entry to a consumer for XCLAIM and
+// XAUTOCLAIM, setting its delivery time and bumping its delivery count
+// unless bump is false
+func claimPending(group *streamGroup, id streamID, p *streamPending, consumer string, deliveryTime time.Time, bump bool) {
+	p.deliveryTime = deliveryTime
+	if bump {
+		p.deliveryCount++
+	}
+	group.assign(id, p, consumer)
+	c := group.consumers[consumer]
+	c.seenTime = clock.Now()
+	c.activeTime = c.seenTime
+}
+
+// handleXClaim implements XCLAIM key group consumer min-idle-time id [id
+// ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE]
+// [JUSTID] [LASTID id], transferring to the consumer the pending entries
+// that have been idle for at least min-idle-time. It replies with the
+// claimed entries, or with JUSTID just their IDs, in which case their
+// delivery counts are left alone. Entries deleted from the stream are
+// dropped from the PEL rather than claimed.
+func handleXClaim(args []string, conn net.Conn) {
+	minIdle, ok := parseStrictInt(args[4])
+	if !ok {
+		writeError(conn, "Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+
+	// the IDs run up to the first argument that isn't one
+	var ids []streamID
+	i := 5
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	nowMs := clock.Now().UnixMilli()
+	deliveryMs := nowMs
+	retryCount := int64(-1)
+	var force, justID, hasLastID bool
+	var lastID streamID
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		hasValue := i+1 < len(args)
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case option == "IDLE" && hasValue:
+			i++
+			ms, ok := parseStrictInt(args[i])
+			if !ok {
+				writeError(conn, "Invalid IDLE option argument for XCLAIM")
+				return
+			}
+			deliveryMs = nowMs - ms
+		case option == "TIME" && hasValue:
+			i++
+			if deliveryMs, ok = parseStrictInt(args[i]); !ok {
+				writeError(conn, "Invalid TIME option argument for XCLAIM")
+				return
+			}
+		case option == "RETRYCOUNT" && hasValue:
+			i++
+			if retryCount, ok = parseStrictInt(args[i]); !ok {
+				writeError(conn, "Invalid RETRYCOUNT option argument for XCLAIM")
+				return
+			}
+		case option == "LASTID" && hasValue:
+			i++
+			var err error
+			if lastID, err = parseStreamID(args[i], 0); err != nil {
+				writeError(conn, err.Error())
+				return
+			}
+			hasLastID = true
+		default:
+			writeError(conn, fmt.Sprintf("Unrecognized XCLAIM option '%s'", args[i]))
+			return
+		}
+	}
+	// a bogus delivery time, say from a client whose clock is ahead, is
+	// taken as now rather than failing the command
+	if deliveryMs < 0 || deliveryMs > nowMs {
+		deliveryMs = nowMs
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	stream, group, ok := streamGroupOf(conn, value, exists, key, args[2])
+	if !ok {
+		return
+	}
+	if hasLastID && compareStreamIDs(lastID, group.lastID) > 0 {
+		group.lastID = lastID
+	}
+
+	name := args[3]
+	if consumer := group.consumers[name]; consumer != nil {
+		consumer.seenTime = clock.Now()
+	}
+
+	var claimed []StreamEntryData
+	for _, id := range ids {
+		entry, inStream := stream.entry(id)
+		p := group.pending[id]
+		switch {
+		case !inStream:
+			if p != nil {
+				group.ack(id)
+			}
+			continue
+		case p == nil && !force:
+			continue
+		case p == nil:
+			// FORCE creates the pending entry, which has no idle time to
+			// check yet
+			p = &streamPending{deliveryTime: clock.Now(), deliveryCount: 1}
+		case nowMs-p.deliveryTime.UnixMilli() < minIdle:
+			continue
+		}
+
+		claimPending(group, id, p, name, time.UnixMilli(deliveryMs), retryCount < 0 && !justID)
+		if retryCount >= 0 {
+			p.deliveryCount = int(retryCount)
+		}
+		claimed = append(claimed, entry)
+	}
+
+	if justID {
+		writeArray(conn, streamEntryIDs(claimed))
+		return
+	}
+	writeValue(conn, streamEntriesReply(claimed))
+}
+
+// handleXAutoClaim implements XAUTOCLAIM key group consumer min-idle-time
+// start [COUNT count] [JUSTID]. It scans the PEL from start, claiming for
+// the consumer up to count entries (100 by default) that have been idle
+// for at least min-idle-time, and looking at no more than ten times that
+// many. It replies with the ID to resume the scan from, 0-0 once the scan
+// is complete, the claimed entries or their IDs, and the IDs of the
+// entries it found deleted from the stream and dropped from the PEL.
+func handleXAutoClaim(args []string, conn net.Conn) {
+	const attemptsFactor = 10
+
+	minIdle, ok := parseStrictInt(args[4])
+	if !ok {
+		writeError(conn, "Invalid min-idle-time argument for XAUTOCLAIM")
+		return
+	}
+	start, err := parseStreamRangeBound(args[5], false)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	count := 100
+	justID := false
+	for i := 6; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "JUSTID":
+			justID = true
+		case option == "COUNT" && i+1 < len(args):
+			i++
+			n, ok := parseStrictInt(args[i])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if n < 1 || n > math.MaxInt64/attemptsFactor {
+				writeError(conn, "COUNT must be > 0")
+				return
+			}
+			count = int(n)
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	stream, group, ok := streamGroupOf(conn, value, exists, key, args[2])
+	if !ok {
+		return
+	}
+
+	name := args[3]
+	if consumer := group.consumers[name]; consumer != nil {
+		consumer.seenTime = clock.Now()
+	}
+
+	now := clock.Now()
+	attempts := count * attemptsFactor
+	var claimed []StreamEntryData
+	deleted := []string{}
+	cursor := streamID{}
+	for _, id := range pendingBetween(group.pending, start, streamID{math.MaxUint64, math.MaxUint64}) {
+		if attempts == 0 || len(claimed) == count {
+			cursor = id
+			break
+		}
+		attempts--
+
+		entry, inStream := stream.entry(id)
+		if !inStream {
+			group.ack(id)
+			deleted = append(deleted, id.String())
+			continue
+		}
+		p := group.pending[id]
+		if now.Sub(p.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+		claimPending(group, id, p, name, now, !justID)
+		claimed = append(claimed, entry)
+	}
+
+	var entries any = streamEntriesReply(claimed)
+	if justID {
+		entries = streamEntryIDs(claimed)
+	}
+	writeValue(conn, []any{cursor.String(), entries, deleted})
+}
+
+// handleXInfo implements XINFO STREAM key [FULL [COUNT count]], XINFO
+// GROUPS key and XINFO CONSUMERS key group
+func handleXInfo(args []string, conn net.Conn) {
+	subcommand := strings.ToUpper(args[1])
+	switch {
+	case subcommand == "STREAM" && len(args) >= 3,
+		subcommand == "GROUPS" && len(args) == 3,
+		subcommand == "CONSUMERS" && len(args) == 4:
+	case subcommand == "STREAM", subcommand == "GROUPS", subcommand == "CONSUMERS":
+		writeError(conn, fmt.Sprintf("wrong number of arguments for 'xinfo|%s' command", strings.ToLower(args[1])))
+		return
+	default:
+		writeError(conn, fmt.Sprintf("unknown subcommand '%s'. Try XINFO HELP.", args[1]))
+		return
+	}
+
+	// FULL lists up to count entries, and pending entries per group and
+	// consumer; 0 lists them all
+	full, count := false, 10
+	if subcommand == "STREAM" && len(args) > 3 {
+		full = strings.ToUpper(args[3]) == "FULL"
+		switch {
+		case full && len(args) == 4:
+		case full && len(args) == 6 && strings.ToUpper(args[4]) == "COUNT":
+			n, ok := parseIntArg(args[5])
+			if !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if n >= 0 {
+				count = n
+			}
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	key := args[2]
+	value, exists, unlock := lookupKeyRead(key)
+	defer unlock()
+
+	if !exists {
+		writeError(conn, "no such key")
+		return
+	}
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	now := clock.Now()
+	switch subcommand {
+	case "STREAM":
+		writeValue(conn, xinfoStream(stream, full, count))
+	case "GROUPS":
+		reply := make([]any, 0, len(stream.groups))
+		for _, name := range slices.Sorted(maps.Keys(stream.groups)) {
+			group := stream.groups[name]
+			reply = append(reply, []any{
+				"name", name,
+				"consumers", len(group.consumers),
+				"pending", len(group.pending),
+				"last-delivered-id", group.lastID.String(),
+				"entries-read", entriesReadReply(group),
+				"lag", lagReply(stream, group),
+			})
+		}
+		writeValue(conn, reply)
+	case "CONSUMERS":
+		group := stream.groups[args[3]]
+		if group == nil {
+			writeRawError(conn, fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", args[3], key))
+			return
+		}
+		reply := make([]any, 0, len(group.consumers))
+		for _, name := range slices.Sorted(maps.Keys(group.consumers)) {
+			consumer := group.consumers[name]
+			inactive := int64(-1)
+			if !consumer.activeTime.IsZero() {
+				inactive = now.Sub(consumer.activeTime).Milliseconds()
+			}
+			reply = append(reply, []any{
+				"name", name,
+				"pending", len(consumer.pending),
+				"idle", now.Sub(consumer.seenTime).Milliseconds(),
+				"inactive", inactive,
+			})
+		}
+		writeValue(conn, reply)
+	}
+}
+
+// xinfoStream is the reply to XINFO STREAM. RegoDB keeps a stream's
+// entries in a single slice rather than a radix tree, so the radix tree
+// figures count the nodes of stream-node-max-entries entries it would have.
+func xinfoStream(stream StreamEntry, full bool, count int) []any {
+	nodes := (len(stream.entries) + streamNodeSize() - 1) / streamNodeSize()
+	reply := []any{
+		"length", len(stream.entries),
+		"radix-tree-keys", nodes,
+		"radix-tree-nodes", nodes,
+		"last-generated-id", stream.lastID.String(),
+		"max-deleted-entry-id", stream.maxDeleted.String(),
+		"entries-added", stream.added,
+		"recorded-first-entry-id", stream.firstID().String(),
+	}
+
+	if !full {
+		reply = append(reply, "groups", len(stream.groups))
+		var first, last any
+		if n := len(stream.entries); n > 0 {
+			first = streamEntriesReply(stream.entries[:1])[0]
+			last = streamEntriesReply(stream.entries[n-1:])[0]
+		}
+		return append(reply, "first-entry", first, "last-entry", last)
+	}
+
+	limit := func(n int) int {
+		if count > 0 {
+			return min(n, count)
+		}
+		return n
+	}
+	reply = append(reply, "entries", streamEntriesReply(stream.entries[:limit(len(stream.entries))]))
+
+	groups := make([]any, 0, len(stream.groups))
+	for _, name := range slices.Sorted(maps.Keys(stream.groups)) {
+		group := stream.groups[name]
+
+		ids := pendingAfter(group.pending, streamID{}, 0)
+		pending := make([]any, limit(len(ids)))
+		for i := range pending {
+			p := group.pending[ids[i]]
+			pending[i] = []any{ids[i].String(), p.consumer, p.deliveryTime.UnixMilli(), p.deliveryCount}
+		}
+
+		consumers := make([]any, 0, len(group.consumers))
+		for _, consumerName := range slices.Sorted(maps.Keys(group.consumers)) {
+			consumer := group.consumers[consumerName]
+			activeTime := int64(-1)
+			if !consumer.activeTime.IsZero() {
+				activeTime = consumer.activeTime.UnixMilli()
+			}
+			ids := pendingAfter(consumer.pending, streamID{}, count)
+			owned := make([]any, len(ids))
+			for i, id := range ids {
+				p := consumer.pending[id]
+				owned[i] = []any{id.String(), p.deliveryTime.UnixMilli(), p.deliveryCount}
+			}
+			consumers = append(consumers, []any{
+				"name", consumerName,
+				"seen-time", consumer.seenTime.UnixMilli(),
+				"active-time", activeTime,
+				"pel-count", len(consumer.pending),
+				"pending", owned,
+			})
+		}
+
+		groups = append(groups, []any{
+			"name", name,
+			"last-delivered-id", group.lastID.String(),
+			"entries-read", entriesReadReply(group),
+			"lag", lagReply(stream, group),
+			"pel-count", len(group.pending),
+			"pending", pending,
+			"consumers", consumers,
+		})
+	}
+	return append(reply, "groups", groups)
+}
+
+// entriesReadReply is a group's entries read count for XINFO, null if it
+// is unknown
+func entriesReadReply(group *streamGroup) any {
+	if group.entriesRead == entriesReadUnknown {
+		return nil
+	}
+	return group.entriesRead
+}
+
+// lagReply is a group's lag for XINFO, null if it can't be told
+func lagReply(stream StreamEntry, group *streamGroup) any {
+	lag, ok := stream.lag(group)
+	if !ok {
+		return nil
+	}
+	return lag
+}
+
+// handleXSetID implements XSETID key last-id [ENTRIESADDED entries-added]
+// [MAXDELETEDID max-deleted-id], setting the last ID of a stream, which
+// can't go below its last entry, and optionally the number of entries ever
+// added to it and the greatest ID deleted from it
+func handleXSetID(args []string, conn net.Conn) {
+	id, err := parseStreamID(args[2], 0)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	added := int64(-1)
+	var maxDeleted streamID
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "ENTRIESADDED" && i+1 < len(args):
+			i++
+			var ok bool
+			if added, ok = parseStrictInt(args[i]); !ok {
+				writeError(conn, "value is not an integer or out of range")
+				return
+			}
+			if added < 0 {
+				writeError(conn, "entries_added must be positive")
+				return
+			}
+		case option == "MAXDELETEDID" && i+1 < len(args):
+			i++
+			if maxDeleted, err = parseStreamID(args[i], 0); err != nil {
+				writeError(conn, err.Error())
+				return
+			}
+			if compareStreamIDs(id, maxDeleted) < 0 {
+				writeError(conn, "The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+				return
+			}
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeError(conn, "no such key")
+		return
+	}
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	if n := len(stream.entries); n > 0 {
+		if compareStreamIDs(id, stream.entries[n-1].id) < 0 {
+			writeError(conn, "The ID specified in XSETID is smaller than the target stream top item")
+			return
+		}
+		if added >= 0 && int64(n) > added {
+			writeError(conn, "The entries_added specified in XSETID is smaller than the target stream length")
+			return
+		}
+	}
+
+	stream.lastID = id
+	if added >= 0 {
+		stream.added = added
+	}
+	if maxDeleted != (streamID{}) {
+		stream.maxDeleted = maxDeleted
+	}
+	DB.Store(key, stream)
+	writeSimpleString(conn, "OK")
+}
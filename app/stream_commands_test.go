@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestXAddArguments(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "xs", "str")
+	c.do("SET", "str", "v")
+
+	const wrongArgs = "-ERR wrong number of arguments for 'xadd' command\r\n"
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing value", []string{"XADD", "xs", "1-1", "field"}, wrongArgs},
+		{"no fields", []string{"XADD", "xs", "1-1"}, wrongArgs},
+		{"odd pairs", []string{"XADD", "xs", "1-1", "a", "1", "b"}, wrongArgs},
+		{"odd pairs after options", []string{"XADD", "xs", "NOMKSTREAM", "MAXLEN", "10", "1-1", "f"}, wrongArgs},
+		{"options and no fields", []string{"XADD", "xs", "MAXLEN", "10", "1-1"}, wrongArgs},
+		{"options and no id", []string{"XADD", "xs", "MAXLEN", "10"}, wrongArgs},
+		{"wrong type", []string{"XADD", "str", "1-1", "f", "v"}, "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"pairs", []string{"XADD", "xs", "1-1", "a", "1", "b", "2"}, "$3\r\n1-1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.do(tt.args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+
+	if got := c.do("XLEN", "xs"); got != ":1\r\n" {
+		t.Errorf("XLEN after the rejected XADDs = %q, want :1", got)
+	}
+}
+
+func TestXReadGroupDeletedPending(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "rg")
+	c.do("XADD", "rg", "1-1", "f", "v")
+	c.do("XADD", "rg", "2-1", "f", "w")
+	c.do("XGROUP", "CREATE", "rg", "g", "0")
+	c.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "rg", ">")
+	c.do("XDEL", "rg", "1-1")
+
+	got := c.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "rg", "0")
+	want := "*1\r\n*2\r\n$2\r\nrg\r\n*2\r\n" +
+		"*2\r\n$3\r\n1-1\r\n*-1\r\n" +
+		"*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$1\r\nw\r\n"
+	if got != want {
+		t.Errorf("XREADGROUP of pending entries = %q, want %q", got, want)
+	}
+}
+
+func TestXPendingSummaryEmpty(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "pe")
+	c.do("XGROUP", "CREATE", "pe", "g", "$", "MKSTREAM")
+
+	want := "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"
+	if got := c.do("XPENDING", "pe", "g"); got != want {
+		t.Errorf("XPENDING with nothing pending = %q, want %q", got, want)
+	}
+}
+
+// waitForBlocked waits until n clients are blocked on key
+func waitForBlocked(t *testing.T, key string, n int) {
+	t.Helper()
+	for i := 0; ; i++ {
+		blockedClientsMutex.Lock()
+		blocked := len(blockedClients[key])
+		blockedClientsMutex.Unlock()
+		if blocked >= n {
+			return
+		}
+		if i == 5000 {
+			t.Fatalf("%d clients blocked on %s, want %d", blocked, key, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// streamReply encodes an XREAD or XREADGROUP reply for one stream with
+// entries of a single field
+func streamReply(key string, entries ...[3]string) string {
+	reply := fmt.Sprintf("*1\r\n*2\r\n%s*%d\r\n", encodeValue(key), len(entries))
+	for _, e := range entries {
+		reply += "*2\r\n" + encodeValue(e[0]) + encodeValue([]string{e[1], e[2]})
+	}
+	return reply
+}
+
+func TestXReadDollar(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "xd", "xd-missing")
+	c.do("XADD", "xd", "1-1", "f", "old")
+
+	// without BLOCK, $ means nothing newer than what's there now
+	if got := c.do("XREAD", "STREAMS", "xd", "$"); got != "*-1\r\n" {
+		t.Errorf("XREAD STREAMS xd $ = %q, want a null array", got)
+	}
+	if got := c.do("XREAD", "STREAMS", "xd-missing", "$"); got != "*-1\r\n" {
+		t.Errorf("XREAD of a missing stream from $ = %q, want a null array", got)
+	}
+
+	for _, key := range []string{"xd", "xd-missing"} {
+		t.Run("block "+key, func(t *testing.T) {
+			reader := newTestClient(t)
+			reply := make(chan string, 1)
+			go func() { reply <- reader.do("XREAD", "BLOCK", "0", "STREAMS", key, "$") }()
+			waitForBlocked(t, key, 1)
+
+			if got := c.do("XADD", key, "2-1", "f", "new"); got != encodeValue("2-1") {
+				t.Fatalf("XADD %s = %q", key, got)
+			}
+			select {
+			case got := <-reply:
+				if want := streamReply(key, [3]string{"2-1", "f", "new"}); got != want {
+					t.Errorf("XREAD BLOCK 0 STREAMS %s $ = %q, want %q", key, got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("XREAD on %s wasn't woken by XADD", key)
+			}
+		})
+	}
+}
+
+func TestXReadGroupNew(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "xg")
+	c.do("XADD", "xg", "1-1", "f", "a")
+	c.do("XGROUP", "CREATE", "xg", "g", "0")
+
+	// > delivers what the group hasn't seen yet, then nothing
+	if got, want := c.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "xg", ">"), streamReply("xg", [3]string{"1-1", "f", "a"}); got != want {
+		t.Errorf("first XREADGROUP > = %q, want %q", got, want)
+	}
+	if got := c.do("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "xg", ">"); got != "*-1\r\n" {
+		t.Errorf("XREADGROUP > with nothing new = %q, want a null array", got)
+	}
+
+	reader := newTestClient(t)
+	reply := make(chan string, 1)
+	go func() {
+		reply <- reader.do("XREADGROUP", "GROUP", "g", "bob", "BLOCK", "0", "STREAMS", "xg", ">")
+	}()
+	waitForBlocked(t, "xg", 1)
+
+	if got := c.do("XADD", "xg", "2-1", "f", "b"); got != encodeValue("2-1") {
+		t.Fatalf("XADD xg = %q", got)
+	}
+	select {
+	case got := <-reply:
+		if want := streamReply("xg", [3]string{"2-1", "f", "b"}); got != want {
+			t.Errorf("blocked XREADGROUP > = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("XREADGROUP > wasn't woken by XADD")
+	}
+
+	// the entry went to bob, so it is pending for bob alone
+	if got, want := c.do("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "xg", "0"), streamReply("xg", [3]string{"2-1", "f", "b"}); got != want {
+		t.Errorf("bob's pending entries = %q, want %q", got, want)
+	}
+	if got, want := c.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "xg", "0"), streamReply("xg", [3]string{"1-1", "f", "a"}); got != want {
+		t.Errorf("alice's pending entries = %q, want %q", got, want)
+	}
+}
+
+// pelEntry is what a test expects of a pending entry: the consumer that
+// owns it and how many times it was delivered
+type pelEntry struct {
+	consumer string
+	count    int
+}
+
+// checkPEL fails the test unless the group's PEL holds exactly want, and
+// every consumer's PEL holds the same records for the entries it owns
+func checkPEL(t *testing.T, key, groupName string, want map[string]pelEntry) {
+	t.Helper()
+	unlock := DB.RLock(key)
+	defer unlock()
+	value, _ := DB.Load(key)
+	stream, ok := value.(StreamEntry)
+	if !ok {
+		t.Fatalf("%s holds %T, not a stream", key, value)
+	}
+	group := stream.groups[groupName]
+	if group == nil {
+		t.Fatalf("%s has no group %s", key, groupName)
+	}
+
+	if len(group.pending) != len(want) {
+		t.Errorf("group PEL has %d entries, want %d", len(group.pending), len(want))
+	}
+	owned := 0
+	for id, p := range group.pending {
+		w, ok := want[id.String()]
+		if !ok {
+			t.Errorf("%s is pending, want it acknowledged", id)
+			continue
+		}
+		if p.consumer != w.consumer || p.deliveryCount != w.count {
+			t.Errorf("%s is pending for %s with %d deliveries, want %s with %d", id, p.consumer, p.deliveryCount, w.consumer, w.count)
+		}
+		if consumer := group.consumers[p.consumer]; consumer == nil || consumer.pending[id] != p {
+			t.Errorf("%s is missing from the PEL of its consumer %s", id, p.consumer)
+		}
+	}
+	for _, consumer := range group.consumers {
+		owned += len(consumer.pending)
+	}
+	if owned != len(group.pending) {
+		t.Errorf("consumer PELs hold %d entries, the group's %d", owned, len(group.pending))
+	}
+}
+
+// TestStreamPELBookkeeping follows entries through delivery, redelivery,
+// acknowledgement and claiming, checking the group and consumer PELs
+// after each step
+func TestStreamPELBookkeeping(t *testing.T) {
+	fake := useFakeClock(t)
+	c := newTestClient(t)
+	c.do("DEL", "pel")
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		c.do("XADD", "pel", id, "f", id)
+	}
+	c.do("XGROUP", "CREATE", "pel", "g", "0")
+
+	steps := []struct {
+		args []string
+		want string // the reply, or "" not to check it
+		pel  map[string]pelEntry
+	}{
+		{
+			[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "pel", ">"}, "",
+			map[string]pelEntry{"1-0": {"alice", 1}, "2-0": {"alice", 1}},
+		},
+		{
+			[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "pel", ">"}, "",
+			map[string]pelEntry{"1-0": {"alice", 1}, "2-0": {"alice", 1}, "3-0": {"bob", 1}, "4-0": {"bob", 1}},
+		},
+		// reading its history delivers alice's entries again
+		{
+			[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "pel", "0"}, "",
+			map[string]pelEntry{"1-0": {"alice", 2}, "2-0": {"alice", 2}, "3-0": {"bob", 1}, "4-0": {"bob", 1}},
+		},
+		// only entries that are pending count as acknowledged
+		{
+			[]string{"XACK", "pel", "g", "1-0", "9-0", "1-0"}, ":1\r\n",
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"bob", 1}, "4-0": {"bob", 1}},
+		},
+		{
+			[]string{"XACK", "pel", "g", "1-0"}, ":0\r\n",
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"bob", 1}, "4-0": {"bob", 1}},
+		},
+		// nothing has been idle for a minute
+		{
+			[]string{"XCLAIM", "pel", "g", "carol", "60000", "3-0"}, "*0\r\n",
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"bob", 1}, "4-0": {"bob", 1}},
+		},
+		{
+			[]string{"XCLAIM", "pel", "g", "carol", "0", "3-0"}, "",
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"carol", 2}, "4-0": {"bob", 1}},
+		},
+		// JUSTID moves the entry without counting a delivery
+		{
+			[]string{"XCLAIM", "pel", "g", "carol", "0", "4-0", "JUSTID"}, encodeValue([]string{"4-0"}),
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"carol", 2}, "4-0": {"carol", 1}},
+		},
+		// an ID that isn't pending is left alone without FORCE
+		{
+			[]string{"XCLAIM", "pel", "g", "carol", "0", "1-0", "JUSTID"}, "*0\r\n",
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"carol", 2}, "4-0": {"carol", 1}},
+		},
+		// a deleted entry is dropped from the PEL instead of claimed
+		{
+			[]string{"XDEL", "pel", "2-0"}, ":1\r\n",
+			map[string]pelEntry{"2-0": {"alice", 2}, "3-0": {"carol", 2}, "4-0": {"carol", 1}},
+		},
+		{
+			[]string{"XCLAIM", "pel", "g", "carol", "0", "2-0"}, "*0\r\n",
+			map[string]pelEntry{"3-0": {"carol", 2}, "4-0": {"carol", 1}},
+		},
+		{
+			[]string{"XACK", "pel", "g", "3-0", "4-0"}, ":2\r\n",
+			map[string]pelEntry{},
+		},
+	}
+	for _, step := range steps {
+		fake.advance(time.Second)
+		got := c.do(step.args...)
+		if step.want != "" && got != step.want {
+			t.Errorf("%v = %q, want %q", step.args, got, step.want)
+		}
+		checkPEL(t, "pel", "g", step.pel)
+		if t.Failed() {
+			t.Fatalf("PEL wrong after %v", step.args)
+		}
+	}
+
+	// NOACK delivers without adding to the PEL
+	c.do("XADD", "pel", "5-0", "f", "5-0")
+	if got, want := c.do("XREADGROUP", "GROUP", "g", "alice", "NOACK", "STREAMS", "pel", ">"), streamReply("pel", [3]string{"5-0", "f", "5-0"}); got != want {
+		t.Errorf("XREADGROUP NOACK = %q, want %q", got, want)
+	}
+	checkPEL(t, "pel", "g", map[string]pelEntry{})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,34 +11,113 @@ import (
 type Entry struct {
 	value     string
 	expiresAt time.Time
+	// raw is set once the string has been edited in place by APPEND or
+	// SETRANGE, which Redis always leaves in the raw encoding
+	raw bool
 }
 
-// ListEntry represents a list data structure
+// ListEntry represents a list data structure. The quicklist is shared by
+// every copy of the entry, so changes to it happen in place.
 type ListEntry struct {
-	elements  []string
+	list      *quicklist
 	expiresAt time.Time
+	// encoding is the encoding OBJECT ENCODING reports, which withEncoding
+	// updates on every write that can grow the list
+	encoding string
 }
 
-// StreamEntry represents a Redis stream data structure
-type StreamEntry struct {
-	entries   []StreamEntryData
+// HashEntry represents a hash of field/value pairs. Like a list's
+// quicklist, the map is shared by every copy of the entry.
+type HashEntry struct {
+	fields       map[string]string
+	fieldExpires map[string]time.Time // deadlines of the fields that have a TTL
+	expiresAt    time.Time
+	encoding     string // as in ListEntry
+}
+
+// SetEntry represents a set of unique members. The map is shared by every
+// copy of the entry.
+type SetEntry struct {
+	members   map[string]struct{}
 	expiresAt time.Time
+	encoding  string // as in ListEntry
+}
+
+// ZSetEntry represents a sorted set. The zset is shared by every copy of
+// the entry.
+type ZSetEntry struct {
+	zset      *zset
+	expiresAt time.Time
+}
+
+// StreamEntry represents a Redis stream data structure. Its consumer groups
+// are shared by every copy of the entry.
+type StreamEntry struct {
+	entries    []StreamEntryData
+	lastID     streamID // the last ID generated, which new IDs must exceed
+	maxDeleted streamID // the greatest ID deleted with XDEL
+	added      int64    // the number of entries ever added
+	groups     map[string]*streamGroup
+	expiresAt  time.Time
 }
 
 // StreamEntryData represents a single entry within a stream
 type StreamEntryData struct {
-	id   string
-	data map[string]string // key-value pairs for the entry
+	id     streamID
+	fields []string // flattened field/value pairs, in insertion order
 }
 
-// BlockedClient represents a client blocked on BLPOP
+// BlockedClient represents a client blocked on keys until data arrives
 type BlockedClient struct {
-	conn      net.Conn
-	listKey   string
-	timeout   float64
-	startTime time.Time
-	done      chan struct{} // channel to signal when client should stop blocking
+	conn  net.Conn
+	keys  []string                        // keys the client is waiting on
+	serve func(key string) (string, bool) // serves the client in place; nil to wake it instead
+	woken bool                            // set when the client is woken to retry
+	reply string                          // written by the client once released, if not empty
+	done  chan struct{}                   // closed once the client is served, woken or unblocked
 }
 
 // CommandHandler defines the signature for all command handler functions
 type CommandHandler func(args []string, conn net.Conn)
+
+// Command describes a command: its handler, arity and where its keys are
+type Command struct {
+	name     string
+	handler  CommandHandler
+	arity    int    // exact argument count if positive, minimum if negative; the name counts
+	flags    string // space-separated flags reported by COMMAND
+	firstKey int
+	lastKey  int // negative values count back from the last argument
+	keyStep  int
+}
+
+// Client wraps a connection with the per-connection state the server keeps.
+// It is passed to handlers in place of the raw connection.
+type Client struct {
+	net.Conn
+	id           int64
+	proto        int           // the RESP version chosen with HELLO, 2 by default
+	errorReplies atomic.Int64  // number of error replies written so far
+	blockedTime  time.Duration // time the current command spent blocked
+
+	// transaction state: whether the client is inside MULTI, the commands
+	// queued so far, whether one was rejected while queuing, and whether
+	// EXEC is running them
+	multi        bool
+	queued       [][]string
+	multiAborted bool
+	inExec       bool
+
+	// the channels and patterns the client is subscribed to, guarded by
+	// pubsubMu
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// commandStat holds the counters INFO commandstats reports for one command
+type commandStat struct {
+	calls         atomic.Int64
+	usec          atomic.Int64
+	rejectedCalls atomic.Int64
+	failedCalls   atomic.Int64
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// zsetItem is a sorted set member with its score
+type zsetItem struct {
+	member string
+	score  float64
+}
+
+// compareZSetItems orders sorted set members by score, and members with the
+// same score lexicographically, as Redis does
+func compareZSetItems(a, b zsetItem) int {
+	if c := cmp.Compare(a.score, b.score); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.member, b.member)
+}
+
+// zset holds the members of a sorted set and their scores. Like Redis it
+// has two encodings: a small sorted set is a listpack, its items kept in
+// order in a single slice, and one that outgrows zset-max-listpack-entries
+// or gets a member longer than zset-max-listpack-value is converted to a
+// skiplist, with a map from members to scores for O(1) score lookups. It
+// stays a skiplist from then on.
+type zset struct {
+	listpack []zsetItem
+	dict     map[string]float64
+	sl       *skiplist
+}
+
+// newZSet returns an empty sorted set, encoded as a listpack
+func newZSet() *zset {
+	return &zset{}
+}
+
+// encoding returns the encoding of the sorted set, as OBJECT ENCODING
+// reports it
+func (z *zset) encoding() string {
+	if z.sl != nil {
+		return "skiplist"
+	}
+	return "listpack"
+}
+
+// len returns the number of members
+func (z *zset) len() int {
+	if z.sl != nil {
+		return z.sl.length
+	}
+	return len(z.listpack)
+}
+
+// listpackIndex returns the index of a member in the listpack
+func (z *zset) listpackIndex(member string) (int, bool) {
+	for i, item := range z.listpack {
+		if item.member == member {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// score returns the score of a member
+func (z *zset) score(member string) (float64, bool) {
+	if z.sl != nil {
+		score, ok := z.dict[member]
+		return score, ok
+	}
+	i, ok := z.listpackIndex(member)
+	if !ok {
+		return 0, false
+	}
+	return z.listpack[i].score, true
+}
+
+// set adds a member or updates its score, converting the sorted set to a
+// skiplist if it no longer fits a listpack
+func (z *zset) set(member string, score float64) {
+	item := zsetItem{member, score}
+	if z.sl != nil {
+		if current, ok := z.dict[member]; ok {
+			if current == score {
+				return
+			}
+			z.sl.delete(zsetItem{member, current})
+		}
+		z.sl.insert(item)
+		z.dict[member] = score
+		return
+	}
+
+	if i, ok := z.listpackIndex(member); ok {
+		z.listpack = slices.Delete(z.listpack, i, i+1)
+	}
+	i, _ := slices.BinarySearchFunc(z.listpack, item, compareZSetItems)
+	z.listpack = slices.Insert(z.listpack, i, item)
+
+	if int64(len(z.listpack)) > zsetMaxListpackEntries.value.Load() ||
+		int64(len(member)) > zsetMaxListpackValue.value.Load() {
+		z.convertToSkiplist()
+	}
+}
+
+// convertToSkiplist moves the items of a listpack into a skiplist
+func (z *zset) convertToSkiplist() {
+	z.sl = newSkiplist()
+	z.dict = make(map[string]float64, len(z.listpack))
+	for _, item := range z.listpack {
+		z.sl.insert(item)
+		z.dict[item.member] = item.score
+	}
+	z.listpack = nil
+}
+
+// remove removes a member, reporting whether it was there
+func (z *zset) remove(member string) bool {
+	if z.sl != nil {
+		score, ok := z.dict[member]
+		if !ok {
+			return false
+		}
+		delete(z.dict, member)
+		z.sl.delete(zsetItem{member, score})
+		return true
+	}
+
+	i, ok := z.listpackIndex(member)
+	if !ok {
+		return false
+	}
+	z.listpack = slices.Delete(z.listpack, i, i+1)
+	return true
+}
+
+// rank returns the 0-based position of a member in sorted set order, or
+// counting from the highest score if reverse is set
+func (z *zset) rank(member string, reverse bool) (int, bool) {
+	var rank int
+	if z.sl != nil {
+		score, ok := z.dict[member]
+		if !ok {
+			return 0, false
+		}
+		rank = z.sl.rank(zsetItem{member, score}) - 1
+	} else {
+		var ok bool
+		if rank, ok = z.listpackIndex(member); !ok {
+			return 0, false
+		}
+	}
+	if reverse {
+		rank = z.len() - 1 - rank
+	}
+	return rank, true
+}
+
+// items returns every member with its score, in sorted set order
+func (z *zset) items() []zsetItem {
+	if z.sl == nil {
+		return slices.Clone(z.listpack)
+	}
+	items := make([]zsetItem, 0, z.sl.length)
+	for x := z.sl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		items = append(items, x.item)
+	}
+	return items
+}
+
+// randomItems returns count members with their scores, picked uniformly at
+// random: distinct ones, or all of them if there are no more than count,
+// unless repeats is set, in which case each is picked on its own
+func (z *zset) randomItems(count int, repeats bool) []zsetItem {
+	if z.sl == nil {
+		if repeats {
+			items := make([]zsetItem, count)
+			for i := range items {
+				items[i] = z.listpack[rand.IntN(len(z.listpack))]
+			}
+			return items
+		}
+		perm := rand.Perm(len(z.listpack))
+		items := make([]zsetItem, min(count, len(perm)))
+		for i := range items {
+			items[i] = z.listpack[perm[i]]
+		}
+		return items
+	}
+
+	var members []string
+	if repeats {
+		members = sampleKeysWithRepeats(z.dict, count)
+	} else {
+		members = sampleKeys(z.dict, count)
+	}
+	items := make([]zsetItem, len(members))
+	for i, member := range members {
+		items[i] = zsetItem{member, z.dict[member]}
+	}
+	return items
+}
+
+// clone returns a copy of the sorted set that shares nothing with it
+func (z *zset) clone() *zset {
+	if z.sl == nil {
+		return &zset{listpack: slices.Clone(z.listpack)}
+	}
+	c := &zset{sl: newSkiplist(), dict: maps.Clone(z.dict)}
+	for x := z.sl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.sl.insert(x.item)
+	}
+	return c
+}
+
+// walk calls f for the members in sorted set order, or in reverse, until it
+// returns false, starting at the first member for which start holds.
+// start must go from false to true at most once along the way.
+func (z *zset) walk(reverse bool, start func(zsetItem) bool, f func(zsetItem) bool) {
+	if z.sl != nil {
+		for x := z.sl.seek(reverse, start); x != nil; {
+			if !f(x.item) {
+				return
+			}
+			if reverse {
+				x = x.backward
+			} else {
+				x = x.level[0].forward
+			}
+		}
+		return
+	}
+
+	items := z.listpack
+	if reverse {
+		// start holds for a prefix, so begin at its last item
+		first := sort.Search(len(items), func(i int) bool { return !start(items[i]) }) - 1
+		for i := first; i >= 0; i-- {
+			if !f(items[i]) {
+				return
+			}
+		}
+		return
+	}
+	first := sort.Search(len(items), func(i int) bool { return start(items[i]) })
+	for i := first; i < len(items); i++ {
+		if !f(items[i]) {
+			return
+		}
+	}
+}
+
+// scoreRange is a range of scores, each end inclusive unless marked
+// exclusive
+type scoreRange struct {
+	min, max     float64
+	minEx, maxEx bool
+}
+
+// parseScoreRange parses the min and max of a score range, each a float or
+// -inf/+inf, made exclusive by a leading "("
+func parseScoreRange(min, max string) (scoreRange, error) {
+	var r scoreRange
+	var okMin, okMax bool
+	r.min, r.minEx, okMin = parseScoreBound(min)
+	r.max, r.maxEx, okMax = parseScoreBound(max)
+	if !okMin || !okMax {
+		return r, errors.New("min or max is not a float")
+	}
+	return r, nil
+}
+
+func parseScoreBound(bound string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(bound, "(")
+	if exclusive {
+		bound = bound[1:]
+	}
+	score, ok := parseStrictFloat(bound)
+	return score, exclusive, ok
+}
+
+// aboveMin reports whether a score is not below the range
+func (r scoreRange) aboveMin(score float64) bool {
+	if r.minEx {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+// belowMax reports whether a score is not above the range
+func (r scoreRange) belowMax(score float64) bool {
+	if r.maxEx {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// lexBound is one end of a lexicographic range: a member, or -inf (inf < 0)
+// or +inf (inf > 0) for the "-" and "+" bounds
+type lexBound struct {
+	member    string
+	exclusive bool
+	inf       int
+}
+
+// lexRange is a range of members, compared bytewise
+type lexRange struct {
+	min, max lexBound
+}
+
+// parseLexRange parses the min and max of a lexicographic range, each "-",
+// "+", or a member behind "[" to include it or "(" to exclude it
+func parseLexRange(min, max string) (lexRange, error) {
+	var r lexRange
+	var okMin, okMax bool
+	r.min, okMin = parseLexBound(min)
+	r.max, okMax = parseLexBound(max)
+	if !okMin || !okMax {
+		return r, errors.New("min or max not valid string range item")
+	}
+	return r, nil
+}
+
+func parseLexBound(bound string) (lexBound, bool) {
+	switch {
+	case bound == "-":
+		return lexBound{inf: -1}, true
+	case bound == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(bound, "["):
+		return lexBound{member: bound[1:]}, true
+	case strings.HasPrefix(bound, "("):
+		return lexBound{member: bound[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// aboveMin reports whether a member is not below the range
+func (r lexRange) aboveMin(member string) bool {
+	if r.min.inf != 0 {
+		return r.min.inf < 0
+	}
+	if r.min.exclusive {
+		return member > r.min.member
+	}
+	return member >= r.min.member
+}
+
+// belowMax reports whether a member is not above the range
+func (r lexRange) belowMax(member string) bool {
+	if r.max.inf != 0 {
+		return r.max.inf > 0
+	}
+	if r.max.exclusive {
+		return member < r.max.member
+	}
+	return member <= r.max.member
+}
+
+// rangeByRank returns the members from rank start to stop, both inclusive
+// and within range, counting from the highest score if reverse is set
+func (z *zset) rangeByRank(start, stop int, reverse bool) []zsetItem {
+	result := make([]zsetItem, 0, stop-start+1)
+	if z.sl == nil {
+		n := len(z.listpack)
+		for rank := start; rank <= stop; rank++ {
+			if reverse {
+				result = append(result, z.listpack[n-1-rank])
+			} else {
+				result = append(result, z.listpack[rank])
+			}
+		}
+		return result
+	}
+
+	// skiplist ranks are 1-based and count from the lowest score
+	x := z.sl.byRank(start + 1)
+	if reverse {
+		x = z.sl.byRank(z.sl.length - start)
+	}
+	for len(result) < cap(result) {
+		result = append(result, x.item)
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return result
+}
+
+// rangeByScore returns the members whose scores are in r, from the lowest
+// score or from the highest if reverse is set, skipping the first offset
+// and returning at most count of them if count isn't negative
+func (z *zset) rangeByScore(r scoreRange, reverse bool, offset, count int) []zsetItem {
+	aboveMin := func(item zsetItem) bool { return r.aboveMin(item.score) }
+	belowMax := func(item zsetItem) bool { return r.belowMax(item.score) }
+	return z.rangeBetween(aboveMin, belowMax, reverse, offset, count)
+}
+
+// rangeByLex is rangeByScore for members in a lexicographic range, which
+// is only meaningful when all the members share a score
+func (z *zset) rangeByLex(r lexRange, reverse bool, offset, count int) []zsetItem {
+	aboveMin := func(item zsetItem) bool { return r.aboveMin(item.member) }
+	belowMax := func(item zsetItem) bool { return r.belowMax(item.member) }
+	return z.rangeBetween(aboveMin, belowMax, reverse, offset, count)
+}
+
+// rangeBetween returns the members between the first one that is aboveMin
+// and the last one that is belowMax, seeking straight to the end it starts
+// from rather than scanning up to it
+func (z *zset) rangeBetween(aboveMin, belowMax func(zsetItem) bool, reverse bool, offset, count int) []zsetItem {
+	result := []zsetItem{}
+	if offset < 0 || count == 0 {
+		return result
+	}
+
+	start, inRange := aboveMin, belowMax
+	if reverse {
+		start, inRange = belowMax, aboveMin
+	}
+	z.walk(reverse, start, func(item zsetItem) bool {
+		if !inRange(item) {
+			return false
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		result = append(result, item)
+		return count < 0 || len(result) < count
+	})
+	return result
+}
+
+// formatScore renders a sorted set score the way Redis replies with one:
+// the shortest representation that reads back as the same double, and
+// "inf" or "-inf" for the infinities. Scores of ordinary magnitude are
+// written out without an exponent, so that 1000000 stays "1000000" and an
+// integral score reads as an integer, which is what lets a listpack store
+// it as one. Beyond 17 digits either way an exponent comes back, or 1e300
+// would take 301 digits.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	if abs := math.Abs(score); abs != 0 && (abs < 1e-17 || abs >= 1e17) {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -0,0 +1,1122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// zaddOptions are the flags ZADD takes before its score/member pairs
+type zaddOptions struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// handleZAdd adds members to a sorted set or updates their scores, creating
+// the key if needed. It replies with the number of members added, or added
+// and changed with CH; with INCR it increments the one member's score and
+// replies with the new score, or null if a flag stopped the update.
+func handleZAdd(args []string, conn net.Conn) {
+	var opts zaddOptions
+	i := 2
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GT":
+			opts.gt = true
+		case "LT":
+			opts.lt = true
+		case "CH":
+			opts.ch = true
+		case "INCR":
+			opts.incr = true
+		default:
+			break flags
+		}
+	}
+	zaddGeneric(args[1], args[i:], opts, conn)
+}
+
+// zaddGeneric adds or updates the score/member pairs of ZADD and ZINCRBY
+// according to opts
+func zaddGeneric(key string, pairs []string, opts zaddOptions, conn net.Conn) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		writeError(conn, "syntax error")
+		return
+	}
+	if opts.nx && opts.xx {
+		writeError(conn, "XX and NX options at the same time are not compatible")
+		return
+	}
+	if (opts.gt && opts.lt) || (opts.nx && (opts.gt || opts.lt)) {
+		writeError(conn, "GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if opts.incr && len(pairs) > 2 {
+		writeError(conn, "INCR option supports a single increment-element pair")
+		return
+	}
+
+	// parse every score before touching the set, so a bad one leaves it as
+	// it was
+	scores := make([]float64, len(pairs)/2)
+	members := make([]string, len(pairs)/2)
+	for j := range scores {
+		score, ok := parseStrictFloat(pairs[2*j])
+		if !ok {
+			writeError(conn, "value is not a valid float")
+			return
+		}
+		scores[j], members[j] = score, pairs[2*j+1]
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	var entry ZSetEntry
+	value, exists := lookupKey(key)
+	if exists {
+		var ok bool
+		if entry, ok = value.(ZSetEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+	} else {
+		entry = ZSetEntry{zset: newZSet()}
+	}
+	z := entry.zset
+
+	// count the new members up front, so the limits can be checked before
+	// anything changes; XX never adds any, and a member named twice is only
+	// new once
+	newMembers := 0
+	if !opts.xx {
+		seen := make(map[string]bool)
+		for _, member := range members {
+			if _, exists := z.score(member); !exists && !seen[member] {
+				newMembers++
+			}
+			seen[member] = true
+		}
+	}
+	if newMembers > 0 {
+		if err := checkCollectionLimits(z.len()+newMembers, members...); err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+	}
+
+	if opts.incr {
+		score, updated, err := zaddIncr(z, members[0], scores[0], opts)
+		if err != nil {
+			writeError(conn, err.Error())
+			return
+		}
+		if !updated {
+			writeNullBulkString(conn)
+			return
+		}
+		DB.Store(key, entry)
+		notifyBlockedClients(key)
+		writeBulkString(conn, formatScore(score))
+		return
+	}
+
+	added, changed := 0, 0
+	for j, member := range members {
+		current, exists := z.score(member)
+		switch {
+		case !exists:
+			if opts.xx {
+				continue
+			}
+			z.set(member, scores[j])
+			added++
+		case opts.nx,
+			opts.gt && scores[j] <= current,
+			opts.lt && scores[j] >= current,
+			scores[j] == current:
+			continue
+		default:
+			z.set(member, scores[j])
+			changed++
+		}
+	}
+
+	if z.len() > 0 && added+changed > 0 {
+		DB.Store(key, entry)
+		notifyBlockedClients(key)
+	}
+	if opts.ch {
+		added += changed
+	}
+	writeInteger(conn, added)
+}
+
+// handleZIncrBy increments a member's score, adding the member and creating
+// the key if needed, and replies with the new score
+func handleZIncrBy(args []string, conn net.Conn) {
+	zaddGeneric(args[1], args[2:], zaddOptions{incr: true}, conn)
+}
+
+// errScoreNaN is returned when incrementing a score gives NaN, as adding inf
+// to -inf does
+var errScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+// zaddIncr applies ZADD INCR to one member, returning its new score and
+// whether the flags let it be set
+func zaddIncr(z *zset, member string, incr float64, opts zaddOptions) (float64, bool, error) {
+	current, exists := z.score(member)
+	if (exists && opts.nx) || (!exists && opts.xx) {
+		return 0, false, nil
+	}
+
+	score := current + incr
+	if math.IsNaN(score) {
+		return 0, false, errScoreNaN
+	}
+	if exists && ((opts.gt && score <= current) || (opts.lt && score >= current)) {
+		return 0, false, nil
+	}
+
+	z.set(member, score)
+	return score, true, nil
+}
+
+// handleZScore replies with the score of a member, or null if the member or
+// the key doesn't exist
+func handleZScore(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	if !exists {
+		writeNullBulkString(conn)
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	score, ok := entry.zset.score(args[2])
+	if !ok {
+		writeNullBulkString(conn)
+		return
+	}
+	writeBulkString(conn, formatScore(score))
+}
+
+// handleZCard replies with the number of members in a sorted set
+func handleZCard(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	writeInteger(conn, entry.zset.len())
+}
+
+// handleZMScore replies with the scores of the given members, with nulls
+// for members that don't exist
+func handleZMScore(args []string, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	scores := make([]*string, len(args)-2)
+	if !exists {
+		writeNullableArray(conn, scores)
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	for i, member := range args[2:] {
+		if score, ok := entry.zset.score(member); ok {
+			formatted := formatScore(score)
+			scores[i] = &formatted
+		}
+	}
+	writeNullableArray(conn, scores)
+}
+
+// zrangeBy is what a ZRANGE selects members by
+type zrangeBy int
+
+const (
+	zrangeByRank zrangeBy = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeSpec is a parsed ZRANGE query
+type zrangeSpec struct {
+	by          zrangeBy
+	reverse     bool
+	withScores  bool
+	start, stop int
+	scores      scoreRange
+	lex         lexRange
+
+	// offset and count are the LIMIT, with a negative count for no limit
+	offset, count int
+}
+
+// parseZRange parses the arguments of ZRANGE that follow the key: the
+// bounds, then BYSCORE or BYLEX, REV, LIMIT and WITHSCORES in any order.
+// With REV, score and lex ranges are given from max to min.
+func parseZRange(args []string) (zrangeSpec, error) {
+	spec := zrangeSpec{count: -1}
+	limit := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			if spec.by == zrangeByLex {
+				return spec, errors.New("syntax error")
+			}
+			spec.by = zrangeByScore
+		case "BYLEX":
+			if spec.by == zrangeByScore {
+				return spec, errors.New("syntax error")
+			}
+			spec.by = zrangeByLex
+		case "REV":
+			spec.reverse = true
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return spec, errors.New("syntax error")
+			}
+			offset, ok1 := parseIntArg(args[i+1])
+			count, ok2 := parseIntArg(args[i+2])
+			if !ok1 || !ok2 {
+				return spec, errors.New("value is not an integer or out of range")
+			}
+			spec.offset, spec.count = offset, count
+			limit = true
+			i += 2
+		default:
+			return spec, errors.New("syntax error")
+		}
+	}
+
+	if limit && spec.by == zrangeByRank {
+		return spec, errors.New("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == zrangeByLex {
+		return spec, errors.New("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	min, max := args[0], args[1]
+	if spec.reverse && spec.by != zrangeByRank {
+		min, max = max, min
+	}
+	return spec, spec.parseBounds(min, max)
+}
+
+// parseBounds parses the start and stop of a query, as ranks, scores or
+// members depending on what it selects by
+func (spec *zrangeSpec) parseBounds(min, max string) error {
+	var err error
+	switch spec.by {
+	case zrangeByScore:
+		spec.scores, err = parseScoreRange(min, max)
+	case zrangeByLex:
+		spec.lex, err = parseLexRange(min, max)
+	default:
+		var ok1, ok2 bool
+		spec.start, ok1 = parseIntArg(min)
+		spec.stop, ok2 = parseIntArg(max)
+		if !ok1 || !ok2 {
+			err = errors.New("value is not an integer or out of range")
+		}
+	}
+	return err
+}
+
+// run returns the members of z the query selects, in reply order
+func (spec zrangeSpec) run(z *zset) []zsetItem {
+	switch spec.by {
+	case zrangeByScore:
+		return z.rangeByScore(spec.scores, spec.reverse, spec.offset, spec.count)
+	case zrangeByLex:
+		return z.rangeByLex(spec.lex, spec.reverse, spec.offset, spec.count)
+	}
+
+	start, stop, n := spec.start, spec.stop, z.len()
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []zsetItem{}
+	}
+	return z.rangeByRank(start, stop, spec.reverse)
+}
+
+// writeZSetItems replies with the members of items, each followed by its
+// score if withScores is set
+func writeZSetItems(conn net.Conn, items []zsetItem, withScores bool) {
+	reply := make([]string, 0, len(items)*2)
+	for _, item := range items {
+		reply = append(reply, item.member)
+		if withScores {
+			reply = append(reply, formatScore(item.score))
+		}
+	}
+	writeArray(conn, reply)
+}
+
+// zrangeGeneric runs a parsed range query against the sorted set at key
+func zrangeGeneric(key string, spec zrangeSpec, conn net.Conn) {
+	value, exists, unlock := lookupKeyRead(key)
+	defer unlock()
+
+	if !exists {
+		writeArray(conn, []string{})
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+	writeZSetItems(conn, spec.run(entry.zset), spec.withScores)
+}
+
+// handleZRange replies with a range of a sorted set's members, selected by
+// rank, score or member and optionally reversed and limited
+func handleZRange(args []string, conn net.Conn) {
+	spec, err := parseZRange(args[2:])
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	zrangeGeneric(args[1], spec, conn)
+}
+
+// handleZRangeStore implements ZRANGESTORE dst src min max [BYSCORE|BYLEX]
+// [REV] [LIMIT offset count]: the selected members and their scores replace
+// whatever dst held, with no TTL, or delete it if there are none. It replies
+// with the number of members stored.
+func handleZRangeStore(args []string, conn net.Conn) {
+	spec, err := parseZRange(args[3:])
+	if err == nil && spec.withScores {
+		err = errors.New("syntax error")
+	}
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	dst, src := args[1], args[2]
+	unlock := DB.Lock(dst, src)
+	defer unlock()
+
+	result := newZSet()
+	if value, exists := lookupKey(src); exists {
+		entry, ok := value.(ZSetEntry)
+		if !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		for _, item := range spec.run(entry.zset) {
+			result.set(item.member, item.score)
+		}
+	}
+
+	if result.len() > 0 {
+		replaceValue(dst, ZSetEntry{zset: result})
+	} else {
+		replaceValue(dst, nil)
+	}
+	writeInteger(conn, result.len())
+}
+
+// handleZRevRange is ZRANGE by rank with REV, kept for older clients
+func handleZRevRange(args []string, conn net.Conn) {
+	spec := zrangeSpec{reverse: true, count: -1}
+	if len(args) == 5 {
+		if !strings.EqualFold(args[4], "WITHSCORES") {
+			writeError(conn, "syntax error")
+			return
+		}
+		spec.withScores = true
+	} else if len(args) > 5 {
+		writeError(conn, "syntax error")
+		return
+	}
+	if err := spec.parseBounds(args[2], args[3]); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	zrangeGeneric(args[1], spec, conn)
+}
+
+// zrangeLegacy runs ZRANGEBYSCORE, ZRANGEBYLEX and their reverse forms,
+// kept for older clients, as the ZRANGE query they stand for: the bounds
+// selected by score or member, optionally reversed. They take only the
+// WITHSCORES and LIMIT options.
+func zrangeLegacy(args []string, conn net.Conn, by string, reverse bool) {
+	rangeArgs := []string{args[2], args[3], by}
+	if reverse {
+		rangeArgs = append(rangeArgs, "REV")
+	}
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHSCORES":
+		case "LIMIT":
+			i += 2
+		default:
+			writeError(conn, "syntax error")
+			return
+		}
+	}
+
+	spec, err := parseZRange(append(rangeArgs, args[4:]...))
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	zrangeGeneric(args[1], spec, conn)
+}
+
+func handleZRangeByScore(args []string, conn net.Conn) {
+	zrangeLegacy(args, conn, "BYSCORE", false)
+}
+
+func handleZRevRangeByScore(args []string, conn net.Conn) {
+	zrangeLegacy(args, conn, "BYSCORE", true)
+}
+
+func handleZRangeByLex(args []string, conn net.Conn) {
+	zrangeLegacy(args, conn, "BYLEX", false)
+}
+
+func handleZRevRangeByLex(args []string, conn net.Conn) {
+	zrangeLegacy(args, conn, "BYLEX", true)
+}
+
+// zrankGeneric replies with a member's rank, counted from the lowest score
+// or from the highest if reverse is set, or null if it doesn't exist. With
+// WITHSCORE the reply is the rank and the score, or a null array.
+func zrankGeneric(args []string, conn net.Conn, reverse bool) {
+	withScore := false
+	if len(args) == 4 {
+		if !strings.EqualFold(args[3], "WITHSCORE") {
+			writeError(conn, "syntax error")
+			return
+		}
+		withScore = true
+	} else if len(args) > 4 {
+		writeError(conn, "syntax error")
+		return
+	}
+
+	writeNull := writeNullBulkString
+	if withScore {
+		writeNull = writeNullArray
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+
+	if !exists {
+		writeNull(conn)
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	rank, ok := entry.zset.rank(args[2], reverse)
+	if !ok {
+		writeNull(conn)
+		return
+	}
+	if withScore {
+		score, _ := entry.zset.score(args[2])
+		writeValue(conn, []any{rank, formatScore(score)})
+		return
+	}
+	writeInteger(conn, rank)
+}
+
+func handleZRank(args []string, conn net.Conn) {
+	zrankGeneric(args, conn, false)
+}
+
+func handleZRevRank(args []string, conn net.Conn) {
+	zrankGeneric(args, conn, true)
+}
+
+// handleZRandMember implements ZRANDMEMBER key [count [WITHSCORES]]. Without
+// a count it replies with one random member; a positive count asks for up
+// to that many distinct members, a negative one for exactly -count members
+// that may repeat.
+func handleZRandMember(args []string, conn net.Conn) {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3], "WITHSCORES")) {
+		writeError(conn, "syntax error")
+		return
+	}
+	withScores := len(args) == 4
+
+	count, hasCount := 1, len(args) >= 3
+	if hasCount {
+		var ok bool
+		count, ok = parseIntArg(args[2])
+		if !ok {
+			writeError(conn, "value is not an integer or out of range")
+			return
+		}
+		// keep -count, and twice that with scores, from overflowing
+		if count == math.MinInt || (withScores && count < -math.MaxInt/2) {
+			writeError(conn, "value is out of range")
+			return
+		}
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		if hasCount {
+			writeArray(conn, []string{})
+		} else {
+			writeNullBulkString(conn)
+		}
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	var items []zsetItem
+	if count >= 0 {
+		items = entry.zset.randomItems(count, false)
+	} else {
+		items = entry.zset.randomItems(-count, true)
+	}
+
+	if !hasCount {
+		writeBulkString(conn, items[0].member)
+		return
+	}
+	writeZSetItems(conn, items, withScores)
+}
+
+// handleZRem removes members from a sorted set, deleting the key once the
+// last one is gone, and replies with the number of members removed
+func handleZRem(args []string, conn net.Conn) {
+	key := args[1]
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	removed := 0
+	for _, member := range args[2:] {
+		if entry.zset.remove(member) {
+			removed++
+		}
+	}
+	storeZSetAfterRemove(key, entry, removed)
+	writeInteger(conn, removed)
+}
+
+// storeZSetAfterRemove writes back a sorted set that removed members were
+// taken out of, deleting the key if none are left
+func storeZSetAfterRemove(key string, entry ZSetEntry, removed int) {
+	if entry.zset.len() == 0 {
+		DB.Delete(key)
+	} else if removed > 0 {
+		DB.Store(key, entry)
+	}
+}
+
+// zremRangeGeneric removes the members of a sorted set that fall in a
+// range of ranks, scores or members, and replies with how many it removed
+func zremRangeGeneric(args []string, conn net.Conn, by zrangeBy) {
+	key := args[1]
+	spec := zrangeSpec{by: by, count: -1}
+	if err := spec.parseBounds(args[2], args[3]); err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(key)
+	defer unlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		writeInteger(conn, 0)
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	items := spec.run(entry.zset)
+	for _, item := range items {
+		entry.zset.remove(item.member)
+	}
+	storeZSetAfterRemove(key, entry, len(items))
+	writeInteger(conn, len(items))
+}
+
+func handleZRemRangeByRank(args []string, conn net.Conn) {
+	zremRangeGeneric(args, conn, zrangeByRank)
+}
+
+func handleZRemRangeByScore(args []string, conn net.Conn) {
+	zremRangeGeneric(args, conn, zrangeByScore)
+}
+
+func handleZRemRangeByLex(args []string, conn net.Conn) {
+	zremRangeGeneric(args, conn, zrangeByLex)
+}
+
+// how ZUNIONSTORE and ZINTERSTORE combine the scores of a member found in
+// several inputs
+const (
+	zsetAggregateSum = iota
+	zsetAggregateMin
+	zsetAggregateMax
+)
+
+// zsetOpArgs are the parsed inputs of a sorted set combination: the keys,
+// a weight per key, the aggregate and, for ZDIFF, WITHSCORES
+type zsetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// parseZSetOp parses the numkeys, keys and options of a combination
+// command. WEIGHTS and AGGREGATE apply to unions and intersections, and
+// WITHSCORES only to commands that reply with the result.
+func parseZSetOp(name string, args []string, op int, reply bool) (zsetOpArgs, error) {
+	var opts zsetOpArgs
+	numKeys, ok := parseIntArg(args[0])
+	if !ok {
+		return opts, errors.New("value is not an integer or out of range")
+	}
+	if numKeys < 1 {
+		return opts, fmt.Errorf("at least 1 input key is needed for '%s' command", strings.ToLower(name))
+	}
+	if numKeys > len(args)-1 {
+		return opts, errors.New("syntax error")
+	}
+	opts.keys = args[1 : numKeys+1]
+	opts.weights = make([]float64, numKeys)
+	for i := range opts.weights {
+		opts.weights[i] = 1
+	}
+
+	syntaxErr := errors.New("syntax error")
+	rest := args[numKeys+1:]
+	for i := 0; i < len(rest); i++ {
+		switch option := strings.ToUpper(rest[i]); {
+		case option == "WEIGHTS" && op != setDiff:
+			if i+numKeys >= len(rest) {
+				return opts, syntaxErr
+			}
+			for j := range opts.weights {
+				weight, ok := parseStrictFloat(rest[i+1+j])
+				if !ok {
+					return opts, errors.New("weight value is not a float")
+				}
+				opts.weights[j] = weight
+			}
+			i += numKeys
+		case option == "AGGREGATE" && op != setDiff:
+			if i+1 >= len(rest) {
+				return opts, syntaxErr
+			}
+			switch strings.ToUpper(rest[i+1]) {
+			case "SUM":
+				opts.aggregate = zsetAggregateSum
+			case "MIN":
+				opts.aggregate = zsetAggregateMin
+			case "MAX":
+				opts.aggregate = zsetAggregateMax
+			default:
+				return opts, syntaxErr
+			}
+			i++
+		case option == "WITHSCORES" && reply:
+			opts.withScores = true
+		default:
+			return opts, syntaxErr
+		}
+	}
+	return opts, nil
+}
+
+// lookupZSetInputs returns the sorted sets stored at keys, with nil for
+// missing keys. A plain set counts as a sorted set whose members all score
+// 1. The caller must hold the locks of keys.
+func lookupZSetInputs(keys []string) ([]*zset, error) {
+	inputs := make([]*zset, len(keys))
+	for i, key := range keys {
+		value, exists := lookupKey(key)
+		if !exists {
+			continue
+		}
+		switch v := value.(type) {
+		case ZSetEntry:
+			inputs[i] = v.zset
+		case SetEntry:
+			inputs[i] = newZSet()
+			for member := range v.members {
+				inputs[i].set(member, 1)
+			}
+		default:
+			return nil, errWrongType
+		}
+	}
+	return inputs, nil
+}
+
+// aggregateScores combines two scores of a member. A sum of opposite
+// infinities counts as 0, as Redis does, since a score can't be NaN.
+func aggregateScores(a, b float64, aggregate int) float64 {
+	switch aggregate {
+	case zsetAggregateMin:
+		return min(a, b)
+	case zsetAggregateMax:
+		return max(a, b)
+	}
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// weightScore applies a weight to a score, with 0 for the NaN that
+// weighting an infinity by 0 gives
+func weightScore(score, weight float64) float64 {
+	if weighted := score * weight; !math.IsNaN(weighted) {
+		return weighted
+	}
+	return 0
+}
+
+// combineZSets computes the union, intersection or difference of sorted
+// sets, a missing one counting as empty. Unions and intersections weight
+// and aggregate the scores; a difference is the first sorted set, scores
+// unchanged, minus the members of all the others.
+func combineZSets(inputs []*zset, opts zsetOpArgs, op int) *zset {
+	result := newZSet()
+	switch op {
+	case setUnion:
+		for i, in := range inputs {
+			if in == nil {
+				continue
+			}
+			for _, item := range in.items() {
+				score := weightScore(item.score, opts.weights[i])
+				if current, ok := result.score(item.member); ok {
+					score = aggregateScores(current, score, opts.aggregate)
+				}
+				result.set(item.member, score)
+			}
+		}
+	case setInter:
+		if slices.Contains(inputs, nil) {
+			return result
+		}
+		// walk the smallest input, so a small one cuts the work short
+		order := make([]int, len(inputs))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int {
+			return inputs[a].len() - inputs[b].len()
+		})
+	members:
+		for _, item := range inputs[order[0]].items() {
+			score := weightScore(item.score, opts.weights[order[0]])
+			for _, i := range order[1:] {
+				other, ok := inputs[i].score(item.member)
+				if !ok {
+					continue members
+				}
+				score = aggregateScores(score, weightScore(other, opts.weights[i]), opts.aggregate)
+			}
+			result.set(item.member, score)
+		}
+	case setDiff:
+		if inputs[0] == nil {
+			return result
+		}
+	diff:
+		for _, item := range inputs[0].items() {
+			for _, other := range inputs[1:] {
+				if other == nil {
+					continue
+				}
+				if _, ok := other.score(item.member); ok {
+					continue diff
+				}
+			}
+			result.set(item.member, item.score)
+		}
+	}
+	return result
+}
+
+// zsetOpStoreGeneric implements ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE:
+// the combined sorted set replaces whatever destination held, with no TTL,
+// or deletes it if the result is empty. It replies with the size of the
+// result.
+func zsetOpStoreGeneric(args []string, conn net.Conn, op int) {
+	opts, err := parseZSetOp(args[0], args[2:], op, false)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	dst := args[1]
+	unlock := DB.Lock(append([]string{dst}, opts.keys...)...)
+	defer unlock()
+
+	inputs, err := lookupZSetInputs(opts.keys)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	result := combineZSets(inputs, opts, op)
+	if result.len() > 0 {
+		replaceValue(dst, ZSetEntry{zset: result})
+	} else {
+		replaceValue(dst, nil)
+	}
+	writeInteger(conn, result.len())
+}
+
+func handleZUnionStore(args []string, conn net.Conn) {
+	zsetOpStoreGeneric(args, conn, setUnion)
+}
+
+func handleZInterStore(args []string, conn net.Conn) {
+	zsetOpStoreGeneric(args, conn, setInter)
+}
+
+func handleZDiffStore(args []string, conn net.Conn) {
+	zsetOpStoreGeneric(args, conn, setDiff)
+}
+
+// handleZDiff replies with the members of the first sorted set that are in
+// none of the others, in score order and optionally with their scores
+func handleZDiff(args []string, conn net.Conn) {
+	opts, err := parseZSetOp(args[0], args[1:], setDiff, true)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(opts.keys...)
+	defer unlock()
+
+	inputs, err := lookupZSetInputs(opts.keys)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	writeZSetItems(conn, combineZSets(inputs, opts, setDiff).items(), opts.withScores)
+}
+
+// handleZScan implements ZSCAN key cursor [MATCH pattern] [COUNT count],
+// replying with members each followed by its score. Like HSCAN, it returns
+// small sorted sets whole, in score order, in a single call.
+func handleZScan(args []string, conn net.Conn) {
+	opts, err := parseScanArgs(args, false)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	value, exists, unlock := lookupKeyRead(args[1])
+	defer unlock()
+	if !exists {
+		writeValue(conn, []any{"0", []string{}})
+		return
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok {
+		writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return
+	}
+
+	items, next := entry.zset.items(), uint64(0)
+	if entry.zset.encoding() == "skiplist" {
+		members := make([]string, len(items))
+		for i, item := range items {
+			members[i] = item.member
+		}
+		members, next = scanPage(members, opts.cursor, opts.count)
+		items = items[:0]
+		for _, member := range members {
+			score, _ := entry.zset.score(member)
+			items = append(items, zsetItem{member, score})
+		}
+	}
+
+	result := make([]string, 0, len(items)*2)
+	for _, item := range items {
+		if opts.matches(item.member) {
+			result = append(result, item.member, formatScore(item.score))
+		}
+	}
+	writeValue(conn, []any{strconv.FormatUint(next, 10), result})
+}
+
+// parseZSetSide parses the MIN|MAX argument of ZMPOP, reporting true for
+// MIN, the end with the lowest scores
+func parseZSetSide(arg string) (bool, bool) {
+	switch strings.ToUpper(arg) {
+	case "MIN":
+		return true, true
+	case "MAX":
+		return false, true
+	}
+	return false, false
+}
+
+// popZSetItems pops up to count members with the lowest scores (fromMin) or
+// the highest from the sorted set at key, in pop order, deleting the key if
+// it empties. It returns nil if there is no sorted set to pop from. The
+// caller must hold the lock of key.
+func popZSetItems(key string, fromMin bool, count int) []zsetItem {
+	value, exists := lookupKey(key)
+	if !exists {
+		return nil
+	}
+	entry, ok := value.(ZSetEntry)
+	if !ok || entry.zset.len() == 0 {
+		return nil
+	}
+
+	popped := zrangeSpec{reverse: !fromMin, stop: count - 1}.run(entry.zset)
+	for _, item := range popped {
+		entry.zset.remove(item.member)
+	}
+	storeZSetAfterRemove(key, entry, len(popped))
+	return popped
+}
+
+// zmpopReply is the reply to ZMPOP and BZMPOP: the key, then the popped
+// members as member/score pairs
+func zmpopReply(key string, popped []zsetItem) []any {
+	pairs := make([]any, len(popped))
+	for i, item := range popped {
+		pairs[i] = []string{item.member, formatScore(item.score)}
+	}
+	return []any{key, pairs}
+}
+
+// zmpopFirst pops up to count members from the first non-empty sorted set
+// among keys and replies with them. It reports whether it found anything,
+// and writes WRONGTYPE for a key that isn't a sorted set.
+func zmpopFirst(conn net.Conn, keys []string, fromMin bool, count int) (done bool) {
+	for _, key := range keys {
+		value, exists := lookupKey(key)
+		if !exists {
+			continue
+		}
+		if _, ok := value.(ZSetEntry); !ok {
+			writeError(conn, "WRONGTYPE Operation against a key holding the wrong kind of value")
+			return true
+		}
+		if popped := popZSetItems(key, fromMin, count); popped != nil {
+			writeValue(conn, zmpopReply(key, popped))
+			return true
+		}
+	}
+	return false
+}
+
+// handleZMPop implements ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+func handleZMPop(args []string, conn net.Conn) {
+	keys, fromMin, count, err := parseMPopArgs(args[1:], parseZSetSide)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(keys...)
+	defer unlock()
+
+	if !zmpopFirst(conn, keys, fromMin, count) {
+		writeNullArray(conn)
+	}
+}
+
+// handleBZMPop implements BZMPOP timeout numkeys key [key ...] MIN|MAX
+// [COUNT count], blocking on every key until one of them gets members
+func handleBZMPop(args []string, conn net.Conn) {
+	deadline, err := parseBlockTimeout(args[1])
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+	keys, fromMin, count, err := parseMPopArgs(args[2:], parseZSetSide)
+	if err != nil {
+		writeError(conn, err.Error())
+		return
+	}
+
+	unlock := DB.Lock(keys...)
+	if zmpopFirst(conn, keys, fromMin, count) {
+		unlock()
+		return
+	}
+
+	client := blockClient(conn, keys, func(key string) (string, bool) {
+		popped := popZSetItems(key, fromMin, count)
+		if popped == nil {
+			return "", false
+		}
+		return encodeValue(zmpopReply(key, popped)), true
+	})
+	unlock()
+	waitBlocked(client, deadline)
+}
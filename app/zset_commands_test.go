@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestZRangeByScoreMatchesZRange(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zs")
+	c.do("ZADD", "zs", "1", "a", "2", "b", "2", "c", "3.5", "d", "1000000", "e", "-inf", "lo", "+inf", "hi")
+
+	tests := []struct {
+		name     string
+		min, max string
+		opts     []string
+	}{
+		{"all", "-inf", "+inf", nil},
+		{"inclusive", "1", "2", nil},
+		{"exclusive min", "(1", "2", nil},
+		{"exclusive both", "(1", "(3.5", nil},
+		{"empty", "5", "4", nil},
+		{"withscores", "-inf", "+inf", []string{"WITHSCORES"}},
+		{"limit", "-inf", "+inf", []string{"LIMIT", "1", "3"}},
+		{"limit to the end", "1", "+inf", []string{"LIMIT", "2", "-1"}},
+		{"limit and withscores", "2", "+inf", []string{"WITHSCORES", "LIMIT", "0", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacy := slices.Concat([]string{"ZRANGEBYSCORE", "zs", tt.min, tt.max}, tt.opts)
+			zrange := slices.Concat([]string{"ZRANGE", "zs", tt.min, tt.max, "BYSCORE"}, tt.opts)
+			if got, want := c.do(legacy...), c.do(zrange...); got != want {
+				t.Errorf("%v = %q, ZRANGE gives %q", legacy, got, want)
+			}
+
+			legacy = slices.Concat([]string{"ZREVRANGEBYSCORE", "zs", tt.max, tt.min}, tt.opts)
+			zrange = slices.Concat([]string{"ZRANGE", "zs", tt.max, tt.min, "BYSCORE", "REV"}, tt.opts)
+			if got, want := c.do(legacy...), c.do(zrange...); got != want {
+				t.Errorf("%v = %q, ZRANGE gives %q", legacy, got, want)
+			}
+		})
+	}
+}
+
+func TestZRangeByScoreErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zs")
+	c.do("ZADD", "zs", "1", "a")
+
+	for _, args := range [][]string{
+		{"ZRANGEBYSCORE", "zs", "x", "1"},
+		{"ZRANGEBYSCORE", "zs", "0", "1", "REV"},
+		{"ZRANGEBYSCORE", "zs", "0", "1", "BYLEX"},
+		{"ZRANGEBYSCORE", "zs", "0", "1", "LIMIT", "0"},
+		{"ZREVRANGEBYSCORE", "zs", "1", "0", "LIMIT", "a", "1"},
+	} {
+		if got := c.do(args...); got[0] != '-' {
+			t.Errorf("%v = %q, want an error", args, got)
+		}
+	}
+}
+
+func TestZRangeByLex(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zl")
+	c.do("ZADD", "zl", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+
+	tests := []struct {
+		min, max string
+		opts     []string
+		want     []string
+	}{
+		{"-", "+", nil, []string{"a", "b", "c", "d", "e"}},
+		{"[b", "[d", nil, []string{"b", "c", "d"}},
+		{"(b", "[d", nil, []string{"c", "d"}},
+		{"[b", "(d", nil, []string{"b", "c"}},
+		{"(b", "(d", nil, []string{"c"}},
+		{"-", "[c", nil, []string{"a", "b", "c"}},
+		{"-", "(c", nil, []string{"a", "b"}},
+		{"[c", "+", nil, []string{"c", "d", "e"}},
+		{"(c", "+", nil, []string{"d", "e"}},
+		{"[aa", "(c", nil, []string{"b"}},
+		{"+", "-", nil, []string{}},
+		{"[d", "[b", nil, []string{}},
+		{"-", "+", []string{"LIMIT", "1", "2"}, []string{"b", "c"}},
+		{"[b", "+", []string{"LIMIT", "2", "-1"}, []string{"d", "e"}},
+	}
+	for _, tt := range tests {
+		args := slices.Concat([]string{"ZRANGEBYLEX", "zl", tt.min, tt.max}, tt.opts)
+		if got, want := c.do(args...), encodeValue(tt.want); got != want {
+			t.Errorf("%v = %q, want %q", args, got, want)
+		}
+
+		// the reverse query takes the bounds the other way around and, with
+		// no LIMIT, returns the same members backwards
+		if tt.opts != nil {
+			continue
+		}
+		args = []string{"ZREVRANGEBYLEX", "zl", tt.max, tt.min}
+		reversed := slices.Clone(tt.want)
+		slices.Reverse(reversed)
+		if got, want := c.do(args...), encodeValue(reversed); got != want {
+			t.Errorf("%v = %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestZRangeByLexErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zl")
+	c.do("ZADD", "zl", "0", "a")
+
+	for _, args := range [][]string{
+		{"ZRANGEBYLEX", "zl", "a", "+"},
+		{"ZRANGEBYLEX", "zl", "-", "c"},
+		{"ZRANGEBYLEX", "zl", "-", "+", "WITHSCORES"},
+		{"ZREVRANGEBYLEX", "zl", "+", "-", "BYSCORE"},
+	} {
+		if got := c.do(args...); got[0] != '-' {
+			t.Errorf("%v = %q, want an error", args, got)
+		}
+	}
+}
+
+func TestZRandMember(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zr", "missing")
+	c.do("ZADD", "zr", "1", "a", "2", "b", "3", "c")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ZRANDMEMBER", "missing"}, "$-1\r\n"},
+		{[]string{"ZRANDMEMBER", "missing", "3"}, "*0\r\n"},
+		{[]string{"ZRANDMEMBER", "zr", "0"}, "*0\r\n"},
+		{[]string{"ZRANDMEMBER", "zr", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"ZRANDMEMBER", "zr", "1", "WITHVALUES"}, "-ERR syntax error\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	scores := map[string]string{"a": "1", "b": "2", "c": "3"}
+	for _, tt := range []struct {
+		count   string
+		members int
+		repeats bool
+	}{
+		{"2", 2, false},
+		{"5", 3, false},
+		{"-5", 5, true},
+	} {
+		reply := c.do("ZRANDMEMBER", "zr", tt.count, "WITHSCORES")
+		elems := parseArrayReply(t, reply)
+		if len(elems) != 2*tt.members {
+			t.Fatalf("ZRANDMEMBER zr %s WITHSCORES = %q, want %d members", tt.count, reply, tt.members)
+		}
+		seen := map[string]bool{}
+		for i := 0; i < len(elems); i += 2 {
+			if scores[elems[i]] != elems[i+1] {
+				t.Errorf("ZRANDMEMBER zr %s WITHSCORES paired %q with %q", tt.count, elems[i], elems[i+1])
+			}
+			if seen[elems[i]] && !tt.repeats {
+				t.Errorf("ZRANDMEMBER zr %s repeated %q", tt.count, elems[i])
+			}
+			seen[elems[i]] = true
+		}
+	}
+}
+
+// TestZRandMemberUniform checks that every member comes up about as often
+// as the others, for both encodings
+func TestZRandMemberUniform(t *testing.T) {
+	c := newTestClient(t)
+	for _, size := range []int{10, 200} {
+		c.do("DEL", "zu")
+		args := []string{"ZADD", "zu"}
+		for i := range size {
+			args = append(args, strconv.Itoa(i), "m"+strconv.Itoa(i))
+		}
+		c.do(args...)
+
+		const rounds = 200
+		counts := map[string]int{}
+		for range rounds {
+			for _, member := range parseArrayReply(t, c.do("ZRANDMEMBER", "zu", strconv.Itoa(size/10))) {
+				counts[member]++
+			}
+		}
+		// each member is expected rounds/10 times; allow a wide margin
+		for i := range size {
+			member := "m" + strconv.Itoa(i)
+			if n := counts[member]; n < rounds/10/3 || n > rounds/10*3 {
+				t.Errorf("size %d: %s picked %d times in %d rounds, want about %d", size, member, n, rounds, rounds/10)
+			}
+		}
+	}
+}
+
+// parseArrayReply returns the elements of a flat array of bulk strings
+func parseArrayReply(t *testing.T, reply string) []string {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(reply))
+	header, _ := r.ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "*")))
+	if err != nil || !strings.HasPrefix(header, "*") {
+		t.Fatalf("reply %q is not an array", reply)
+	}
+	elems := make([]string, n)
+	for i := range elems {
+		r.ReadString('\n')
+		line, _ := r.ReadString('\n')
+		elems[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return elems
+}
+
+// TestZAddFlagCombinations runs ZADD with every combination of NX, XX, GT
+// and LT, checking that the incompatible ones are rejected before the set
+// is touched
+func TestZAddFlagCombinations(t *testing.T) {
+	const (
+		nxXX = "-ERR XX and NX options at the same time are not compatible\r\n"
+		gtLT = "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"
+	)
+	c := newTestClient(t)
+	for mask := range 16 {
+		var flags []string
+		nx, xx, gt, lt := mask&1 != 0, mask&2 != 0, mask&4 != 0, mask&8 != 0
+		for j, flag := range []string{"NX", "XX", "GT", "LT"} {
+			if mask&(1<<j) != 0 {
+				flags = append(flags, flag)
+			}
+		}
+
+		want := ":1\r\n"
+		switch {
+		case nx && xx:
+			want = nxXX
+		case gt && lt, nx && (gt || lt):
+			want = gtLT
+		case xx:
+			// XX never adds the new member
+			want = ":0\r\n"
+		}
+
+		c.do("DEL", "zf")
+		c.do("ZADD", "zf", "5", "a")
+		args := slices.Concat([]string{"ZADD", "zf"}, flags, []string{"7", "a", "1", "b"})
+		if got := c.do(args...); got != want {
+			t.Errorf("%v = %q, want %q", args, got, want)
+		}
+		if want[0] == '-' {
+			if got := c.do("ZRANGE", "zf", "0", "-1", "WITHSCORES"); got != encodeValue([]string{"a", "5"}) {
+				t.Errorf("set after the rejected %v = %q, want it unchanged", args, got)
+			}
+		}
+	}
+}
+
+func TestZAddFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		want  string
+		after []string
+	}{
+		{"plain", []string{"3", "a", "4", "c"}, ":1\r\n", []string{"a", "3", "c", "4", "b", "5"}},
+		{"nx", []string{"NX", "3", "a", "4", "c"}, ":1\r\n", []string{"c", "4", "a", "5", "b", "5"}},
+		{"xx", []string{"XX", "3", "a", "4", "c"}, ":0\r\n", []string{"a", "3", "b", "5"}},
+		{"gt", []string{"GT", "3", "a", "6", "b", "4", "c"}, ":1\r\n", []string{"c", "4", "a", "5", "b", "6"}},
+		{"lt", []string{"LT", "3", "a", "6", "b", "4", "c"}, ":1\r\n", []string{"a", "3", "c", "4", "b", "5"}},
+		{"xx gt", []string{"XX", "GT", "3", "a", "6", "b", "4", "c"}, ":0\r\n", []string{"a", "5", "b", "6"}},
+		{"xx lt ch", []string{"XX", "LT", "CH", "3", "a", "6", "b", "4", "c"}, ":1\r\n", []string{"a", "3", "b", "5"}},
+		{"ch", []string{"CH", "3", "a", "5", "b", "4", "c"}, ":2\r\n", []string{"a", "3", "c", "4", "b", "5"}},
+		{"nx ch", []string{"NX", "CH", "3", "a", "4", "c"}, ":1\r\n", []string{"c", "4", "a", "5", "b", "5"}},
+		{"gt ch", []string{"GT", "CH", "3", "a", "6", "b"}, ":1\r\n", []string{"a", "5", "b", "6"}},
+		{"lowercase flags", []string{"xx", "ch", "3", "a"}, ":1\r\n", []string{"a", "3", "b", "5"}},
+		{"same member twice", []string{"1", "c", "2", "c"}, ":1\r\n", []string{"c", "2", "a", "5", "b", "5"}},
+		{"same member twice ch", []string{"CH", "1", "c", "2", "c"}, ":2\r\n", []string{"c", "2", "a", "5", "b", "5"}},
+		{"incr several pairs", []string{"INCR", "1", "a", "1", "b"}, "-ERR INCR option supports a single increment-element pair\r\n", []string{"a", "5", "b", "5"}},
+		{"bad score", []string{"3", "a", "x", "b"}, "-ERR value is not a valid float\r\n", []string{"a", "5", "b", "5"}},
+		{"missing member", []string{"3", "a", "4"}, "-ERR syntax error\r\n", []string{"a", "5", "b", "5"}},
+		{"only flags", []string{"NX", "CH"}, "-ERR syntax error\r\n", []string{"a", "5", "b", "5"}},
+		{"nx xx", []string{"NX", "XX", "3", "a"}, "-ERR XX and NX options at the same time are not compatible\r\n", []string{"a", "5", "b", "5"}},
+		{"nx gt", []string{"NX", "GT", "3", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n", []string{"a", "5", "b", "5"}},
+		{"nx lt", []string{"NX", "LT", "3", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n", []string{"a", "5", "b", "5"}},
+		{"gt lt", []string{"GT", "LT", "3", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n", []string{"a", "5", "b", "5"}},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "zf")
+			c.do("ZADD", "zf", "5", "a", "5", "b")
+			args := slices.Concat([]string{"ZADD", "zf"}, tt.args)
+			if got := c.do(args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", args, got, tt.want)
+			}
+			if got, want := c.do("ZRANGE", "zf", "0", "-1", "WITHSCORES"), encodeValue(tt.after); got != want {
+				t.Errorf("set after %v = %q, want %q", args, got, want)
+			}
+		})
+	}
+}
+
+func TestZAddIncr(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+		// the member the command targets, and its score afterwards
+		member, score string
+	}{
+		{"existing", []string{"INCR", "2", "a"}, "$1\r\n7\r\n", "a", "7"},
+		{"new member", []string{"INCR", "2", "new"}, "$1\r\n2\r\n", "new", "2"},
+		{"nx existing", []string{"NX", "INCR", "2", "a"}, "$-1\r\n", "a", "5"},
+		{"nx new member", []string{"NX", "INCR", "2", "new"}, "$1\r\n2\r\n", "new", "2"},
+		{"xx existing", []string{"XX", "INCR", "2", "a"}, "$1\r\n7\r\n", "a", "7"},
+		{"xx new member", []string{"XX", "INCR", "2", "new"}, "$-1\r\n", "new", ""},
+		{"gt up", []string{"GT", "INCR", "2", "a"}, "$1\r\n7\r\n", "a", "7"},
+		{"gt down", []string{"GT", "INCR", "-2", "a"}, "$-1\r\n", "a", "5"},
+		{"gt zero", []string{"GT", "INCR", "0", "a"}, "$-1\r\n", "a", "5"},
+		{"gt new member", []string{"GT", "INCR", "-2", "new"}, "$2\r\n-2\r\n", "new", "-2"},
+		{"lt down", []string{"LT", "INCR", "-2", "a"}, "$1\r\n3\r\n", "a", "3"},
+		{"lt up", []string{"LT", "INCR", "2", "a"}, "$-1\r\n", "a", "5"},
+		{"xx gt up", []string{"XX", "GT", "INCR", "1", "a"}, "$1\r\n6\r\n", "a", "6"},
+		{"xx lt up", []string{"XX", "LT", "INCR", "1", "a"}, "$-1\r\n", "a", "5"},
+		{"xx lt new member", []string{"XX", "LT", "INCR", "-1", "new"}, "$-1\r\n", "new", ""},
+		{"several pairs", []string{"INCR", "1", "a", "1", "b"}, "-ERR INCR option supports a single increment-element pair\r\n", "a", "5"},
+		{"several pairs with nx", []string{"NX", "INCR", "1", "new", "1", "b"}, "-ERR INCR option supports a single increment-element pair\r\n", "new", ""},
+		{"nan", []string{"INCR", "-inf", "inf"}, "-ERR resulting score is not a number (NaN)\r\n", "inf", "inf"},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "zi")
+			c.do("ZADD", "zi", "5", "a", "+inf", "inf")
+			args := slices.Concat([]string{"ZADD", "zi"}, tt.args)
+			if got := c.do(args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", args, got, tt.want)
+			}
+
+			want := "$-1\r\n"
+			if tt.score != "" {
+				want = encodeValue(tt.score)
+			}
+			if got := c.do("ZSCORE", "zi", tt.member); got != want {
+				t.Errorf("ZSCORE of %s after %v = %q, want %q", tt.member, args, got, want)
+			}
+		})
+	}
+}
+
+func TestZUnionInterStore(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zo1", "zo2", "zo-set", "zo-disjoint", "zo-str")
+	c.do("ZADD", "zo1", "1", "a", "2", "b", "3", "c")
+	c.do("ZADD", "zo2", "10", "b", "20", "c", "30", "d")
+	c.do("SADD", "zo-set", "a", "c", "e")
+	c.do("SADD", "zo-disjoint", "a", "e")
+	c.do("SET", "zo-str", "x")
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"union", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2"}, []string{"a", "1", "b", "12", "c", "23", "d", "30"}},
+		{"union weights", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "WEIGHTS", "2", "0.5"}, []string{"a", "2", "b", "9", "d", "15", "c", "16"}},
+		{"union sum", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE", "sum"}, []string{"a", "1", "b", "12", "c", "23", "d", "30"}},
+		{"union min", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE", "MIN"}, []string{"a", "1", "b", "2", "c", "3", "d", "30"}},
+		{"union max", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE", "MAX"}, []string{"a", "1", "b", "10", "c", "20", "d", "30"}},
+		{"union weights max", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "WEIGHTS", "100", "1", "AGGREGATE", "MAX"}, []string{"d", "30", "a", "100", "b", "200", "c", "300"}},
+		{"union negative weight", []string{"ZUNIONSTORE", "zo-dst", "1", "zo1", "WEIGHTS", "-1"}, []string{"c", "-3", "b", "-2", "a", "-1"}},
+		{"union with a set", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo-set"}, []string{"e", "1", "a", "2", "b", "2", "c", "4"}},
+		{"union with a missing key", []string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo-missing"}, []string{"a", "1", "b", "2", "c", "3"}},
+		{"inter", []string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2"}, []string{"b", "12", "c", "23"}},
+		{"inter weights", []string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2", "WEIGHTS", "3", "0"}, []string{"b", "6", "c", "9"}},
+		{"inter min", []string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE", "MIN"}, []string{"b", "2", "c", "3"}},
+		{"inter max", []string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE", "MAX"}, []string{"b", "10", "c", "20"}},
+		{"inter weights min", []string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2", "WEIGHTS", "10", "1", "AGGREGATE", "MIN"}, []string{"b", "10", "c", "20"}},
+		{"inter with a set", []string{"ZINTERSTORE", "zo-dst", "2", "zo-set", "zo1", "WEIGHTS", "5", "1"}, []string{"a", "6", "c", "8"}},
+		{"inter with a set max", []string{"ZINTERSTORE", "zo-dst", "2", "zo-set", "zo2", "AGGREGATE", "MAX"}, []string{"c", "20"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.do("DEL", "zo-dst")
+			if got, want := c.do(tt.args...), ":"+strconv.Itoa(len(tt.want)/2)+"\r\n"; got != want {
+				t.Errorf("%v = %q, want %q", tt.args, got, want)
+			}
+			if got, want := c.do("ZRANGE", "zo-dst", "0", "-1", "WITHSCORES"), encodeValue(tt.want); got != want {
+				t.Errorf("destination after %v = %q, want %q", tt.args, got, want)
+			}
+		})
+	}
+
+	t.Run("empty intersection deletes the destination", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo-missing"},
+			{"ZINTERSTORE", "zo-dst", "2", "zo-disjoint", "zo2", "WEIGHTS", "2", "1", "AGGREGATE", "MIN"},
+			{"ZUNIONSTORE", "zo-dst", "1", "zo-missing"},
+		} {
+			c.do("SET", "zo-dst", "old")
+			if got := c.do(args...); got != ":0\r\n" {
+				t.Errorf("%v = %q, want :0", args, got)
+			}
+			if got := c.do("EXISTS", "zo-dst"); got != ":0\r\n" {
+				t.Errorf("EXISTS zo-dst after %v = %q, want :0", args, got)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tt := range []struct {
+			args []string
+			want string
+		}{
+			{[]string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "WEIGHTS", "1"}, "-ERR syntax error\r\n"},
+			{[]string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo2", "WEIGHTS", "1", "x"}, "-ERR weight value is not a float\r\n"},
+			{[]string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE", "AVG"}, "-ERR syntax error\r\n"},
+			{[]string{"ZINTERSTORE", "zo-dst", "2", "zo1", "zo2", "AGGREGATE"}, "-ERR syntax error\r\n"},
+			{[]string{"ZINTERSTORE", "zo-dst", "0", "zo1"}, "-ERR at least 1 input key is needed for 'zinterstore' command\r\n"},
+			{[]string{"ZUNIONSTORE", "zo-dst", "3", "zo1", "zo2"}, "-ERR syntax error\r\n"},
+			{[]string{"ZUNIONSTORE", "zo-dst", "2", "zo1", "zo-str"}, "-ERR WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		} {
+			if got := c.do(tt.args...); got != tt.want {
+				t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+			}
+		}
+	})
+}
+
+// TestZIncrByReplyFormat checks that ZINCRBY replies with the new score in
+// the same format as ZSCORE: plain decimals at ordinary magnitudes, an
+// exponent for very large and very small scores
+func TestZIncrByReplyFormat(t *testing.T) {
+	c := newTestClient(t)
+	c.do("DEL", "zincr-fmt")
+
+	tests := []struct{ incr, want string }{
+		{"1000000", "1000000"},
+		{"0.5", "1000000.5"},
+		{"-1000000.5", "0"},
+		{"1e20", "1e+20"},
+		{"-1e20", "0"},
+		{"1e-20", "1e-20"},
+		{"3.0", "3"},
+		{"0.1", "3.1"},
+		{"inf", "inf"},
+	}
+	for _, tt := range tests {
+		if got := c.do("ZINCRBY", "zincr-fmt", tt.incr, "m"); got != encodeValue(tt.want) {
+			t.Errorf("ZINCRBY by %s = %q, want %q", tt.incr, got, tt.want)
+		}
+		if got := c.do("ZSCORE", "zincr-fmt", "m"); got != encodeValue(tt.want) {
+			t.Errorf("ZSCORE after ZINCRBY by %s = %q, want %q", tt.incr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestFormatScore(t *testing.T) {
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{-2.5, "-2.5"},
+		{1e6, "1000000"},
+		{0.1, "0.1"},
+		{3.0, "3"},
+		{1e16, "10000000000000000"},
+		{-1e16, "-10000000000000000"},
+		{1.5e-10, "0.00000000015"},
+		{1e17, "1e+17"},
+		{1e300, "1e+300"},
+		{-1e300, "-1e+300"},
+		{1e20, "1e+20"},
+		{-1e20, "-1e+20"},
+		{1e-18, "1e-18"},
+		{1e-20, "1e-20"},
+		{5e-324, "5e-324"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+	}
+	for _, tt := range tests {
+		got := formatScore(tt.score)
+		if got != tt.want {
+			t.Errorf("formatScore(%v) = %q, want %q", tt.score, got, tt.want)
+		}
+		if back, err := strconv.ParseFloat(got, 64); err != nil || back != tt.score {
+			t.Errorf("formatScore(%v) = %q, which reads back as %v", tt.score, got, back)
+		}
+	}
+}